@@ -1,20 +1,15 @@
 package main
 
 import (
+    "context"
     "fmt"
     "io"
     "net/http"
     "github.com/coloshword/OrcaNetAPIServer/manageOrcaNet"
-    "strings"
 	"strconv"
 	"encoding/json"
 )
 
-type Block struct {
-    Hash   string `json:"hash"`
-    Height int    `json:"height"`
-}
-
 // some basic endpoints
 
 // getRoot: the root endpoint ('/')
@@ -29,96 +24,88 @@ func getHello(w http.ResponseWriter, r *http.Request) {
     io.WriteString(w, "Hello, HTTP!\n")
 }
 
+// writeResult: writes the result of an RPC call back to the querier, the way btcctl would print it
+func writeResult(w http.ResponseWriter, result interface{}, err error) {
+    if err != nil {
+        fmt.Println(err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    switch value := result.(type) {
+    case string:
+        io.WriteString(w, value + "\n")
+    default:
+        body, err := json.MarshalIndent(value, "", "  ")
+        if err != nil {
+            http.Error(w, "Error encoding the result", http.StatusInternalServerError)
+            return
+        }
+        w.Write(append(body, '\n'))
+    }
+}
+
 // getBlockchainInfo: endpoint to get the blockchain info
 func getBlockchainInfo(w http.ResponseWriter, r *http.Request) {
     fmt.Println("getBlockchainInfo request") 
-    const command string = "getblockchaininfo"
-    stdout, err :=  manageOrcaNet.CallBtcctlCmd(command)
-    fmt.Println(err)
-    // return the output of CallBtcctlCommand back to the querier 
-    io.WriteString(w, stdout)
+    info, err := manageOrcaNet.Chain().GetBlockChainInfo(r.Context())
+    writeResult(w, info, err)
 }
 // getNewAddress: endpoint to get a new wallet address
 // this wallet address can be used for mining rewards / sending / receiving transactions
 // For security purposes, it is recommended to create a new address everytime 
 func getNewAddress(w http.ResponseWriter, r *http.Request) {
     fmt.Println("getNewAddress request")
-    const command string = "getnewaddress --wallet"
-    stdout, err := manageOrcaNet.CallBtcctlCmd(command)
-    fmt.Println(err)
-    io.WriteString(w, stdout)
+    address, err := manageOrcaNet.Wallet().GetNewAddress(r.Context())
+    writeResult(w, address, err)
 }
 
 // getBalance: gets the balance of the wallet 
 func getBalance(w http.ResponseWriter, r *http.Request) {
     fmt.Println("getBalance endpoint")
-    const command string = "getbalance --wallet"
-    stdout, err := manageOrcaNet.CallBtcctlCmd(command)
-    fmt.Println(err)
-    io.WriteString(w, stdout)
+    balance, err := manageOrcaNet.Wallet().GetBalance(r.Context())
+    writeResult(w, balance, err)
 }
 // getPeerInfo: gets the peer info 
 func getPeerInfo(w http.ResponseWriter, r *http.Request) {
     fmt.Println("Get peer endpoint")
-    const command string = "getpeerinfo"
-    stdout, err := manageOrcaNet.CallBtcctlCmd(command)
-    fmt.Println(err)
-    io.WriteString(w, stdout)
+    peers, err := manageOrcaNet.Chain().GetPeerInfo(r.Context())
+    writeResult(w, peers, err)
 }
 
 // getBestBlock: gets the best block
 func getBestBlock(w http.ResponseWriter, r *http.Request) {
     fmt.Println("Get best block endpoint")
-    const command string = "getbestblock"
-    stdout, err := manageOrcaNet.CallBtcctlCmd(command)
-    fmt.Println(err)
-    io.WriteString(w, stdout)
+    block, err := manageOrcaNet.Chain().GetBestBlock(r.Context())
+    writeResult(w, block, err)
 }
 
 // getBestBlockInfo: gets the best block info
 func getBestBlockInfo(w http.ResponseWriter, r *http.Request) {
     // get bestblock hash then run getblock on it
     fmt.Println("Get best block info endpoint")
-    const command string = "getbestblock"
-    stdout, err := manageOrcaNet.CallBtcctlCmd(command)
-    fmt.Println(err)
-    // get the hash of the best block
-    var block Block
-
-    // Unmarshal the JSON data into the block variable
-    err = json.Unmarshal([]byte(stdout), &block)
+    bestBlock, err := manageOrcaNet.Chain().GetBestBlock(r.Context())
     if err != nil {
-        fmt.Println(err)
-        http.Error(w, "Error accessing JSON data", http.StatusInternalServerError)
+        writeResult(w, nil, err)
+        return
     }
-
-    getBlockCommand := "getblock " + block.Hash
-    stdout, err = manageOrcaNet.CallBtcctlCmd(getBlockCommand)
-    fmt.Println(err)
-    io.WriteString(w, stdout)
+    block, err := manageOrcaNet.Chain().GetBlockVerbose(r.Context(), bestBlock.Hash)
+    writeResult(w, block, err)
 }
 // mine: endpoint to start mining, mining rewards go to the associated wallet on this node 
 func mine(w http.ResponseWriter, r *http.Request) {
     fmt.Println("Mine endpoint")
     // to mine, we need to restart the OrcaNet node with --generate and --mingaddr=<newAddress>
-    const getAddressCmd string = "getnewaddress --wallet"
-    stdout, err := manageOrcaNet.CallBtcctlCmd(getAddressCmd)
+    address, err := manageOrcaNet.Wallet().GetNewAddress(r.Context())
     if err != nil {
-        fmt.Println("error getting a new address for mining")
-        io.WriteString(w, "error getting a new address for mining")
+        fmt.Println("error getting a new address for mining:", err)
+        http.Error(w, "error getting a new address for mining", http.StatusInternalServerError)
+        return
     }
-    //std out is the address
-    address := strings.TrimSpace(stdout)
     orcaNetParams := []string{"--generate", "--miningaddr=" + address}
     fmt.Println("orcaParams[0] " + orcaNetParams[0])
     fmt.Println("orcaParams[1] " + orcaNetParams[1])
-    // we need to kill the OrcaNet process first
-    if err := manageOrcaNet.Stop(); err != nil {
-        fmt.Println("failed to end OrcaNet:", err)
-        http.Error(w, "failed to kill original OrcaNet instance to start mining", http.StatusInternalServerError)
-        return 
-    }
-    if err := manageOrcaNet.Start(orcaNetParams...); err != nil {
+    // the supervisor stops the running OrcaNet process first
+    if err := manageOrcaNet.RestartOrcaNet(orcaNetParams...); err != nil {
         fmt.Println("failed to start mining:", err)
         http.Error(w, "failed to start mining", http.StatusInternalServerError)
         return
@@ -145,18 +132,19 @@ func sendToAddress(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if _, err := strconv.ParseFloat(request.Coins, 64); err != nil {
+    coins, err := strconv.ParseFloat(request.Coins, 64)
+    if err != nil {
         http.Error(w, "Invalid number format for coins", http.StatusBadRequest)
         return
     }
 
-    if err := unlockWallet(request.SenderWalletPass); err != nil {
+    if err := unlockWallet(r.Context(), request.SenderWalletPass); err != nil {
         fmt.Printf("Failed to unlock wallet: %v\n", err)
         http.Error(w, "Error unlocking wallet", http.StatusInternalServerError)
         return
     }
 
-    if err := sendCoins(request.Coins, request.Address); err != nil {
+    if err := sendCoins(r.Context(), coins, request.Address); err != nil {
         fmt.Printf("Error sending coins: %v\n", err)
         http.Error(w, "Error sending coins", http.StatusInternalServerError)
         return
@@ -165,20 +153,16 @@ func sendToAddress(w http.ResponseWriter, r *http.Request) {
     fmt.Fprintf(w, "Successfully sent %s coins to %s\n", request.Coins, request.Address)
 }
 
-func unlockWallet(walletPass string) error {
-    command := fmt.Sprintf("--wallet walletpassphrase %s 100", walletPass)
-    stdout, err := manageOrcaNet.CallBtcctlCmd(command)
-    if err != nil {
-        return fmt.Errorf("Failed to unlock wallet: %s, error: %v", stdout, err)
+func unlockWallet(ctx context.Context, walletPass string) error {
+    if err := manageOrcaNet.Wallet().WalletPassphrase(ctx, walletPass, 100); err != nil {
+        return fmt.Errorf("Failed to unlock wallet: %w", err)
     }
     return nil
 }
 
-func sendCoins(numCoins, address string) error {
-    command := fmt.Sprintf("--wallet sendtoaddress %s %s", address, numCoins)
-    stdout, err := manageOrcaNet.CallBtcctlCmd(command)
-    if err != nil {
-        return fmt.Errorf("Failed to send coins: %s, error: %v", stdout, err)
+func sendCoins(ctx context.Context, numCoins float64, address string) error {
+    if _, err := manageOrcaNet.Wallet().SendToAddress(ctx, address, numCoins); err != nil {
+        return fmt.Errorf("Failed to send coins: %w", err)
     }
     return nil
 }
@@ -188,18 +172,13 @@ func sendCoins(numCoins, address string) error {
 // stopMine: endpoint to stop mining
 func stopMine(w http.ResponseWriter, r *http.Request) {
     fmt.Println("stop mine endpoint")
-    // kill the instance first 
-    if err := manageOrcaNet.Stop(); err != nil {
-        fmt.Println("failed to end Orcanet:", err)
-        http.Error(w, "failed to kill original OrcaNet isntance to start mining", http.StatusInternalServerError)
+    // restart the instance without --generate
+    if err := manageOrcaNet.RestartOrcaNet(); err != nil {
+        fmt.Println("failed to stop mining:", err)
+        http.Error(w, "failed to stop mining", http.StatusInternalServerError)
         return 
     }
-    if err := manageOrcaNet.Start(); err != nil {
-        fmt.Println("failed to start mining:", err)
-        http.Error(w, "failed to start mining", http.StatusInternalServerError)
-        return 
-    }
-    io.WriteString(w, "Mining successfully started")
+    io.WriteString(w, "Mining successfully stopped")
 }
 
 
@@ -1,20 +1,21 @@
 package main
 
 import (
+    "context"
     "fmt"
     "errors"
     "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
     "github.com/coloshword/OrcaNetAPIServer/manageOrcaNet"
 ) 
 
-// startOrcaNet: starts an OrcaNet full node instance for the server to communicate with
-func startOrcaNet() (error) {
-   return manageOrcaNet.Start()
-}
-
-// startOrcaWallet: starts OrcoWallet instance for the server to communicate with
-func startOrcaWallet() (error) {
-    return manageOrcaNet.StartOrcaWallet() 
+// startOrcaNet: starts an OrcaNet full node instance and an OrcaWallet instance for the server to communicate with,
+// and keeps them running until ctx is done
+func startOrcaNet(ctx context.Context) (error) {
+   return manageOrcaNet.Start(ctx)
 }
 
 func main() {
@@ -29,14 +30,31 @@ func main() {
     http.HandleFunc("/getBestBlock", getBestBlock)
     http.HandleFunc("/getBestBlockInfo", getBestBlockInfo)
     http.HandleFunc("/stopMine", stopMine)
+
+    // stop everything in order on ctrl-c, or when whoever started us stops us
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
     fmt.Println("starting orcanet")
-    startOrcaNet()    
-    startOrcaWallet()
-    err := http.ListenAndServe(":3333", nil)
+    if err := startOrcaNet(ctx); err != nil {
+        fmt.Println("failed to start OrcaNet:", err)
+        os.Exit(1)
+    }
+    server := &http.Server{Addr: ":3333"}
+    go func() {
+        <-ctx.Done()
+        fmt.Println("shutting down")
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        server.Shutdown(shutdownCtx)
+    }()
+    err := server.ListenAndServe()
     if errors.Is(err, http.ErrServerClosed) {
         fmt.Println("server is closed")
     } else if err != nil {
         fmt.Printf("error starting the server %s\n ", err)
     }
+    if err := manageOrcaNet.Stop(); err != nil {
+        fmt.Println("failed to stop OrcaNet cleanly:", err)
+    }
 }
-
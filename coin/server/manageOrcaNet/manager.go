@@ -1,15 +1,16 @@
  package manageOrcaNet
 
  import (
+     "context"
+     "crypto/rand"
+     "encoding/hex"
      "fmt"
      "os"
-     "os/exec"
-     "strings"
-     "bufio"
-     "runtime"
      "path/filepath"
-     "time"
-     "io"
+     "strings"
+
+     "github.com/coloshword/OrcaNetAPIServer/orcarpc"
+     "github.com/coloshword/OrcaNetAPIServer/supervisor"
  )
 
 
@@ -25,200 +26,202 @@ func getExePath() (string, error) {
 
 const (
      orcaNetPath string = "./OrcaNet/OrcaNet"
-     btcctlPath string = "./OrcaNet/cmd/btcctl/btcctl"
      orcaWalletPath string = "./OrcaWallet/btcwallet"
+     btcdSampleConfPath string = "./OrcaNet/sample-btcd.conf"
+     walletSampleConfPath string = "./OrcaWallet/sample-btcwallet.conf"
+     // logs of OrcaNet and OrcaWallet are kept next to the server
+     logsPath string = "./server/logs"
+
+     orcaNetName string = "OrcaNet"
+     orcaWalletName string = "OrcaWallet"
  )
 
-var cmdProcess *exec.Cmd
+var orcaSupervisor *supervisor.Supervisor
+// clients of OrcaNet and OrcaWallet, set once both are ready
+var chainClient, walletClient *orcarpc.Client
+
+// Chain: the client of the running OrcaNet
+func Chain() *orcarpc.Client {
+    return chainClient
+}
+
+// Wallet: the client of the running OrcaWallet
+func Wallet() *orcarpc.Client {
+    return walletClient
+}
 
-func Start(params ...string) error {
-    exePath, err := getExePath();
+// coinPath: path of a file in the coin directory, relative to the executable so the server can be run from anywhere
+func coinPath(path string) (string, error) {
+    exePath, err := getExePath()
     if err != nil {
-        return err;
+        return "", err
     }
-    var orcaNetFullPath = filepath.Join(exePath, "..", "..", orcaNetPath)
-    _, err = os.Stat(orcaNetFullPath)
-    if os.IsNotExist(err) {
-        fmt.Println("Cannot find OrcaNet executable")
-        return err
+    fullPath := filepath.Join(exePath, "..", "..", path)
+    if _, err := os.Stat(fullPath); err != nil {
+        return "", fmt.Errorf("cannot find %s: %w", path, err)
     }
+    return fullPath, nil
+}
 
-    cmdProcess = exec.Command(orcaNetFullPath, params...)
-
-    stdout, err := cmdProcess.StdoutPipe()
+// Start: generates the configs, then starts OrcaNet and OrcaWallet, each once its RPC server answers.
+// Both are restarted if they crash or stop answering, until Stop is called
+func Start(ctx context.Context) error {
+    btcdConf, walletConf, err := generateConfigs()
     if err != nil {
-        return fmt.Errorf("failed to create stdout pipe: %w", err)
+        return fmt.Errorf("failed to generate the configs: %w", err)
     }
-    stderr, err := cmdProcess.StderrPipe()
+    orcaNetFullPath, err := coinPath(orcaNetPath)
     if err != nil {
-        return fmt.Errorf("failed to create stderr pipe: %w", err)
+        return err
     }
-
-    fmt.Println("Start OrcaNet with params: ", params)
-    if err := cmdProcess.Start();  err != nil {
-        fmt.Println("Failed to start OrcaNet:", err)
+    walletFullPath, err := coinPath(orcaWalletPath)
+    if err != nil {
         return err
     }
-    fmt.Println("OrcaNet started successfully")
-    go printOutput(stdout)
-    go printOutput(stderr)
-
-
-    return nil
-}
-
-
-func printOutput(r io.Reader) {
-    scanner := bufio.NewScanner(r)
-    for scanner.Scan() {
-        fmt.Println(scanner.Text())
+    exePath, err := getExePath()
+    if err != nil {
+        return err
     }
-    if err := scanner.Err(); err != nil {
-        fmt.Printf("Error reading stream: %v\n", err)
+    logsFullPath := filepath.Join(exePath, "..", "..", logsPath)
+
+    orcaSupervisor = supervisor.New(
+        &supervisor.Process{
+            Name: orcaNetName,
+            Path: orcaNetFullPath,
+            Args: orcaNetArgs(btcdConf),
+            LogFile: filepath.Join(logsFullPath, "orcanet.log"),
+            Ready: rpcReady(orcarpc.ChainConfig),
+        },
+        &supervisor.Process{
+            Name: orcaWalletName,
+            Path: walletFullPath,
+            Args: []string{"--configfile=" + walletConf},
+            LogFile: filepath.Join(logsFullPath, "orcawallet.log"),
+            Ready: rpcReady(orcarpc.WalletConfig),
+        },
+    )
+    if err := orcaSupervisor.Start(ctx); err != nil {
+        return err
     }
-}
-
 
-// Stop: ends the running OrcaNet instance if its running
-//TO DO: right now it sleeps for 5 seconds instead of just waiting for the interrupt (fix this)
-func Stop() error {
-    if cmdProcess == nil || cmdProcess.Process == nil {
-        fmt.Println("OrcaNet process is not currently running.")
-        return fmt.Errorf("OrcaNet process is not running")
+    if chainClient, err = newClient(orcarpc.ChainConfig); err != nil {
+        orcaSupervisor.Stop()
+        return err
     }
-
-    fmt.Println("Stopping OrcaNet...")
-    // send interrupt sig
-    if err := cmdProcess.Process.Signal(os.Interrupt); err != nil {
-        fmt.Println("Failed to send interrupt:", err)
+    if walletClient, err = newClient(orcarpc.WalletConfig); err != nil {
+        orcaSupervisor.Stop()
         return err
     }
-    time.Sleep(5 * time.Second)
-    fmt.Println("OrcaNet stopped successfully.")
+    go chainClient.NotifyBlocks(ctx, func(block orcarpc.BlockConnected) {
+        fmt.Printf("OrcaNet connected block %d %s\n", block.Height, block.Hash)
+    })
     return nil
 }
 
-//startOrcaWallet: starts the OrcaWallet
-func StartOrcaWallet() error {
-    // get path relative to executable so it can be run anywhere and not just from the location of the exe
-    exePath, err := getExePath();
+func newClient(config func() (*orcarpc.ConnConfig, error)) (*orcarpc.Client, error) {
+    connConfig, err := config()
     if err != nil {
-        return err;
-    }
-    var walletFullPath = filepath.Join(exePath, "..", "..", orcaWalletPath)
-    // check for the existence of the executable 
-    _, err = os.Stat(walletFullPath)
-    if os.IsNotExist(err) {
-        fmt.Println("Cannot find Orcawallet executable")
-        return err
-    }
-
-    cmd := exec.Command(walletFullPath)
-    if err := cmd.Start(); err != nil {
-        fmt.Println(err)
-        fmt.Println("failed to start wallet executable")
-        return nil
+        return nil, err
     }
-    fmt.Println("Wallet started successfully")
-    return nil
+    return orcarpc.New(connConfig)
 }
-// getBtcdConfFilePath returns the file path for btcd.conf based on the user's OS
-func getBtcdConfFilePath() string {
-    const defaultConfigFilename = "btcd.conf"
-    homeDir := getUserHomeDir()
-    if homeDir == "" {
-        return "" // Return empty string if the home directory can't be determined
-    }
-    
-    // Determine the application data directory based on the operating system
-    var appDataDir string
-    switch runtime.GOOS {
-    case "windows":
-        appDataDir = filepath.Join(homeDir, "AppData", "Roaming", "Btcd")
-    case "darwin": // macOS
-        appDataDir = filepath.Join(homeDir, "Library", "Application Support", "Btcd")
-    case "linux":
-        appDataDir = filepath.Join(homeDir, ".btcd")
-    default:
-        appDataDir = filepath.Join(homeDir, ".btcd") // Default to a Unix-style hidden directory
-    }
-
-    return filepath.Join(appDataDir, defaultConfigFilename)
+
+// orcaNetArgs: the arguments OrcaNet always runs with, followed by params
+func orcaNetArgs(btcdConf string, params ...string) []string {
+    return append([]string{"--configfile=" + btcdConf}, params...)
 }
 
-// getUserHomeDir returns the home directory of the current user
-func getUserHomeDir() string {
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        fmt.Println("Error getting user home directory:", err)
-        return ""
+// RestartOrcaNet: restarts OrcaNet with params, such as --generate to mine, and waits for it to be ready
+func RestartOrcaNet(params ...string) error {
+    if orcaSupervisor == nil {
+        return fmt.Errorf("OrcaNet process is not running")
     }
-    return homeDir
+    btcdConf := filepath.Join(orcarpc.AppDataDir("btcd"), "btcd.conf")
+    fmt.Println("Restarting OrcaNet with params: ", params)
+    return orcaSupervisor.Restart(orcaNetName, orcaNetArgs(btcdConf, params...)...)
 }
 
-// returns an array [rpcuser, rpcpass]
-func readRPCInfo(path string) ([]string, error) {
-	body, err := os.ReadFile(path)
-
-	if err != nil {
-		return nil, fmt.Errorf("error reading the btcd.conf file")
-	}
-
-	content := string(body)
-	var rpcInfo []string 
-	// find the line with "rpcuser" and "rpcpass"
-	lines := strings.Split(content, "\n");
-	for _, line := range lines {
-		if strings.HasPrefix(line, "rpcuser") || strings.HasPrefix(line, "rpcpass") {
-			parts := strings.Split(line, "=")
-			// if len(parts) == 2 {
-			// 	fmt.Println(parts)
-			// 	rpcInfo = append(rpcInfo, strings.TrimSpace(parts[1]))
-			// }
-			rpcInfo = append(rpcInfo, strings.TrimSpace(parts[1]))
-		}
-	}
-
-	if len(rpcInfo) < 2 {
-		return nil, fmt.Errorf("error finding rpcuser and rpc pass")
-	}
-	return rpcInfo, nil 
+// Stop: stops OrcaWallet and then OrcaNet, waiting for each to exit
+func Stop() error {
+    if orcaSupervisor == nil {
+        return fmt.Errorf("OrcaNet process is not running")
+    }
+    return orcaSupervisor.Stop()
 }
 
+// generateConfigs: writes btcd.conf from the sample with a random RPC user and password if it has none,
+// and points btcwallet.conf at the same user and password. Returns the paths of both
+func generateConfigs() (string, string, error) {
+    btcdConf := filepath.Join(orcarpc.AppDataDir("btcd"), "btcd.conf")
+    walletConf := filepath.Join(orcarpc.AppDataDir("btcwallet"), "btcwallet.conf")
 
-// callBtcctlCmd: calls a Btcctl command Exactly as specified in string param, and returns the stdout of btcctl as a string 
-// its a singular string, but you can pass as many arguments, we will split the arguments in this fn
-func CallBtcctlCmd(cmdStr string) (string, error) {
-    // get the rpc values 
-    rpcInfo, err := readRPCInfo(getBtcdConfFilePath())
+    rpcInfo, err := orcarpc.ReadConfValues(btcdConf, "rpcuser", "rpcpass")
     if err != nil {
-        return "", fmt.Errorf("failed to get rpc info")
+        rpcInfo = []string{randomHex(20), randomHex(20)}
+        fmt.Println("Generating", btcdConf)
+        err = writeFromSample(btcdSampleConfPath, btcdConf, map[string]string{
+            "rpcuser=": "rpcuser=" + rpcInfo[0],
+            "rpcpass=": "rpcpass=" + rpcInfo[1],
+        })
+        if err != nil {
+            return "", "", err
+        }
+    }
+
+    walletInfo, err := orcarpc.ReadConfValues(walletConf, "username", "password")
+    if err != nil || walletInfo[0] != rpcInfo[0] || walletInfo[1] != rpcInfo[1] {
+        fmt.Println("Generating", walletConf)
+        err = writeFromSample(walletSampleConfPath, walletConf, map[string]string{
+            "username=": "username=" + rpcInfo[0],
+            "password=": "password=" + rpcInfo[1],
+        })
+        if err != nil {
+            return "", "", err
+        }
+    }
+    return btcdConf, walletConf, nil
+}
+
+// writeFromSample: copies a sample config to dest, replacing every line setting a key of replace, commented out or not, with its value
+func writeFromSample(sample string, dest string, replace map[string]string) error {
+    samplePath, err := coinPath(sample)
+    if err != nil {
+        return err
     }
-    // get the bttcl full path
-    exePath, err := getExePath()
+    body, err := os.ReadFile(samplePath)
     if err != nil {
-        fmt.Println("error finding APIServer exec")
-        return "", err
+        return err
     }
-    
-    var btcctlFullPath = filepath.Join(exePath, "..", "..", btcctlPath)
-    _, err = os.Stat(btcctlFullPath)
-    if os.IsNotExist(err) {
-        fmt.Println("Error finding btcctl full path")
-        return "", err 
+    lines := strings.Split(string(body), "\n")
+    for i, line := range lines {
+        for key, value := range replace {
+            if strings.HasPrefix(strings.TrimLeft(line, "; "), key) {
+                lines[i] = value
+            }
+        }
     }
-    params :=  strings.Split(cmdStr, " ") 
-    params = append(params, "--rpcuser=" + strings.TrimSpace(rpcInfo[0]) + "=", "--rpcpass=" + strings.TrimSpace(rpcInfo[1]) + "=")
-
-    fmt.Println(params)
-    cmd := exec.Command(btcctlFullPath, params...)
-    // get the stdout of cmd, CAN HANG but shouldn't be a problem in a btcctl command
-    stdout, err := cmd.CombinedOutput() 
-    if err != nil {
-        return "", fmt.Errorf("failed to execute btcctl commands '%s': %s, error: %v", cmdStr, stdout, err)
+    if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+        return err
     }
-    fmt.Println(err);
-    return string(stdout), nil
+    return os.WriteFile(dest, []byte(strings.Join(lines, "\n")), 0600)
 }
 
+func randomHex(n int) string {
+    bytes := make([]byte, n)
+    rand.Read(bytes)
+    return hex.EncodeToString(bytes)
+}
 
+// rpcReady: a probe that answers nil once the RPC server answers getblockcount
+func rpcReady(config func() (*orcarpc.ConnConfig, error)) func(ctx context.Context) error {
+    return func(ctx context.Context) error {
+        // the cert is created by the server on its first start, so the config is read on every probe
+        client, err := newClient(config)
+        if err != nil {
+            return err
+        }
+        defer client.Close()
+        _, err = client.GetBlockCount(ctx)
+        return err
+    }
+}
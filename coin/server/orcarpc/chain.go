@@ -0,0 +1,62 @@
+package orcarpc
+
+import (
+	"context"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// BlockChainInfo: the result of getblockchaininfo
+type BlockChainInfo = btcjson.GetBlockChainInfoResult
+
+// BestBlock: the result of getbestblock
+type BestBlock = btcjson.GetBestBlockResult
+
+// Block: the result of getblock with verbosity 1
+type Block = btcjson.GetBlockVerboseResult
+
+// PeerInfo: one peer in the result of getpeerinfo
+type PeerInfo = btcjson.GetPeerInfoResult
+
+// GetBlockCount: the height of the best block
+func (c *Client) GetBlockCount(ctx context.Context) (int64, error) {
+	var count int64
+	err := c.call(ctx, btcjson.NewGetBlockCountCmd(), &count)
+	return count, err
+}
+
+// GetBlockChainInfo: the state of the chain
+func (c *Client) GetBlockChainInfo(ctx context.Context) (*BlockChainInfo, error) {
+	var info BlockChainInfo
+	if err := c.call(ctx, btcjson.NewGetBlockChainInfoCmd(), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetBestBlock: the hash and height of the best block
+func (c *Client) GetBestBlock(ctx context.Context) (*BestBlock, error) {
+	var block BestBlock
+	if err := c.call(ctx, btcjson.NewGetBestBlockCmd(), &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// GetBlockVerbose: a block and the ids of its transactions
+func (c *Client) GetBlockVerbose(ctx context.Context, hash string) (*Block, error) {
+	var block Block
+	if err := c.call(ctx, btcjson.NewGetBlockCmd(hash, btcjson.Int(1)), &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// GetPeerInfo: the peers the node is connected to
+func (c *Client) GetPeerInfo(ctx context.Context) ([]PeerInfo, error) {
+	var peers []PeerInfo
+	if err := c.call(ctx, btcjson.NewGetPeerInfoCmd(), &peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
@@ -0,0 +1,157 @@
+// Package orcarpc is a typed client of the JSON-RPC servers of OrcaNet (btcd) and OrcaWallet
+// (btcwallet), built on OrcaNet's rpcclient. Requests are posted over a persistent TLS connection,
+// and notifications arrive over the server's websocket.
+package orcarpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Error codes the servers answer with, as defined by btcjson
+const (
+	ErrRPCMisc                      = btcjson.ErrRPCMisc
+	ErrRPCWallet                    = btcjson.ErrRPCWallet
+	ErrRPCInvalidAddressOrKey       = btcjson.ErrRPCInvalidAddressOrKey
+	ErrRPCWalletInsufficientFunds   = btcjson.ErrRPCWalletInsufficientFunds
+	ErrRPCInvalidParameter          = btcjson.ErrRPCInvalidParameter
+	ErrRPCWalletUnlockNeeded        = btcjson.ErrRPCWalletUnlockNeeded
+	ErrRPCWalletPassphraseIncorrect = btcjson.ErrRPCWalletPassphraseIncorrect
+	// btcjson only has this one as an *RPCError
+	ErrRPCMethodNotFound btcjson.RPCErrorCode = -32601
+)
+
+// ConnConfig: where an RPC server is and how to log in to it
+type ConnConfig struct {
+	Host         string // host:port
+	Endpoint     string // websocket endpoint, "ws"
+	User         string
+	Pass         string
+	DisableTLS   bool
+	Certificates []byte // PEM encoded certificate of the server, rpc.cert
+	// Timeout: how long a request may take when its context has no deadline, 30 seconds if zero
+	Timeout time.Duration
+}
+
+// Error: an error answered by the RPC server
+type Error = btcjson.RPCError
+
+// IsCode: whether err is an error the RPC server answered with code
+func IsCode(err error, code btcjson.RPCErrorCode) bool {
+	var rpcErr *Error
+	return errors.As(err, &rpcErr) && rpcErr.Code == code
+}
+
+// Client: a connection to one RPC server, safe to use from several goroutines
+type Client struct {
+	config *ConnConfig
+	rpc    *rpcclient.Client
+}
+
+// New: a client of the server in config, connections are made as requests need them and kept open
+func New(config *ConnConfig) (*Client, error) {
+	if !config.DisableTLS && len(config.Certificates) == 0 {
+		return nil, fmt.Errorf("no certificate of %s", config.Host)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	rpc, err := rpcclient.New(config.rpcConfig(true), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{config: config, rpc: rpc}, nil
+}
+
+// rpcConfig: the configuration of an rpcclient, posting requests over HTTP or keeping a websocket open
+func (config *ConnConfig) rpcConfig(httpPostMode bool) *rpcclient.ConnConfig {
+	return &rpcclient.ConnConfig{
+		Host:         config.Host,
+		Endpoint:     config.Endpoint,
+		User:         config.User,
+		Pass:         config.Pass,
+		DisableTLS:   config.DisableTLS,
+		Certificates: config.Certificates,
+		HTTPPostMode: httpPostMode,
+	}
+}
+
+// Close: stops the client and closes its connections to the server
+func (c *Client) Close() {
+	c.rpc.Shutdown()
+}
+
+/*
+ * Wait for the response to a request, arguments are never put on a command line so passphrases stay private
+ *
+ * Parameters:
+ *   ctx: The context, the client's timeout applies if it has no deadline
+ *   method: The method, used in errors
+ *   future: The channel rpcclient answers the request on
+ *   result: Decoded into from the server's result, nil to ignore the result
+ *
+ * Returns:
+ *   An *Error if the server answered with one, otherwise an error about reaching the server, if any
+ */
+func (c *Client) wait(ctx context.Context, method string, future chan *rpcclient.Response, result interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+	var reply []byte
+	var err error
+	// rpcclient has no way to cancel a request, the response is dropped instead
+	done := make(chan struct{})
+	go func() {
+		reply, err = rpcclient.ReceiveFuture(future)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(reply, result); err != nil {
+		return fmt.Errorf("%s: unexpected result: %w", method, err)
+	}
+	return nil
+}
+
+// call: sends a btcjson command and decodes its result into result
+func (c *Client) call(ctx context.Context, cmd interface{}, result interface{}) error {
+	method, err := btcjson.CmdMethod(cmd)
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, method, c.rpc.SendCmd(cmd), result)
+}
+
+// RawRequest: calls a method the client has no typed function for, and returns its result as is
+func (c *Client) RawRequest(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+	rawParams := make([]json.RawMessage, len(params))
+	for i, param := range params {
+		rawParam, err := json.Marshal(param)
+		if err != nil {
+			return nil, err
+		}
+		rawParams[i] = rawParam
+	}
+	var result json.RawMessage
+	err := c.wait(ctx, method, c.rpc.RawRequestAsync(method, rawParams), &result)
+	return result, err
+}
@@ -0,0 +1,90 @@
+package orcarpc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	chainHost  = "localhost:8334"
+	walletHost = "localhost:8332"
+)
+
+// AppDataDir: the data directory of btcd or btcwallet on this OS
+func AppDataDir(name string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	capitalized := strings.ToUpper(name[:1]) + name[1:]
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(homeDir, "AppData", "Roaming", capitalized)
+	case "darwin":
+		return filepath.Join(homeDir, "Library", "Application Support", capitalized)
+	default:
+		return filepath.Join(homeDir, "."+name)
+	}
+}
+
+// ChainConfig: how to reach the local OrcaNet, from btcd.conf and its rpc.cert
+func ChainConfig() (*ConnConfig, error) {
+	return localConfig(chainHost, AppDataDir("btcd"))
+}
+
+// WalletConfig: how to reach the local OrcaWallet, it shares OrcaNet's user and password
+func WalletConfig() (*ConnConfig, error) {
+	return localConfig(walletHost, AppDataDir("btcwallet"))
+}
+
+func localConfig(host string, certDir string) (*ConnConfig, error) {
+	rpcInfo, err := ReadConfValues(filepath.Join(AppDataDir("btcd"), "btcd.conf"), "rpcuser", "rpcpass")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := os.ReadFile(filepath.Join(certDir, "rpc.cert"))
+	if err != nil {
+		return nil, err
+	}
+	return &ConnConfig{
+		Host:         host,
+		Endpoint:     "ws",
+		User:         rpcInfo[0],
+		Pass:         rpcInfo[1],
+		Certificates: cert,
+	}, nil
+}
+
+// ReadConfValues: reads the values of keys from a config file, in the order of keys
+func ReadConfValues(path string, keys ...string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	found := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Values such as base64 passwords may contain '=' themselves
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok {
+			found[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		if found[key] == "" {
+			return nil, fmt.Errorf("%s is not set in %s", key, path)
+		}
+		values[i] = found[key]
+	}
+	return values, nil
+}
@@ -0,0 +1,64 @@
+package orcarpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// BlockConnected: a block the chain connected
+type BlockConnected struct {
+	Hash   string
+	Height int32
+	Time   time.Time
+}
+
+/*
+ * Receive every block the server connects, until ctx is done. The websocket is dialed with backoff
+ * until it connects, after that rpcclient dials it again and registers for blocks whenever it drops,
+ * so blocks connected while it is down are missed.
+ *
+ * Parameters:
+ *   ctx: The context
+ *   onBlock: Called with each block, in order, from one goroutine
+ *
+ * Returns:
+ *   The error of ctx, or the error the server answered notifyblocks with
+ */
+func (c *Client) NotifyBlocks(ctx context.Context, onBlock func(BlockConnected)) error {
+	handlers := &rpcclient.NotificationHandlers{
+		OnBlockConnected: func(hash *chainhash.Hash, height int32, t time.Time) {
+			onBlock(BlockConnected{Hash: hash.String(), Height: height, Time: t})
+		},
+	}
+	backoff := minReconnectBackoff
+	var ws *rpcclient.Client
+	for {
+		var err error
+		ws, err = rpcclient.New(c.config.rpcConfig(false), handlers)
+		if err == nil {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+	defer ws.Shutdown()
+
+	// The server has no notifications if this fails, dialing again will not change that
+	if err := c.wait(ctx, "notifyblocks", ws.NotifyBlocksAsync(), nil); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
@@ -0,0 +1,73 @@
+package orcarpc
+
+import (
+	"context"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// Addresses are passed around as strings, rpcclient's typed wallet functions would decode them with
+// Bitcoin's network parameters rather than OrcaNet's
+
+// TransactionDetail: one output of a transaction that involves the wallet
+type TransactionDetail = btcjson.GetTransactionDetailsResult
+
+// Transaction: the result of gettransaction
+type Transaction = btcjson.GetTransactionResult
+
+// GetNewAddress: a fresh address of the wallet
+func (c *Client) GetNewAddress(ctx context.Context) (string, error) {
+	var address string
+	err := c.call(ctx, btcjson.NewGetNewAddressCmd(nil, nil), &address)
+	return address, err
+}
+
+// GetBalance: the spendable balance of the wallet, in coins
+func (c *Client) GetBalance(ctx context.Context) (float64, error) {
+	var balance float64
+	err := c.call(ctx, btcjson.NewGetBalanceCmd(nil, nil), &balance)
+	return balance, err
+}
+
+// WalletPassphrase: unlocks the wallet for timeoutSecs seconds
+func (c *Client) WalletPassphrase(ctx context.Context, passphrase string, timeoutSecs int64) error {
+	return c.call(ctx, btcjson.NewWalletPassphraseCmd(passphrase, timeoutSecs), nil)
+}
+
+// WalletLock: locks the wallet again
+func (c *Client) WalletLock(ctx context.Context) error {
+	return c.call(ctx, btcjson.NewWalletLockCmd(), nil)
+}
+
+// SendToAddress: sends amount coins to address and returns the id of the transaction, the wallet
+// must be unlocked
+func (c *Client) SendToAddress(ctx context.Context, address string, amount float64) (string, error) {
+	var txId string
+	err := c.call(ctx, btcjson.NewSendToAddressCmd(address, amount, nil, nil), &txId)
+	return txId, err
+}
+
+// GetReceivedByAddress: the coins received by an address of the wallet in transactions with at
+// least minConf confirmations, 0 counts transactions that are still in the mempool
+func (c *Client) GetReceivedByAddress(ctx context.Context, address string, minConf int64) (float64, error) {
+	var received float64
+	err := c.call(ctx, btcjson.NewGetReceivedByAddressCmd(address, btcjson.Int(int(minConf))), &received)
+	return received, err
+}
+
+// GetTransaction: a transaction that involves the wallet
+func (c *Client) GetTransaction(ctx context.Context, txId string) (*Transaction, error) {
+	var transaction Transaction
+	if err := c.call(ctx, btcjson.NewGetTransactionCmd(txId, nil), &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// SendMany: sends coins to several addresses in one transaction, spending from fromAccount only outputs
+// with at least minConf confirmations, and returns the id of the transaction. The wallet must be unlocked
+func (c *Client) SendMany(ctx context.Context, fromAccount string, amounts map[string]float64, minConf int64) (string, error) {
+	var txId string
+	err := c.call(ctx, btcjson.NewSendManyCmd(fromAccount, amounts, btcjson.Int(int(minConf)), nil), &txId)
+	return txId, err
+}
@@ -0,0 +1,329 @@
+// Package supervisor keeps child processes running. Processes are started in order, each one
+// ready before the next starts, restarted with backoff when they exit or stop answering, and
+// stopped in reverse order.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReadyTimeout   = 2 * time.Minute
+	defaultHealthInterval = 30 * time.Second
+	defaultStopTimeout    = 30 * time.Second
+	// How many health checks in a row may fail before the process is killed and restarted
+	healthFailures = 3
+	minBackoff     = time.Second
+	maxBackoff     = time.Minute
+	// A process that ran this long before exiting is restarted right away, not after the backoff
+	stableAfter = time.Minute
+	readyPoll   = 500 * time.Millisecond
+)
+
+// Process: a program to keep running
+type Process struct {
+	Name    string
+	Path    string
+	Args    []string
+	Dir     string
+	LogFile string // stdout and stderr are appended here, empty means the supervisor's stdout
+	// Ready: answers nil once the process serves requests, it is polled after every start and
+	// doubles as the health check. Nil means the process is ready as soon as it started.
+	Ready          func(ctx context.Context) error
+	ReadyTimeout   time.Duration
+	HealthInterval time.Duration
+	StopTimeout    time.Duration
+
+	run       *run
+	restartCh chan restartRequest
+}
+
+// run: one start of a process
+type run struct {
+	cmd     *exec.Cmd
+	started time.Time
+	done    chan struct{}
+	err     error
+}
+
+type restartRequest struct {
+	args   []string
+	result chan error
+}
+
+// Supervisor: starts, watches and stops a list of processes
+type Supervisor struct {
+	processes []*Process
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	stopOnce  sync.Once
+	stopErr   error
+}
+
+// New: a supervisor of the processes, in the order they start in
+func New(processes ...*Process) *Supervisor {
+	for _, p := range processes {
+		if p.ReadyTimeout == 0 {
+			p.ReadyTimeout = defaultReadyTimeout
+		}
+		if p.HealthInterval == 0 {
+			p.HealthInterval = defaultHealthInterval
+		}
+		if p.StopTimeout == 0 {
+			p.StopTimeout = defaultStopTimeout
+		}
+		p.restartCh = make(chan restartRequest)
+	}
+	return &Supervisor{processes: processes}
+}
+
+// Start: starts every process and waits for each to be ready before starting the next. If one
+// cannot start the ones already running are stopped again.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, p := range s.processes {
+		if err := s.ctx.Err(); err != nil {
+			s.Stop()
+			return err
+		}
+		r, err := p.start()
+		if err == nil {
+			p.run = r
+			err = p.waitReady(s.ctx, r)
+		}
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("starting %s: %w", p.Name, err)
+		}
+		fmt.Printf("%s is ready\n", p.Name)
+		s.wg.Add(1)
+		go s.watch(p)
+	}
+	return nil
+}
+
+// Restart: stops a process and starts it again with new arguments, returns once it is ready
+func (s *Supervisor) Restart(name string, args ...string) error {
+	for _, p := range s.processes {
+		if p.Name != name {
+			continue
+		}
+		req := restartRequest{args: args, result: make(chan error, 1)}
+		select {
+		case p.restartCh <- req:
+			return <-req.result
+		case <-s.ctx.Done():
+			return errors.New("the supervisor is stopped")
+		}
+	}
+	return fmt.Errorf("no process named %s", name)
+}
+
+// Stop: stops every process, the last one started first. Each is interrupted and killed if it has
+// not exited after its stop timeout.
+func (s *Supervisor) Stop() error {
+	s.stopOnce.Do(func() {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		s.wg.Wait()
+		var errs []error
+		for i := len(s.processes) - 1; i >= 0; i-- {
+			p := s.processes[i]
+			if p.run == nil {
+				continue
+			}
+			fmt.Printf("Stopping %s...\n", p.Name)
+			if err := p.stop(p.run); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+			}
+			p.run = nil
+		}
+		s.stopErr = errors.Join(errs...)
+	})
+	return s.stopErr
+}
+
+// watch: restarts the process whenever it exits or fails its health checks, until the supervisor stops
+func (s *Supervisor) watch(p *Process) {
+	defer s.wg.Done()
+	backoff := minBackoff
+	failures := 0
+	ticker := time.NewTicker(p.HealthInterval)
+	defer ticker.Stop()
+	for s.ctx.Err() == nil {
+		// A run that never started has nothing to wait for
+		var done chan struct{}
+		if p.run != nil {
+			done = p.run.done
+		} else {
+			done = make(chan struct{})
+			close(done)
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case req := <-p.restartCh:
+			if p.run != nil {
+				p.stop(p.run)
+			}
+			p.Args = req.args
+			p.run = nil
+			r, err := p.start()
+			if err == nil {
+				p.run = r
+				err = p.waitReady(s.ctx, r)
+			}
+			req.result <- err
+			backoff, failures = minBackoff, 0
+		case <-ticker.C:
+			if p.Ready == nil || p.run == nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(s.ctx, p.HealthInterval)
+			err := p.Ready(ctx)
+			cancel()
+			if err == nil {
+				failures = 0
+				continue
+			}
+			failures++
+			fmt.Printf("%s failed its health check (%d/%d): %v\n", p.Name, failures, healthFailures, err)
+			if failures >= healthFailures {
+				fmt.Printf("Killing unresponsive %s\n", p.Name)
+				p.run.cmd.Process.Kill()
+			}
+		case <-done:
+			if p.run != nil {
+				fmt.Printf("%s exited: %v, see %s\n", p.Name, p.run.err, p.logName())
+				if time.Since(p.run.started) >= stableAfter {
+					backoff = minBackoff
+				}
+				p.run = nil
+			}
+			fmt.Printf("Restarting %s in %s\n", p.Name, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-s.ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, maxBackoff)
+			failures = 0
+			r, err := p.start()
+			if err != nil {
+				fmt.Printf("Failed to restart %s: %v\n", p.Name, err)
+				continue
+			}
+			p.run = r
+			if err := p.waitReady(s.ctx, r); err != nil && s.ctx.Err() == nil {
+				fmt.Printf("%s did not come back: %v\n", p.Name, err)
+				r.cmd.Process.Kill()
+			}
+		}
+	}
+}
+
+// start: starts the process with its output going to its log file
+func (p *Process) start() (*run, error) {
+	var out io.Writer = os.Stdout
+	var logFile *os.File
+	if p.LogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(p.LogFile), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(p.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(f, "---- %s starting %s %v\n", time.Now().Format(time.RFC3339), p.Name, p.Args)
+		out, logFile = f, f
+	}
+	cmd := exec.Command(p.Path, p.Args...)
+	cmd.Dir = p.Dir
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		if logFile != nil {
+			logFile.Close()
+		}
+		return nil, err
+	}
+	fmt.Printf("Started %s with params: %v\n", p.Name, p.Args)
+	r := &run{cmd: cmd, started: time.Now(), done: make(chan struct{})}
+	go func() {
+		r.err = cmd.Wait()
+		if logFile != nil {
+			logFile.Close()
+		}
+		close(r.done)
+	}()
+	return r, nil
+}
+
+// waitReady: polls the Ready probe until it answers, the process exits or the timeout passes
+func (p *Process) waitReady(ctx context.Context, r *run) error {
+	if p.Ready == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.ReadyTimeout)
+	defer cancel()
+	ticker := time.NewTicker(readyPoll)
+	defer ticker.Stop()
+	var err error
+	for {
+		probeCtx, probeCancel := context.WithTimeout(ctx, 5*time.Second)
+		err = p.Ready(probeCtx)
+		probeCancel()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-r.done:
+			return fmt.Errorf("exited before it was ready: %v, see %s", r.err, p.logName())
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return errors.New("the supervisor is stopped")
+			}
+			return fmt.Errorf("not ready after %s: %w", p.ReadyTimeout, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop: interrupts the run and kills it if it has not exited after the stop timeout
+func (p *Process) stop(r *run) error {
+	select {
+	case <-r.done:
+		return nil
+	default:
+	}
+	// Windows cannot deliver an interrupt to another process
+	if runtime.GOOS == "windows" || r.cmd.Process.Signal(os.Interrupt) != nil {
+		r.cmd.Process.Kill()
+	}
+	select {
+	case <-r.done:
+		return nil
+	case <-time.After(p.StopTimeout):
+		fmt.Printf("%s did not stop after %s, killing it\n", p.Name, p.StopTimeout)
+		r.cmd.Process.Kill()
+		<-r.done
+		return fmt.Errorf("killed after %s", p.StopTimeout)
+	}
+}
+
+func (p *Process) logName() string {
+	if p.LogFile == "" {
+		return "the output above"
+	}
+	return p.LogFile
+}
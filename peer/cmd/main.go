@@ -2,27 +2,24 @@ package main
 
 import (
 	"fmt"
-	orcaAPI "orca-peer/internal/api"
 	orcaCLI "orca-peer/internal/cli"
+	"orca-peer/internal/config"
 	orcaHash "orca-peer/internal/hash"
 	"os"
-	"os/exec"
 )
 
-var boostrapNodeAddress string
-
 func main() {
-	boostrapNodeAddress = ""
-	publicKey, privateKey := orcaHash.LoadInKeys()
-	os.MkdirAll("./files/stored/", 0755)
-
-	cmd := exec.Command("./OrcaNetAPIServer")
-	cmd.Dir = "../coin/server/"
-	err := cmd.Start()
+	settings, err := config.Load(os.Args[1:])
 	if err != nil {
-		fmt.Printf("Error starting OrcaNetAPIServer: %s\n", err)
-		return
+		fmt.Println("Error loading settings:", err)
+		os.Exit(1)
 	}
-	fmt.Println("Started block chain api server")
-	orcaCLI.StartCLI(&boostrapNodeAddress, publicKey, privateKey, cmd, orcaAPI.InitServer)
+	os.MkdirAll(config.Path(settings.DataDir, "config"), 0755)
+	// Keys live in the data directory, so load them once it is known
+	publicKey, privateKey := orcaHash.LoadInKeys(settings.DataDir)
+	os.MkdirAll(config.Path(settings.DataDir, "files", "stored"), 0755)
+	os.MkdirAll(config.Path(settings.DataDir, "files", "requested"), 0755)
+	os.MkdirAll(config.Path(settings.DataDir, "files", "manifests"), 0755)
+
+	orcaCLI.StartCLI(settings, publicKey, privateKey)
 }
@@ -0,0 +1,47 @@
+package accounting
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Write entries as CSV, with a header row
+func WriteCSV(w io.Writer, entries []Entry) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "time", "kind", "amount", "txid", "address", "jobId", "fileKey", "peer", "note"})
+	for _, entry := range entries {
+		writer.Write([]string{
+			entry.Id,
+			entry.Time.Format(time.RFC3339Nano),
+			entry.Kind,
+			strconv.FormatFloat(entry.Amount, 'f', -1, 64),
+			entry.TxId,
+			entry.Address,
+			entry.JobId,
+			entry.FileKey,
+			entry.Peer,
+			entry.Note,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Write revenue as CSV, with a header row
+func WriteRevenueCSV(w io.Writer, revenue []Revenue) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "earning", "spending", "net", "entries"})
+	for _, period := range revenue {
+		writer.Write([]string{
+			period.Date,
+			strconv.FormatFloat(period.Earning, 'f', -1, 64),
+			strconv.FormatFloat(period.Spending, 'f', -1, 64),
+			strconv.FormatFloat(period.Net, 'f', -1, 64),
+			strconv.Itoa(period.Entries),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,375 @@
+package accounting
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Why coins moved, the sign of an entry's amount says which way
+const (
+	KindPurchase = "purchase" // Paid for chunks, files or subscriptions
+	KindSale     = "sale"     // Paid by a consumer
+	KindFee      = "fee"      // Paid to miners along with a purchase
+	KindRefund   = "refund"   // Paid back for an earlier purchase or sale, in either direction
+)
+
+// Every entry is kept in entriesBucket under its key, the time it was recorded followed by a
+// sequence number, so entries are ordered by time. indexBucket has a key for every reference an
+// entry has, <field>\x00<value>\x00<entry key>, so entries can be looked up by job, file, peer,
+// transaction or kind without reading the others.
+var (
+	entriesBucket = []byte("entries")
+	indexBucket   = []byte("index")
+)
+
+type Entry struct {
+	Id      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Amount  float64   `json:"amount"` // Coins, positive when this peer received them and negative when it spent them
+	Time    time.Time `json:"time"`
+	TxId    string    `json:"txid,omitempty"`
+	Address string    `json:"address,omitempty"` // The address that was paid
+	JobId   string    `json:"jobId,omitempty"`
+	FileKey string    `json:"fileKey,omitempty"`
+	Peer    string    `json:"peer,omitempty"` // The other side, a peer ID, or a public key for entries imported from files/transactions
+	Note    string    `json:"note,omitempty"`
+}
+
+// Which entries to return, zero fields match every entry
+type Filter struct {
+	From    time.Time
+	To      time.Time // Exclusive
+	Kind    string
+	JobId   string
+	FileKey string
+	Peer    string
+	TxId    string
+}
+
+// The entries of a ledger, in a bbolt database
+type Ledger struct {
+	db   *bolt.DB
+	fees func(txId string) (float64, error)
+}
+
+/*
+ * Open the ledger saved at path, creating it if it does not exist yet. Only one ledger can have a
+ * file open at a time, so every node keeps its ledger in its own data directory.
+ *
+ * Parameters:
+ *   path: File of the database
+ *   fees: Looks up the fee of a transaction this peer sent, purchases are followed by an entry for it
+ *
+ * Returns:
+ *   The ledger
+ *   An error, if any
+ */
+func Open(path string, fees func(txId string) (float64, error)) (*Ledger, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open ledger %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, indexBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Ledger{db: db, fees: fees}, nil
+}
+
+// Close the ledger
+func (ledger *Ledger) Close() error {
+	if ledger == nil {
+		return nil
+	}
+	return ledger.db.Close()
+}
+
+// Check the kind and the direction of an entry
+func validate(entry Entry) error {
+	switch entry.Kind {
+	case KindPurchase, KindFee:
+		if entry.Amount >= 0 {
+			return fmt.Errorf("a %s must spend coins, got %g", entry.Kind, entry.Amount)
+		}
+	case KindSale:
+		if entry.Amount <= 0 {
+			return fmt.Errorf("a sale must receive coins, got %g", entry.Amount)
+		}
+	case KindRefund:
+		if entry.Amount == 0 {
+			return errors.New("a refund must move coins")
+		}
+	default:
+		return errors.New("unknown kind of entry " + entry.Kind)
+	}
+	return nil
+}
+
+/*
+ * Record an entry. It is given an id, and the current time if it has none. A purchase paid in a
+ * transaction is followed by an entry for the transaction's fee, once per transaction.
+ *
+ * Parameters:
+ *   entry: The entry
+ *
+ * Returns:
+ *   The entry as it was recorded
+ *   An error, if any
+ */
+func (ledger *Ledger) Record(entry Entry) (Entry, error) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	err := validate(entry)
+	if err != nil {
+		return entry, err
+	}
+	err = ledger.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, &entry)
+	})
+	if err != nil {
+		return entry, err
+	}
+	if entry.Kind == KindPurchase && entry.TxId != "" && ledger.fees != nil {
+		ledger.recordFee(entry)
+	}
+	return entry, nil
+}
+
+// Record an entry, errors are printed. A nil ledger records nothing, so payments never fail on account of it.
+func (ledger *Ledger) Add(entry Entry) {
+	if ledger == nil {
+		return
+	}
+	_, err := ledger.Record(entry)
+	if err != nil {
+		fmt.Println("Error recording ledger entry:", err)
+	}
+}
+
+// Record the fee of the transaction that paid for a purchase, unless it already was
+func (ledger *Ledger) recordFee(purchase Entry) {
+	fees, err := ledger.Query(Filter{TxId: purchase.TxId, Kind: KindFee})
+	if err != nil || len(fees) > 0 {
+		return
+	}
+	fee, err := ledger.fees(purchase.TxId)
+	if err != nil {
+		fmt.Println("Unable to look up fee of transaction", purchase.TxId+":", err)
+		return
+	}
+	if fee > 0 {
+		fee = -fee
+	}
+	if fee == 0 {
+		return
+	}
+	ledger.Add(Entry{
+		Kind:    KindFee,
+		Amount:  fee,
+		Time:    purchase.Time,
+		TxId:    purchase.TxId,
+		JobId:   purchase.JobId,
+		FileKey: purchase.FileKey,
+		Peer:    purchase.Peer,
+	})
+}
+
+// Store an entry under a new key along with its references, inside of a bbolt transaction
+func put(tx *bolt.Tx, entry *Entry) error {
+	entries := tx.Bucket(entriesBucket)
+	sequence, err := entries.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(entry.Time.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], sequence)
+	entry.Id = hex.EncodeToString(key)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = entries.Put(key, data)
+	if err != nil {
+		return err
+	}
+	index := tx.Bucket(indexBucket)
+	for field, value := range references(*entry) {
+		if value == "" {
+			continue
+		}
+		err = index.Put(append(indexPrefix(field, value), key...), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func references(entry Entry) map[string]string {
+	return map[string]string{
+		"kind": entry.Kind,
+		"job":  entry.JobId,
+		"file": entry.FileKey,
+		"peer": entry.Peer,
+		"tx":   entry.TxId,
+	}
+}
+
+func indexPrefix(field string, value string) []byte {
+	return []byte(field + "\x00" + value + "\x00")
+}
+
+func (filter Filter) matches(entry Entry) bool {
+	if !filter.From.IsZero() && entry.Time.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !entry.Time.Before(filter.To) {
+		return false
+	}
+	for field, value := range references(Entry{Kind: filter.Kind, JobId: filter.JobId, FileKey: filter.FileKey, Peer: filter.Peer, TxId: filter.TxId}) {
+		if value != "" && references(entry)[field] != value {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * Look up entries. When the filter names a job, file, peer or transaction only the entries
+ * referencing it are read, otherwise only those in the time range are.
+ *
+ * Parameters:
+ *   filter: Which entries to return
+ *
+ * Returns:
+ *   The entries, oldest first
+ *   An error, if any
+ */
+func (ledger *Ledger) Query(filter Filter) ([]Entry, error) {
+	found := make([]Entry, 0)
+	err := ledger.db.View(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		keep := func(data []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			if filter.matches(entry) {
+				found = append(found, entry)
+			}
+			return nil
+		}
+
+		for _, field := range []string{"tx", "job", "file", "peer"} {
+			value := references(Entry{JobId: filter.JobId, FileKey: filter.FileKey, Peer: filter.Peer, TxId: filter.TxId})[field]
+			if value == "" {
+				continue
+			}
+			prefix := indexPrefix(field, value)
+			cursor := tx.Bucket(indexBucket).Cursor()
+			for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+				if err := keep(entries.Get(key[len(prefix):])); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		cursor := entries.Cursor()
+		start := make([]byte, 8)
+		if !filter.From.IsZero() {
+			binary.BigEndian.PutUint64(start, uint64(filter.From.UnixNano()))
+		}
+		for key, data := cursor.Seek(start); key != nil; key, data = cursor.Next() {
+			if !filter.To.IsZero() && binary.BigEndian.Uint64(key[:8]) >= uint64(filter.To.UnixNano()) {
+				break
+			}
+			if err := keep(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Id < found[j].Id })
+	return found, err
+}
+
+// Coins earned and spent over one day, month or year
+type Revenue struct {
+	Date     string  `json:"date"` // 2006-01-02, 2006-01 or 2006
+	Earning  float64 `json:"earning"`
+	Spending float64 `json:"spending"` // Purchases, fees and refunds paid, as a positive number
+	Net      float64 `json:"net"`
+	Entries  int     `json:"entries"`
+}
+
+// Layouts of the dates revenue is grouped by
+var periods = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+	"year":  "2006",
+}
+
+/*
+ * Sum up the coins earned and spent between two times, by day, month or year in local time.
+ *
+ * Parameters:
+ *   period: day, month or year
+ *   from: Start of the range, the zero time for every entry until to
+ *   to: End of the range, exclusive, the zero time for every entry since from
+ *
+ * Returns:
+ *   The revenue of every period with an entry, oldest first
+ *   An error, if any
+ */
+func (ledger *Ledger) Revenue(period string, from time.Time, to time.Time) ([]Revenue, error) {
+	layout, ok := periods[period]
+	if !ok {
+		return nil, errors.New("period must be day, month or year, got " + period)
+	}
+	entries, err := ledger.Query(Filter{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	revenue := make([]Revenue, 0)
+	for _, entry := range entries {
+		date := entry.Time.Local().Format(layout)
+		if len(revenue) == 0 || revenue[len(revenue)-1].Date != date {
+			revenue = append(revenue, Revenue{Date: date})
+		}
+		current := &revenue[len(revenue)-1]
+		if entry.Amount > 0 {
+			current.Earning += entry.Amount
+		} else {
+			current.Spending -= entry.Amount
+		}
+		current.Net += entry.Amount
+		current.Entries++
+	}
+	return revenue, nil
+}
@@ -0,0 +1,136 @@
+package accounting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Transactions used to be saved as one JSON file each in files/transactions, named by the time
+// they were made, or all together in files/transactions/transactions.json.
+type legacyData struct {
+	Bytes               []byte  `json:"bytes"`
+	UnlockedTransaction []byte  `json:"transaction"`
+	PublicKey           string  `json:"public_key"`
+	Date                string  `json:"date"`
+	Cost                float64 `json:"cost"`
+}
+
+type legacyTransaction struct {
+	TransactionData legacyData `json:"transaction"`
+	TransactionDate string     `json:"date"`
+}
+
+// Turn a saved transaction into an entry. The payer signed it, so it was a purchase if this peer signed it and a sale otherwise.
+func legacyEntry(data legacyData, name string, publicKey string) (Entry, error) {
+	var price struct {
+		Uuid string `json:"uuid"`
+	}
+	json.Unmarshal(data.UnlockedTransaction, &price)
+	timestamp, err := time.Parse(time.RFC3339Nano, data.Date)
+	if err != nil {
+		timestamp, err = time.Parse(time.RFC3339Nano, name)
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("transaction %s has no date", name)
+	}
+	entry := Entry{
+		Kind:   KindSale,
+		Amount: data.Cost,
+		Time:   timestamp.UTC(),
+		Peer:   data.PublicKey,
+		Note:   "imported transaction " + price.Uuid,
+	}
+	if data.PublicKey == publicKey {
+		entry.Kind = KindPurchase
+		entry.Amount = -data.Cost
+		entry.Peer = ""
+	}
+	return entry, validate(entry)
+}
+
+/*
+ * Move the transactions saved in files/transactions into the ledger. The files are removed once
+ * they are recorded, ones that cannot be read are left alone.
+ *
+ * Parameters:
+ *   dir: The files/transactions directory
+ *   publicKey: This peer's public key as PEM, it signed the transactions it paid
+ *
+ * Returns:
+ *   How many transactions were imported
+ *   An error, if any
+ */
+func (ledger *Ledger) ImportLegacy(dir string, publicKey string) (int, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	entries := make([]Entry, 0)
+	imported := make([]string, 0)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Println("Error reading file:", err)
+			continue
+		}
+		var transactions []legacyTransaction
+		if file.Name() == "transactions.json" {
+			err = json.Unmarshal(content, &transactions)
+		} else {
+			transactions = []legacyTransaction{{TransactionDate: file.Name()}}
+			err = json.Unmarshal(content, &transactions[0].TransactionData)
+		}
+		if err != nil {
+			fmt.Println("Error unmarshaling data:", err)
+			continue
+		}
+		fileEntries := make([]Entry, 0, len(transactions))
+		for _, transaction := range transactions {
+			entry, err := legacyEntry(transaction.TransactionData, transaction.TransactionDate, publicKey)
+			if err != nil {
+				break
+			}
+			fileEntries = append(fileEntries, entry)
+		}
+		if len(fileEntries) != len(transactions) {
+			fmt.Println("Unable to import", path)
+			continue
+		}
+		entries = append(entries, fileEntries...)
+		imported = append(imported, path)
+	}
+	if len(imported) == 0 {
+		return 0, nil
+	}
+
+	err = ledger.db.Update(func(tx *bolt.Tx) error {
+		for i := range entries {
+			if err := put(tx, &entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range imported {
+		err = os.Remove(path)
+		if err != nil {
+			fmt.Println("Error removing file:", err)
+		}
+	}
+	return len(entries), nil
+}
@@ -6,10 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	orcaCLI "orca-peer/internal/cli"
-	"orca-peer/internal/fileshare"
+	"orca-peer/internal/config"
 	orcaHash "orca-peer/internal/hash"
-	orcaJobs "orca-peer/internal/jobs"
 	orcaMining "orca-peer/internal/mining"
 	"orca-peer/internal/server"
 	"os"
@@ -23,11 +21,29 @@ type UploadFileJSONBody struct {
 	fileData http.File
 }
 
-var backend *Backend
-var peers *PeerStorage
-var publicKey *rsa.PublicKey
-var privateKey *rsa.PrivateKey
-var storedFileInfoMap map[string]fileshare.FileInfo
+// The HTTP API the GUI talks to, it serves the files of one market peer
+type API struct {
+	backend    *Backend
+	peers      *PeerStorage
+	publicKey  *rsa.PublicKey
+	privateKey *rsa.PrivateKey
+	market     *server.FileShareServerNode
+	ip         string
+	port       int64
+}
+
+// Create the API of a market peer, ip and port are the address files are listed under
+func NewAPI(market *server.FileShareServerNode, publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey, ip string, port int64) *API {
+	return &API{
+		backend:    NewBackend(),
+		peers:      NewPeerStorage(),
+		publicKey:  publicKey,
+		privateKey: privateKey,
+		market:     market,
+		ip:         ip,
+		port:       port,
+	}
+}
 
 type GetFileJSONResponseBody struct {
 	Filename    string   `json:"name"`
@@ -36,7 +52,7 @@ type GetFileJSONResponseBody struct {
 	Producers   []string `json:"listProducers"`
 }
 
-func getFile(w http.ResponseWriter, r *http.Request) {
+func (a *API) getFile(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
 	queryParams := r.URL.Query()
 	hash := queryParams.Get("hash")
@@ -61,9 +77,10 @@ func getFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	fileaddress = ""
-	orcaFileInfo, ok := storedFileInfoMap[hash]
+	orcaFileInfo, ok := a.market.StoredFileInfoMap[hash]
 	if !ok {
 		http.Error(w, "Specified hash is not in orcastore fileshare server node list", http.StatusBadRequest)
+		return
 	}
 
 	hashes := orcaFileInfo.ChunkHashes
@@ -72,8 +89,8 @@ func getFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := os.Stat("files/stored/" + hashes[chunkIndexInt]); !os.IsNotExist(err) {
-		fileaddress = "files/stored/" + hashes[chunkIndexInt]
+	if _, err := os.Stat(config.Path(a.market.DataDir(), "files", "stored", hashes[chunkIndexInt])); !os.IsNotExist(err) {
+		fileaddress = config.Path(a.market.DataDir(), "files", "stored", hashes[chunkIndexInt])
 	}
 
 	if fileaddress != "" {
@@ -131,7 +148,7 @@ type UploadFileReq struct {
 	Price    int64  `json:"price"`
 }
 
-func uploadFile(w http.ResponseWriter, r *http.Request) {
+func (a *API) uploadFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		var payload UploadFileReq
 		decoder := json.NewDecoder(r.Body)
@@ -141,7 +158,7 @@ func uploadFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		fileName := filepath.Base(payload.FilePath)
-		hashKey, _, err := orcaHash.SaveChunkedFile(payload.FilePath, fileName)
+		hashKey, _, err := orcaHash.SaveChunkedFile(a.market.DataDir(), payload.FilePath, fileName)
 		if err != nil {
 			http.Error(w, "Unable to create chunked file, maybe filepath doesnt exist?", http.StatusInternalServerError)
 			return
@@ -153,7 +170,7 @@ func uploadFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer sourceFile.Close()
-		destinationFile, err := os.Create("./files/" + fileName)
+		destinationFile, err := os.Create(config.Path(a.market.DataDir(), "files", fileName))
 		if err != nil {
 			fmt.Println("Error creating destination file:", err)
 			return
@@ -165,7 +182,7 @@ func uploadFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = server.SetupRegisterFile(payload.FilePath, fileName, payload.Price, orcaCLI.Ip, int32(orcaCLI.Port))
+		err = a.market.SetupRegisterFile(payload.FilePath, fileName, payload.Price, a.ip, int32(a.port))
 		if err != nil {
 			http.Error(w, "Unable to store file on DHT", http.StatusInternalServerError)
 			return
@@ -188,7 +205,7 @@ type WriteFileJSONBody struct {
 	OriginalFileName string `json:"originalFileName"`
 }
 
-func writeFile(w http.ResponseWriter, r *http.Request) {
+func (a *API) writeFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		contentType := r.Header.Get("Content-Type")
 		switch contentType {
@@ -200,7 +217,7 @@ func writeFile(w http.ResponseWriter, r *http.Request) {
 				writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
 				return
 			}
-			backend.UploadFile(payload.Base64File, payload.OriginalFileName, payload.Filesize)
+			a.backend.UploadFile(payload.Base64File, payload.OriginalFileName, payload.Filesize)
 		default:
 			w.WriteHeader(http.StatusBadRequest)
 			writeStatusUpdate(w, "Request must have the content header set as application/json")
@@ -214,7 +231,7 @@ func writeFile(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func handleFileRoute(w http.ResponseWriter, r *http.Request) {
+func (a *API) handleFileRoute(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodDelete {
 		path := r.URL.Path
 		parts := strings.Split(path, "/")
@@ -223,7 +240,7 @@ func handleFileRoute(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		hash := parts[2]
-		filePath := "./files/" + hash
+		filePath := config.Path(a.market.DataDir(), "files", hash)
 		if _, err := os.Stat(filePath); err == nil {
 			err := os.Remove(filePath)
 			if err != nil {
@@ -252,7 +269,7 @@ func handleFileRoute(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		hash := parts[2]
-		holders, err := server.SetupCheckHolders(hash)
+		holders, err := a.market.SetupCheckHolders(hash)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			writeStatusUpdate(w, "Unable to find holders of this file.")
@@ -288,7 +305,7 @@ type GetFileJSONBody struct {
 	Hash     string `json:"hash"`
 }
 
-func deleteFile(w http.ResponseWriter, r *http.Request) {
+func (a *API) deleteFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodDelete {
 
 		contentType := r.Header.Get("Content-Type")
@@ -308,8 +325,8 @@ func deleteFile(w http.ResponseWriter, r *http.Request) {
 				writeStatusUpdate(w, "Missing Filename and CID values inside of the payload.")
 				return
 			}
-			fileDir := "./files/"
-			filePath := "./files/" + payload.Hash
+			fileDir := config.Path(a.market.DataDir(), "files")
+			filePath := config.Path(a.market.DataDir(), "files", payload.Hash)
 
 			// Check if the file exists in the "stored" directory
 			storedFilePath := filepath.Join(fileDir, "stored", payload.Hash)
@@ -347,24 +364,19 @@ func deleteFile(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func InitServer(fileInfoMap *map[string]fileshare.FileInfo) {
-	storedFileInfoMap = *fileInfoMap
-	backend = NewBackend()
-	peers = NewPeerStorage()
+// Register the routes of the API on mux
+func (a *API) RegisterRoutes(mux *http.ServeMux) {
 	fmt.Println("Settig up API Routes")
-	publicKey, privateKey = orcaHash.LoadInKeys()
-	orcaJobs.InitJobRoutes()
-	orcaMining.InitDeviceTracker()
-	http.HandleFunc("/file/", handleFileRoute)
-	http.HandleFunc("/upload", uploadFile)
-	http.HandleFunc("/get-file", getFile)
-	http.HandleFunc("/upload-file", uploadFile)
-	http.HandleFunc("/delete-file", deleteFile)
-
-	http.HandleFunc("/writeFile", writeFile)
-	http.HandleFunc("/sendMoney", sendMoney)
-	http.HandleFunc("/getLocation", getLocation)
-	http.HandleFunc("/job-peer", JobPeerHandler)
-	http.HandleFunc("/device", orcaMining.PutDeviceHandler)
-	http.HandleFunc("/device_list", orcaMining.PutDeviceHandler)
+	mux.HandleFunc("/file/", a.handleFileRoute)
+	mux.HandleFunc("/upload", a.uploadFile)
+	mux.HandleFunc("/get-file", a.getFile)
+	mux.HandleFunc("/upload-file", a.uploadFile)
+	mux.HandleFunc("/delete-file", a.deleteFile)
+
+	mux.HandleFunc("/writeFile", a.writeFile)
+	mux.HandleFunc("/sendMoney", a.sendMoney)
+	mux.HandleFunc("/getLocation", getLocation)
+	mux.HandleFunc("/job-peer", a.JobPeerHandler)
+	mux.HandleFunc("/device", orcaMining.PutDeviceHandler)
+	mux.HandleFunc("/device_list", orcaMining.PutDeviceHandler)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	orcaClient "orca-peer/internal/client"
+	"orca-peer/internal/config"
 	orcaStatus "orca-peer/internal/status"
 	"os"
 )
@@ -22,7 +23,7 @@ type SendMoneyJSONRequest struct {
 	ServerPort string  `json:"port"`
 }
 
-func sendMoney(w http.ResponseWriter, r *http.Request) {
+func (a *API) sendMoney(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		contentType := r.Header.Get("Content-Type")
 		switch contentType {
@@ -35,7 +36,7 @@ func sendMoney(w http.ResponseWriter, r *http.Request) {
 				writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
 				return
 			}
-			orcaClient.SendTransaction(payload.Amount, payload.ServerIp, payload.ServerPort, publicKey, privateKey)
+			orcaClient.SendTransaction(payload.Amount, payload.ServerIp, payload.ServerPort, a.publicKey, a.privateKey)
 			return
 		default:
 			w.WriteHeader(http.StatusBadRequest)
@@ -103,7 +104,7 @@ func getLocation(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func hashFile(w http.ResponseWriter, r *http.Request) {
+func (a *API) hashFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		contentType := r.Header.Get("Content-Type")
 		switch contentType {
@@ -116,7 +117,7 @@ func hashFile(w http.ResponseWriter, r *http.Request) {
 				writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
 				return
 			}
-			fileData, err := os.ReadFile("files/" + payload.Filepath)
+			fileData, err := os.ReadFile(config.Path(a.market.DataDir(), "files", payload.Filepath))
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				writeStatusUpdate(w, "Failed to read in file from given path")
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	orcaJob "orca-peer/internal/jobs"
-	orcaServer "orca-peer/internal/server"
 )
 
 type JobPeerResPayload struct {
@@ -17,16 +16,16 @@ type JobPeerResPayload struct {
 	Price             string `json:"price"`
 }
 
-func JobPeerHandler(w http.ResponseWriter, r *http.Request) {
+func (a *API) JobPeerHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		queryParams := r.URL.Query()
 		filehash := queryParams.Get("fileHash")
 		peerId := queryParams.Get("peer")
-		peers := orcaServer.GetPeerTable()
+		peers := a.market.GetPeerTable()
 		if val, ok := peers[peerId]; ok {
 			var currJob orcaJob.Job
 			found := false
-			for _, job := range orcaJob.Manager.Jobs {
+			for _, job := range a.market.Jobs.Jobs {
 				if job.FileHash == filehash {
 					currJob = job
 					found = true
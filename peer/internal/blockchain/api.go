@@ -1,178 +1,197 @@
-package blockchain
-
-import (
-	"bufio"
-	"errors"
-	"fmt"
-	"io"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime"
-	"strconv"
-	"strings"
-)
-
-const (
-	orcaNetPath    string = "./OrcaNet/OrcaNet"
-	btcctlPath     string = "./OrcaNet/cmd/btcctl/btcctl"
-	orcaWalletPath string = "./OrcaWallet/btcwallet"
-)
-
-var cmdProcess *exec.Cmd
-
-func printOutput(r io.Reader) {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		fmt.Println(scanner.Text())
-	}
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error reading stream: %v\n", err)
-	}
-}
-
-// startOrcaWallet: starts the OrcaWallet
-func StartOrcaWallet() (*exec.Cmd, error) {
-	// check for the existence of the executable
-	_, err := os.Stat(orcaWalletPath)
-	if os.IsNotExist(err) {
-		fmt.Println("Cannot find Orcawallet executable")
-		return nil, err
-	}
-
-	cmd := exec.Command(orcaWalletPath)
-	if err := cmd.Start(); err != nil {
-		fmt.Println(err)
-		fmt.Println("failed to start wallet executable")
-		return nil, err
-	}
-	fmt.Println("Wallet started successfully")
-	return cmd, err
-}
-
-// getBtcdConfFilePath returns the file path for btcd.conf based on the user's OS
-func getBtcdConfFilePath() string {
-	const defaultConfigFilename = "btcd.conf"
-	homeDir := getUserHomeDir()
-	if homeDir == "" {
-		return "" // Return empty string if the home directory can't be determined
-	}
-
-	// Determine the application data directory based on the operating system
-	var appDataDir string
-	switch runtime.GOOS {
-	case "windows":
-		appDataDir = filepath.Join(homeDir, "AppData", "Roaming", "Btcd")
-	case "darwin": // macOS
-		appDataDir = filepath.Join(homeDir, "Library", "Application Support", "Btcd")
-	case "linux":
-		appDataDir = filepath.Join(homeDir, ".btcd")
-	default:
-		appDataDir = filepath.Join(homeDir, ".btcd") // Default to a Unix-style hidden directory
-	}
-
-	return filepath.Join(appDataDir, defaultConfigFilename)
-}
-
-// getUserHomeDir returns the home directory of the current user
-func getUserHomeDir() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Println("Error getting user home directory:", err)
-		return ""
-	}
-	return homeDir
-}
-
-// returns an array [rpcuser, rpcpass]
-func readRPCInfo(path string) ([]string, error) {
-	body, err := os.ReadFile(path)
-
-	if err != nil {
-		return nil, fmt.Errorf("error reading the btcd.conf file")
-	}
-
-	content := string(body)
-	var rpcInfo []string
-	// find the line with "rpcuser" and "rpcpass"
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "rpcuser") || strings.HasPrefix(line, "rpcpass") {
-			parts := strings.Split(line, "=")
-			// if len(parts) == 2 {
-			// 	fmt.Println(parts)
-			// 	rpcInfo = append(rpcInfo, strings.TrimSpace(parts[1]))
-			// }
-			rpcInfo = append(rpcInfo, strings.TrimSpace(parts[1]))
-		}
-	}
-
-	if len(rpcInfo) < 2 {
-		return nil, fmt.Errorf("error finding rpcuser and rpc pass")
-	}
-	return rpcInfo, nil
-}
-
-// callBtcctlCmd: calls a Btcctl command Exactly as specified in string param, and returns the stdout of btcctl as a string
-// its a singular string, but you can pass as many arguments, we will split the arguments in this fn
-func CallBtcctlCmd(cmdStr string) (string, error) {
-	// get the rpc values
-	rpcInfo, err := readRPCInfo(getBtcdConfFilePath())
-	if err != nil {
-		return "", fmt.Errorf("failed to get rpc info")
-	}
-	fmt.Println(rpcInfo)
-	params := strings.Split(cmdStr, " ")
-	params = append(params, "--rpcuser="+strings.TrimSpace(rpcInfo[0])+"=", "--rpcpass="+strings.TrimSpace(rpcInfo[1])+"=")
-
-	fmt.Println(params)
-	cmd := exec.Command(btcctlPath, params...)
-	// get the stdout of cmd, CAN HANG but shouldn't be a problem in a btcctl command
-	stdout, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to execute btcctl commands '%s': %s, error: %v", cmdStr, stdout, err)
-	}
-	fmt.Println(err)
-	return string(stdout), nil
-}
-
-func unlockWallet(walletPass string) error {
-	command := fmt.Sprintf("--wallet walletpassphrase %s 100", walletPass)
-	stdout, err := CallBtcctlCmd(command)
-	if err != nil {
-		return fmt.Errorf("failed to unlock wallet: %s, error: %v", stdout, err)
-	}
-	return nil
-}
-
-func sendCoins(numCoins string, address string) error {
-	command := fmt.Sprintf("--wallet sendtoaddress %s %s", address, numCoins)
-	stdout, err := CallBtcctlCmd(command)
-	if err != nil {
-		return fmt.Errorf("failed to send coins: %s, error: %v", stdout, err)
-	}
-	return nil
-}
-
-// sendToAddress: endpoint to send n coins to an address
-// if you want to send coins to a specific wallet, ask the recepient to getNewAddress and pass that address to the query string
-// Usage: make a JSON request with 2 fields "coins" and "address"
-func SendToAddress(coins string, address string, senderWalletPass string) error {
-	if coins == "" || address == "" || senderWalletPass == "" {
-		return errors.New("missing parameter")
-	}
-
-	if _, err := strconv.ParseFloat(coins, 64); err != nil {
-		return errors.New("invalid coin amount")
-	}
-
-	if err := unlockWallet(senderWalletPass); err != nil {
-		return errors.New("unable to unlock wallet")
-	}
-
-	if err := sendCoins(coins, address); err != nil {
-		return errors.New("unable to send coins")
-	}
-
-	return nil
-}
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coloshword/OrcaNetAPIServer/orcarpc"
+)
+
+// How long the wallet stays unlocked for a payment, in seconds
+const unlockTimeout = 100
+
+var (
+	walletClient *orcarpc.Client
+	walletMUT    sync.Mutex
+)
+
+// wallet: the client of the local OrcaWallet, connected on first use
+func wallet() (*orcarpc.Client, error) {
+	walletMUT.Lock()
+	defer walletMUT.Unlock()
+	if walletClient == nil {
+		config, err := orcarpc.WalletConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the wallet's RPC settings: %w", err)
+		}
+		walletClient, err = orcarpc.New(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return walletClient, nil
+}
+
+func unlockWallet(client *orcarpc.Client, walletPass string) error {
+	if err := client.WalletPassphrase(context.Background(), walletPass, unlockTimeout); err != nil {
+		return fmt.Errorf("failed to unlock wallet: %w", err)
+	}
+	return nil
+}
+
+func sendCoins(client *orcarpc.Client, numCoins float64, address string) (string, error) {
+	txId, err := client.SendToAddress(context.Background(), address, numCoins)
+	if err != nil {
+		return "", fmt.Errorf("failed to send coins: %w", err)
+	}
+	return txId, nil
+}
+
+// sendToAddress: endpoint to send n coins to an address
+// if you want to send coins to a specific wallet, ask the recepient to getNewAddress and pass that address to the query string
+// Usage: make a JSON request with 2 fields "coins" and "address"
+// Returns the id of the transaction that sent the coins
+func SendToAddress(coins string, address string, senderWalletPass string) (string, error) {
+	if coins == "" || address == "" || senderWalletPass == "" {
+		return "", errors.New("missing parameter")
+	}
+
+	amount, err := strconv.ParseFloat(coins, 64)
+	if err != nil {
+		return "", errors.New("invalid coin amount")
+	}
+
+	client, err := wallet()
+	if err != nil {
+		return "", err
+	}
+
+	if err := unlockWallet(client, senderWalletPass); err != nil {
+		return "", fmt.Errorf("unable to unlock wallet: %w", err)
+	}
+
+	txId, err := sendCoins(client, amount, address)
+	if err != nil {
+		return "", fmt.Errorf("unable to send coins: %w", err)
+	}
+
+	return txId, nil
+}
+
+// SendMany: pays several addresses out of the default account in one transaction, amounts are in coins
+// Returns the id of the transaction
+func SendMany(amounts map[string]float64, senderWalletPass string) (string, error) {
+	if len(amounts) == 0 || senderWalletPass == "" {
+		return "", errors.New("missing parameter")
+	}
+	for address, amount := range amounts {
+		if address == "" || amount <= 0 {
+			return "", errors.New("invalid payment to " + address)
+		}
+	}
+
+	client, err := wallet()
+	if err != nil {
+		return "", err
+	}
+
+	if err := unlockWallet(client, senderWalletPass); err != nil {
+		return "", fmt.Errorf("unable to unlock wallet: %w", err)
+	}
+
+	txId, err := client.SendMany(context.Background(), "default", amounts, 1)
+	if err != nil {
+		return "", fmt.Errorf("unable to send coins: %w", err)
+	}
+	return txId, nil
+}
+
+// GetNewAddress: returns a fresh address of this peer's wallet, so a payment to it can be told apart from any other
+func GetNewAddress() (string, error) {
+	client, err := wallet()
+	if err != nil {
+		return "", err
+	}
+	address, err := client.GetNewAddress(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("failed to get new address: %w", err)
+	}
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", errors.New("wallet returned an empty address")
+	}
+	return address, nil
+}
+
+// GetReceivedByAddress: returns the coins paid to one of this peer's addresses in transactions with at least
+// minConfirmations confirmations, with 0 a payment counts as soon as it reaches the mempool
+func GetReceivedByAddress(address string, minConfirmations int64) (float64, error) {
+	client, err := wallet()
+	if err != nil {
+		return 0, err
+	}
+	received, err := client.GetReceivedByAddress(context.Background(), address, minConfirmations)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get received amount: %w", err)
+	}
+	return received, nil
+}
+
+type TransactionDetail struct {
+	Address  string  `json:"address"`
+	Amount   float64 `json:"amount"`
+	Category string  `json:"category"`
+}
+
+type WalletTransaction struct {
+	TxId          string              `json:"txid"`
+	Amount        float64             `json:"amount"`
+	Fee           float64             `json:"fee"` // Negative, for transactions this peer sent
+	Confirmations int64               `json:"confirmations"`
+	TimeReceived  int64               `json:"timereceived"` // Unix time the wallet first saw the transaction
+	Details       []TransactionDetail `json:"details"`
+}
+
+// GetTransaction: looks up a transaction that involves this peer's wallet
+func GetTransaction(txId string) (*WalletTransaction, error) {
+	if txId == "" || strings.ContainsAny(txId, " \t\n") {
+		return nil, errors.New("invalid transaction id")
+	}
+	client, err := wallet()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.GetTransaction(context.Background(), txId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	transaction := WalletTransaction{TxId: result.TxID, Amount: result.Amount, Fee: result.Fee, Confirmations: result.Confirmations, TimeReceived: result.TimeReceived}
+	for _, detail := range result.Details {
+		transaction.Details = append(transaction.Details, TransactionDetail{Address: detail.Address, Amount: detail.Amount, Category: detail.Category})
+	}
+	return &transaction, nil
+}
+
+// TransactionFee: returns the fee this peer paid for a transaction it sent, as a negative number of coins
+func TransactionFee(txId string) (float64, error) {
+	transaction, err := GetTransaction(txId)
+	if err != nil {
+		return 0, err
+	}
+	return transaction.Fee, nil
+}
+
+// ReceivedAmount: returns how many coins a transaction paid to one of this peer's addresses
+func (transaction *WalletTransaction) ReceivedAmount(address string) float64 {
+	received := 0.0
+	for _, detail := range transaction.Details {
+		if detail.Category == "receive" && detail.Address == address {
+			received += detail.Amount
+		}
+	}
+	return received
+}
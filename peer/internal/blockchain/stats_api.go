@@ -1,155 +1,19 @@
 package blockchain
 
 import (
-	"bytes"
-	"crypto/rsa"
 	"encoding/json"
 	"fmt"
-	"io"
+	"math"
 	"net/http"
-	orcaHash "orca-peer/internal/hash"
-	orcaStatus "orca-peer/internal/status"
-	"os"
-	"sort"
-	"strings"
+	orcaAccounting "orca-peer/internal/accounting"
 	"time"
-
-	"github.com/google/uuid"
 )
 
-var publicKey *rsa.PublicKey
-
-type TransactionFile struct {
-	Bytes               []byte  `json:"bytes"`
-	UnlockedTransaction []byte  `json:"transaction"`
-	PublicKey           string  `json:"public_key"`
-	Date                string  `json:"date"`
-	Cost                float64 `json:"cost"`
+// Revenue and transaction routes of a wallet, answered from the peer's ledger
+type statsAPI struct {
+	ledger *orcaAccounting.Ledger
 }
 
-func getDailyRevenue(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		pubKeyString, err := orcaHash.ExportRsaPublicKeyAsPemStr(publicKey)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		totalSent := 0
-		totalReceived := 0
-		orcaStatus.LoadInTransactions()
-		timeThreshold := time.Now().Add(-24 * time.Hour)
-		for _, transaction := range orcaStatus.AllTransactions {
-			timestamp, err := time.Parse(time.RFC3339Nano, transaction.TransactionData.Date)
-			if err != nil {
-				fmt.Println("Error:", err)
-				continue
-			}
-			if timestamp.After(timeThreshold) {
-				if transaction.TransactionData.PublicKey == string(pubKeyString) {
-					totalSent += int(transaction.TransactionData.Cost)
-				} else {
-					totalReceived += int(transaction.TransactionData.Cost)
-				}
-			}
-		}
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-}
-
-type Revenue struct {
-	Date     string `json:"date"`
-	Earning  int    `json:"earning"`
-	Spending int    `json:"spending"`
-}
-
-func getMonthlyRevenue(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		pubKeyString, err := orcaHash.ExportRsaPublicKeyAsPemStr(publicKey)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		orcaStatus.LoadInTransactions()
-		timeThreshold := time.Now().Add(-24 * 30 * time.Hour)
-		hashMap := make(map[string]*Revenue)
-		for _, transaction := range orcaStatus.AllTransactions {
-			timestamp, err := time.Parse(time.RFC3339Nano, transaction.TransactionData.Date)
-			if err != nil {
-				fmt.Println("Error:", err)
-				continue
-			}
-			if timestamp.After(timeThreshold) {
-				key := timestamp.Month().String() + "/" + string(timestamp.Day())
-				value, ok := hashMap[key]
-				var rev *Revenue
-				rev = nil
-				if ok {
-					rev = value
-				} else {
-					rev = &Revenue{Date: key, Earning: 0, Spending: 0}
-					hashMap[key] = rev
-				}
-				if transaction.TransactionData.PublicKey == string(pubKeyString) {
-					rev.Spending += int(transaction.TransactionData.Cost)
-				} else {
-					rev.Earning += int(transaction.TransactionData.Cost)
-				}
-			}
-		}
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-}
-func getYearlyRevenue(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		pubKeyString, err := orcaHash.ExportRsaPublicKeyAsPemStr(publicKey)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		orcaStatus.LoadInTransactions()
-		timeThreshold := time.Now().Add(-24 * 365 * time.Hour)
-		hashMap := make(map[string]*Revenue)
-		for _, transaction := range orcaStatus.AllTransactions {
-			timestamp, err := time.Parse(time.RFC3339Nano, transaction.TransactionData.Date)
-			if err != nil {
-				fmt.Println("Error:", err)
-				continue
-			}
-			if timestamp.After(timeThreshold) {
-				key := timestamp.Month().String() + "/" + string(timestamp.Day())
-				value, ok := hashMap[key]
-				var rev *Revenue
-				rev = nil
-				if ok {
-					rev = value
-				} else {
-					rev = &Revenue{Date: key, Earning: 0, Spending: 0}
-					hashMap[key] = rev
-				}
-				if transaction.TransactionData.PublicKey == string(pubKeyString) {
-					rev.Spending += int(transaction.TransactionData.Cost)
-				} else {
-					rev.Earning += int(transaction.TransactionData.Cost)
-				}
-			}
-		}
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-}
-
-type TransactionFileData struct {
-	Bytes               []byte  `json:"bytes"`
-	UnlockedTransaction []byte  `json:"transaction"`
-	PublicKey           string  `json:"public_key"`
-	Date                string  `json:"date"`
-	Cost                float64 `json:"cost"`
-}
 type TransactionResponse struct {
 	Id       string `json:"id"`
 	Reciever string `json:"receiver"`
@@ -158,288 +22,228 @@ type TransactionResponse struct {
 	Reason   string `json:"reason"`
 	Date     string `json:"date"`
 }
-type Transaction struct {
-	Price     float64 `json:"price"`
-	Timestamp string  `json:"timestamp"`
-	Uuid      string  `json:"uuid"`
-}
 type LatestTransactionResponse struct {
 	WalletId     string                `json:"wallet_id"`
 	Transactions []TransactionResponse `json:"transactions"`
 }
 
-func getLatestTransactions(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		dir := "./files/transactions"
-		files, err := os.ReadDir(dir)
-		if err != nil {
-			http.Error(w, "error reading directory", http.StatusInternalServerError)
-			return
-		}
-		type FileWithTime struct {
-			Name     string
-			Modified time.Time
-		}
-		var filesWithTime []FileWithTime
-		for _, file := range files {
-			t, err := time.Parse(time.RFC3339Nano, file.Name())
-			if err != nil {
-				continue
-			}
-			filesWithTime = append(filesWithTime, FileWithTime{Name: file.Name(), Modified: t})
-		}
-		sort.Slice(filesWithTime, func(i, j int) bool {
-			return filesWithTime[i].Modified.Before(filesWithTime[j].Modified)
-		})
-		latestTransactions := make([]TransactionResponse, 0)
-		for idx, file := range filesWithTime {
-			if idx > 5 {
-				break
-			}
-			file, err := os.Open("./files/transactions/" + file.Name)
-			if err != nil {
-				http.Error(w, "transaction file does not exist", http.StatusInternalServerError)
-				return
-			}
-			defer file.Close()
-			fileContent, err := io.ReadAll(file)
-			if err != nil {
-				http.Error(w, "Error reading in file", http.StatusInternalServerError)
-				return
-			}
-			var data TransactionFileData
-			err = json.Unmarshal(fileContent, &data)
-			if err != nil {
-				http.Error(w, "Error unmarshaling JSON", http.StatusInternalServerError)
-				return
-			}
-			var transaction Transaction
-			err = json.Unmarshal(data.UnlockedTransaction, &transaction)
-			if err != nil {
-				fmt.Println("Error unmarshalling JSON:", err)
-				return
-			}
-			latestTransactions = append(latestTransactions, TransactionResponse{
-				Id:       transaction.Uuid,
-				Reciever: "",
-				Amount:   fmt.Sprintf("%f", transaction.Price),
-				Status:   "Success",
-				Reason:   "Auto-Transaction",
-				Date:     transaction.Timestamp,
-			})
-		}
-		response := LatestTransactionResponse{
-			WalletId:     "",
-			Transactions: latestTransactions,
-		}
-		jsonData, err := json.Marshal(response)
-		if err != nil {
-			http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		_, err = w.Write(jsonData)
-		if err != nil {
-			http.Error(w, "Error  marshalling JSON", http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// How many entries /wallet/transactions/latest returns
+const latestTransactions = 5
+
+func writeStatusUpdate(w http.ResponseWriter, message string) {
+	responseMsg := map[string]interface{}{
+		"status": message,
+	}
+	responseMsgJsonString, err := json.Marshal(responseMsg)
+	if err != nil {
+		fmt.Println("Error:", err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseMsgJsonString)
 }
-func getCompleteTransactions(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		dir := "./files/transactions"
-		files, err := os.ReadDir(dir)
-		if err != nil {
-			http.Error(w, "error reading directory", http.StatusInternalServerError)
-			return
-		}
-		type FileWithTime struct {
-			Name     string
-			Modified time.Time
-		}
-		var filesWithTime []FileWithTime
-		for _, file := range files {
-			t, err := time.Parse(time.RFC3339Nano, file.Name())
-			if err != nil {
-				continue
-			}
-			filesWithTime = append(filesWithTime, FileWithTime{Name: file.Name(), Modified: t})
-		}
-		allTransactions := make([]TransactionResponse, 0)
-		for _, file := range filesWithTime {
-			file, err := os.Open("./files/transactions/" + file.Name)
-			if err != nil {
-				http.Error(w, "transaction file does not exist", http.StatusInternalServerError)
-				return
-			}
-			defer file.Close()
-			fileContent, err := io.ReadAll(file)
-			if err != nil {
-				http.Error(w, "Error reading in file", http.StatusInternalServerError)
-				return
-			}
-			var data TransactionFileData
-			err = json.Unmarshal(fileContent, &data)
-			if err != nil {
-				http.Error(w, "Error unmarshaling JSON", http.StatusInternalServerError)
-				return
-			}
-			var transaction Transaction
-			err = json.Unmarshal(data.UnlockedTransaction, &transaction)
-			if err != nil {
-				fmt.Println("Error unmarshalling JSON:", err)
-				return
-			}
-			allTransactions = append(allTransactions, TransactionResponse{
-				Id:       transaction.Uuid,
-				Reciever: "",
-				Amount:   fmt.Sprintf("%f", transaction.Price),
-				Status:   "Success",
-				Reason:   "Auto-Transaction",
-				Date:     transaction.Timestamp,
-			})
-		}
-		response := LatestTransactionResponse{
-			WalletId:     "",
-			Transactions: allTransactions,
-		}
-		jsonData, err := json.Marshal(response)
-		if err != nil {
-			http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		_, err = w.Write(jsonData)
-		if err != nil {
-			http.Error(w, "Error  marshalling JSON", http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+
+func writeJSON(w http.ResponseWriter, result interface{}) {
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStatusUpdate(w, "Failed to convert JSON Data into a string")
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
 }
 
-type StatsRequest struct {
-	PublicKey string `json:"pub_key"`
-}
-type StatsResponse struct {
-	Id            string `json:"_id"`
-	PublicKey     string `json:"pub_key"`
-	IncomingSpeed string `json:"incoming_speed"`
-	OutgoingSpeed string `json:"outgoing_speed"`
+// Parse a time given as RFC 3339 or as a date in local time
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
 }
 
-func getStatsNetwork(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		// Decode the JSON payload from the request body into a MyRequest struct
-		var requestData StatsRequest
-		err := json.NewDecoder(r.Body).Decode(&requestData)
-		if err != nil {
-			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
-			return
+// The from and to query parameters of a request, from is start when it is not given and to is open ended
+func timeRange(r *http.Request, start time.Time) (time.Time, time.Time, error) {
+	from, to := start, time.Time{}
+	var err error
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = parseTime(value); err != nil {
+			return from, to, fmt.Errorf("invalid from: %s", value)
 		}
-		byteString := strings.Repeat("a", 8192)
-		data := strings.NewReader(byteString)
-		startTime := time.Now()
-		totalIncomingTime := float64(0)
-		testIterations := 10
-		for i := 0; i < testIterations; i++ {
-			response, err := http.Post("http://localhost:8082/speed/sender", "application/json", bytes.NewBuffer([]byte(byteString)))
-			if err != nil {
-				fmt.Println("Error sending POST request:", err)
-				return
-			}
-			defer response.Body.Close()
-			var responseData SpeedEndpointResponse
-			err = json.NewDecoder(response.Body).Decode(&responseData)
-			if err != nil {
-				fmt.Println("Error decoding JSON:", err)
-				return
-			}
-			totalIncomingTime += responseData.KbPerSecond
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = parseTime(value); err != nil {
+			return from, to, fmt.Errorf("invalid to: %s", value)
 		}
-		endTime := time.Now()
-		elapsedTime := endTime.Sub(startTime)
-		outgoingKbPerSecond := float64(data.Len()) / (elapsedTime.Seconds() * 1024)
-		incomingKbPerSecond := float64(totalIncomingTime / float64(testIterations))
-
-		newUUID := uuid.New()
-		uuidString := newUUID.String()
-		statsResponse := StatsResponse{
-			Id:            uuidString,
-			PublicKey:     "",
-			IncomingSpeed: fmt.Sprintf("%f", incomingKbPerSecond),
-			OutgoingSpeed: fmt.Sprintf("%f", outgoingKbPerSecond),
+	}
+	return from, to, nil
+}
+
+/*
+ * Route for the revenue of every day, month or year in a range, GET /wallet/revenue/<daily|monthly|yearly>.
+ * The range is given with from and to, RFC 3339 times or dates, and is the last 30 days, the last
+ * 12 months or every year when it is not. With format=csv the revenue is returned as CSV.
+ */
+func (api *statsAPI) revenueHandler(period string, start func(now time.Time) time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			writeStatusUpdate(w, "Only GET requests will be handled.")
+			return
 		}
-		jsonData, err := json.Marshal(statsResponse)
+		from, to, err := timeRange(r, start(time.Now()))
 		if err != nil {
-			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, err.Error())
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, err = w.Write(jsonData)
+		revenue, err := api.ledger.Revenue(period, from, to)
 		if err != nil {
-			http.Error(w, "Error writing response", http.StatusInternalServerError)
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, err.Error())
 			return
 		}
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"revenue-%s.csv\"", period))
+			w.WriteHeader(http.StatusOK)
+			orcaAccounting.WriteRevenueCSV(w, revenue)
+			return
+		}
+		writeJSON(w, revenue)
 	}
 }
 
-type SpeedEndpointResponse struct {
-	KbPerSecond float64 `json:"KbPerSecond"`
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
 
-func speedEndpointReceiver(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-	buffer := make([]byte, 1024)
-	totalBytesRead := 0
-	for {
-		n, err := r.Body.Read(buffer)
-		totalBytesRead += n
-		if err != nil {
-			break
+// Entries as the GUI's transaction list shows them
+func transactionResponses(entries []orcaAccounting.Entry) []TransactionResponse {
+	transactions := make([]TransactionResponse, 0, len(entries))
+	for _, entry := range entries {
+		id := entry.TxId
+		if id == "" {
+			id = entry.Id
 		}
+		transactions = append(transactions, TransactionResponse{
+			Id:       id,
+			Reciever: entry.Address,
+			Amount:   fmt.Sprintf("%f", math.Abs(entry.Amount)),
+			Status:   "Success",
+			Reason:   entry.Kind,
+			Date:     entry.Time.Format(time.RFC3339),
+		})
 	}
+	return transactions
+}
 
-	endTime := time.Now()
-	elapsedTime := endTime.Sub(startTime)
-	bytesPerSecond := float64(totalBytesRead) / (elapsedTime.Seconds() * 1024)
-	data := SpeedEndpointResponse{
-		KbPerSecond: bytesPerSecond,
+// GET /wallet/transactions/latest, the most recent entries of the ledger, newest first
+func (api *statsAPI) getLatestTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
-	jsonData, err := json.Marshal(data)
+	entries, err := api.ledger.Query(orcaAccounting.Filter{})
 	if err != nil {
-		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(jsonData)
+	latest := make([]orcaAccounting.Entry, 0, latestTransactions)
+	for i := len(entries) - 1; i >= 0 && len(latest) < latestTransactions; i-- {
+		latest = append(latest, entries[i])
+	}
+	writeJSON(w, LatestTransactionResponse{Transactions: transactionResponses(latest)})
+}
+
+// GET /wallet/revenue/complete, every entry of the ledger, oldest first
+func (api *statsAPI) getCompleteTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := api.ledger.Query(orcaAccounting.Filter{})
 	if err != nil {
-		http.Error(w, "Error writing response", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, LatestTransactionResponse{Transactions: transactionResponses(entries)})
+}
+
+/*
+ * Route for the ledger itself.
+ * GET /wallet/ledger exports entries as JSON, or as CSV with format=csv. They can be narrowed down
+ * with from, to, kind, jobId, fileKey, peer and txid.
+ * POST /wallet/ledger records an entry that no payment of this peer records by itself, such as a refund.
+ */
+func (api *statsAPI) ledgerHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		from, to, err := timeRange(r, time.Time{})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		query := r.URL.Query()
+		entries, err := api.ledger.Query(orcaAccounting.Filter{
+			From:    from,
+			To:      to,
+			Kind:    query.Get("kind"),
+			JobId:   query.Get("jobId"),
+			FileKey: query.Get("fileKey"),
+			Peer:    query.Get("peer"),
+			TxId:    query.Get("txid"),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		if query.Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"ledger.csv\"")
+			w.WriteHeader(http.StatusOK)
+			orcaAccounting.WriteCSV(w, entries)
+			return
+		}
+		writeJSON(w, entries)
+	case http.MethodPost:
+		var entry orcaAccounting.Entry
+		err := json.NewDecoder(r.Body).Decode(&entry)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
+			return
+		}
+		entry, err = api.ledger.Record(entry)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		writeJSON(w, entry)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only GET and POST requests will be handled.")
+	}
 }
 
-func InitBlockchainStats(publicKey *rsa.PublicKey) {
-	http.HandleFunc("/wallet/revenue/daily", getDailyRevenue)
-	http.HandleFunc("/wallet/revenue/monthly", getMonthlyRevenue)
-	http.HandleFunc("/wallet/revenue/yearly", getYearlyRevenue)
-
-	http.HandleFunc("/wallet/transactions/latest", getLatestTransactions)
-	http.HandleFunc("/wallet/revenue/complete", getCompleteTransactions)
-
-	http.HandleFunc("/stats/network", getStatsNetwork)
-
-	http.HandleFunc("/speed/sender", speedEndpointReceiver)
+// Register the wallet statistics routes on mux, they are answered from ledger
+func RegisterStatsRoutes(mux *http.ServeMux, ledger *orcaAccounting.Ledger) {
+	api := &statsAPI{ledger: ledger}
+	mux.HandleFunc("/wallet/revenue/daily", api.revenueHandler("day", func(now time.Time) time.Time {
+		return startOfDay(now).AddDate(0, 0, -29)
+	}))
+	mux.HandleFunc("/wallet/revenue/monthly", api.revenueHandler("month", func(now time.Time) time.Time {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -11, 0)
+	}))
+	mux.HandleFunc("/wallet/revenue/yearly", api.revenueHandler("year", func(now time.Time) time.Time {
+		return time.Time{}
+	}))
+
+	mux.HandleFunc("/wallet/transactions/latest", api.getLatestTransactions)
+	mux.HandleFunc("/wallet/revenue/complete", api.getCompleteTransactions)
+	mux.HandleFunc("/wallet/ledger", api.ledgerHandler)
 }
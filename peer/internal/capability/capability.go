@@ -0,0 +1,150 @@
+package capability
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"orca-peer/internal/config"
+	"orca-peer/internal/fileshare"
+	orcaToken "orca-peer/internal/token"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+const TokenExtension = ".cap"
+
+// Tokens are saved in files/capabilities inside of the data directory
+func TokenDirectory(dataDir string) string {
+	return config.Path(dataDir, "files", "capabilities")
+}
+
+/*
+ * Create a capability token granting a consumer access to a restricted file, signed by the publisher.
+ *
+ * Parameters:
+ *   fileKey: Key of the restricted file
+ *   consumerKey: Public key of the consumer's peer
+ *   duration: How long the token lasts, starting now
+ *   privKey: Private key of the publisher
+ *
+ * Returns:
+ *   A marshalled SignedCapabilityToken
+ *   The id of the token, used to revoke it
+ *   An error, if any
+ */
+func CreateToken(fileKey string, consumerKey libp2pcrypto.PubKey, duration time.Duration, privKey libp2pcrypto.PrivKey) ([]byte, string, error) {
+	publisherKeyBytes, err := libp2pcrypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, "", err
+	}
+	consumerKeyBytes, err := libp2pcrypto.MarshalPublicKey(consumerKey)
+	if err != nil {
+		return nil, "", err
+	}
+	idBytes := make([]byte, 16)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	now := time.Now().UTC()
+	tokenBytes, signature, err := orcaToken.Sign(&fileshare.CapabilityToken{
+		Id:           id,
+		FileKey:      fileKey,
+		PublisherKey: publisherKeyBytes,
+		ConsumerKey:  consumerKeyBytes,
+		NotBefore:    now.Unix(),
+		NotAfter:     now.Add(duration).Unix(),
+	}, privKey)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := proto.Marshal(&fileshare.SignedCapabilityToken{Token: tokenBytes, Signature: signature})
+	if err != nil {
+		return nil, "", err
+	}
+	return data, id, nil
+}
+
+// Unmarshal the token inside of a marshalled SignedCapabilityToken, without checking its signature
+func ParseToken(data []byte) (*fileshare.CapabilityToken, error) {
+	token := &fileshare.CapabilityToken{}
+	err := orcaToken.Parse(data, &fileshare.SignedCapabilityToken{}, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+/*
+ * Check that a token was signed by a publisher for a consumer and file, has not expired and was not revoked.
+ *
+ * Parameters:
+ *   data: A marshalled SignedCapabilityToken
+ *   publisherKey: Public key the token must be signed by
+ *   consumerKey: Public key of the peer presenting the token
+ *   fileKey: File the token must grant access to
+ *   revoked: Ids of revoked tokens, may be nil
+ *
+ * Returns:
+ *   The token
+ *   An error, if any
+ */
+func VerifyToken(data []byte, publisherKey libp2pcrypto.PubKey, consumerKey libp2pcrypto.PubKey, fileKey string, revoked map[string]bool) (*fileshare.CapabilityToken, error) {
+	token := &fileshare.CapabilityToken{}
+	err := orcaToken.Verify(data, &fileshare.SignedCapabilityToken{}, token, publisherKey)
+	if err != nil {
+		return nil, err
+	}
+	if token.GetFileKey() != fileKey {
+		return nil, errors.New("token is for another file")
+	}
+	if revoked[token.GetId()] {
+		return nil, errors.New("token has been revoked")
+	}
+	err = orcaToken.CheckHolder(token.GetConsumerKey(), consumerKey)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Returns the peer ID of the publisher that issued a token
+func Publisher(token *fileshare.CapabilityToken) (peer.ID, error) {
+	return orcaToken.Publisher(token)
+}
+
+// Save a token received from a publisher to the token directory of dataDir. The token must be signed by the
+// publisher it names and have a hex id, as CreateToken gives it.
+func SaveToken(dataDir string, data []byte) error {
+	token, err := ParseToken(data)
+	if err != nil {
+		return err
+	}
+	publisherKey, err := orcaToken.PublisherKey(token)
+	if err != nil {
+		return err
+	}
+	err = orcaToken.Verify(data, &fileshare.SignedCapabilityToken{}, token, publisherKey)
+	if err != nil {
+		return err
+	}
+	if _, err := hex.DecodeString(token.GetId()); err != nil || token.GetId() == "" {
+		return errors.New("token has an invalid id")
+	}
+	publisher, err := peer.IDFromPublicKey(publisherKey)
+	if err != nil {
+		return err
+	}
+	return orcaToken.Save(TokenDirectory(dataDir), publisher, token.GetId(), TokenExtension, data)
+}
+
+// Returns every token saved in dataDir that was issued by a publisher and has not expired yet
+func LoadTokens(dataDir string, publisher peer.ID) [][]byte {
+	return orcaToken.Load(TokenDirectory(dataDir), publisher, TokenExtension, func(data []byte) (orcaToken.Claims, error) {
+		return ParseToken(data)
+	})
+}
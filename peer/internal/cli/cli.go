@@ -2,154 +2,55 @@ package cli
 
 import (
 	"bufio"
+	"context"
 	"crypto/rsa"
-	"crypto/x509"
-	"log"
 
 	// "crypto/x509"
-	"encoding/json"
 	"fmt"
-	"io"
 
 	// "log"
-	"net"
-	"net/http"
-	orcaBlockchain "orca-peer/internal/blockchain"
+	orcaCapability "orca-peer/internal/capability"
 	orcaClient "orca-peer/internal/client"
+	"orca-peer/internal/config"
 	"orca-peer/internal/fileshare"
 	orcaHash "orca-peer/internal/hash"
+	orcaManifest "orca-peer/internal/manifest"
+	"orca-peer/internal/node"
 	"orca-peer/internal/relay"
 	"orca-peer/internal/server"
 	orcaServer "orca-peer/internal/server"
+	orcaSpeedtest "orca-peer/internal/speedtest"
 	orcaStatus "orca-peer/internal/status"
 	orcaStore "orca-peer/internal/store"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/libp2p/go-libp2p"
 	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
-	"github.com/multiformats/go-multiaddr"
 	"github.com/spf13/cobra"
 )
 
-var (
-	Ip     string
-	Port   int64
-	Client *orcaClient.Client
-)
-
-type Settings struct {
-	MarketRPCPort      string `json:"RPC_PORT"`
-	MarketDHTPort      string `json:"DHT_PORT"`
-	HTTPAPIPort        string `json:"API_PORT"`
-	BlockchainPassword string `json:"BLOCKCHAIN_PW"`
-}
-
-func loadSetttings() (Settings, error) {
-	jsonFile, err := os.Open("config/settings.json")
-	if err != nil {
-		return Settings{}, err
+func StartCLI(settings config.Settings, pubKey *rsa.PublicKey, privKey *rsa.PrivateKey) {
+	if settings.BlockchainPassword == "" {
+		settings.BlockchainPassword = getPassKey()
 	}
-	defer jsonFile.Close()
-	byteValue, err := io.ReadAll(jsonFile)
+	n, err := node.New(settings, pubKey, privKey)
 	if err != nil {
 		fmt.Println(err)
-		return Settings{}, err
-	}
-	var settings Settings
-	err = json.Unmarshal(byteValue, &settings)
-	if err != nil {
-		return Settings{}, err
-	}
-	for settings.MarketRPCPort == "" || settings.MarketDHTPort == "" || settings.HTTPAPIPort == "" || settings.BlockchainPassword == "" {
-		if settings.MarketRPCPort == "" {
-			settings.MarketRPCPort = getPort("Market RPC Server")
-		}
-		if settings.MarketDHTPort == "" {
-			settings.MarketDHTPort = getPort("Market DHT Host")
-		}
-		if settings.HTTPAPIPort == "" {
-			settings.HTTPAPIPort = getPort("HTTP Server")
-		}
-		if settings.BlockchainPassword == "" {
-			settings.BlockchainPassword = getPassKey()
-		}
-	}
-	return settings, nil
-}
-func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.PrivateKey, orcaNetAPIProc *exec.Cmd, startAPIRoutes func(*map[string]fileshare.FileInfo)) {
-	settings, err := loadSetttings()
-	if err != nil {
-		log.Fatal("unable to load settings")
-	}
-	serverReady := make(chan bool)
-	confirming := false
-	confirmation := ""
-	locationJsonString := orcaStatus.GetLocationData()
-	var locationJson map[string]interface{}
-	err = json.Unmarshal([]byte(locationJsonString), &locationJson)
-	if err != nil {
-		fmt.Println("Unable to establish user IP, please try again")
 		return
 	}
-	Ip = locationJson["ip"].(string)
-	Port, err = strconv.ParseInt(settings.HTTPAPIPort, 10, 64)
+	err = n.Start(context.Background())
 	if err != nil {
-		fmt.Println("Error parsing in port: must be a integer.", err)
+		fmt.Println("Unable to start node:", err)
 		return
 	}
+	defer n.Stop()
+	port := int32(settings.HTTPAPIPort)
 
-	//Get libp2p wrapped privKey
-	libp2pPrivKey, _, err := libp2pcrypto.KeyPairFromStdKey(privKey)
-	if err != nil {
-		panic("Could not generate libp2p wrapped key from standard private key.")
-	}
-
-	//Construct multiaddr from string and create host to listen on it
-	sourceMultiAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%s", settings.MarketDHTPort))
-	opts := []libp2p.Option{
-		libp2p.ListenAddrStrings(sourceMultiAddr.String()),
-		libp2p.Identity(libp2pPrivKey), //derive id from private key
-		libp2p.EnableRelay(),
-	}
-
-	host, err := libp2p.New(opts...)
-	if err != nil {
-		panic(err)
-	}
-
-	hostMultiAddr := ""
-	fmt.Printf("\nlibp2p DHT Host ID: %s\n", host.ID())
-	fmt.Println("DHT Market Multiaddr (if in server mode):")
-	for _, addr := range host.Addrs() {
-		if !strings.Contains(fmt.Sprintf("%s", addr), "127.0.0.1") {
-			hostMultiAddr = fmt.Sprintf("%s/p2p/%s", addr, host.ID())
-		}
-		fmt.Printf("%s/p2p/%s\n", addr, host.ID())
-	}
-
-	Client = orcaClient.NewClient("files/names/")
-	Client.PrivateKey = privKey
-	Client.PublicKey = pubKey
-	Client.Host = host
-	if detectNAT() {
-		// if true {
-		addresses := []string{
-			"/ip4/194.113.73.99/tcp/44981/p2p/QmZyLQd66AYP9sPxGbdjqZ5Ys76ZBaFFJy5PwzXxosXz74",
-			"/ip4/209.151.148.27/tcp/44981/p2p/QmcAhU6MTzDeDvPhJgbk83PpT5dyB5LrZdSYaZW9K7gJm1",
-			"/ip4/209.151.155.108/tcp/44981/p2p/QmYGQgBaiukGEUYqsoLAVerqBooERL13btPnLDogshiWi4",
-		}
-		selected := addresses[0]
-		hostMultiAddr = fmt.Sprintf("%s/p2p-circuit/p2p/%s", selected, host.ID())
-		//hostMultiAddr, _ = multiaddr.NewMultiaddr(selected + "/p2p-circuit/p2p/" + host.ID())
-	}
-	go orcaServer.StartServer(orcaServer.Settings(settings), serverReady, &confirming, &confirmation, libp2pPrivKey, Client, startAPIRoutes, host, hostMultiAddr)
-	<-serverReady
-	orcaBlockchain.InitBlockchainStats(pubKey)
 	var cmdLocation = &cobra.Command{
 		Use:   "location",
 		Short: "Gets current location of THIS peer node",
@@ -159,12 +60,24 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 		},
 	}
 
+	var manifestSource string
 	var cmdGet = &cobra.Command{
-		Use:   "get [fileHash | fileName]",
+		Use:   "get [fileHash | fileName] [relativePath]",
 		Short: "Get either a hash of a file or an entire file from the DHT network.",
-		Args:  cobra.MinimumNArgs(1),
+		Long: `Get either a hash of a file or an entire file from the DHT network.
+				If the hash belongs to a directory, every file inside of it is bought and the directory is
+				reconstructed inside of files/requested. Pass a relative path to only buy that file or sub directory.`,
+		Args: cobra.MaximumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
-			holders, err := server.SetupCheckHolders(args[0])
+			if manifestSource != "" {
+				getFromManifest(n, manifestSource, settings.BlockchainPassword)
+				return
+			}
+			if len(args) < 1 {
+				fmt.Println("Pass either a file hash or --manifest")
+				return
+			}
+			holders, err := n.Server.SetupCheckHolders(args[0])
 			if err != nil {
 				fmt.Printf("Error finding holders for file: %x", err)
 				return
@@ -183,16 +96,20 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 				return
 			}
 			fmt.Printf("%s - %d OrcaCoin\n", bestHolder.GetIp(), bestHolder.GetPrice())
-			pubKeyInterface, err := x509.ParsePKIXPublicKey(bestHolder.Id)
+			key, err := orcaServer.HolderWalletAddress(bestHolder)
 			if err != nil {
-				log.Fatal("failed to parse DER encoded public key: ", err)
+				fmt.Println(err)
+				return
 			}
-			rsaPubKey, ok := pubKeyInterface.(*rsa.PublicKey)
-			if !ok {
-				log.Fatal("not an RSA public key")
+			if len(args) > 1 || n.Client.IsDirectory(bestHolder.GetIp(), args[0]) {
+				relativePath := ""
+				if len(args) > 1 {
+					relativePath = args[1]
+				}
+				err = n.Client.GetDirectory(bestHolder.GetIp(), bestHolder.GetPort(), args[0], relativePath, key, fmt.Sprintf("%d", bestHolder.GetPrice()), settings.BlockchainPassword, "")
+			} else {
+				err = n.Client.GetFileOnce(bestHolder.GetIp(), bestHolder.GetPort(), args[0], key, fmt.Sprintf("%d", bestHolder.GetPrice()), settings.BlockchainPassword, "")
 			}
-			key := orcaServer.ConvertKeyToString(rsaPubKey.N, rsaPubKey.E)
-			err = Client.GetFileOnce(bestHolder.GetIp(), bestHolder.GetPort(), args[0], key, fmt.Sprintf("%d", bestHolder.GetPrice()), settings.BlockchainPassword, "")
 
 			if err != nil {
 				fmt.Printf("Error getting file %s", err)
@@ -200,20 +117,200 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 		},
 	}
 
+	cmdGet.Flags().StringVar(&manifestSource, "manifest", "", "Path to a .orca manifest or an orca:// link to get the file from")
+
+	var exportLink bool
+	var exportMetadata []string
+	var cmdExport = &cobra.Command{
+		Use:   "export [fileHash] [outPath]",
+		Short: "Export a signed .orca manifest for a file stored by the peer node",
+		Long: `A manifest contains the chunk list of a file, its metadata, the key of the publisher and the holders known to the market.
+				It can be shared outside of OrcaNet and opened with 'get --manifest', much like a .torrent file.
+				Manifests are saved inside of files/manifests. Pass --link to print a compact orca:// link instead.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			metadata := make(map[string]string)
+			for _, entry := range exportMetadata {
+				key, value, found := strings.Cut(entry, "=")
+				if !found {
+					fmt.Println("Metadata must be of the form key=value:", entry)
+					return
+				}
+				metadata[key] = value
+			}
+			signed, err := n.Server.SetupExportManifest(args[0], metadata)
+			if err != nil {
+				fmt.Println("Unable to export manifest:", err)
+				return
+			}
+			if len(args) > 1 {
+				err = orcaManifest.WriteFile(args[1], signed)
+				if err != nil {
+					fmt.Println("Unable to write manifest:", err)
+					return
+				}
+			}
+			if exportLink {
+				manifest, err := orcaManifest.Verify(signed)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				link, err := orcaManifest.ToLink(manifest)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				fmt.Println(link)
+				return
+			}
+			fmt.Printf("Manifest saved to files/manifests/%s%s\n", args[0], orcaManifest.FileExtension)
+		},
+	}
+	cmdExport.Flags().BoolVar(&exportLink, "link", false, "Print an orca:// link for the file")
+	cmdExport.Flags().StringArrayVar(&exportMetadata, "meta", nil, "Metadata to include in the manifest, as key=value")
+
+	var cmdPublishName = &cobra.Command{
+		Use:   "publish-name [name] [fileHash]",
+		Short: "Point one of your names at a file hash on the DHT",
+		Long: `Names are mutable pointers owned by your peer ID. Publishing a name again replaces the file hash it points at,
+				so consumers can follow the latest version of a file with 'resolve [peerId] [name]'.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			sequence, err := n.Server.SetupPublishName(args[0], args[1])
+			if err != nil {
+				fmt.Println("Unable to publish name:", err)
+				return
+			}
+			fmt.Printf("Published %s/%s -> %s (sequence %d)\n", n.Client.Host.ID(), args[0], args[1], sequence)
+		},
+	}
+	var cmdResolve = &cobra.Command{
+		Use:   "resolve [peerId] [name]",
+		Short: "Look up the file hash a peer's name currently points at",
+		Long:  `Both 'resolve [peerId] [name]' and 'resolve [peerId]/[name]' are accepted.`,
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			peerId, name := args[0], ""
+			if len(args) > 1 {
+				name = args[1]
+			} else {
+				peerId, name, _ = strings.Cut(args[0], "/")
+			}
+			record, err := n.Server.SetupResolveName(peerId, name)
+			if err != nil {
+				fmt.Println("Unable to resolve name:", err)
+				return
+			}
+			fmt.Printf("%s (sequence %d, published %s)\n", record.GetFileKey(), record.GetSequence(), time.Unix(record.GetCreatedAt(), 0).Format(time.RFC3339))
+		},
+	}
+
+	var cmdFeed = &cobra.Command{
+		Use:   "feed",
+		Short: "Sell subscriptions to the files you release under a feed",
+		Long: `A subscriber pays once for a number of days and receives a token signed by you.
+				Until it expires, every file released under the feed can be downloaded from you without paying per chunk.`,
+	}
+	var cmdFeedCreate = &cobra.Command{
+		Use:   "create [name] [pricePerDay]",
+		Short: "Create a feed, or change the price of an existing one",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			pricePerDay, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				fmt.Println("Error parsing in price per day: must be a int64", err)
+				return
+			}
+			err = n.Server.SetupCreateFeed(args[0], pricePerDay)
+			if err != nil {
+				fmt.Println("Unable to create feed:", err)
+				return
+			}
+			fmt.Printf("Subscribers can find the feed at %s/%s\n", n.Client.Host.ID(), args[0])
+		},
+	}
+	var cmdFeedAdd = &cobra.Command{
+		Use:   "add [name] [fileHash]",
+		Short: "Release a file you are storing under a feed",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := n.Server.SetupAddToFeed(args[0], args[1])
+			if err != nil {
+				fmt.Println("Unable to add file to feed:", err)
+			}
+		},
+	}
+	var cmdFeedInfo = &cobra.Command{
+		Use:   "info [peerId/feed]",
+		Short: "Print the price and files of a publisher's feed",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			peerId, feed, _ := strings.Cut(args[0], "/")
+			publisher, err := n.Server.SetupFindPeer(peerId)
+			if err != nil {
+				fmt.Println("Unable to find publisher:", err)
+				return
+			}
+			info, err := n.Client.RequestFeed(publisher, feed, 0, "")
+			if err != nil {
+				fmt.Println("Unable to get feed:", err)
+				return
+			}
+			fmt.Printf("%s - %d OrcaCoin per day\n", info.Feed, info.PricePerDay)
+			for _, fileKey := range info.FileKeys {
+				fmt.Println(fileKey)
+			}
+		},
+	}
+	cmdFeed.AddCommand(cmdFeedCreate, cmdFeedAdd, cmdFeedInfo)
+	var cmdSubscribe = &cobra.Command{
+		Use:   "subscribe [peerId/feed] [days]",
+		Short: "Pay for a subscription to a publisher's feed",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			peerId, feed, _ := strings.Cut(args[0], "/")
+			days, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				fmt.Println("Error parsing in days: must be a int64", err)
+				return
+			}
+			publisher, err := n.Server.SetupFindPeer(peerId)
+			if err != nil {
+				fmt.Println("Unable to find publisher:", err)
+				return
+			}
+			fileKeys, err := n.Client.Subscribe(publisher, feed, days, settings.BlockchainPassword)
+			if err != nil {
+				fmt.Println("Unable to subscribe:", err)
+				return
+			}
+			fmt.Printf("Subscribed to %s for %d days. Files released so far:\n", feed, days)
+			for _, fileKey := range fileKeys {
+				fmt.Println(fileKey)
+			}
+		},
+	}
+
+	var encryptFor []string
+	var restricted bool
 	var cmdStore = &cobra.Command{
-		Use:   "store",
-		Short: "Inform the DHT that a specific file will be stored by the peer node",
+		Use:   "store [fileName | dirName] [amount]",
+		Short: "Inform the DHT that a specific file or directory will be stored by the peer node",
 		Long: `The DHT will keep track of files that each peer has.
 				When a file is requested, the DHT will be able to inform the requester of potential peer nodes that have the file.
-				The DHT also keeps track of prices and specific hashes.`,
+				The DHT also keeps track of prices and specific hashes.
+				A directory is published under a single key, and each file inside of it can also be bought on its own.
+				With --encrypt-for, the file is encrypted before it is chunked and only the listed recipients can decrypt it
+				using the manifest saved inside of files/manifests.`,
 		Args: cobra.MinimumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			fileName := args[0]
-			filePath := "./files/" + fileName
+			filePath := config.Path(settings.DataDir, "files", fileName)
 			if _, err := os.Stat(filePath); err == nil {
 
 			} else if os.IsNotExist(err) {
-				fmt.Println("file does not exist inside files folder")
+				fmt.Println("file or directory does not exist inside files folder")
 				return
 			} else {
 				fmt.Println("error checking file's existence, please try again")
@@ -224,7 +321,43 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 				fmt.Println("Error parsing in cost per MB: must be a int64", err)
 				return
 			}
-			err = server.SetupRegisterFile(filePath, fileName, costPerMB, Ip, int32(Port))
+			if fileInfo, err := os.Stat(filePath); err == nil && fileInfo.IsDir() {
+				dirKey, err := n.Server.SetupRegisterDirectory(filePath, filepath.Base(fileName), costPerMB, port)
+				if err != nil {
+					fmt.Printf("Unable to register directory on DHT: %s", err)
+				} else {
+					fmt.Printf("Sucessfully registered directory on DHT under %s.\n", dirKey)
+				}
+				return
+			}
+			if len(encryptFor) > 0 {
+				recipients := make([]libp2pcrypto.PubKey, 0)
+				for _, recipient := range encryptFor {
+					recipientKey, err := n.Server.ResolveRecipientKey(recipient)
+					if err != nil {
+						fmt.Println("Unable to find key of recipient:", err)
+						return
+					}
+					recipients = append(recipients, recipientKey)
+				}
+				fileKey, err := n.Server.SetupRegisterEncryptedFile(filePath, fileName, costPerMB, port, recipients)
+				if err != nil {
+					fmt.Printf("Unable to register encrypted file on DHT: %s", err)
+				} else {
+					fmt.Printf("Sucessfully registered encrypted file on DHT. Share files/manifests/%s%s with the recipients.\n", fileKey, orcaManifest.FileExtension)
+				}
+				return
+			}
+			if restricted {
+				fileKey, err := n.Server.SetupRegisterRestrictedFile(filePath, fileName, costPerMB, port)
+				if err != nil {
+					fmt.Printf("Unable to register file on DHT: %s", err)
+				} else {
+					fmt.Printf("Sucessfully registered restricted file on DHT. Issue tokens with 'capability issue %s'.\n", fileKey)
+				}
+				return
+			}
+			err = n.Server.SetupRegisterFile(filePath, fileName, costPerMB, n.Ip, port)
 			if err != nil {
 				fmt.Printf("Unable to register file on DHT: %s", err)
 			} else {
@@ -232,18 +365,211 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 			}
 		},
 	}
+	cmdStore.Flags().StringSliceVar(&encryptFor, "encrypt-for", nil, "Encrypt the file so only these peer IDs or PEM public key files can read it")
+	cmdStore.Flags().BoolVar(&restricted, "restricted", false, "Only serve the file to consumers holding a capability token")
+
+	var cmdCapability = &cobra.Command{
+		Use:   "capability",
+		Short: "Control who may download your restricted files",
+		Long: `Restricted files are only served to consumers that send a capability token signed by you.
+				Tokens are bound to the consumer's key, expire, and can be revoked at any time.`,
+	}
+	var cmdCapabilityRestrict = &cobra.Command{
+		Use:   "restrict [fileHash]",
+		Short: "Restrict a file you are already storing",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := n.Server.SetupRestrictFile(args[0])
+			if err != nil {
+				fmt.Println("Unable to restrict file:", err)
+			}
+		},
+	}
+	var cmdCapabilityIssue = &cobra.Command{
+		Use:   "issue [fileHash] [peerId | keyFile] [days]",
+		Short: "Issue a token that lets a consumer download a restricted file",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			consumerKey, err := n.Server.ResolveRecipientKey(args[1])
+			if err != nil {
+				fmt.Println("Unable to find key of consumer:", err)
+				return
+			}
+			days, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil || days <= 0 {
+				fmt.Println("Error parsing in days: must be a positive int64")
+				return
+			}
+			token, id, err := n.Server.SetupIssueCapability(args[0], consumerKey, time.Duration(days)*24*time.Hour)
+			if err != nil {
+				fmt.Println("Unable to issue token:", err)
+				return
+			}
+			tokenPath := filepath.Join(orcaCapability.TokenDirectory(settings.DataDir), "issued", id+orcaCapability.TokenExtension)
+			os.MkdirAll(filepath.Dir(tokenPath), 0755)
+			err = os.WriteFile(tokenPath, token, 0644)
+			if err != nil {
+				fmt.Println("Unable to save token:", err)
+				return
+			}
+			fmt.Printf("Issued token %s. Send %s to the consumer.\n", id, tokenPath)
+		},
+	}
+	var cmdCapabilityRevoke = &cobra.Command{
+		Use:   "revoke [tokenId]",
+		Short: "Revoke a token you issued",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := n.Server.SetupRevokeCapability(args[0])
+			if err != nil {
+				fmt.Println("Unable to revoke token:", err)
+			}
+		},
+	}
+	var cmdCapabilityAdd = &cobra.Command{
+		Use:   "add [tokenFile]",
+		Short: "Add a token you received so it is sent when getting files from its publisher",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			token, err := os.ReadFile(args[0])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			err = orcaCapability.SaveToken(settings.DataDir, token)
+			if err != nil {
+				fmt.Println("Invalid token:", err)
+			}
+		},
+	}
+	cmdCapability.AddCommand(cmdCapabilityRestrict, cmdCapabilityIssue, cmdCapabilityRevoke, cmdCapabilityAdd)
+
+	var cmdInvoice = &cobra.Command{
+		Use:   "invoice",
+		Short: "Buy whole files with signed invoices instead of paying per chunk",
+		Long: `The cheapest holder of a file signs an invoice for all of it, payable to a fresh address of its wallet.
+				Once it is paid, getting the file from that holder costs nothing more. Invoices are settled when
+				the wallet sees their payment, and both sides keep them in files/invoices.`,
+	}
+	var cmdInvoiceRequest = &cobra.Command{
+		Use:   "request [fileHash]",
+		Short: "Ask the cheapest holder of a file for an invoice and print its orcacoin: URI",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			holders, err := n.Server.SetupCheckHolders(args[0])
+			if err != nil {
+				fmt.Println("Error finding holders for file:", err)
+				return
+			}
+			holder := orcaServer.CheapestHolder(holders.GetHolders())
+			if holder == nil {
+				fmt.Println("Unable to find holder for this hash.")
+				return
+			}
+			record, err := n.Client.RequestInvoice(holder.GetIp(), args[0], "")
+			if err != nil {
+				fmt.Println("Unable to get invoice:", err)
+				return
+			}
+			fmt.Printf("Invoice %s - %d OrcaCoin, expires %s\n", record.Invoice.Id, record.Invoice.Amount, time.Unix(record.Invoice.ExpiresAt, 0).Format(time.RFC3339))
+			fmt.Println(record.URI)
+		},
+	}
+	var cmdInvoicePay = &cobra.Command{
+		Use:   "pay [orcacoinURI]",
+		Short: "Pay an invoice from your wallet",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			record, err := n.Server.PayInvoice(args[0])
+			if err != nil {
+				fmt.Println("Unable to pay invoice:", err)
+				return
+			}
+			fmt.Printf("Paid invoice %s in transaction %s\n", record.Invoice.Id, record.TxId)
+		},
+	}
+	var cmdInvoiceList = &cobra.Command{
+		Use:   "list",
+		Short: "List the invoices you issued and paid",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, record := range n.Server.Invoices.List() {
+				fmt.Printf("%s %-8s %-7s %d OrcaCoin for %s %s\n", record.Invoice.Id, record.Role, record.Status, record.Invoice.Amount, record.Invoice.FileKey, record.TxId)
+			}
+		},
+	}
+	cmdInvoice.AddCommand(cmdInvoiceRequest, cmdInvoicePay, cmdInvoiceList)
+
+	var cmdSettle = &cobra.Command{
+		Use:   "settle",
+		Short: "Pay every holder you owe for chunks in one transaction",
+		Long: `With SETTLE_THRESHOLD set, chunks from holders that accept IOUs are not paid for one by one.
+				A signed IOU is sent to the holder instead, and everything owed is paid in one sendmany
+				transaction once SETTLE_THRESHOLD coins are owed or every SETTLE_INTERVAL seconds.
+				This settles right away.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if n.Server.Settlement == nil {
+				fmt.Println("This peer pays for every chunk on its own. Set SETTLE_THRESHOLD to settle in batches.")
+				return
+			}
+			settlement, err := n.Server.Settlement.Settle()
+			if err != nil {
+				fmt.Println("Unable to settle:", err)
+				return
+			}
+			if settlement == nil {
+				fmt.Println("Nothing is owed")
+			}
+		},
+	}
+	var cmdSettleList = &cobra.Command{
+		Use:   "list",
+		Short: "List what you owe each holder and the settlements that paid them",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if n.Server.Settlement == nil {
+				fmt.Println("This peer pays for every chunk on its own. Set SETTLE_THRESHOLD to settle in batches.")
+				return
+			}
+			for _, debt := range n.Server.Settlement.Debts() {
+				fmt.Printf("%s at %s: %d OrcaCoin owed, %d paid\n", debt.Provider, debt.Address, debt.Outstanding(), debt.Paid)
+			}
+			for _, settlement := range n.Server.Settlement.Settlements() {
+				total := int64(0)
+				for _, amount := range settlement.Amounts {
+					total += amount
+				}
+				fmt.Printf("%s %s: %d OrcaCoin to %d holders\n", time.Unix(settlement.SettledAt, 0).Format(time.RFC3339), settlement.TxId, total, len(settlement.Amounts))
+			}
+		},
+	}
+	cmdSettle.AddCommand(cmdSettleList)
+
 	var cmdNetwork = &cobra.Command{
 		Use:   "network",
 		Short: "Print out information about the network status of the peer node",
 		Args:  cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println("Testing Network Speeds...")
-			networkData := orcaStatus.GetNetworkInfo()
-			if networkData.Success {
-				fmt.Printf("Latency: %fms, Download: %fMbps, Upload: %fMbps\n", networkData.LatencyMs, networkData.DownloadSpeedMbps, networkData.UploadSpeedMbps)
-			} else {
+			results, err := n.SpeedTester.Run(context.Background())
+			if err != nil {
+				fmt.Println("Unable to test network speeds:", err)
+				return
+			}
+			for _, result := range results {
+				if result.Error != "" {
+					fmt.Printf("%s: %s\n", result.PeerID, result.Error)
+					continue
+				}
+				fmt.Printf("%s: Latency: %fms, Download: %fMbps, Upload: %fMbps\n", result.PeerID, result.LatencyMs, result.DownloadMbps, result.UploadMbps)
+			}
+			summary := orcaSpeedtest.Summarize(results)
+			if summary.Peers == 0 {
 				fmt.Println("Unable to test network speeds. Please try again")
+				return
 			}
+			fmt.Printf("Average over %d peers: Latency: %fms, Download: %fMbps, Upload: %fMbps\n", summary.Peers, summary.LatencyMs, summary.DownloadMbps, summary.UploadMbps)
 		},
 	}
 	var cmdImport = &cobra.Command{
@@ -254,7 +580,7 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 				It is also possible to manually move files into the peer node.`,
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			err := Client.ImportFile(args[0])
+			err := n.Client.ImportFile(args[0])
 			if err != nil {
 				fmt.Println(err)
 			}
@@ -269,7 +595,7 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 				There are also parts of a file, called hashes, stored in the file directory`,
 		Args: cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
-			files := orcaStore.GetAllLocalFiles()
+			files := orcaStore.GetAllLocalFiles(settings.DataDir)
 			fmt.Print("Files found: \n")
 			for _, file := range files {
 				fmt.Println(file.Name)
@@ -282,7 +608,7 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 		Short: "Return the hash of a file",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			orcaHash.HashFile(args[0])
+			orcaHash.HashFile(settings.DataDir, args[0])
 		},
 	}
 	var cmdSend = &cobra.Command{
@@ -317,10 +643,28 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 		Short: "Run the node as a relay server for other peers",
 		Long: `This will run the peer node until SIGINT or SIGTERM is received in the terminal.
 				This functionality is provided to allow for the peer node to run as a relay server for any nodes behind a NAT.
+				Resource limits and extra listen addresses are read from config/relay.json.
 				`,
 		Args: cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
-			go relay.SetupRelay()
+			relayConfig, err := relay.LoadConfig(config.Path(settings.DataDir, "config", "relay.json"))
+			if err != nil {
+				fmt.Println("Error reading config/relay.json:", err)
+				return
+			}
+			service, tracer, err := relay.StartRelay(n.Host, relayConfig)
+			if err != nil {
+				fmt.Println("Unable to start relay:", err)
+				return
+			}
+			defer service.Close()
+			relay.RegisterRoutes(n.Mux(), tracer)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err = n.Server.SetupAdvertiseRelay(ctx)
+			if err != nil {
+				fmt.Println("Unable to advertise relay:", err)
+			}
 			sigs := make(chan os.Signal, 1)
 			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 			<-sigs
@@ -328,31 +672,68 @@ func StartCLI(bootstrapAddress *string, pubKey *rsa.PublicKey, privKey *rsa.Priv
 	}
 
 	var rootCmd = &cobra.Command{Use: "orca"}
-	rootCmd.AddCommand(cmdLocation, cmdGet, cmdStore, cmdNetwork, cmdImport, cmdList, cmdHash, cmdSend, cmdRun, cmdRelay)
+	// Settings were already read from the command line, this lets them appear in any command
+	rootCmd.PersistentFlags().AddFlagSet(config.Flags())
+	rootCmd.AddCommand(cmdLocation, cmdGet, cmdExport, cmdPublishName, cmdResolve, cmdFeed, cmdSubscribe, cmdCapability, cmdInvoice, cmdSettle, cmdStore, cmdNetwork, cmdImport, cmdList, cmdHash, cmdSend, cmdRun, cmdRelay)
 	rootCmd.Execute()
 }
 
-// Ask user to enter a port and returns it
-func getPort(useCase string) string {
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Printf("Enter a port number to start listening to requests for %s: ", useCase)
-	for {
-		port, err := reader.ReadString('\n')
+// Get the file described by a .orca manifest or an orca:// link
+func getFromManifest(n *node.Node, source string, passKey string) {
+	var fileKey, fileName string
+	var fileInfo *fileshare.FileInfo
+	var holders []*fileshare.User
+	var wrappedKeys []*fileshare.WrappedKey
+	if strings.HasPrefix(source, orcaManifest.LinkScheme+"://") {
+		link, err := orcaManifest.ParseLink(source)
 		if err != nil {
-			fmt.Println("Error reading input:", err)
-			os.Exit(1)
+			fmt.Println("Invalid link:", err)
+			return
 		}
-		port = strings.TrimSpace(port)
-
-		// Validate port
-		listener, err := net.Listen("tcp", ":"+port)
-		if err == nil {
-			defer listener.Close()
-			return port
+		fileKey = link.FileKey
+		fileName = link.FileName
+		holders = n.Server.ManifestHolders(fileKey, nil, link.Holders)
+	} else {
+		manifest, err := orcaManifest.ReadFile(source)
+		if err != nil {
+			fmt.Println("Invalid manifest:", err)
+			return
 		}
+		publisher, _ := orcaManifest.Publisher(manifest)
+		fmt.Printf("Manifest for %s published by %s\n", manifest.GetFileInfo().GetFileName(), publisher)
+		for key, value := range manifest.GetMetadata() {
+			fmt.Printf("  %s: %s\n", key, value)
+		}
+		fileKey = manifest.GetFileKey()
+		fileName = manifest.GetFileInfo().GetFileName()
+		fileInfo = manifest.GetFileInfo()
+		holders = n.Server.ManifestHolders(fileKey, manifest.GetHolders(), nil)
+		wrappedKeys = manifest.GetWrappedKeys()
+	}
 
-		fmt.Print("Invalid port. Please enter a different port: ")
+	holder := server.CheapestHolder(holders)
+	if holder == nil {
+		fmt.Println("Unable to find holder for this file.")
+		return
+	}
+	fmt.Printf("%s - %d OrcaCoin\n", holder.GetIp(), holder.GetPrice())
+	key, err := orcaServer.HolderWalletAddress(holder)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	savedPath, err := n.Client.GetManifestFile(holder.GetIp(), holder.GetPort(), fileKey, fileName, fileInfo, key, fmt.Sprintf("%d", holder.GetPrice()), passKey, "")
+	if err != nil {
+		fmt.Printf("Error getting file %s\n", err)
+		return
+	}
+	if len(wrappedKeys) > 0 {
+		err = n.Client.DecryptFile(savedPath, wrappedKeys)
+		if err != nil {
+			fmt.Printf("Unable to decrypt %s: %s\n", savedPath, err)
+			return
+		}
+		fmt.Printf("Decrypted %s\n", savedPath)
 	}
 }
 
@@ -367,52 +748,3 @@ func getPassKey() string {
 	passKey = strings.TrimSpace(passKey)
 	return passKey
 }
-
-// detectNAT simulates the process of detecting whether the node is behind NAT.
-func detectNAT() bool {
-	ipapiClient := http.Client{}
-
-	ipv4Req, err := http.NewRequest("GET", "http://httpbin.org/ip", nil)
-	if err != nil {
-		fmt.Println("Error creating IPv4 request:", err)
-		os.Exit(1)
-	}
-	resp, err := ipapiClient.Do(ipv4Req)
-	if err != nil {
-		fmt.Println("Error retrieving IPv4:", err)
-		os.Exit(1)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading IPv4 response body:", err)
-		os.Exit(1)
-	}
-
-	var ipv4JSON struct {
-		Origin string `json:"origin"`
-	}
-	err = json.Unmarshal(body, &ipv4JSON)
-	if err != nil {
-		fmt.Println("Error unmarshalling IPv4 response body:", err)
-		os.Exit(1)
-	}
-
-	publicIP := net.ParseIP(ipv4JSON.Origin)
-
-	// Define private IP address ranges.
-	privateRanges := []*net.IPNet{
-		{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
-		{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)},
-		{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)},
-	}
-
-	// Check if the public IP address is within any of the private IP address ranges.
-	for _, pr := range privateRanges {
-		if pr.Contains(publicIP) {
-			return false
-		}
-	}
-	return true
-}
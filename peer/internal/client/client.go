@@ -13,11 +13,18 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	orcaAccounting "orca-peer/internal/accounting"
 	orcaBlockchain "orca-peer/internal/blockchain"
+	orcaCapability "orca-peer/internal/capability"
+	"orca-peer/internal/config"
+	orcaEncryption "orca-peer/internal/encryption"
 	"orca-peer/internal/fileshare"
 	"orca-peer/internal/hash"
 	orcaHash "orca-peer/internal/hash"
+	orcaInvoice "orca-peer/internal/invoice"
 	orcaJobs "orca-peer/internal/jobs"
+	orcaSettlement "orca-peer/internal/settlement"
+	orcaSubscription "orca-peer/internal/subscription"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -29,6 +36,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/protobuf/proto"
 )
 
 type Client struct {
@@ -36,14 +44,22 @@ type Client struct {
 	PublicKey         *rsa.PublicKey
 	PrivateKey        *rsa.PrivateKey
 	Host              host.Host
-	StoredFileInfoMap *map[string]fileshare.FileInfo
+	StoredFileInfoMap *map[string]*fileshare.FileInfo
+	Jobs              *orcaJobs.JobManager   // Progress of downloads made for a job is reported here
+	Invoices          *orcaInvoice.Store     // Invoices paid to holders are sent along with chunk requests
+	Settlement        *orcaSettlement.Ledger // Owes holders that take IOUs instead of paying them per chunk, if set
+	Accounts          *orcaAccounting.Ledger // Every payment this client makes is recorded here, if set
+	dataDir           string
 }
 
-func NewClient(path string) *Client {
+// Create the client of a node, files it gets and tokens it was given are kept in dataDir
+func NewClient(dataDir string) *Client {
 	return &Client{
-		name_map:   *hash.NewNameStore(path),
+		name_map:   *hash.NewNameStore(config.Path(dataDir, "files", "names")),
 		PublicKey:  nil,
 		PrivateKey: nil,
+		Jobs:       orcaJobs.NewJobManager(dataDir, nil, nil),
+		dataDir:    dataDir,
 	}
 }
 
@@ -64,7 +80,7 @@ func (client *Client) ImportFile(filePath string) error {
 		return errors.New("cant find given absolute file path")
 	}
 	defer src.Close()
-	destinationFile, err := os.Create("./files/" + fileName)
+	destinationFile, err := os.Create(config.Path(client.dataDir, "files", fileName))
 	if err != nil {
 		return errors.New("error creating destination file")
 	}
@@ -87,7 +103,11 @@ type Data struct {
 
 func SendTransaction(price float64, ip string, port string, publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey) {
 	cost := orcaHash.GeneratePriceBytes(price)
-	byteBuffer := bytes.NewBuffer(cost)
+	signature, err := orcaHash.SignFile(cost, privateKey)
+	if err != nil {
+		fmt.Println("Error signing transaction:", err)
+		return
+	}
 	pubKeyString, err := orcaHash.ExportRsaPublicKeyAsPemStr(publicKey)
 	if err != nil {
 		fmt.Println("Error sending public key in header:", err)
@@ -96,7 +116,7 @@ func SendTransaction(price float64, ip string, port string, publicKey *rsa.Publi
 	currentTime := time.Now()
 	dateTimeString := currentTime.Format(time.RFC3339Nano)
 	data := Data{
-		Bytes:               byteBuffer.Bytes(),
+		Bytes:               signature,
 		UnlockedTransaction: cost,
 		PublicKey:           string(pubKeyString),
 		Date:                dateTimeString,
@@ -125,58 +145,59 @@ func SendTransaction(price float64, ip string, port string, publicKey *rsa.Publi
 		fmt.Println("Send Request")
 	}
 	defer resp.Body.Close()
-	err = os.WriteFile("./files/transactions/"+dateTimeString, jsonData, 0644)
-	if err != nil {
-		fmt.Println("Error writing transaction to file:", err)
-		return
-	}
 }
-func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walletAddress string, price string, passKey string, jobId string) error {
-	//Dial peer and start stream to request file
-	peerMA, err := multiaddr.NewMultiaddr(ip)
-	if err != nil {
-		log.Println(err)
-		orcaJobs.UpdateJobStatus(jobId, "terminated")
-		return err
-	}
 
-	peer, err := peer.AddrInfoFromP2pAddr(peerMA)
-	if err != nil {
-		log.Println(err)
-		orcaJobs.UpdateJobStatus(jobId, "terminated")
+// Download a file into files/requested, its job is finished once every chunk was written
+func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walletAddress string, price string, passKey string, jobId string) error {
+	err := client.getFile(ip, port, file_hash, walletAddress, price, passKey, jobId)
+	if err != nil || client.Jobs.GetJobStatus(jobId) == "terminated" {
 		return err
 	}
+	client.Jobs.UpdateJobStatus(jobId, "finished")
+	return nil
+}
 
-	client.Host.Peerstore().AddAddrs(peer.ID, peer.Addrs, peerstore.AddressTTL)
-
-	err = client.Host.Connect(context.Background(), *peer)
+// Download a file into files/requested without finishing its job, a job may download several files
+func (client *Client) getFile(ip string, port int32, file_hash string, walletAddress string, price string, passKey string, jobId string) error {
+	//Dial peer and start stream to request file
+	peerID, err := client.connectToPeer(ip)
 	if err != nil {
 		log.Println(err)
-		orcaJobs.UpdateJobStatus(jobId, "terminated")
+		client.Jobs.UpdateJobStatus(jobId, "terminated")
 		return err
 	}
 
-	s, err := client.Host.NewStream(context.Background(), peer.ID, protocol.ID("orcanet-fileshare/1.0/"+file_hash))
+	s, err := client.Host.NewStream(context.Background(), peerID, protocol.ID("orcanet-fileshare/1.0/"+file_hash))
 	if err != nil {
 		log.Println(err)
-		orcaJobs.UpdateJobStatus(jobId, "terminated")
+		client.Jobs.UpdateJobStatus(jobId, "terminated")
 		return err
 	}
 	defer s.Close()
 
+	//tokens for any feeds of this peer we are subscribed to, so we do not pay for files inside of them
+	tokens := orcaSubscription.LoadTokens(client.dataDir, peerID)
+	capabilities := orcaCapability.LoadTokens(client.dataDir, peerID)
+	invoices := client.paidInvoices(peerID, file_hash)
+
 	//continously send request and process response from peer
 	chunkIndex := -1
 	for {
 		fileChunkReq := orcaJobs.FileChunkRequest{
-			FileHash:   file_hash,
-			ChunkIndex: chunkIndex + 1,
-			JobId:      jobId,
+			FileHash:     file_hash,
+			ChunkIndex:   chunkIndex + 1,
+			JobId:        jobId,
+			Tokens:       tokens,
+			Capabilities: capabilities,
+			Invoices:     invoices,
+			Batched:      client.Settlement != nil,
+			IOU:          client.Settlement.LatestIOU(peerID),
 		}
 
 		nextChunkReqBytes, err := json.Marshal(fileChunkReq)
 		if err != nil {
 			fmt.Println("Error:", err)
-			orcaJobs.UpdateJobStatus(jobId, "terminated")
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
 			return err
 		}
 
@@ -185,14 +206,14 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 		_, err = s.Write(lengthBytes)
 		if err != nil {
 			fmt.Println(err)
-			orcaJobs.UpdateJobStatus(jobId, "terminated")
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
 			return nil
 		}
 
 		_, err = s.Write(nextChunkReqBytes)
 		if err != nil {
 			fmt.Println(err)
-			orcaJobs.UpdateJobStatus(jobId, "terminated")
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
 			return nil
 		}
 
@@ -202,7 +223,7 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 			b, err := buf.ReadByte()
 			if err != nil {
 				fmt.Println(err)
-				orcaJobs.UpdateJobStatus(jobId, "terminated")
+				client.Jobs.UpdateJobStatus(jobId, "terminated")
 				return err
 			}
 			lengthBytes = append(lengthBytes, b)
@@ -213,7 +234,7 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 		_, err = io.ReadFull(buf, payload)
 		if err != nil {
 			fmt.Println(err)
-			orcaJobs.UpdateJobStatus(jobId, "terminated")
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
 			return err
 		}
 
@@ -221,37 +242,48 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 		err = json.Unmarshal(payload, &fileChunk)
 		if err != nil {
 			fmt.Println("Error unmarshaling JSON:", err)
-			orcaJobs.UpdateJobStatus(jobId, "terminated")
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
 			return err
 		}
+		if fileChunk.Error != "" {
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
+			return errors.New(fileChunk.Error)
+		}
 		hash := fileChunk.FileHash
 
-		err = client.sendTransactionFee(price, walletAddress, passKey)
-		if err != nil {
-			orcaJobs.UpdateJobStatus(jobId, "terminated")
-			return err
-		}
-		priceInt, err := strconv.ParseInt(price, 10, 64)
-		if err != nil {
-			fmt.Println(err)
-		} else {
-			if client.PublicKey != nil && client.PrivateKey != nil {
-				SendTransaction(float64(priceInt), ip, string(port), client.PublicKey, client.PrivateKey)
+		if !fileChunk.Subscribed && !fileChunk.Invoiced {
+			owed := fileChunk.CreditLimit > 0 && client.Settlement != nil
+			if owed {
+				err = client.Settlement.Accrue(peerID, fileChunk.PaymentAddress, hash, jobId, fileChunk.ChunkIndex, fileChunk.Price, fileChunk.CreditLimit)
+			} else {
+				err = client.sendTransactionFee(price, chunkPaymentAddress(&fileChunk, walletAddress), passKey, orcaAccounting.Entry{JobId: jobId, FileKey: hash, Peer: peerID.String()})
+			}
+			if err != nil {
+				client.Jobs.UpdateJobStatus(jobId, "terminated")
+				return err
+			}
+			priceInt, err := strconv.ParseInt(price, 10, 64)
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				if !owed && client.PublicKey != nil && client.PrivateKey != nil {
+					SendTransaction(float64(priceInt), ip, string(port), client.PublicKey, client.PrivateKey)
+				}
+				client.Jobs.UpdateJobCost(jobId, int(priceInt))
 			}
-			orcaJobs.UpdateJobCost(jobId, int(priceInt))
 		}
 
-		file, err := os.OpenFile("./files/requested/"+hash, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		file, err := os.OpenFile(config.Path(client.dataDir, "files", "requested", hash), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 		defer file.Close()
 
 		_, err = file.Write(fileChunk.Data)
 		if err != nil {
 			log.Fatal(err)
-			orcaJobs.UpdateJobStatus(jobId, "terminated")
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
 			return err
 		}
 
-		fmt.Printf("Chunk %d for %s received and written\n", hash, fileChunk.ChunkIndex)
+		fmt.Printf("Chunk %d for %s received and written\n", fileChunk.ChunkIndex, hash)
 
 		if fileChunk.ChunkIndex == fileChunk.MaxChunk-1 {
 			fmt.Println("All chunks received and written")
@@ -261,21 +293,19 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 		chunkIndex += 1
 
 		if jobId != "" {
-			status := orcaJobs.GetJobStatus(jobId)
+			status := client.Jobs.GetJobStatus(jobId)
 			if status == "terminated" {
 				return nil
 			} else if status == "paused" {
 				for {
 					time.Sleep(10 * time.Second)
-					if orcaJobs.GetJobStatus(jobId) != "paused" {
+					if client.Jobs.GetJobStatus(jobId) != "paused" {
 						break
 					}
 				}
 			}
 		}
 	}
-
-	orcaJobs.UpdateJobStatus(jobId, "finished")
 	return nil
 }
 
@@ -319,7 +349,7 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 // 				if client.PublicKey != nil && client.PrivateKey != nil {
 // 					SendTransaction(float64(priceInt), ip, string(port), client.PublicKey, client.PrivateKey)
 // 				}
-// 				orcaJobs.UpdateJobCost(jobId, int(priceInt))
+// 				client.Jobs.UpdateJobCost(jobId, int(priceInt))
 // 			}
 // 		}
 // 		if _, err := destFile.Write(data); err != nil {
@@ -337,7 +367,7 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 // 			} else if status == "paused" {
 // 				for {
 // 					time.Sleep(10 * time.Second)
-// 					if orcaJobs.GetJobStatus(jobId) != "paused" {
+// 					if client.Jobs.GetJobStatus(jobId) != "paused" {
 // 						break
 // 					}
 // 				}
@@ -351,7 +381,7 @@ func (client *Client) GetFileOnce(ip string, port int32, file_hash string, walle
 
 func (client *Client) RequestStorage(ip, port, filename string) (string, error) {
 	// Read file content
-	content, err := os.ReadFile("./files/requested/" + filename)
+	content, err := os.ReadFile(config.Path(client.dataDir, "files", "requested", filename))
 	if err != nil {
 		fmt.Println("Error reading file:", err)
 		return "", err
@@ -369,102 +399,241 @@ func (client *Client) RequestStorage(ip, port, filename string) (string, error)
 	return hash, err
 }
 
-func (client *Client) GetDirectory(ip string, port int32, path string) {
-	// data, err := client.getData(ip, port, path)
-	// if err != nil {
-	// 	fmt.Println("Failed to Get Directory")
-	// 	return
-	// }
-	// var dir_tree map[string]any
-	// err = json.Unmarshal(data, &dir_tree)
-	// if err != nil {
-	// 	fmt.Println("Failed to parse dir tree")
-	// 	return
-	// }
-	// err = client.getDirectory(ip, port, dir_tree)
-	// if err != nil {
-	// 	fmt.Println("Failed to Get Directory")
-	// 	return
-	// }
+// Download a directory listed on the market and reconstruct it inside of files/requested.
+// If relativePath is not empty, only the file (or sub directory) at that path is bought.
+func (client *Client) GetDirectory(ip string, port int32, dirKey string, relativePath string, walletAddress string, price string, passKey string, jobId string) error {
+	manifest, err := client.FetchDirectoryManifest(ip, dirKey)
+	if err != nil {
+		client.Jobs.UpdateJobStatus(jobId, "terminated")
+		return err
+	}
+
+	relativePath = strings.Trim(filepath.ToSlash(relativePath), "/")
+	found := false
+	for _, entry := range manifest.GetEntries() {
+		entryPath := entry.GetRelativePath()
+		if relativePath != "" && entryPath != relativePath && !strings.HasPrefix(entryPath, relativePath+"/") {
+			continue
+		}
+		found = true
+
+		// Chunks are appended to files/requested/<fileKey>, so start from an empty file
+		downloadPath := config.Path(client.dataDir, "files", "requested", entry.GetFileKey())
+		os.Remove(downloadPath)
+		err = client.getFile(ip, port, entry.GetFileKey(), walletAddress, price, passKey, jobId)
+		if err != nil || client.Jobs.GetJobStatus(jobId) == "terminated" {
+			return err
+		}
+
+		destPath := filepath.Join(config.Path(client.dataDir, "files", "requested"), manifest.GetName(), filepath.FromSlash(entryPath))
+		err = os.MkdirAll(filepath.Dir(destPath), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.Truncate(downloadPath, entry.GetFileInfo().GetFileSize())
+		if err != nil {
+			return err
+		}
+		err = orcaHash.VerifyChunkedFile(downloadPath, entry.GetFileInfo())
+		if err != nil {
+			os.Remove(downloadPath)
+			client.Jobs.UpdateJobStatus(jobId, "terminated")
+			return errors.New(entryPath + " does not match the directory manifest: " + err.Error())
+		}
+		err = os.Rename(downloadPath, destPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reconstructed %s\n", destPath)
+	}
+	if !found {
+		return errors.New("no file in the directory matches " + relativePath)
+	}
+	client.name_map.PutFileHash(manifest.GetName(), dirKey)
+	client.Jobs.UpdateJobStatus(jobId, "finished")
+	return nil
 }
 
-// func (client *Client) getDirectory(ip string, port int32, dir_tree map[string]any) error {
-// 	for path, v := range dir_tree {
-// 		switch val := v.(type) {
-// 		case string:
-// 			err := os.MkdirAll(filepath.Join("./files/requested/", filepath.Dir(path)), 0755)
-// 			if err != nil {
-// 				return err
-// 			}
-// 			// need to fix to match new blockchain requirements
-// 			err = client.GetFileOnce(ip, port, path, "", "", "")
-// 			if err != nil {
-// 				return err
-// 			}
-// 		case map[string]any:
-// 			client.getDirectory(ip, port, val)
-// 		default:
-// 			panic("Bug: dir_tree should only have strings or recursive dir_tree")
-// 		}
-// 	}
-// 	return nil
-// }
+// Request a single chunk of a file from a holder and pay for it, unless a subscription covers it.
+// Unlike GetFileOnce nothing is written to disk, the chunk is returned to the caller.
+func (client *Client) FetchChunk(ip string, fileKey string, chunkIndex int, walletAddress string, price string, passKey string) (*orcaJobs.FileChunk, error) {
+	peerID, err := client.connectToPeer(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := client.Host.NewStream(context.Background(), peerID, protocol.ID("orcanet-fileshare/1.0/"+fileKey))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
 
-func (client *Client) StoreDirectory(ip, port, path string) {
-	dir_tree_hashes, err := client.storeDirectory(ip, port, filepath.Join("./files/documents/", path))
+	requestBytes, err := json.Marshal(orcaJobs.FileChunkRequest{
+		FileHash:     fileKey,
+		ChunkIndex:   chunkIndex,
+		Tokens:       orcaSubscription.LoadTokens(client.dataDir, peerID),
+		Capabilities: orcaCapability.LoadTokens(client.dataDir, peerID),
+		Invoices:     client.paidInvoices(peerID, fileKey),
+		Batched:      client.Settlement != nil,
+		IOU:          client.Settlement.LatestIOU(peerID),
+	})
 	if err != nil {
-		fmt.Println("Error storing directory", path)
+		return nil, err
 	}
-	data, err := json.Marshal(dir_tree_hashes)
+	lengthBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(lengthBytes, uint32(len(requestBytes)))
+	_, err = s.Write(append(lengthBytes, requestBytes...))
 	if err != nil {
-		fmt.Println("Error parsing directory hash tree")
+		return nil, err
 	}
-	filedata := FileData{
-		FileName: path,
-		Content:  data,
+
+	buf := bufio.NewReader(s)
+	_, err = io.ReadFull(buf, lengthBytes)
+	if err != nil {
+		return nil, err
 	}
-	dir_hash, err := client.storeData(ip, port, path, &filedata)
+	payload := make([]byte, binary.LittleEndian.Uint32(lengthBytes))
+	_, err = io.ReadFull(buf, payload)
 	if err != nil {
-		fmt.Println("Error storing directory", path)
-		return
+		return nil, err
 	}
-	client.name_map.PutFileHash(path, dir_hash)
+	fileChunk := &orcaJobs.FileChunk{}
+	err = json.Unmarshal(payload, fileChunk)
+	if err != nil {
+		return nil, err
+	}
+	if fileChunk.Error != "" {
+		return nil, errors.New(fileChunk.Error)
+	}
+	if fileChunk.ChunkIndex != chunkIndex {
+		return nil, fmt.Errorf("asked for chunk %d, holder sent chunk %d", chunkIndex, fileChunk.ChunkIndex)
+	}
+
+	if fileChunk.CreditLimit > 0 && client.Settlement != nil {
+		err = client.Settlement.Accrue(peerID, fileChunk.PaymentAddress, fileKey, "", chunkIndex, fileChunk.Price, fileChunk.CreditLimit)
+		if err != nil {
+			return nil, err
+		}
+	} else if !fileChunk.Subscribed && !fileChunk.Invoiced && price != "0" {
+		err = client.sendTransactionFee(price, chunkPaymentAddress(fileChunk, walletAddress), passKey, orcaAccounting.Entry{FileKey: fileKey, Peer: peerID.String()})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fileChunk, nil
+}
+
+// Download a file that was shared through a .orca manifest or orca:// link and save it under its name inside of files/requested.
+// fileInfo comes from a verified manifest and is used to check every chunk, it is nil for links.
+// Returns the path the file was saved to.
+func (client *Client) GetManifestFile(ip string, port int32, fileKey string, fileName string, fileInfo *fileshare.FileInfo, walletAddress string, price string, passKey string, jobId string) (string, error) {
+	// Chunks are appended to files/requested/<fileKey>, so start from an empty file
+	downloadPath := config.Path(client.dataDir, "files", "requested", fileKey)
+	os.Remove(downloadPath)
+	err := client.GetFileOnce(ip, port, fileKey, walletAddress, price, passKey, jobId)
+	if err != nil {
+		return "", err
+	}
+	if fileInfo != nil {
+		err = orcaHash.VerifyChunkedFile(downloadPath, fileInfo)
+		if err != nil {
+			os.Remove(downloadPath)
+			return "", errors.New("downloaded file does not match its manifest: " + err.Error())
+		}
+	}
+	fileName = filepath.Base(filepath.Clean("/" + fileName))
+	if fileName == "/" {
+		return downloadPath, nil
+	}
+	savedPath := filepath.Join(config.Path(client.dataDir, "files", "requested"), fileName)
+	err = os.Rename(downloadPath, savedPath)
+	if err != nil {
+		return "", err
+	}
+	client.name_map.PutFileHash(fileName, fileKey)
+	fmt.Printf("Saved %s\n", savedPath)
+	return savedPath, nil
 }
 
-func (client *Client) storeDirectory(ip, port string, path string) (map[string]any, error) {
-	entries, err := os.ReadDir(path)
+// Decrypt a downloaded file in place with the content key wrapped for this peer
+func (client *Client) DecryptFile(filePath string, wrappedKeys []*fileshare.WrappedKey) error {
+	contentKey, err := orcaEncryption.UnwrapKeyFromRecipients(wrappedKeys, client.PrivateKey)
+	if err != nil {
+		return err
+	}
+	err = orcaEncryption.DecryptFile(filePath, filePath+".dec", contentKey)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filePath+".dec", filePath)
+}
+
+// Request the manifest of a directory from a peer that is storing it and check it against its key
+func (client *Client) FetchDirectoryManifest(ip string, dirKey string) (*fileshare.DirectoryManifest, error) {
+	peerID, err := client.connectToPeer(ip)
 	if err != nil {
-		fmt.Println("Error reading directory", path)
 		return nil, err
 	}
-	mapping := map[string]any{}
 
-	for _, entry := range entries {
-		path := filepath.Join(path, entry.Name())
-		if entry.IsDir() {
-			sub_mapping, err := client.storeDirectory(ip, port, path)
-			if err != nil {
-				return nil, err
-			}
-			mapping[path] = sub_mapping
-		} else {
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return nil, err
-			}
-			filedata := FileData{
-				FileName: path,
-				Content:  data,
-			}
+	s, err := client.Host.NewStream(context.Background(), peerID, protocol.ID("orcanet-manifest/1.0/"+dirKey))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
 
-			file_hash, err := client.storeData(ip, port, path, &filedata)
-			if err != nil {
-				return nil, err
-			}
-			mapping[path] = file_hash
-		}
+	buf := bufio.NewReader(s)
+	lengthBytes := make([]byte, 4)
+	_, err = io.ReadFull(buf, lengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(lengthBytes))
+	_, err = io.ReadFull(buf, payload)
+	if err != nil {
+		return nil, err
 	}
-	return mapping, nil
+
+	manifest := &fileshare.DirectoryManifest{}
+	err = proto.Unmarshal(payload, manifest)
+	if err != nil {
+		return nil, err
+	}
+	err = orcaHash.VerifyDirectoryManifest(dirKey, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Check whether the key a peer is selling belongs to a directory rather than a single file
+func (client *Client) IsDirectory(ip string, key string) bool {
+	peerID, err := client.connectToPeer(ip)
+	if err != nil {
+		return false
+	}
+	supported, err := client.Host.Peerstore().SupportsProtocols(peerID, protocol.ID("orcanet-manifest/1.0/"+key))
+	return err == nil && len(supported) > 0
+}
+
+// Dial the peer behind a /p2p multiaddr and return its ID
+func (client *Client) connectToPeer(ip string) (peer.ID, error) {
+	peerMA, err := multiaddr.NewMultiaddr(ip)
+	if err != nil {
+		return "", err
+	}
+
+	peerInfo, err := peer.AddrInfoFromP2pAddr(peerMA)
+	if err != nil {
+		return "", err
+	}
+
+	client.Host.Peerstore().AddAddrs(peerInfo.ID, peerInfo.Addrs, peerstore.AddressTTL)
+
+	err = client.Host.Connect(context.Background(), *peerInfo)
+	if err != nil {
+		return "", err
+	}
+	return peerInfo.ID, nil
 }
 
 func (client *Client) storeData(ip, port, filename string, fileData *FileData) (string, error) {
@@ -505,9 +674,30 @@ func (client *Client) storeData(ip, port, filename string, fileData *FileData) (
 	return string(body), nil
 }
 
-func (client *Client) sendTransactionFee(coins string, address string, senderWalletPass string) error {
-	err := orcaBlockchain.SendToAddress(coins, address, senderWalletPass)
-	return err
+// Holders name an address for each download so they can tell its payments apart, older holders are paid at their listed address
+func chunkPaymentAddress(fileChunk *orcaJobs.FileChunk, walletAddress string) string {
+	if fileChunk.PaymentAddress != "" {
+		return fileChunk.PaymentAddress
+	}
+	return walletAddress
+}
+
+// Pay coins to address and record the purchase, entry says what was bought
+func (client *Client) sendTransactionFee(coins string, address string, senderWalletPass string, entry orcaAccounting.Entry) error {
+	txId, err := orcaBlockchain.SendToAddress(coins, address, senderWalletPass)
+	if err != nil {
+		return err
+	}
+	amount, err := strconv.ParseFloat(coins, 64)
+	if err != nil {
+		return err
+	}
+	entry.Kind = orcaAccounting.KindPurchase
+	entry.Amount = -amount
+	entry.TxId = txId
+	entry.Address = address
+	client.Accounts.Add(entry)
+	return nil
 }
 
 func (client *Client) AddJob(ip string, httpPort string, file_hash string, peerMultiaddr string) (string, error) {
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	orcaAccounting "orca-peer/internal/accounting"
+	orcaSubscription "orca-peer/internal/subscription"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// How many times a paid subscription is asked for, the publisher waits a while for the payment each time
+const subscriptionAttempts = 10
+
+// Ask a publisher about one of its feeds. days is 0 to only get the price and files of the feed,
+// otherwise address is empty to be quoted a subscription, or the address it was quoted and paid at to get a token.
+func (client *Client) RequestFeed(publisher peer.AddrInfo, feed string, days int64, address string) (*orcaSubscription.FeedResponse, error) {
+	client.Host.Peerstore().AddAddrs(publisher.ID, publisher.Addrs, peerstore.AddressTTL)
+	err := client.Host.Connect(context.Background(), publisher)
+	if err != nil {
+		return nil, err
+	}
+	s, err := client.Host.NewStream(context.Background(), publisher.ID, protocol.ID("orcanet-feed/1.0/"+feed))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	requestBytes, err := json.Marshal(orcaSubscription.FeedRequest{Days: days, Address: address})
+	if err != nil {
+		return nil, err
+	}
+	lengthBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(lengthBytes, uint32(len(requestBytes)))
+	_, err = s.Write(append(lengthBytes, requestBytes...))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.ReadFull(s, lengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(lengthBytes))
+	_, err = io.ReadFull(s, payload)
+	if err != nil {
+		return nil, err
+	}
+	response := &orcaSubscription.FeedResponse{}
+	err = json.Unmarshal(payload, response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// Pay a publisher for a number of days of a feed and save the token it signs in return.
+// Returns the files currently released under the feed.
+func (client *Client) Subscribe(publisher peer.AddrInfo, feed string, days int64, passKey string) ([]string, error) {
+	if days <= 0 {
+		return nil, errors.New("subscriptions must last at least one day")
+	}
+	response, err := client.RequestFeed(publisher, feed, days, "")
+	if err != nil {
+		return nil, err
+	}
+	// Free feeds answer with a token right away, others with where to pay
+	if response.Token == nil {
+		address := response.WalletAddress
+		if response.Price <= 0 || address == "" {
+			return nil, errors.New("publisher did not quote a price for the subscription")
+		}
+		err = client.sendTransactionFee(fmt.Sprint(response.Price), address, passKey, orcaAccounting.Entry{Peer: publisher.ID.String(), Note: "subscription to " + feed})
+		if err != nil {
+			return nil, err
+		}
+		for attempt := 1; ; attempt++ {
+			response, err = client.RequestFeed(publisher, feed, days, address)
+			if err == nil || attempt == subscriptionAttempts {
+				break
+			}
+			fmt.Println("Waiting for the publisher to see the payment:", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	publisherKey := client.Host.Peerstore().PubKey(publisher.ID)
+	if publisherKey == nil {
+		return nil, errors.New("unable to find the publisher's public key")
+	}
+	_, err = orcaSubscription.VerifyToken(response.Token, publisherKey, client.Host.Peerstore().PubKey(client.Host.ID()), feed)
+	if err != nil {
+		return nil, err
+	}
+	err = orcaSubscription.SaveToken(client.dataDir, response.Token)
+	if err != nil {
+		return nil, err
+	}
+	return response.FileKeys, nil
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	orcaCapability "orca-peer/internal/capability"
+	orcaInvoice "orca-peer/internal/invoice"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+/*
+ * Ask a holder for an invoice for a whole file and keep it until it is paid.
+ *
+ * Parameters:
+ *   ip: Multiaddr of the holder
+ *   fileKey: Key of the file
+ *   jobId: Job the file is downloaded for, if any
+ *
+ * Returns:
+ *   The record of the invoice, holding its orcacoin: URI
+ *   An error, if any
+ */
+func (client *Client) RequestInvoice(ip string, fileKey string, jobId string) (*orcaInvoice.Record, error) {
+	if client.Invoices == nil {
+		return nil, errors.New("invoices are not kept by this client")
+	}
+	peerID, err := client.connectToPeer(ip)
+	if err != nil {
+		return nil, err
+	}
+	s, err := client.Host.NewStream(context.Background(), peerID, protocol.ID(orcaInvoice.Protocol))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	requestBytes, err := json.Marshal(orcaInvoice.Request{
+		FileKey:      fileKey,
+		JobId:        jobId,
+		Capabilities: orcaCapability.LoadTokens(client.dataDir, peerID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	lengthBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(lengthBytes, uint32(len(requestBytes)))
+	_, err = s.Write(append(lengthBytes, requestBytes...))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.ReadFull(s, lengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(lengthBytes))
+	_, err = io.ReadFull(s, payload)
+	if err != nil {
+		return nil, err
+	}
+	response := orcaInvoice.Response{}
+	err = json.Unmarshal(payload, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+
+	holderKey := client.Host.Peerstore().PubKey(peerID)
+	if holderKey == nil {
+		return nil, errors.New("unable to find the holder's public key")
+	}
+	invoice, err := orcaInvoice.Verify(response.Invoice, holderKey)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.FileKey != fileKey {
+		return nil, errors.New("holder sent an invoice for another file")
+	}
+	return client.Invoices.Add(response.Invoice, orcaInvoice.RolePurchase)
+}
+
+// Returns the invoices this client paid to a holder for a file
+func (client *Client) paidInvoices(holder peer.ID, fileKey string) [][]byte {
+	if client.Invoices == nil {
+		return nil
+	}
+	holderKey := client.Host.Peerstore().PubKey(holder)
+	if holderKey == nil {
+		return nil
+	}
+	publisherKey, err := libp2pcrypto.MarshalPublicKey(holderKey)
+	if err != nil {
+		return nil
+	}
+	return client.Invoices.PaidFor(fileKey, publisherKey)
+}
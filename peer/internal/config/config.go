@@ -0,0 +1,302 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Join path elements onto a node's data directory, Path(dir, "files", "stored") is <dir>/files/stored.
+// Everything a node keeps, such as files, keys and settings, is under its data directory.
+func Path(dataDir string, elem ...string) string {
+	return filepath.Join(append([]string{dataDir}, elem...)...)
+}
+
+// A whole number that may also be written as a string in settings.json, "8080" and 8080 are the same
+type Number int64
+
+func (n *Number) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "" {
+		*n = 0
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s is not a whole number", data)
+	}
+	*n = Number(parsed)
+	return nil
+}
+
+func (n Number) String() string {
+	return strconv.FormatInt(int64(n), 10)
+}
+
+/*
+ * Settings of a node. Each setting is taken from the first of these that sets it:
+ *   1. A command line flag, such as --rpc-port 8080
+ *   2. An environment variable, ORCA_ followed by the JSON name, such as ORCA_RPC_PORT=8080
+ *   3. <data dir>/config/settings.json
+ *   4. The default
+ * The data directory itself is set with --data-dir or ORCA_DATA_DIR. Relative paths to files that
+ * come with Orca, such as the bootstrap list, are relative to the directory the node is started in.
+ */
+type Settings struct {
+	DataDir            string   `json:"-"`
+	MarketRPCPort      Number   `json:"RPC_PORT"`
+	MarketDHTPort      Number   `json:"DHT_PORT"`
+	HTTPAPIPort        Number   `json:"API_PORT"`
+	BlockchainPassword string   `json:"BLOCKCHAIN_PW"`
+	GatewayBudget      Number   `json:"GATEWAY_BUDGET"`
+	CreditChunks       Number   `json:"CREDIT_CHUNKS"`
+	Confirmations      Number   `json:"PAYMENT_CONFIRMATIONS"`
+	CreditLimit        Number   `json:"CREDIT_LIMIT"`
+	SettleCoins        Number   `json:"SETTLE_THRESHOLD"`
+	SettleInterval     Number   `json:"SETTLE_INTERVAL"`
+	DHTMode            string   `json:"DHT_MODE"`
+	SwarmKey           string   `json:"SWARM_KEY"`
+	BootstrapPeers     string   `json:"BOOTSTRAP_PEERS"`
+	MarketProtocol     string   `json:"MARKET_PROTOCOL"`
+	SpeedtestPeers     []string `json:"SPEEDTEST_PEERS"`
+	GeoIPDatabase      string   `json:"GEOIP_DB"`
+	CoinServerDir      string   `json:"COIN_SERVER_DIR"`
+}
+
+// Returns the default settings
+func Defaults() Settings {
+	return Settings{
+		DataDir:        ".",
+		MarketRPCPort:  8080,
+		MarketDHTPort:  8081,
+		HTTPAPIPort:    8082,
+		GatewayBudget:  0,
+		CreditChunks:   4,
+		Confirmations:  0,
+		CreditLimit:    100,
+		SettleCoins:    0,
+		SettleInterval: 600,
+		DHTMode:        "auto",
+		BootstrapPeers: "internal/cli/bootstrap.peers",
+		MarketProtocol: "orcanet/market",
+		SpeedtestPeers: []string{},
+		GeoIPDatabase:  "rsrc/GeoLite2-Country.mmdb",
+		CoinServerDir:  "../coin/server",
+	}
+}
+
+// A setting that can be given on the command line or in the environment
+type setting struct {
+	name  string // JSON name, the environment variable is ORCA_<name>
+	flag  string
+	usage string
+	set   func(settings *Settings, value string) error
+}
+
+func setNumber(field func(*Settings) *Number) func(*Settings, string) error {
+	return func(settings *Settings, value string) error {
+		return field(settings).UnmarshalJSON([]byte(value))
+	}
+}
+
+func setString(field func(*Settings) *string) func(*Settings, string) error {
+	return func(settings *Settings, value string) error {
+		*field(settings) = value
+		return nil
+	}
+}
+
+var settingsTable = []setting{
+	{"DATA_DIR", "data-dir", "directory holding the node's files, keys and settings", setString(func(s *Settings) *string { return &s.DataDir })},
+	{"RPC_PORT", "rpc-port", "port of the market RPC server", setNumber(func(s *Settings) *Number { return &s.MarketRPCPort })},
+	{"DHT_PORT", "dht-port", "port the libp2p host listens on", setNumber(func(s *Settings) *Number { return &s.MarketDHTPort })},
+	{"API_PORT", "api-port", "port of the HTTP API", setNumber(func(s *Settings) *Number { return &s.HTTPAPIPort })},
+	{"BLOCKCHAIN_PW", "blockchain-pw", "passphrase of the blockchain wallet", setString(func(s *Settings) *string { return &s.BlockchainPassword })},
+	{"GATEWAY_BUDGET", "gateway-budget", "coins the /orca/ gateway may spend on chunks", setNumber(func(s *Settings) *Number { return &s.GatewayBudget })},
+	{"CREDIT_CHUNKS", "credit-chunks", "chunks a consumer may download before paying for them", setNumber(func(s *Settings) *Number { return &s.CreditChunks })},
+	{"PAYMENT_CONFIRMATIONS", "payment-confirmations", "confirmations a payment for chunks needs, 0 accepts it from the mempool", setNumber(func(s *Settings) *Number { return &s.Confirmations })},
+	{"CREDIT_LIMIT", "credit-limit", "unpaid coins a consumer settling in batches may owe, 0 makes every consumer pay per chunk", setNumber(func(s *Settings) *Number { return &s.CreditLimit })},
+	{"SETTLE_THRESHOLD", "settle-threshold", "owe holders IOUs and pay them together once this many coins are owed, 0 pays every chunk on its own", setNumber(func(s *Settings) *Number { return &s.SettleCoins })},
+	{"SETTLE_INTERVAL", "settle-interval", "seconds between settlements of the IOUs owed to holders", setNumber(func(s *Settings) *Number { return &s.SettleInterval })},
+	{"DHT_MODE", "dht-mode", "DHT mode, auto, server or client", setString(func(s *Settings) *string { return &s.DHTMode })},
+	{"SWARM_KEY", "swarm-key", "swarm key file of a private market", setString(func(s *Settings) *string { return &s.SwarmKey })},
+	{"BOOTSTRAP_PEERS", "bootstrap-peers", "file listing the market's bootstrap peers", setString(func(s *Settings) *string { return &s.BootstrapPeers })},
+	{"MARKET_PROTOCOL", "market-protocol", "DHT protocol prefix of the market", setString(func(s *Settings) *string { return &s.MarketProtocol })},
+	{"SPEEDTEST_PEERS", "speedtest-peers", "comma separated peers to test network speeds against", func(s *Settings, value string) error {
+		s.SpeedtestPeers = []string{}
+		for _, p := range strings.Split(value, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				s.SpeedtestPeers = append(s.SpeedtestPeers, p)
+			}
+		}
+		return nil
+	}},
+	{"GEOIP_DB", "geoip-db", "GeoLite2 country database used to locate peers", setString(func(s *Settings) *string { return &s.GeoIPDatabase })},
+	{"COIN_SERVER_DIR", "coin-server-dir", "directory of the OrcaNetAPIServer executable", setString(func(s *Settings) *string { return &s.CoinServerDir })},
+}
+
+// Returns the command line flags of the settings, so a command parser can accept them
+func Flags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("settings", pflag.ContinueOnError)
+	for _, s := range settingsTable {
+		flags.String(s.flag, "", s.usage)
+	}
+	return flags
+}
+
+/*
+ * Load the settings from the command line, the environment and the settings file, and set the
+ * data directory. Arguments that are not settings, such as the command to run, are ignored.
+ *
+ * Parameters:
+ *   args: Command line arguments, without the program name
+ *
+ * Returns:
+ *   The validated settings
+ *   An error, if any
+ */
+func Load(args []string) (Settings, error) {
+	flags := Flags()
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.SetOutput(nopWriter{})
+	if err := flags.Parse(args); err != nil {
+		return Settings{}, err
+	}
+	lookupFlag := func(s setting) (string, bool) {
+		flag := flags.Lookup(s.flag)
+		return flag.Value.String(), flag.Changed
+	}
+	lookupEnv := func(s setting) (string, bool) {
+		return os.LookupEnv("ORCA_" + s.name)
+	}
+
+	settings := Defaults()
+	// The settings file is inside of the data directory, so find that first
+	for _, lookup := range []func(setting) (string, bool){lookupEnv, lookupFlag} {
+		if value, ok := lookup(settingsTable[0]); ok {
+			settings.DataDir = value
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(settings.DataDir, "config", "settings.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Settings{}, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return Settings{}, fmt.Errorf("config/settings.json: %w", err)
+		}
+	}
+	for _, lookup := range []func(setting) (string, bool){lookupEnv, lookupFlag} {
+		for _, s := range settingsTable[1:] {
+			if value, ok := lookup(s); ok {
+				if err := s.set(&settings, value); err != nil {
+					return Settings{}, fmt.Errorf("%s: %w", s.name, err)
+				}
+			}
+		}
+	}
+
+	if err := settings.resolvePaths(); err != nil {
+		return Settings{}, err
+	}
+	if err := settings.Validate(); err != nil {
+		return Settings{}, err
+	}
+	return settings, nil
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// The peer directory the binary was built into, bin/node is inside of it
+func installDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Dir(filepath.Dir(exe))
+}
+
+// Make every path absolute so they keep working whatever directory the node is in. Files that come
+// with Orca and are not in the working directory are looked for in the install directory, so the
+// node can be started from anywhere.
+func (settings *Settings) resolvePaths() error {
+	abs, err := filepath.Abs(settings.DataDir)
+	if err != nil {
+		return err
+	}
+	settings.DataDir = abs
+	for _, path := range []*string{&settings.SwarmKey, &settings.BootstrapPeers, &settings.GeoIPDatabase, &settings.CoinServerDir} {
+		if *path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(abs); err != nil && !filepath.IsAbs(*path) && installDir() != "" {
+			if installed := filepath.Join(installDir(), *path); installed != abs {
+				if _, err := os.Stat(installed); err == nil {
+					abs = installed
+				}
+			}
+		}
+		*path = abs
+	}
+	return nil
+}
+
+// Check that every setting has a usable value
+func (settings Settings) Validate() error {
+	ports := map[string]Number{"RPC_PORT": settings.MarketRPCPort, "DHT_PORT": settings.MarketDHTPort, "API_PORT": settings.HTTPAPIPort}
+	used := make(map[Number]string)
+	for _, name := range []string{"RPC_PORT", "DHT_PORT", "API_PORT"} {
+		port := ports[name]
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("%s must be between 1 and 65535, got %d", name, port)
+		}
+		if other, ok := used[port]; ok {
+			return fmt.Errorf("%s and %s are both %d", other, name, port)
+		}
+		used[port] = name
+	}
+	if settings.GatewayBudget < 0 {
+		return errors.New("GATEWAY_BUDGET cannot be negative")
+	}
+	if settings.CreditChunks < 1 {
+		return fmt.Errorf("CREDIT_CHUNKS must be at least 1, got %d", settings.CreditChunks)
+	}
+	if settings.Confirmations < 0 {
+		return errors.New("PAYMENT_CONFIRMATIONS cannot be negative")
+	}
+	if settings.CreditLimit < 0 {
+		return errors.New("CREDIT_LIMIT cannot be negative")
+	}
+	if settings.SettleCoins < 0 {
+		return errors.New("SETTLE_THRESHOLD cannot be negative")
+	}
+	if settings.SettleInterval < 1 {
+		return fmt.Errorf("SETTLE_INTERVAL must be at least 1, got %d", settings.SettleInterval)
+	}
+	switch strings.ToLower(settings.DHTMode) {
+	case "", "auto", "server", "client":
+	default:
+		return fmt.Errorf("DHT_MODE must be auto, server or client, got %s", settings.DHTMode)
+	}
+	for name, path := range map[string]string{"SWARM_KEY": settings.SwarmKey, "BOOTSTRAP_PEERS": settings.BootstrapPeers} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"orca-peer/internal/fileshare"
+	"os"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// Files are encrypted with AES-256-GCM in segments of segmentSize bytes so they never
+// have to fit in memory. Every segment gets its own nonce, the base nonce with the segment
+// index added to its last 8 bytes, and the last segment is marked through its additional data
+// so a truncated file does not decrypt.
+//
+// Format:
+//   magic | base nonce | [segment ciphertext + tag]...
+const (
+	segmentSize = 64 * 1024
+	keySize     = 32
+	magic       = "ORCAENC1"
+)
+
+var (
+	lastSegment  = []byte{1}
+	innerSegment = []byte{0}
+)
+
+// Returns a random content key
+func NewContentKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func segmentNonce(baseNonce []byte, index uint64) []byte {
+	nonce := make([]byte, len(baseNonce))
+	copy(nonce, baseNonce)
+	counter := binary.BigEndian.Uint64(nonce[len(nonce)-8:])
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter+index)
+	return nonce
+}
+
+func newGCM(contentKey []byte) (cipher.AEAD, error) {
+	if len(contentKey) != keySize {
+		return nil, errors.New("content key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt src into dst with a content key
+func Encrypt(dst io.Writer, src io.Reader, contentKey []byte) error {
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return err
+	}
+	baseNonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(baseNonce)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(append([]byte(magic), baseNonce...))
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(src, segmentSize)
+	segment := make([]byte, segmentSize)
+	for index := uint64(0); ; index++ {
+		bytesRead, err := io.ReadFull(reader, segment)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// a full segment is only the last one if nothing follows it
+		_, peekErr := reader.Peek(1)
+		additionalData := innerSegment
+		if bytesRead < segmentSize || peekErr == io.EOF {
+			additionalData = lastSegment
+		}
+		_, err = dst.Write(gcm.Seal(nil, segmentNonce(baseNonce, index), segment[:bytesRead], additionalData))
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(additionalData, lastSegment) {
+			return nil
+		}
+	}
+}
+
+// Decrypt src into dst with a content key, failing if any segment was modified, reordered or cut off
+func Decrypt(dst io.Writer, src io.Reader, contentKey []byte) error {
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(magic)+gcm.NonceSize())
+	_, err = io.ReadFull(src, header)
+	if err != nil {
+		return errors.New("file is not encrypted")
+	}
+	if string(header[:len(magic)]) != magic {
+		return errors.New("file is not encrypted")
+	}
+	baseNonce := header[len(magic):]
+
+	reader := bufio.NewReaderSize(src, segmentSize+gcm.Overhead())
+	segment := make([]byte, segmentSize+gcm.Overhead())
+	for index := uint64(0); ; index++ {
+		bytesRead, err := io.ReadFull(reader, segment)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, peekErr := reader.Peek(1)
+		additionalData := innerSegment
+		if bytesRead < len(segment) || peekErr == io.EOF {
+			additionalData = lastSegment
+		}
+		plaintext, err := gcm.Open(nil, segmentNonce(baseNonce, index), segment[:bytesRead], additionalData)
+		if err != nil {
+			return errors.New("file was modified or is not for this key")
+		}
+		_, err = dst.Write(plaintext)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(additionalData, lastSegment) {
+			return nil
+		}
+	}
+}
+
+// Encrypt the file at srcPath into dstPath
+func EncryptFile(srcPath string, dstPath string, contentKey []byte) error {
+	return transformFile(srcPath, dstPath, contentKey, Encrypt)
+}
+
+// Decrypt the file at srcPath into dstPath, dstPath is removed if decryption fails
+func DecryptFile(srcPath string, dstPath string, contentKey []byte) error {
+	return transformFile(srcPath, dstPath, contentKey, Decrypt)
+}
+
+func transformFile(srcPath string, dstPath string, contentKey []byte, transform func(io.Writer, io.Reader, []byte) error) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(dst)
+	err = transform(writer, src, contentKey)
+	if err == nil {
+		err = writer.Flush()
+	}
+	dst.Close()
+	if err != nil {
+		os.Remove(dstPath)
+	}
+	return err
+}
+
+// Wrap a content key for a recipient with RSA-OAEP, only RSA keys are supported
+func WrapKey(contentKey []byte, recipientKey libp2pcrypto.PubKey) ([]byte, error) {
+	stdKey, err := libp2pcrypto.PubKeyToStdKey(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := stdKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("recipient key is not an RSA public key")
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey, contentKey, []byte(magic))
+}
+
+// Unwrap a content key that was wrapped for this peer
+func UnwrapKey(wrappedKey []byte, privKey *rsa.PrivateKey) ([]byte, error) {
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, privKey, wrappedKey, []byte(magic))
+}
+
+// Wrap a content key for every recipient
+func WrapKeyForRecipients(contentKey []byte, recipients []libp2pcrypto.PubKey) ([]*fileshare.WrappedKey, error) {
+	wrappedKeys := make([]*fileshare.WrappedKey, 0)
+	for _, recipient := range recipients {
+		recipientKey, err := libp2pcrypto.MarshalPublicKey(recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrappedKey, err := WrapKey(contentKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrappedKeys = append(wrappedKeys, &fileshare.WrappedKey{RecipientKey: recipientKey, WrappedKey: wrappedKey})
+	}
+	return wrappedKeys, nil
+}
+
+// Find the content key wrapped for this peer and unwrap it
+func UnwrapKeyFromRecipients(wrappedKeys []*fileshare.WrappedKey, privKey *rsa.PrivateKey) ([]byte, error) {
+	_, libp2pPubKey, err := libp2pcrypto.KeyPairFromStdKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	recipientKey, err := libp2pcrypto.MarshalPublicKey(libp2pPubKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, wrappedKey := range wrappedKeys {
+		if bytes.Equal(wrappedKey.GetRecipientKey(), recipientKey) {
+			return UnwrapKey(wrappedKey.GetWrappedKey(), privKey)
+		}
+	}
+	return nil, errors.New("this file was not encrypted for you")
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"orca-peer/internal/config"
 	"orca-peer/internal/fileshare"
 	"os"
 	"strings"
@@ -18,8 +19,8 @@ type FileChunk struct {
 }
 
 // Returns hash key, fileinfo struct, and error if any
-// will write individual chunks to /files/stored
-func SaveChunkedFile(filePath string, fileName string) (string, fileshare.FileInfo, error) {
+// will write individual chunks to /files/stored inside of dataDir
+func SaveChunkedFile(dataDir string, filePath string, fileName string) (string, fileshare.FileInfo, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", fileshare.FileInfo{}, err
@@ -42,11 +43,11 @@ func SaveChunkedFile(filePath string, fileName string) (string, fileshare.FileIn
 		hasher.Write(chunk[:bytesRead])
 		hash := hasher.Sum(nil)
 		hashedFiles.Hashes = append(hashedFiles.Hashes, hex.EncodeToString(hash))
-		err = ioutil.WriteFile("./files/stored/"+hex.EncodeToString(hash), chunk, 0777)
+		err = ioutil.WriteFile(config.Path(dataDir, "files", "stored", hex.EncodeToString(hash)), chunk[:bytesRead], 0777)
 		if err != nil {
 			//clean up any written hashes
 			for _, chunkHash := range hashedFiles.Hashes {
-				err = os.Remove("./files/stored/" + chunkHash)
+				err = os.Remove(config.Path(dataDir, "files", "stored", chunkHash))
 				if err != nil {
 					return "", fileshare.FileInfo{}, errors.New(fmt.Sprintf("Failed to clean up removing partial chunks for error: %s", err))
 				}
@@ -62,11 +63,46 @@ func SaveChunkedFile(filePath string, fileName string) (string, fileshare.FileIn
 	// if _, err := io.Copy(hasher, file); err != nil {
 	// 	return "", nil, err
 	// }
-	fileKey.FileHash = string(hasher.Sum(nil))
+	fileKey.FileHash = hasher.Sum(nil)
 	fileKey.FileName = fileName
-	concatKey := fileKey.FileHash + strings.Join(fileKey.ChunkHashes, "") + fmt.Sprint(fileKey.FileSize) + fileKey.FileName
-	hasher.Reset()
-	hasher.Write([]byte(concatKey))
-	finalHashedKey := hex.EncodeToString(hasher.Sum(nil))
-	return finalHashedKey, fileKey, nil
+	return GetFileKey(&fileKey), fileKey, nil
+}
+
+// Returns the market key of a file, the hex encoded SHA-256 of its FileInfo fields
+func GetFileKey(fileInfo *fileshare.FileInfo) string {
+	concatKey := string(fileInfo.GetFileHash()) + strings.Join(fileInfo.GetChunkHashes(), "") + fmt.Sprint(fileInfo.GetFileSize()) + fileInfo.GetFileName()
+	hashedKey := sha256.Sum256([]byte(concatKey))
+	return hex.EncodeToString(hashedKey[:])
+}
+
+// Checks a reconstructed file against the chunk hashes and size of its FileInfo
+func VerifyChunkedFile(filePath string, fileInfo *fileshare.FileInfo) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	chunk := make([]byte, 4*1024*1024)
+	var fileSize int64
+	for chunkIndex := 0; ; chunkIndex++ {
+		bytesRead, err := io.ReadFull(file, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if bytesRead == 0 {
+			break
+		}
+		if chunkIndex >= len(fileInfo.GetChunkHashes()) {
+			return errors.New("file has more chunks than expected")
+		}
+		hash := sha256.Sum256(chunk[:bytesRead])
+		if hex.EncodeToString(hash[:]) != fileInfo.GetChunkHashes()[chunkIndex] {
+			return errors.New(fmt.Sprintf("chunk %d does not match its hash", chunkIndex))
+		}
+		fileSize += int64(bytesRead)
+	}
+	if fileSize != fileInfo.GetFileSize() {
+		return errors.New(fmt.Sprintf("file is %d bytes, expected %d", fileSize, fileInfo.GetFileSize()))
+	}
+	return nil
 }
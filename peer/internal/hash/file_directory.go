@@ -0,0 +1,84 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"orca-peer/internal/fileshare"
+	"path/filepath"
+)
+
+// Returns directory key, manifest, and error if any
+// will chunk every regular file below dirPath into /files/stored inside of dataDir
+func SaveChunkedDirectory(dataDir string, dirPath string, dirName string) (string, *fileshare.DirectoryManifest, error) {
+	manifest := &fileshare.DirectoryManifest{Name: dirName}
+	err := filepath.WalkDir(dirPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		fileKey, fileInfo, err := SaveChunkedFile(dataDir, path, entry.Name())
+		if err != nil {
+			return err
+		}
+		manifest.Entries = append(manifest.Entries, &fileshare.DirectoryEntry{
+			RelativePath: filepath.ToSlash(relativePath),
+			FileKey:      fileKey,
+			FileInfo:     &fileInfo,
+		})
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(manifest.Entries) == 0 {
+		return "", nil, errors.New("directory does not contain any files")
+	}
+	return GetDirectoryKey(manifest), manifest, nil
+}
+
+// Returns the market key of a directory, the hex encoded SHA-256 of its name and entries.
+// Entries are hashed in the order they appear in, which SaveChunkedDirectory keeps lexical.
+// Every field is prefixed with its length, so no two manifests hash the same fields.
+func GetDirectoryKey(manifest *fileshare.DirectoryManifest) string {
+	hasher := sha256.New()
+	writeField := func(field string) {
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(len(field)))
+		hasher.Write(length)
+		hasher.Write([]byte(field))
+	}
+	writeField(manifest.GetName())
+	for _, entry := range manifest.GetEntries() {
+		writeField(entry.GetRelativePath())
+		writeField(entry.GetFileKey())
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Checks that every entry of a manifest matches its file key and stays inside the directory
+func VerifyDirectoryManifest(dirKey string, manifest *fileshare.DirectoryManifest) error {
+	if GetDirectoryKey(manifest) != dirKey {
+		return errors.New("directory manifest does not match the requested key")
+	}
+	if !filepath.IsLocal(manifest.GetName()) {
+		return errors.New("directory manifest has an invalid name")
+	}
+	for _, entry := range manifest.GetEntries() {
+		if !filepath.IsLocal(filepath.FromSlash(entry.GetRelativePath())) {
+			return errors.New("directory manifest entry escapes the directory: " + entry.GetRelativePath())
+		}
+		if GetFileKey(entry.GetFileInfo()) != entry.GetFileKey() {
+			return errors.New("directory manifest entry does not match its file key: " + entry.GetRelativePath())
+		}
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"orca-peer/internal/config"
 	"os"
 	"path/filepath"
 )
@@ -61,8 +62,8 @@ func NewDataStore(path string) *DataStore {
 	}
 }
 
-func HashFile(address string) (string, error) {
-	f, err := os.Open("./files/" + address)
+func HashFile(dataDir string, address string) (string, error) {
+	f, err := os.Open(config.Path(dataDir, "files", address))
 	if err != nil {
 		return "", err
 	}
@@ -10,6 +10,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"orca-peer/internal/config"
 	"os"
 	"time"
 
@@ -128,15 +129,14 @@ func ParseRsaPublicKeyFromPemStr(pubPEM string) (*rsa.PublicKey, error) {
 	return nil, errors.New("key type is not RSA")
 }
 
-func LoadInKeys() (*rsa.PublicKey, *rsa.PrivateKey) {
-	fileContent, err := os.ReadFile("Makefile")
+// Load the node's keys from the config directory inside of dataDir, generating them if there are none
+func LoadInKeys(dataDir string) (*rsa.PublicKey, *rsa.PrivateKey) {
+	// Signed and verified below to check that the keys belong together
+	fileContent := []byte("orca key check")
 	var privateKey *rsa.PrivateKey
 	var publicKey *rsa.PublicKey
-	if err != nil {
-		fmt.Println("Error reading file:", err)
-		os.Exit(1)
-	}
-	folderName := "./config"
+	var err error
+	folderName := config.Path(dataDir, "config")
 	if _, err := os.Stat(folderName); os.IsNotExist(err) {
 		// Folder does not exist, create it
 		err := os.Mkdir(folderName, 0755) // 0755 is the permission mode for the folder
@@ -144,16 +144,16 @@ func LoadInKeys() (*rsa.PublicKey, *rsa.PrivateKey) {
 			fmt.Println("Error creating folder:", err)
 		}
 	}
-	_, err1 := os.Stat("./config/key.pub")
-	_, err2 := os.Stat("./config/key.priv")
+	_, err1 := os.Stat(config.Path(dataDir, "config", "key.pub"))
+	_, err2 := os.Stat(config.Path(dataDir, "config", "key.priv"))
 	if err1 == nil && err2 == nil {
 		fmt.Printf("Loading in public/private key locally...\n")
-		privateKeyContent, err := os.ReadFile("./config/key.priv")
+		privateKeyContent, err := os.ReadFile(config.Path(dataDir, "config", "key.priv"))
 		if err != nil {
 			fmt.Println("Error loading in key file:", err)
 			os.Exit(1)
 		}
-		publicKeyContent, err := os.ReadFile("./config/key.pub")
+		publicKeyContent, err := os.ReadFile(config.Path(dataDir, "config", "key.pub"))
 		if err != nil {
 			fmt.Println("Error loading in key file:", err)
 			os.Exit(1)
@@ -182,14 +182,14 @@ func LoadInKeys() (*rsa.PublicKey, *rsa.PrivateKey) {
 			fmt.Println("Error generating public key as PEM str:", err)
 			os.Exit(1)
 		}
-		os.WriteFile("./config/key.pub", pubBytes, 0644)
+		os.WriteFile(config.Path(dataDir, "config", "key.pub"), pubBytes, 0644)
 
 		privBytes := ExportRsaPrivateKeyAsPemStr(privateKey)
 		if err != nil {
 			fmt.Println("Error generating public key as PEM str:", err)
 			os.Exit(1)
 		}
-		os.WriteFile("./config/key.priv", privBytes, 0644)
+		os.WriteFile(config.Path(dataDir, "config", "key.priv"), privBytes, 0644)
 	}
 
 	// Sign file
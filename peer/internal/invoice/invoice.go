@@ -0,0 +1,220 @@
+package invoice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// An HTTP client without a libp2p host buys a byte range of a file in two requests. The first is
+// answered with 402 and a signed invoice, the client pays the invoice and sends it back base64
+// encoded in InvoiceHeader along with the id of its transaction in PaymentHeader.
+const (
+	InvoiceHeader = "X-Orca-Invoice"
+	PaymentHeader = "X-Orca-Payment"
+	URIHeader     = "X-Orca-Payment-URI" // The invoice as an orcacoin: URI, for wallets
+	Lifetime      = 15 * time.Minute
+)
+
+// Peers with a libp2p host ask for an invoice for a whole file over Protocol instead. Once it is
+// paid they send it with their chunk requests and are not charged per chunk.
+const Protocol = "orcanet-invoice/1.0"
+
+type Request struct {
+	FileKey      string   `json:"fileKey"`
+	JobId        string   `json:"jobId,omitempty"`
+	Capabilities [][]byte `json:"capabilities,omitempty"` // Needed to buy restricted files
+}
+
+type Response struct {
+	Invoice []byte `json:"invoice,omitempty"` // A marshalled SignedInvoice
+	URI     string `json:"uri,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Invoice struct {
+	Id           string `json:"id"`
+	FileKey      string `json:"fileKey"`
+	JobId        string `json:"jobId,omitempty"`    // Job of the consumer the invoice was issued for, if any
+	Consumer     string `json:"consumer,omitempty"` // Peer ID of the consumer, only it may download the file with the invoice
+	RangeStart   int64  `json:"rangeStart"`
+	RangeEnd     int64  `json:"rangeEnd"` // Inclusive, as in a Range header
+	Amount       int64  `json:"amount"`
+	Address      string `json:"address"` // Wallet address created for this invoice only
+	PublisherKey []byte `json:"publisherKey"`
+	ExpiresAt    int64  `json:"expiresAt"`
+}
+
+// The invoice is kept as the exact bytes that were signed, it still reads as plain JSON
+type SignedInvoice struct {
+	Invoice   json.RawMessage `json:"invoice"`
+	Signature []byte          `json:"signature"`
+}
+
+/*
+ * Sign an invoice for a byte range of a file.
+ *
+ * Parameters:
+ *   fileKey: Key of the file
+ *   rangeStart: First byte of the range
+ *   rangeEnd: Last byte of the range
+ *   amount: Coins to pay
+ *   address: Wallet address the coins must be sent to
+ *   privKey: Private key of the peer serving the file
+ *
+ * Returns:
+ *   A marshalled SignedInvoice
+ *   An error, if any
+ */
+func Create(fileKey string, rangeStart int64, rangeEnd int64, amount int64, address string, privKey libp2pcrypto.PrivKey) ([]byte, error) {
+	return CreateForJob(fileKey, "", "", rangeStart, rangeEnd, amount, address, privKey)
+}
+
+// Sign an invoice for a byte range of a file that a consumer peer is downloading for one of its jobs
+func CreateForJob(fileKey string, jobId string, consumer string, rangeStart int64, rangeEnd int64, amount int64, address string, privKey libp2pcrypto.PrivKey) ([]byte, error) {
+	publisherKey, err := libp2pcrypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	idBytes := make([]byte, 16)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, err
+	}
+	invoiceBytes, err := json.Marshal(Invoice{
+		Id:           hex.EncodeToString(idBytes),
+		FileKey:      fileKey,
+		JobId:        jobId,
+		Consumer:     consumer,
+		RangeStart:   rangeStart,
+		RangeEnd:     rangeEnd,
+		Amount:       amount,
+		Address:      address,
+		PublisherKey: publisherKey,
+		ExpiresAt:    time.Now().UTC().Add(Lifetime).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signature, err := privKey.Sign(invoiceBytes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(SignedInvoice{Invoice: invoiceBytes, Signature: signature})
+}
+
+// Check that an invoice was signed with pubKey and has not expired
+func Verify(data []byte, pubKey libp2pcrypto.PubKey) (*Invoice, error) {
+	invoice, err := VerifySignature(data, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().UTC().Unix() > invoice.ExpiresAt {
+		return nil, errors.New("invoice has expired")
+	}
+	return invoice, nil
+}
+
+// Check that an invoice was signed with pubKey, whether or not it has expired. The publisher
+// checks a paid invoice this way, as it only has to be paid before it expires.
+func VerifySignature(data []byte, pubKey libp2pcrypto.PubKey) (*Invoice, error) {
+	var signed SignedInvoice
+	err := json.Unmarshal(data, &signed)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := pubKey.Verify(signed.Invoice, signed.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errors.New("invoice signature invalid")
+	}
+	var invoice Invoice
+	err = json.Unmarshal(signed.Invoice, &invoice)
+	if err != nil {
+		return nil, err
+	}
+	publisherKey, err := libp2pcrypto.MarshalPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(invoice.PublisherKey, publisherKey) {
+		return nil, errors.New("invoice is for another peer")
+	}
+	return &invoice, nil
+}
+
+// Check that an invoice was signed by the publisher it names, for payers that only have the invoice
+func Open(data []byte) (*Invoice, libp2pcrypto.PubKey, error) {
+	var signed SignedInvoice
+	err := json.Unmarshal(data, &signed)
+	if err != nil {
+		return nil, nil, err
+	}
+	var invoice Invoice
+	err = json.Unmarshal(signed.Invoice, &invoice)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKey, err := libp2pcrypto.UnmarshalPublicKey(invoice.PublisherKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	verified, err := Verify(data, pubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return verified, pubKey, nil
+}
+
+// Parse a Range header holding a single range, an empty header is the whole file. The end is inclusive.
+func ParseRange(header string, size int64) (int64, int64, error) {
+	if size <= 0 {
+		return 0, 0, errors.New("file is empty")
+	}
+	if header == "" {
+		return 0, size - 1, nil
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errors.New("only a single byte range is supported")
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errors.New("invalid range")
+	}
+	if startStr == "" {
+		// suffix range, the last n bytes
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, errors.New("invalid range")
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, nil
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, errors.New("invalid range")
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errors.New("invalid range")
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end, nil
+}
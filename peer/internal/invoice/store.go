@@ -0,0 +1,158 @@
+package invoice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Which side of a sale a peer was on
+const (
+	RoleSale     = "sale"     // This peer issued the invoice
+	RolePurchase = "purchase" // This peer paid the invoice
+)
+
+// Where an invoice is in its life. Issued invoices stay open until their payment is seen by the
+// wallet, paid invoices until the wallet has seen their transaction with enough confirmations.
+const (
+	StatusOpen    = "open"
+	StatusPaid    = "paid"
+	StatusSettled = "settled"
+	StatusExpired = "expired"
+)
+
+// Everything a peer knows about one invoice, both sides keep one so every sale can be reconciled
+type Record struct {
+	Invoice   Invoice `json:"invoice"`
+	Signed    []byte  `json:"signed"` // The signed invoice, as it was issued
+	URI       string  `json:"uri"`
+	Role      string  `json:"role"`
+	Status    string  `json:"status"`
+	TxId      string  `json:"txid,omitempty"`
+	CreatedAt int64   `json:"createdAt"`
+	PaidAt    int64   `json:"paidAt,omitempty"`
+	SettledAt int64   `json:"settledAt,omitempty"`
+}
+
+// The invoices a peer issued and paid, saved to a JSON file after every change
+type Store struct {
+	path    string
+	records map[string]*Record
+	mut     sync.Mutex
+}
+
+// Open the store saved at path, it is empty if the file does not exist yet
+func NewStore(path string) *Store {
+	store := &Store{path: path, records: make(map[string]*Record)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return store
+	}
+	err = json.Unmarshal(data, &store.records)
+	if err != nil {
+		fmt.Println("Error reading invoices:", err)
+	}
+	return store
+}
+
+// Record a signed invoice this peer issued or is paying
+func (store *Store) Add(data []byte, role string) (*Record, error) {
+	invoice, _, err := Open(data)
+	if err != nil {
+		return nil, err
+	}
+	uri, err := URI(data)
+	if err != nil {
+		return nil, err
+	}
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	if _, ok := store.records[invoice.Id]; ok {
+		return nil, errors.New("invoice " + invoice.Id + " is already known")
+	}
+	record := &Record{
+		Invoice:   *invoice,
+		Signed:    data,
+		URI:       uri,
+		Role:      role,
+		Status:    StatusOpen,
+		CreatedAt: time.Now().UTC().Unix(),
+	}
+	store.records[invoice.Id] = record
+	copied := *record
+	return &copied, store.save()
+}
+
+// Returns a copy of the record of an invoice
+func (store *Store) Get(id string) (*Record, bool) {
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	record, ok := store.records[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *record
+	return &copied, true
+}
+
+// Returns copies of every record, oldest first
+func (store *Store) List() []Record {
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	records := make([]Record, 0, len(store.records))
+	for _, record := range store.records {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].CreatedAt != records[j].CreatedAt {
+			return records[i].CreatedAt < records[j].CreatedAt
+		}
+		return records[i].Invoice.Id < records[j].Invoice.Id
+	})
+	return records
+}
+
+// Change the record of an invoice and save the store
+func (store *Store) Update(id string, update func(record *Record)) error {
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	record, ok := store.records[id]
+	if !ok {
+		return errors.New("no invoice with id " + id)
+	}
+	update(record)
+	return store.save()
+}
+
+// Returns the signed invoices this peer paid to a publisher for a file, newest first
+func (store *Store) PaidFor(fileKey string, publisherKey []byte) [][]byte {
+	paid := make([][]byte, 0)
+	records := store.List()
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Role != RolePurchase || record.Invoice.FileKey != fileKey || string(record.Invoice.PublisherKey) != string(publisherKey) {
+			continue
+		}
+		if record.Status == StatusPaid || record.Status == StatusSettled {
+			paid = append(paid, record.Signed)
+		}
+	}
+	return paid
+}
+
+func (store *Store) save() error {
+	data, err := json.Marshal(store.records)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(store.path, data, 0644)
+}
@@ -0,0 +1,73 @@
+package invoice
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Invoices are handed to wallets as BIP21 style payment requests,
+// orcacoin:<address>?amount=<coins>&label=<file>&message=<text>&invoice=<signed invoice>.
+// The signed invoice is base64url encoded so the payer can check who is asking to be paid.
+const URIScheme = "orcacoin"
+
+// Returns the payment request URI of a signed invoice
+func URI(data []byte) (string, error) {
+	invoice, _, err := Open(data)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("amount", strconv.FormatInt(invoice.Amount, 10))
+	query.Set("label", invoice.FileKey)
+	query.Set("message", fmt.Sprintf("Bytes %d-%d of %s", invoice.RangeStart, invoice.RangeEnd, invoice.FileKey))
+	query.Set("invoice", base64.RawURLEncoding.EncodeToString(data))
+	return URIScheme + ":" + invoice.Address + "?" + query.Encode(), nil
+}
+
+/*
+ * Parse a payment request URI and check the invoice inside of it.
+ *
+ * Parameters:
+ *   uri: An orcacoin: URI carrying a signed invoice
+ *
+ * Returns:
+ *   The invoice, whose address and amount match the URI's
+ *   The signed invoice
+ *   An error, if any
+ */
+func ParseURI(uri string) (*Invoice, []byte, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(uri), URIScheme+":")
+	if !ok {
+		return nil, nil, errors.New("not an " + URIScheme + ": URI")
+	}
+	address, rawQuery, _ := strings.Cut(rest, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key := range query {
+		// BIP21 parameters starting with req- must be understood or the request refused
+		if strings.HasPrefix(key, "req-") {
+			return nil, nil, errors.New("unsupported required parameter " + key)
+		}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(query.Get("invoice"))
+	if err != nil || len(data) == 0 {
+		return nil, nil, errors.New("URI does not carry a signed invoice")
+	}
+	invoice, _, err := Open(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if address != invoice.Address {
+		return nil, nil, errors.New("URI pays another address than its invoice")
+	}
+	if amount := query.Get("amount"); amount != "" && amount != strconv.FormatInt(invoice.Amount, 10) {
+		return nil, nil, errors.New("URI asks for another amount than its invoice")
+	}
+	return invoice, data, nil
+}
@@ -1,14 +1,18 @@
 package jobs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"orca-peer/internal/fileshare"
+	orcaInvoice "orca-peer/internal/invoice"
+	orcaSettlement "orca-peer/internal/settlement"
 	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 type Job struct {
@@ -23,9 +27,15 @@ type Job struct {
 }
 
 type FileChunkRequest struct {
-	FileHash   string `json:"fileHash"`
-	ChunkIndex int    `json:"chunkIndex"`
-	JobId      string `json:"jobId"`
+	FileHash     string   `json:"fileHash"`
+	ChunkIndex   int      `json:"chunkIndex"`
+	JobId        string   `json:"jobId"`
+	Tokens       [][]byte `json:"tokens,omitempty"`       // Subscription tokens issued by the holder
+	Capabilities [][]byte `json:"capabilities,omitempty"` // Capability tokens issued by the holder, needed for restricted files
+	Invoices     [][]byte `json:"invoices,omitempty"`     // Invoices issued by the holder for the whole file that were paid
+	// Set by consumers that settle in batches, they send an IOU for the chunks they were served instead of paying for each
+	Batched bool   `json:"batched,omitempty"`
+	IOU     []byte `json:"iou,omitempty"` // The latest IOU signed for the holder
 }
 
 type FileChunk struct {
@@ -34,66 +44,90 @@ type FileChunk struct {
 	MaxChunk   int    `json:"maxChunk"`
 	JobId      string `json:"jobId"`
 	Data       []byte `json:"data"`
+	Subscribed bool   `json:"subscribed,omitempty"` // The chunk is covered by a subscription and needs no payment
+	Invoiced   bool   `json:"invoiced,omitempty"`   // The chunk is covered by a paid invoice and needs no payment
+	Error      string `json:"error,omitempty"`      // Set instead of Data when the holder refuses the request
+	// Where the holder expects payment for this download and how much each chunk costs. Payments are
+	// checked before more chunks are served, so a consumer that does not pay is cut off.
+	PaymentAddress string `json:"paymentAddress,omitempty"`
+	Price          int64  `json:"price,omitempty"`
+	// Set when the holder takes an IOU for the chunk, it stops serving once this many coins are owed at PaymentAddress
+	CreditLimit int64 `json:"creditLimit,omitempty"`
 }
 
 type JobManager struct {
+	DataDir           string // The node's data directory, jobs and the files they download are saved in it
 	Jobs              []Job
 	Mutex             sync.Mutex
 	Changed           bool
 	Host              host.Host
-	StoredFileInfoMap *map[string]fileshare.FileInfo
+	StoredFileInfoMap *map[string]*fileshare.FileInfo
+	PayChunk          func(holder peer.ID, fileChunk *FileChunk) error // Pays for each chunk a job downloads, chunks are not paid for if nil
+	Settlement        *orcaSettlement.Ledger                           // Owes holders that take IOUs instead of paying them per chunk, if set
+	Invoices          *orcaInvoice.Store                               // Invoices paid to holders are sent along with chunk requests, if set
 }
 
-var Manager JobManager
+// Create the job manager of a node, jobs are downloaded through its host into its data directory
+func NewJobManager(dataDir string, host host.Host, fileInfoMap *map[string]*fileshare.FileInfo) *JobManager {
+	return &JobManager{
+		DataDir:           dataDir,
+		Jobs:              make([]Job, 0),
+		Changed:           false,
+		Host:              host,
+		StoredFileInfoMap: fileInfoMap,
+	}
+}
 
-func GetJobStatus(jobId string) string {
-	for _, job := range Manager.Jobs {
+func (m *JobManager) GetJobStatus(jobId string) string {
+	for _, job := range m.Jobs {
 		if job.JobId == jobId {
 			return job.Status
 		}
 	}
 	return ""
 }
-func UpdateJobStatus(jobId string, status string) error {
-	Manager.Mutex.Lock()
-	for idx, job := range Manager.Jobs {
+func (m *JobManager) UpdateJobStatus(jobId string, status string) error {
+	m.Mutex.Lock()
+	for idx, job := range m.Jobs {
 		if job.JobId == jobId {
-			Manager.Jobs[idx].Status = status
-			Manager.Changed = true
+			m.Jobs[idx].Status = status
+			m.Changed = true
 			break
 		}
 	}
-	Manager.Mutex.Unlock()
+	m.Mutex.Unlock()
 	return nil
 }
-func InitPeriodicJobSave(host host.Host, fileInfoMap *map[string]fileshare.FileInfo) {
-	Manager = JobManager{
-		Jobs:              make([]Job, 0), // Initialize an empty slice of jobs
-		Mutex:             sync.Mutex{},   // Initialize a mutex
-		Changed:           false,
-		Host:              host,
-		StoredFileInfoMap: fileInfoMap,
-	}
 
+// Save the jobs every 10 seconds while they change, and once more when ctx is done
+func (m *JobManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
 	for {
-		time.Sleep(10 * time.Second)
-		Manager.Mutex.Lock()
-		if Manager.Changed {
-			SaveHistory(Manager.Jobs)
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		m.Mutex.Lock()
+		if m.Changed {
+			m.SaveHistory(m.Jobs)
+		}
+		m.Mutex.Unlock()
+		if ctx.Err() != nil {
+			return
 		}
-		Manager.Mutex.Unlock()
 	}
 }
-func UpdateJobCost(jobId string, additionalCost int) error {
-	Manager.Mutex.Lock()
-	for idx, job := range Manager.Jobs {
+func (m *JobManager) UpdateJobCost(jobId string, additionalCost int) error {
+	m.Mutex.Lock()
+	for idx, job := range m.Jobs {
 		if job.JobId == jobId {
-			Manager.Jobs[idx].AccumulatedCost += additionalCost
-			Manager.Changed = true
+			m.Jobs[idx].AccumulatedCost += additionalCost
+			m.Changed = true
 			break
 		}
 	}
-	Manager.Mutex.Unlock()
+	m.Mutex.Unlock()
 	return nil
 }
 
@@ -114,7 +148,7 @@ type RmFromHistoryReqPayload struct {
 	JobId string `json:"jobId"`
 }
 
-func RemoveFromHistoryHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) RemoveFromHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPatch {
 		var payload RmFromHistoryReqPayload
 		decoder := json.NewDecoder(r.Body)
@@ -123,7 +157,7 @@ func RemoveFromHistoryHandler(w http.ResponseWriter, r *http.Request) {
 			writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
 			return
 		}
-		err := RemoveFromHistory(payload.JobId)
+		err := m.RemoveFromHistory(payload.JobId)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			writeStatusUpdate(w, err.Error())
@@ -139,9 +173,9 @@ func RemoveFromHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func ClearHistoryHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) ClearHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPatch {
-		ClearHistory()
+		m.ClearHistory()
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.WriteHeader(http.StatusOK)
 		writeStatusUpdate(w, "success")
@@ -165,11 +199,11 @@ type JobInfoReqPayload struct {
 	JobId string `json:"jobId"`
 }
 
-func JobInfoHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) JobInfoHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		queryParams := r.URL.Query()
 		jobId := queryParams.Get("jobID")
-		job, err := FindJob(jobId)
+		job, err := m.FindJob(jobId)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			writeStatusUpdate(w, err.Error())
@@ -191,7 +225,7 @@ func JobInfoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func StartJobsHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) StartJobsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPut {
 		var jobIds []JobInfoReqPayload
 		decoder := json.NewDecoder(r.Body)
@@ -201,7 +235,7 @@ func StartJobsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		for _, jobId := range jobIds {
-			err := StartJob(jobId.JobId)
+			err := m.StartJob(jobId.JobId)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				writeStatusUpdate(w, err.Error())
@@ -215,7 +249,7 @@ func StartJobsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func PauseJobsHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) PauseJobsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPatch {
 		var jobIds []JobInfoReqPayload
 		decoder := json.NewDecoder(r.Body)
@@ -225,7 +259,7 @@ func PauseJobsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		for _, jobId := range jobIds {
-			err := PauseJob(jobId.JobId)
+			err := m.PauseJob(jobId.JobId)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				writeStatusUpdate(w, err.Error())
@@ -239,7 +273,7 @@ func PauseJobsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func TerminateJobsHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) TerminateJobsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPatch {
 		var jobIds []JobInfoReqPayload
 		decoder := json.NewDecoder(r.Body)
@@ -249,7 +283,7 @@ func TerminateJobsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		for _, jobId := range jobIds {
-			err := TerminateJob(jobId.JobId)
+			err := m.TerminateJob(jobId.JobId)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				writeStatusUpdate(w, err.Error())
@@ -263,9 +297,9 @@ func TerminateJobsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
-func JobListHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) JobListHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		currentJobs := Manager.Jobs
+		currentJobs := m.Jobs
 		jsonData, err := json.Marshal(currentJobs)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -282,9 +316,9 @@ func JobListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+func (m *JobManager) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		histories, err := LoadHistory()
+		histories, err := m.LoadHistory()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			writeStatusUpdate(w, "Unable to read all histories")
@@ -305,13 +339,13 @@ func GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func InitJobRoutes() {
-	http.HandleFunc("/terminate-jobs", TerminateJobsHandler)
-	http.HandleFunc("/pause-jobs", PauseJobsHandler)
-	http.HandleFunc("/job-info", JobInfoHandler)
-	http.HandleFunc("/start-jobs", StartJobsHandler)
-	http.HandleFunc("/remove-from-history", RemoveFromHistoryHandler)
-	http.HandleFunc("/clear-history", ClearHistoryHandler)
-	http.HandleFunc("/get-history", GetHistoryHandler)
-	http.HandleFunc("/job-list", JobListHandler)
+func (m *JobManager) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/terminate-jobs", m.TerminateJobsHandler)
+	mux.HandleFunc("/pause-jobs", m.PauseJobsHandler)
+	mux.HandleFunc("/job-info", m.JobInfoHandler)
+	mux.HandleFunc("/start-jobs", m.StartJobsHandler)
+	mux.HandleFunc("/remove-from-history", m.RemoveFromHistoryHandler)
+	mux.HandleFunc("/clear-history", m.ClearHistoryHandler)
+	mux.HandleFunc("/get-history", m.GetHistoryHandler)
+	mux.HandleFunc("/job-list", m.JobListHandler)
 }
@@ -8,23 +8,27 @@ import (
 	"github.com/multiformats/go-multiaddr"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
 	"bufio"
 	"log"
 	"fmt"
 	"io"
 	"context"
 	"encoding/binary"
+	orcaCapability "orca-peer/internal/capability"
+	orcaSubscription "orca-peer/internal/subscription"
+	"orca-peer/internal/config"
 )
 
-func AddJob(job Job) {
-	Manager.Mutex.Lock()
-	Manager.Jobs = append(Manager.Jobs, job)
-	Manager.Changed = true
-	Manager.Mutex.Unlock()
+func (m *JobManager) AddJob(job Job) {
+	m.Mutex.Lock()
+	m.Jobs = append(m.Jobs, job)
+	m.Changed = true
+	m.Mutex.Unlock()
 }
 
-func LoadHistory() ([]Job, error) {
-	fileData, err := os.ReadFile("./internal/jobs/jobs.json")
+func (m *JobManager) LoadHistory() ([]Job, error) {
+	fileData, err := os.ReadFile(config.Path(m.DataDir, "files", "jobs.json"))
 	if err != nil {
 		return nil, err
 	}
@@ -35,257 +39,286 @@ func LoadHistory() ([]Job, error) {
 	}
 	return jobs, nil
 }
-func SaveHistory(jobs []Job) error {
-	Manager.Changed = false
+func (m *JobManager) SaveHistory(jobs []Job) error {
+	m.Changed = false
 	jsonData, err := json.Marshal(jobs)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile("./internal/jobs/jobs.json", jsonData, 0644)
+	err = os.WriteFile(config.Path(m.DataDir, "files", "jobs.json"), jsonData, 0644)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func RemoveFromHistory(jobId string) error {
-	Manager.Mutex.Lock()
-	for idx, job := range Manager.Jobs {
+func (m *JobManager) RemoveFromHistory(jobId string) error {
+	m.Mutex.Lock()
+	for idx, job := range m.Jobs {
 		if job.JobId == jobId {
-			Manager.Jobs = append(Manager.Jobs[:idx], Manager.Jobs[idx+1:]...)
-			Manager.Changed = true
-			Manager.Mutex.Unlock()
+			m.Jobs = append(m.Jobs[:idx], m.Jobs[idx+1:]...)
+			m.Changed = true
+			m.Mutex.Unlock()
 			return nil
 		}
 	}
-	Manager.Mutex.Unlock()
+	m.Mutex.Unlock()
 	return errors.New("unable to find job that matches jobID")
 }
 
-func ClearHistory() {
-	Manager.Mutex.Lock()
+func (m *JobManager) ClearHistory() {
+	m.Mutex.Lock()
 	newJobs := make([]Job, 0)
-	for _, job := range Manager.Jobs {
+	for _, job := range m.Jobs {
 		if job.Status != "completed" {
-			Manager.Changed = true
+			m.Changed = true
 			newJobs = append(newJobs, job)
 		}
 	}
-	Manager.Jobs = newJobs
-	Manager.Mutex.Unlock()
+	m.Jobs = newJobs
+	m.Mutex.Unlock()
 }
 
-func TerminateJob(jobId string) error {
-	Manager.Mutex.Lock()
-	for idx, job := range Manager.Jobs {
+func (m *JobManager) TerminateJob(jobId string) error {
+	m.Mutex.Lock()
+	for idx, job := range m.Jobs {
 		if job.JobId == jobId {
-			Manager.Jobs[idx].Status = "terminated"
-			Manager.Changed = true
-			Manager.Mutex.Unlock()
+			m.Jobs[idx].Status = "terminated"
+			m.Changed = true
+			m.Mutex.Unlock()
 			return nil
 		}
 	}
-	Manager.Mutex.Unlock()
+	m.Mutex.Unlock()
 	return errors.New("Unable to find jobId: " + jobId)
 }
 
-func PauseJob(jobId string) error {
-	Manager.Mutex.Lock()
-	for idx, job := range Manager.Jobs {
+func (m *JobManager) PauseJob(jobId string) error {
+	m.Mutex.Lock()
+	for idx, job := range m.Jobs {
 		if job.JobId == jobId {
-			Manager.Jobs[idx].Status = "paused"
-			Manager.Changed = true
-			Manager.Mutex.Unlock()
+			m.Jobs[idx].Status = "paused"
+			m.Changed = true
+			m.Mutex.Unlock()
 			return nil
 		}
 	}
-	Manager.Mutex.Unlock()
+	m.Mutex.Unlock()
 	return errors.New("Unable to find jobId: " + jobId)
 }
 
+// Returns the invoices paid to a holder for a whole file, the holder serves the file without charging per chunk
+func (m *JobManager) paidInvoices(holder peer.ID, fileKey string) [][]byte {
+	if m.Invoices == nil {
+		return nil
+	}
+	holderKey := m.Host.Peerstore().PubKey(holder)
+	if holderKey == nil {
+		return nil
+	}
+	publisherKey, err := libp2pcrypto.MarshalPublicKey(holderKey)
+	if err != nil {
+		return nil
+	}
+	return m.Invoices.PaidFor(fileKey, publisherKey)
+}
+
 //TODO in any error situtation stop/delete job?
-func StartJob(jobId string) error {
-	Manager.Mutex.Lock()
-	for idx, job := range Manager.Jobs {
-		if job.JobId == jobId {
-			Manager.Jobs[idx].Status = "active"
-			Manager.Changed = true
-			host := Manager.Host
-			
-			peerMA, err := multiaddr.NewMultiaddr(job.PeerId)
-			if err != nil {
-				log.Println(err)
-				Manager.Mutex.Unlock()
-				return err
-			}
+func (m *JobManager) StartJob(jobId string) error {
+	// The lock only guards the jobs, it is not held while the job downloads so other jobs are not blocked
+	m.Mutex.Lock()
+	found := false
+	var job Job
+	for idx := range m.Jobs {
+		if m.Jobs[idx].JobId == jobId {
+			m.Jobs[idx].Status = "active"
+			m.Changed = true
+			job = m.Jobs[idx]
+			found = true
+			break
+		}
+	}
+	host := m.Host
+	m.Mutex.Unlock()
+	if !found {
+		return errors.New("Unable to find jobId: " + jobId)
+	}
 
-			peer, err := peer.AddrInfoFromP2pAddr(peerMA)
-			if err != nil {
-				log.Println(err)
-				Manager.Mutex.Unlock()
-				return err
-			}
+	peerMA, err := multiaddr.NewMultiaddr(job.PeerId)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 
-			host.Peerstore().AddAddrs(peer.ID, peer.Addrs, peerstore.AddressTTL)
+	peer, err := peer.AddrInfoFromP2pAddr(peerMA)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 
-			err = host.Connect(context.Background(), *peer)
-			if err != nil {
-				log.Println(err)
-				Manager.Mutex.Unlock()
-				return err
-			}
+	host.Peerstore().AddAddrs(peer.ID, peer.Addrs, peerstore.AddressTTL)
 
-			s, err := host.NewStream(context.Background(), peer.ID, protocol.ID("orcanet-fileshare/1.0/" + job.FileHash))
-			if err != nil {
-				log.Println(err)
-				Manager.Mutex.Unlock()
-				return err
-			}
-			defer s.Close()
+	err = host.Connect(context.Background(), *peer)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 
-			Manager.Mutex.Unlock()
-			job, err := FindJob(jobId)
-			Manager.Mutex.Lock()
-			if err != nil {
-				fmt.Println("Error:", err)
-				Manager.Mutex.Unlock()
-				return err
-			}
+	s, err := host.NewStream(context.Background(), peer.ID, protocol.ID("orcanet-fileshare/1.0/" + job.FileHash))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer s.Close()
 
-			fileChunkReq := FileChunkRequest{
-				FileHash: job.FileHash,
-				ChunkIndex: 0,
-				JobId: job.JobId,
-			}
+	job, err = m.FindJob(jobId)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return err
+	}
 
-			nextChunkReqBytes, err := json.Marshal(fileChunkReq)
+	tokens := orcaSubscription.LoadTokens(m.DataDir, peer.ID)
+	capabilities := orcaCapability.LoadTokens(m.DataDir, peer.ID)
+	invoices := m.paidInvoices(peer.ID, job.FileHash)
+	fileChunkReq := FileChunkRequest{
+		FileHash: job.FileHash,
+		ChunkIndex: 0,
+		JobId: job.JobId,
+		Tokens: tokens,
+		Capabilities: capabilities,
+		Invoices: invoices,
+		Batched: m.Settlement != nil,
+		IOU: m.Settlement.LatestIOU(peer.ID),
+	}
+
+	nextChunkReqBytes, err := json.Marshal(fileChunkReq)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return err
+	}
+
+	lengthBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(lengthBytes, uint32(len(nextChunkReqBytes)))
+	_, err = s.Write(lengthBytes)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+
+	_, err = s.Write(nextChunkReqBytes)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+
+	for {
+		buf := bufio.NewReader(s)
+		lengthBytes := make([]byte, 0)
+		for i := 0; i < 4; i++ {
+			b, err := buf.ReadByte()
 			if err != nil {
-				fmt.Println("Error:", err)
-				Manager.Mutex.Unlock()
+				fmt.Println(err)
 				return err
 			}
+			lengthBytes = append(lengthBytes, b)
+		}
 
-			lengthBytes := make([]byte, 4)
-    		binary.LittleEndian.PutUint32(lengthBytes, uint32(len(nextChunkReqBytes)))
-			_, err = s.Write(lengthBytes)
-			if err != nil {
-				fmt.Println(err)
-				Manager.Mutex.Unlock()
-				return nil
+		length := binary.LittleEndian.Uint32(lengthBytes)
+		payload := make([]byte, length)
+		_, err := io.ReadFull(buf, payload)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		fileChunk := FileChunk{}
+		err = json.Unmarshal(payload, &fileChunk)
+		if err != nil {
+			fmt.Println("Error unmarshaling JSON:", err)
+			return err
+		}
+
+		_, err = m.FindJob(fileChunk.JobId)
+		if err != nil {
+			log.Fatal(err)
+			return err
+		}
+		if fileChunk.Error != "" {
+			fmt.Println("Error:", fileChunk.Error)
+			return errors.New(fileChunk.Error)
+		}
+		hash := fileChunk.FileHash
+
+		file, err := os.OpenFile(config.Path(m.DataDir, "files", "requested", hash), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		defer file.Close()
+
+		_, err = file.Write(fileChunk.Data)
+		if err != nil {
+			log.Fatal(err)
+			return err
+		}
+
+		fmt.Printf("Chunk %d for %s received and written\n", hash, fileChunk.ChunkIndex)
+
+		if !fileChunk.Subscribed && !fileChunk.Invoiced && fileChunk.Price > 0 && fileChunk.PaymentAddress != "" {
+			if fileChunk.CreditLimit > 0 && m.Settlement != nil {
+				err = m.Settlement.Accrue(peer.ID, fileChunk.PaymentAddress, hash, fileChunk.JobId, fileChunk.ChunkIndex, fileChunk.Price, fileChunk.CreditLimit)
+			} else if m.PayChunk != nil {
+				err = m.PayChunk(peer.ID, &fileChunk)
 			}
-			
-			_, err = s.Write(nextChunkReqBytes)
 			if err != nil {
-				fmt.Println(err)
-				Manager.Mutex.Unlock()
-				return nil
-			}
-
-			for {
-				buf := bufio.NewReader(s)
-				lengthBytes := make([]byte, 0)
-				for i := 0; i < 4; i++ {
-					b, err := buf.ReadByte()
-					if err != nil {
-						fmt.Println(err)
-						Manager.Mutex.Unlock()
-						return err
-					}	
-					lengthBytes = append(lengthBytes, b)
-				}
-		
-				length := binary.LittleEndian.Uint32(lengthBytes)
-				payload := make([]byte, length)
-				_, err := io.ReadFull(buf, payload)
-				if err != nil {
-					fmt.Println(err)
-					Manager.Mutex.Unlock()
-					return err
-				}
-				
-				fileChunk := FileChunk{}
-				err = json.Unmarshal(payload, &fileChunk)
-				if err != nil {
-					fmt.Println("Error unmarshaling JSON:", err)
-					Manager.Mutex.Unlock()
-					return err
-				}
-		
-				Manager.Mutex.Unlock()
-				_, err = FindJob(fileChunk.JobId)
-				Manager.Mutex.Lock()
-				if err != nil {
-					log.Fatal(err)
-					Manager.Mutex.Unlock()
-					return err
-				}
-				hash := fileChunk.FileHash
-			
-				file, err := os.OpenFile("./files/requested/" + hash, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-				defer file.Close()
-			
-				_, err = file.Write(fileChunk.Data)
-				if err != nil {
-					log.Fatal(err)
-					Manager.Mutex.Unlock()
-					return err
-				}
-		
-				fmt.Printf("Chunk %d for %s received and written\n", hash, fileChunk.ChunkIndex)
-		
-				if fileChunk.ChunkIndex == fileChunk.MaxChunk - 1 {
-					fmt.Println("All chunks received and written")
-					Manager.Mutex.Unlock()
-					return nil
-				}
-			
-				fileChunkReq := FileChunkRequest{
-					FileHash: hash,
-					ChunkIndex: fileChunk.ChunkIndex + 1,
-					JobId: fileChunk.JobId,
-				}
-			
-				nextChunkReqBytes, err := json.Marshal(fileChunkReq)
-				if err != nil {
-					fmt.Println("Error:", err)
-					Manager.Mutex.Unlock()
-					return err
-				}
-		
-				reqLengthHeader := make([]byte, 4)
-				binary.LittleEndian.PutUint32(reqLengthHeader, uint32(len(nextChunkReqBytes)))
-				_, err = s.Write(reqLengthHeader)
-				if err != nil {
-					fmt.Println(err)
-					Manager.Mutex.Unlock()
-					return err
-				}
-
-				_, err = s.Write(nextChunkReqBytes)
-				if err != nil {
-					fmt.Println(err)
-					Manager.Mutex.Unlock()
-					return err
-				}
+				fmt.Println("Error paying for chunk:", err)
+				return err
 			}
+		}
 
-			Manager.Mutex.Unlock()
+		if fileChunk.ChunkIndex == fileChunk.MaxChunk - 1 {
+			fmt.Println("All chunks received and written")
 			return nil
 		}
+
+		fileChunkReq := FileChunkRequest{
+			FileHash: hash,
+			ChunkIndex: fileChunk.ChunkIndex + 1,
+			JobId: fileChunk.JobId,
+			Tokens: tokens,
+			Capabilities: capabilities,
+			Invoices: invoices,
+			Batched: m.Settlement != nil,
+			IOU: m.Settlement.LatestIOU(peer.ID),
+		}
+
+		nextChunkReqBytes, err := json.Marshal(fileChunkReq)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+
+		reqLengthHeader := make([]byte, 4)
+		binary.LittleEndian.PutUint32(reqLengthHeader, uint32(len(nextChunkReqBytes)))
+		_, err = s.Write(reqLengthHeader)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		_, err = s.Write(nextChunkReqBytes)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
 	}
-	Manager.Mutex.Unlock()
-	return errors.New("Unable to find jobId: " + jobId)
 }
 
 
-func FindJob(jobId string) (Job, error) {
-	Manager.Mutex.Lock()
-	for _, job := range Manager.Jobs {
+func (m *JobManager) FindJob(jobId string) (Job, error) {
+	m.Mutex.Lock()
+	for _, job := range m.Jobs {
 		if job.JobId == jobId {
-			Manager.Mutex.Unlock()
+			m.Mutex.Unlock()
 			return job, nil
 		}
 	}
-	Manager.Mutex.Unlock()
+	m.Mutex.Unlock()
 	return Job{}, errors.New("unable to find job with specified jobId")
 }
@@ -0,0 +1,209 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"orca-peer/internal/fileshare"
+	orcaHash "orca-peer/internal/hash"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	FileExtension = ".orca"
+	LinkScheme    = "orca"
+)
+
+// The parts of an OrcaManifest that fit inside of an orca:// link
+type Link struct {
+	FileKey   string
+	FileName  string
+	FileSize  int64
+	Publisher peer.ID
+	Holders   []string
+}
+
+/*
+ * Create a manifest for a file and sign it with the publisher's key.
+ *
+ * Parameters:
+ *   fileKey: Hash of fileInfo
+ *   fileInfo: The chunk list of the file
+ *   metadata: Free form metadata, may be nil
+ *   holders: Peers known to hold the file, may be empty
+ *   wrappedKeys: Content keys wrapped for each recipient if the file is encrypted, may be empty
+ *   privKey: Private key of the publisher
+ *
+ * Returns:
+ *   The signed manifest
+ *   An error, if any
+ */
+func Create(fileKey string, fileInfo *fileshare.FileInfo, metadata map[string]string, holders []*fileshare.User, wrappedKeys []*fileshare.WrappedKey, privKey libp2pcrypto.PrivKey) (*fileshare.SignedOrcaManifest, error) {
+	if orcaHash.GetFileKey(fileInfo) != fileKey {
+		return nil, errors.New("file info does not match the file key")
+	}
+	publisherKey, err := libp2pcrypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	manifestBytes, err := proto.Marshal(&fileshare.OrcaManifest{
+		FileKey:      fileKey,
+		FileInfo:     fileInfo,
+		Metadata:     metadata,
+		PublisherKey: publisherKey,
+		Holders:      holders,
+		CreatedAt:    time.Now().UTC().Unix(),
+		WrappedKeys:  wrappedKeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	signature, err := privKey.Sign(manifestBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &fileshare.SignedOrcaManifest{Manifest: manifestBytes, Signature: signature}, nil
+}
+
+/*
+ * Check the publisher's signature of a manifest and that its file info matches its key.
+ *
+ * Parameters:
+ *   signed: The signed manifest
+ *
+ * Returns:
+ *   The manifest
+ *   An error, if any
+ */
+func Verify(signed *fileshare.SignedOrcaManifest) (*fileshare.OrcaManifest, error) {
+	manifest := &fileshare.OrcaManifest{}
+	err := proto.Unmarshal(signed.GetManifest(), manifest)
+	if err != nil {
+		return nil, err
+	}
+	publisherKey, err := libp2pcrypto.UnmarshalPublicKey(manifest.GetPublisherKey())
+	if err != nil {
+		return nil, err
+	}
+	valid, err := publisherKey.Verify(signed.GetManifest(), signed.GetSignature())
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errors.New("manifest signature invalid")
+	}
+	if orcaHash.GetFileKey(manifest.GetFileInfo()) != manifest.GetFileKey() {
+		return nil, errors.New("manifest file info does not match its file key")
+	}
+	return manifest, nil
+}
+
+// Returns the peer ID of the publisher of a verified manifest
+func Publisher(manifest *fileshare.OrcaManifest) (peer.ID, error) {
+	publisherKey, err := libp2pcrypto.UnmarshalPublicKey(manifest.GetPublisherKey())
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPublicKey(publisherKey)
+}
+
+// Marshal a signed manifest into the bytes of a .orca file
+func Marshal(signed *fileshare.SignedOrcaManifest) ([]byte, error) {
+	return proto.Marshal(signed)
+}
+
+// Unmarshal and verify the bytes of a .orca file
+func Unmarshal(data []byte) (*fileshare.OrcaManifest, error) {
+	signed := &fileshare.SignedOrcaManifest{}
+	err := proto.Unmarshal(data, signed)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(signed)
+}
+
+// Write a signed manifest to a .orca file
+func WriteFile(path string, signed *fileshare.SignedOrcaManifest) error {
+	data, err := Marshal(signed)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Read and verify a .orca file
+func ReadFile(path string) (*fileshare.OrcaManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(data)
+}
+
+/*
+ * Encode a manifest into a compact orca:// link. The link leaves out the chunk list,
+ * which a consumer learns from the holders, so it is only as trustworthy as the place it was pasted.
+ *
+ * Format:
+ *   orca://<fileKey>?name=<fileName>&size=<bytes>&publisher=<peerId>&holder=<multiaddr>...
+ */
+func ToLink(manifest *fileshare.OrcaManifest) (string, error) {
+	publisher, err := Publisher(manifest)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("name", manifest.GetFileInfo().GetFileName())
+	query.Set("size", fmt.Sprint(manifest.GetFileInfo().GetFileSize()))
+	query.Set("publisher", publisher.String())
+	for _, holder := range manifest.GetHolders() {
+		query.Add("holder", holder.GetIp())
+	}
+	link := url.URL{Scheme: LinkScheme, Host: manifest.GetFileKey(), RawQuery: query.Encode()}
+	return link.String(), nil
+}
+
+// Parse an orca:// link
+func ParseLink(link string) (*Link, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != LinkScheme {
+		return nil, errors.New("link must start with " + LinkScheme + "://")
+	}
+	fileKey := strings.ToLower(parsed.Host)
+	if len(fileKey) != 64 || strings.Trim(fileKey, "0123456789abcdef") != "" {
+		return nil, errors.New("link does not contain a valid file key")
+	}
+
+	query := parsed.Query()
+	result := &Link{FileKey: fileKey, FileName: query.Get("name")}
+	if size := query.Get("size"); size != "" {
+		result.FileSize, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, errors.New("link contains an invalid size")
+		}
+	}
+	if publisher := query.Get("publisher"); publisher != "" {
+		result.Publisher, err = peer.Decode(publisher)
+		if err != nil {
+			return nil, errors.New("link contains an invalid publisher")
+		}
+	}
+	for _, holder := range query["holder"] {
+		if _, err := multiaddr.NewMultiaddr(holder); err != nil {
+			return nil, errors.New("link contains an invalid holder address")
+		}
+		result.Holders = append(result.Holders, holder)
+	}
+	return result, nil
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	libp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+// One measurement of a peer
+type Sample struct {
+	Time      time.Time `json:"time"`
+	LatencyMs float64   `json:"latencyMs"` // Round trip time of a libp2p ping, 0 if the ping failed
+	RateIn    float64   `json:"rateIn"`    // Bytes per second received from the peer
+	RateOut   float64   `json:"rateOut"`   // Bytes per second sent to the peer
+}
+
+// Traffic exchanged with a peer or over a protocol
+type Traffic struct {
+	TotalIn  int64   `json:"totalIn"`
+	TotalOut int64   `json:"totalOut"`
+	RateIn   float64 `json:"rateIn"`
+	RateOut  float64 `json:"rateOut"`
+}
+
+// What we know about a connected peer, History is oldest first
+type PeerMetrics struct {
+	PeerID    string   `json:"peerId"`
+	LatencyMs float64  `json:"latencyMs"`
+	Traffic   Traffic  `json:"traffic"`
+	History   []Sample `json:"history"`
+}
+
+func toTraffic(stats libp2pmetrics.Stats) Traffic {
+	return Traffic{
+		TotalIn:  stats.TotalIn,
+		TotalOut: stats.TotalOut,
+		RateIn:   stats.RateIn,
+		RateOut:  stats.RateOut,
+	}
+}
+
+/*
+ * Measures every connected peer with the libp2p ping protocol and the host's bandwidth counter,
+ * and keeps a rolling history for each. Unlike ICMP pings the ping protocol needs no privileges,
+ * and it also works for peers behind relays.
+ */
+type Tracker struct {
+	host        host.Host
+	bandwidth   *libp2pmetrics.BandwidthCounter
+	historySize int
+	history     map[peer.ID][]Sample
+	mut         sync.Mutex
+}
+
+/*
+ * Create a tracker, the bandwidth counter must be the one the host was created with through
+ * libp2p.BandwidthReporter.
+ *
+ * Parameters:
+ *   h: libp2p host
+ *   bandwidth: The host's bandwidth counter
+ *   historySize: How many samples to keep for each peer
+ *
+ * Returns:
+ *   The tracker
+ */
+func NewTracker(h host.Host, bandwidth *libp2pmetrics.BandwidthCounter, historySize int) *Tracker {
+	return &Tracker{
+		host:        h,
+		bandwidth:   bandwidth,
+		historySize: historySize,
+		history:     make(map[peer.ID][]Sample),
+	}
+}
+
+// Sample every connected peer once, peers we are no longer connected to are forgotten
+func (tracker *Tracker) Sample(ctx context.Context) {
+	peers := tracker.host.Network().Peers()
+	samples := make(map[peer.ID]Sample)
+	var wg sync.WaitGroup
+	var samplesMut sync.Mutex
+	for _, id := range peers {
+		wg.Add(1)
+		go func(id peer.ID) {
+			defer wg.Done()
+			sample := Sample{Time: time.Now()}
+			pingCtx, cancel := context.WithTimeout(network.WithAllowLimitedConn(ctx, "metrics"), 5*time.Second)
+			defer cancel()
+			result := <-ping.Ping(pingCtx, tracker.host, id)
+			if result.Error == nil {
+				sample.LatencyMs = float64(result.RTT.Microseconds()) / 1000
+			}
+			stats := tracker.bandwidth.GetBandwidthForPeer(id)
+			sample.RateIn = stats.RateIn
+			sample.RateOut = stats.RateOut
+			samplesMut.Lock()
+			samples[id] = sample
+			samplesMut.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	tracker.mut.Lock()
+	defer tracker.mut.Unlock()
+	history := make(map[peer.ID][]Sample)
+	for id, sample := range samples {
+		peerHistory := append(tracker.history[id], sample)
+		if len(peerHistory) > tracker.historySize {
+			peerHistory = peerHistory[len(peerHistory)-tracker.historySize:]
+		}
+		history[id] = peerHistory
+	}
+	tracker.history = history
+}
+
+// Sample every interval until ctx is done
+func (tracker *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tracker.Sample(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Metrics of a peer, false if it has not been sampled while connected
+func (tracker *Tracker) Peer(id peer.ID) (PeerMetrics, bool) {
+	tracker.mut.Lock()
+	defer tracker.mut.Unlock()
+	history, ok := tracker.history[id]
+	if !ok {
+		return PeerMetrics{}, false
+	}
+	return tracker.peerMetrics(id, history), true
+}
+
+// Metrics of every connected peer that has been sampled
+func (tracker *Tracker) Peers() []PeerMetrics {
+	tracker.mut.Lock()
+	defer tracker.mut.Unlock()
+	peers := make([]PeerMetrics, 0, len(tracker.history))
+	for id, history := range tracker.history {
+		peers = append(peers, tracker.peerMetrics(id, history))
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PeerID < peers[j].PeerID })
+	return peers
+}
+
+func (tracker *Tracker) peerMetrics(id peer.ID, history []Sample) PeerMetrics {
+	metrics := PeerMetrics{
+		PeerID:  id.String(),
+		Traffic: toTraffic(tracker.bandwidth.GetBandwidthForPeer(id)),
+		History: append([]Sample{}, history...),
+	}
+	// Report the latest successful ping
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].LatencyMs > 0 {
+			metrics.LatencyMs = history[i].LatencyMs
+			break
+		}
+	}
+	return metrics
+}
+
+// Traffic over each protocol since the host started
+func (tracker *Tracker) Protocols() map[protocol.ID]Traffic {
+	protocols := make(map[protocol.ID]Traffic)
+	for id, stats := range tracker.bandwidth.GetBandwidthByProtocol() {
+		protocols[id] = toTraffic(stats)
+	}
+	return protocols
+}
+
+// Traffic of the whole host since it started
+func (tracker *Tracker) Total() Traffic {
+	return toTraffic(tracker.bandwidth.GetBandwidthTotals())
+}
@@ -1,8 +1,10 @@
 package mining
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"orca-peer/internal/config"
 	"os"
 	"sync"
 	"time"
@@ -21,29 +23,41 @@ type DeviceManager struct {
 	Devices []Device
 	Changed bool
 	Mutex   sync.Mutex
+	dataDir string
 }
 
-var Manager DeviceManager
-
-func InitDeviceTracker() {
-	Manager = DeviceManager{
+// Create the device tracker of a node, its devices are kept in files/devices.json inside of dataDir
+func NewDeviceManager(dataDir string) *DeviceManager {
+	return &DeviceManager{
 		Devices: make([]Device, 0), // Initialize an empty slice of jobs
-		Mutex:   sync.Mutex{},      // Initialize a mutex
 		Changed: false,
+		dataDir: dataDir,
 	}
-	devs, err := LoadHistory()
+}
+
+// Load the devices and save them every 10 seconds while they change, until ctx is done
+func (m *DeviceManager) Run(ctx context.Context) {
+	devs, err := m.LoadHistory()
 	if err != nil {
 		fmt.Println("Error loading devices, no devices will be shown.")
 		return
 	}
-	Manager.Devices = devs
+	m.Mutex.Lock()
+	m.Devices = devs
+	m.Mutex.Unlock()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
 	for {
-		time.Sleep(10 * time.Second)
-		Manager.Mutex.Lock()
-		if Manager.Changed {
-			SaveHistory(Manager.Devices)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.Mutex.Lock()
+		if m.Changed {
+			m.SaveHistory(m.Devices)
 		}
-		Manager.Mutex.Unlock()
+		m.Mutex.Unlock()
 	}
 }
 
@@ -51,8 +65,8 @@ func PutDevice() {
 
 }
 
-func LoadHistory() ([]Device, error) {
-	fileData, err := os.ReadFile("./internal/mining/devices.json")
+func (m *DeviceManager) LoadHistory() ([]Device, error) {
+	fileData, err := os.ReadFile(config.Path(m.dataDir, "files", "devices.json"))
 	if err != nil {
 		return nil, err
 	}
@@ -63,13 +77,13 @@ func LoadHistory() ([]Device, error) {
 	}
 	return jobs, nil
 }
-func SaveHistory(devices []Device) error {
-	Manager.Changed = false
+func (m *DeviceManager) SaveHistory(devices []Device) error {
+	m.Changed = false
 	jsonData, err := json.Marshal(devices)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile("./internal/mining/devices.json", jsonData, 0644)
+	err = os.WriteFile(config.Path(m.dataDir, "files", "devices.json"), jsonData, 0644)
 	if err != nil {
 		return err
 	}
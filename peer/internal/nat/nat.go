@@ -0,0 +1,160 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+/*
+ * Options for a host that works out whether it is reachable and gets around NATs when it is not.
+ * AutoNAT asks other peers to dial us back to learn our reachability, and we answer those requests
+ * for others in turn. When we are unreachable, autorelay reserves slots on relays from peerSource
+ * and advertises circuit addresses through them, and DCUtR upgrades relayed connections to direct
+ * ones by hole punching.
+ *
+ * Parameters:
+ *   peerSource: Returns candidate relays, it is called again whenever more are needed
+ *
+ * Returns:
+ *   The libp2p options
+ */
+func HostOptions(peerSource autorelay.PeerSource) []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.EnableRelay(),
+		libp2p.EnableNATService(),
+		libp2p.NATPortMap(),
+		libp2p.EnableHolePunching(),
+		libp2p.EnableAutoRelayWithPeerSource(peerSource, autorelay.WithMinInterval(time.Minute)),
+	}
+}
+
+func isRelayAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+/*
+ * Addresses for the host to listen on, TCP and QUIC over both IPv4 and IPv6 on the same port.
+ * Listening fails quietly on the ones the machine has no interface for.
+ *
+ * Parameters:
+ *   port: Port to listen on
+ *   quic: Whether to listen on QUIC, which does not work on a private network
+ *
+ * Returns:
+ *   The listen multiaddrs
+ */
+func ListenAddrs(port string, quic bool) []string {
+	addrs := []string{
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%s", port),
+		fmt.Sprintf("/ip6/::/tcp/%s", port),
+	}
+	if quic {
+		addrs = append(addrs,
+			fmt.Sprintf("/ip4/0.0.0.0/udp/%s/quic-v1", port),
+			fmt.Sprintf("/ip6/::/udp/%s/quic-v1", port),
+		)
+	}
+	return addrs
+}
+
+/*
+ * Pick the addresses to list this peer under on the market. Reachable peers are listed under their
+ * public addresses first, unreachable ones under relay circuits as soon as they have a reservation.
+ * Private addresses come last, they only help peers on the same network.
+ *
+ * Parameters:
+ *   id: ID of this peer
+ *   addrs: Addresses of the host, as returned by host.Addrs()
+ *   reachability: Reachability reported by AutoNAT
+ *
+ * Returns:
+ *   The multiaddrs ending in /p2p/<id>, best first
+ */
+func SelectAdvertiseAddrs(id peer.ID, addrs []ma.Multiaddr, reachability network.Reachability) []string {
+	var public, relayed, private []ma.Multiaddr
+	for _, addr := range addrs {
+		switch {
+		case isRelayAddr(addr):
+			relayed = append(relayed, addr)
+		case manet.IsIPLoopback(addr):
+		case manet.IsPublicAddr(addr):
+			public = append(public, addr)
+		default:
+			private = append(private, addr)
+		}
+	}
+
+	order := [][]ma.Multiaddr{public, relayed, private}
+	if reachability == network.ReachabilityPrivate {
+		order = [][]ma.Multiaddr{relayed, public, private}
+	}
+	selected := make([]string, 0)
+	for _, group := range order {
+		for _, addr := range group {
+			selected = append(selected, fmt.Sprintf("%s/p2p/%s", addr, id))
+		}
+	}
+	return selected
+}
+
+// Pick the best address to list this peer under, empty if there is no usable address
+func SelectAdvertiseAddr(id peer.ID, addrs []ma.Multiaddr, reachability network.Reachability) string {
+	selected := SelectAdvertiseAddrs(id, addrs, reachability)
+	if len(selected) == 0 {
+		return ""
+	}
+	return selected[0]
+}
+
+/*
+ * Keep track of the addresses this peer should be listed under. onChange is called with the new
+ * addresses whenever AutoNAT changes our reachability or our addresses change, for example when a
+ * relay reservation is made or lost. Returns once ctx is done.
+ *
+ * Parameters:
+ *   ctx: The context
+ *   h: libp2p host
+ *   current: The addresses the peer is listed under now
+ *   onChange: Called with the new addresses, best first
+ */
+func WatchAddresses(ctx context.Context, h host.Host, current []string, onChange func([]string)) {
+	sub, err := h.EventBus().Subscribe([]interface{}{new(event.EvtLocalReachabilityChanged), new(event.EvtLocalAddressesUpdated)})
+	if err != nil {
+		fmt.Println("Unable to watch for address changes:", err)
+		return
+	}
+	defer sub.Close()
+
+	reachability := network.ReachabilityUnknown
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			if reachabilityEvt, ok := evt.(event.EvtLocalReachabilityChanged); ok {
+				reachability = reachabilityEvt.Reachability
+				fmt.Println("Reachability is now", reachability)
+			}
+			addrs := SelectAdvertiseAddrs(h.ID(), h.Addrs(), reachability)
+			if len(addrs) > 0 && !slices.Equal(addrs, current) {
+				current = addrs
+				onChange(addrs)
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package node
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	orcaAccounting "orca-peer/internal/accounting"
+	"orca-peer/internal/api"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	orcaClient "orca-peer/internal/client"
+	"orca-peer/internal/config"
+	orcaHash "orca-peer/internal/hash"
+	orcaJobs "orca-peer/internal/jobs"
+	orcaMetrics "orca-peer/internal/metrics"
+	orcaMining "orca-peer/internal/mining"
+	orcaNat "orca-peer/internal/nat"
+	orcaServer "orca-peer/internal/server"
+	orcaSettlement "orca-peer/internal/settlement"
+	orcaSpeedtest "orca-peer/internal/speedtest"
+	orcaStatus "orca-peer/internal/status"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coloshword/OrcaNetAPIServer/supervisor"
+	"github.com/libp2p/go-libp2p"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	libp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// How long Stop waits for HTTP requests in flight to finish
+const shutdownTimeout = 5 * time.Second
+
+const (
+	coinServerAddr = "http://localhost:3333"
+	// The blockchain API server waits for OrcaNet and OrcaWallet to be ready before it answers, and
+	// stops them one after the other when it is stopped
+	coinServerReadyTimeout = 5 * time.Minute
+	coinServerStopTimeout  = 90 * time.Second
+)
+
+/*
+ * A peer node: its libp2p host, its place on the market, the HTTP API and the blockchain API server
+ * it pays through. Everything a node runs and keeps is its own, so several nodes can run in one
+ * process, as long as their ports and data directories differ.
+ */
+type Node struct {
+	Settings    config.Settings
+	PublicKey   *rsa.PublicKey
+	PrivateKey  *rsa.PrivateKey
+	Ip          string // Our public IP, empty if it could not be looked up
+	Host        host.Host
+	Server      *orcaServer.FileShareServerNode
+	Client      *orcaClient.Client
+	Jobs        *orcaJobs.JobManager
+	Metrics     *orcaMetrics.Tracker
+	SpeedTester *orcaSpeedtest.Tester
+	Devices     *orcaMining.DeviceManager
+
+	mux        *http.ServeMux
+	httpServer *http.Server
+	coinServer *supervisor.Supervisor
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	stopOnce   sync.Once
+	stopErr    error
+}
+
+/*
+ * Create a node. Nothing runs until Start is called.
+ *
+ * Parameters:
+ *   settings: The node's settings
+ *   pubKey: The node's public key
+ *   privKey: The node's private key, its libp2p identity is derived from it
+ *
+ * Returns:
+ *   The node
+ *   An error, if any
+ */
+func New(settings config.Settings, pubKey *rsa.PublicKey, privKey *rsa.PrivateKey) (*Node, error) {
+	libp2pPrivKey, _, err := libp2pcrypto.KeyPairFromStdKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate libp2p wrapped key from standard private key: %w", err)
+	}
+	client := orcaClient.NewClient(settings.DataDir)
+	client.PrivateKey = privKey
+	client.PublicKey = pubKey
+	return &Node{
+		Settings:   settings,
+		PublicKey:  pubKey,
+		PrivateKey: privKey,
+		Server:     orcaServer.NewFileShareServerNode(settings, libp2pPrivKey),
+		Client:     client,
+		Devices:    orcaMining.NewDeviceManager(settings.DataDir),
+		mux:        http.NewServeMux(),
+	}, nil
+}
+
+// The routes of the node's HTTP API, more can be added while it runs
+func (n *Node) Mux() *http.ServeMux {
+	return n.mux
+}
+
+/*
+ * Start the node: the blockchain API server, the libp2p host, the market and the HTTP API.
+ * Background work runs until ctx is done or Stop is called, and if the node cannot start
+ * whatever did start is stopped again.
+ *
+ * Parameters:
+ *   ctx: The context
+ *
+ * Returns:
+ *   An error, if any
+ */
+func (n *Node) Start(ctx context.Context) error {
+	ctx, n.cancel = context.WithCancel(ctx)
+	err := n.start(ctx)
+	if err != nil {
+		n.Stop()
+	}
+	return err
+}
+
+func (n *Node) start(ctx context.Context) error {
+	settings := n.Settings
+	if settings.CoinServerDir != "" {
+		n.startCoinServer(ctx)
+	}
+
+	accounts, err := orcaAccounting.Open(config.Path(settings.DataDir, "files", "ledger.db"), orcaBlockchain.TransactionFee)
+	if err != nil {
+		return err
+	}
+	n.Server.Accounts = accounts
+	publicKeyPEM, err := orcaHash.ExportRsaPublicKeyAsPemStr(n.PublicKey)
+	if err != nil {
+		return err
+	}
+	imported, err := accounts.ImportLegacy(config.Path(settings.DataDir, "files", "transactions"), string(publicKeyPEM))
+	if err != nil {
+		fmt.Println("Unable to import saved transactions into the ledger:", err)
+	} else if imported > 0 {
+		fmt.Printf("Imported %d saved transactions into the ledger\n", imported)
+	}
+
+	// Without internet there is no public IP to look up, peers still reach us through libp2p
+	var locationJson map[string]interface{}
+	err = json.Unmarshal([]byte(orcaStatus.GetLocationData()), &locationJson)
+	if err != nil {
+		fmt.Println("Unable to establish public IP, continuing without it")
+	}
+	n.Ip, _ = locationJson["ip"].(string)
+
+	bandwidth := libp2pmetrics.NewBandwidthCounter()
+	//Create host to listen on TCP and QUIC over IPv4 and IPv6, private networks only support TCP
+	opts := []libp2p.Option{
+		libp2p.ListenAddrStrings(orcaNat.ListenAddrs(settings.MarketDHTPort.String(), settings.SwarmKey == "")...),
+		libp2p.Identity(n.Server.PrivKey), //derive id from private key
+		libp2p.BandwidthReporter(bandwidth),
+	}
+	opts = append(opts, orcaNat.HostOptions(n.Server.RelayPeerSource)...)
+	privateNetworkOpts, err := orcaServer.PrivateNetworkOptions(settings.SwarmKey)
+	if err != nil {
+		return fmt.Errorf("unable to load swarm key: %w", err)
+	}
+	opts = append(opts, privateNetworkOpts...)
+	n.Host, err = libp2p.New(opts...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nlibp2p DHT Host ID: %s\n", n.Host.ID())
+	fmt.Println("DHT Market Multiaddr (if in server mode):")
+	for _, addr := range n.Host.Addrs() {
+		fmt.Printf("%s/p2p/%s\n", addr, n.Host.ID())
+	}
+	// Start out with our best guess, AutoNAT will tell us if we need a relay
+	hostMultiAddrs := orcaNat.SelectAdvertiseAddrs(n.Host.ID(), n.Host.Addrs(), network.ReachabilityUnknown)
+
+	n.Jobs = orcaJobs.NewJobManager(settings.DataDir, n.Host, &n.Server.StoredFileInfoMap)
+	n.Jobs.PayChunk = func(holder peer.ID, fileChunk *orcaJobs.FileChunk) error {
+		txId, err := orcaBlockchain.SendToAddress(fmt.Sprint(fileChunk.Price), fileChunk.PaymentAddress, n.Server.PassKey)
+		if err != nil {
+			return err
+		}
+		n.Server.Accounts.Add(orcaAccounting.Entry{
+			Kind:    orcaAccounting.KindPurchase,
+			Amount:  -float64(fileChunk.Price),
+			TxId:    txId,
+			Address: fileChunk.PaymentAddress,
+			JobId:   fileChunk.JobId,
+			FileKey: fileChunk.FileHash,
+			Peer:    holder.String(),
+		})
+		return nil
+	}
+	n.Client.Host = n.Host
+	n.Client.Jobs = n.Jobs
+	n.Client.Invoices = n.Server.Invoices
+	n.Jobs.Invoices = n.Server.Invoices
+	n.Client.Accounts = n.Server.Accounts
+	if settings.SettleCoins > 0 {
+		var ledger *orcaSettlement.Ledger
+		sendMany := func(amounts map[string]float64) (string, error) {
+			txId, err := orcaBlockchain.SendMany(amounts, n.Server.PassKey)
+			if err != nil {
+				return "", err
+			}
+			for _, debt := range ledger.Debts() {
+				if coins, ok := amounts[debt.Address]; ok {
+					n.Server.Accounts.Add(orcaAccounting.Entry{
+						Kind:    orcaAccounting.KindPurchase,
+						Amount:  -coins,
+						TxId:    txId,
+						Address: debt.Address,
+						Peer:    debt.Provider,
+						Note:    "settlement of chunk IOUs",
+					})
+				}
+			}
+			return txId, nil
+		}
+		interval := time.Duration(settings.SettleInterval) * time.Second
+		ledger = orcaSettlement.NewLedger(config.Path(settings.DataDir, "files", "settlement", "ledger.json"), n.Server.PrivKey, int64(settings.SettleCoins), interval, sendMany)
+		n.Server.Settlement = ledger
+		n.Client.Settlement = ledger
+		n.Jobs.Settlement = ledger
+	}
+	n.Server.Client = n.Client
+	n.Server.Jobs = n.Jobs
+	n.Metrics = orcaMetrics.NewTracker(n.Host, bandwidth, 60)
+	n.Server.SetMetricsTracker(n.Metrics)
+	orcaSpeedtest.Register(n.Host)
+	n.SpeedTester = orcaSpeedtest.NewTester(n.Host, settings.SpeedtestPeers, orcaSpeedtest.DefaultSize)
+	n.Server.SetSpeedTester(n.SpeedTester)
+
+	err = n.Server.StartMarket(ctx, n.Host, settings.MarketRPCPort.String(), settings.DHTMode, hostMultiAddrs)
+	if err != nil {
+		return err
+	}
+
+	n.Server.RegisterRoutes(n.mux)
+	n.Jobs.RegisterRoutes(n.mux)
+	api.NewAPI(n.Server, n.PublicKey, n.PrivateKey, n.Ip, int64(settings.HTTPAPIPort)).RegisterRoutes(n.mux)
+	orcaBlockchain.RegisterStatsRoutes(n.mux, n.Server.Accounts)
+
+	listener, err := net.Listen("tcp", ":"+settings.HTTPAPIPort.String())
+	if err != nil {
+		return err
+	}
+	n.httpServer = &http.Server{Handler: n.mux}
+	fmt.Printf("HTTP Listening on port %s...\n", settings.HTTPAPIPort)
+
+	n.run(func() {
+		if err := n.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Println("HTTP server stopped:", err)
+		}
+	})
+	n.run(func() { n.Metrics.Run(ctx, 10*time.Second) })
+	n.run(func() { n.Jobs.Run(ctx) })
+	n.run(func() { n.Devices.Run(ctx) })
+	if n.Server.Settlement != nil {
+		n.run(func() { n.Server.Settlement.Run(ctx) })
+	}
+	n.run(func() { orcaNat.WatchAddresses(ctx, n.Host, hostMultiAddrs, n.Server.SetHostMultiAddrs) })
+	return nil
+}
+
+// Start the blockchain API server in the background and keep it running. It takes a while to
+// become ready, so the node does not wait for it, payments fail until it answers.
+func (n *Node) startCoinServer(ctx context.Context) {
+	n.coinServer = supervisor.New(&supervisor.Process{
+		Name:    "OrcaNetAPIServer",
+		Path:    filepath.Join(n.Settings.CoinServerDir, "OrcaNetAPIServer"),
+		Dir:     n.Settings.CoinServerDir,
+		LogFile: config.Path(n.Settings.DataDir, "logs", "OrcaNetAPIServer.log"),
+		Ready: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, coinServerAddr+"/hello", nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("got %s", resp.Status)
+			}
+			return nil
+		},
+		ReadyTimeout: coinServerReadyTimeout,
+		StopTimeout:  coinServerStopTimeout,
+	})
+	n.run(func() {
+		if err := n.coinServer.Start(ctx); err != nil {
+			fmt.Println("Error starting block chain api server:", err)
+			return
+		}
+		fmt.Println("Started block chain api server")
+	})
+}
+
+// Run f in the background, Stop waits for it to return
+func (n *Node) run(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+/*
+ * Stop the node. Requests in flight are given a few seconds to finish, then the market is left,
+ * the host is closed, the jobs are saved and the blockchain API server is stopped, which stops
+ * OrcaNet and OrcaWallet in turn.
+ *
+ * Returns:
+ *   An error, if any
+ */
+func (n *Node) Stop() error {
+	n.stopOnce.Do(func() {
+		var errs []error
+		if n.cancel != nil {
+			n.cancel()
+		}
+		if n.httpServer != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			errs = append(errs, n.httpServer.Shutdown(ctx))
+			cancel()
+		}
+		errs = append(errs, n.Server.Close())
+		if n.Host != nil {
+			errs = append(errs, n.Host.Close())
+		}
+		n.wg.Wait()
+		errs = append(errs, n.Server.Accounts.Close())
+		if n.coinServer != nil {
+			errs = append(errs, n.coinServer.Stop())
+		}
+		n.stopErr = errors.Join(errs...)
+	})
+	return n.stopErr
+}
@@ -3,20 +3,27 @@ package relay
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	peers "github.com/libp2p/go-libp2p/core/peer"
+	pbv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/pb"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
+// Relays advertise themselves on the DHT under this namespace so NATed peers can find them
+const Namespace = "orcanet/relay"
+
 func ConnectRelay() {
 	peer, err := libp2p.New(
 		libp2p.NoListenAddrs,
@@ -58,15 +65,203 @@ func ConnectRelay() {
 	}
 }
 
+// Settings of relay mode, read from config/relay.json. Fields left out keep their default.
+type Config struct {
+	ListenAddrs            []string `json:"listenAddrs"`            // Extra addresses to accept relay traffic on
+	MaxReservations        int      `json:"maxReservations"`        // Peers that may hold a reservation at once
+	MaxReservationsPerPeer int      `json:"maxReservationsPerPeer"` // Reservations a single peer may hold
+	MaxReservationsPerIP   int      `json:"maxReservationsPerIP"`   // Reservations peers behind one IP may hold
+	MaxCircuits            int      `json:"maxCircuits"`            // Relayed connections open at once for each peer
+	ReservationTTL         int64    `json:"reservationTTL"`         // Seconds a reservation lasts before it must be refreshed
+	CircuitDuration        int64    `json:"circuitDuration"`        // Seconds a relayed connection may stay open
+	CircuitData            int64    `json:"circuitData"`            // Bytes a relayed connection may carry in each direction
+}
+
+// Returns the default relay settings, more generous than libp2p's since our relays carry file chunks
+func DefaultConfig() Config {
+	return Config{
+		ListenAddrs:            []string{},
+		MaxReservations:        128,
+		MaxReservationsPerPeer: 4,
+		MaxReservationsPerIP:   8,
+		MaxCircuits:            16,
+		ReservationTTL:         60 * 60,
+		CircuitDuration:        10 * 60,
+		CircuitData:            64 * 1024 * 1024,
+	}
+}
+
+// Load relay settings, a missing file gives the defaults
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+// Returns the circuit v2 resource limits of the settings
+func (config Config) Resources() relay.Resources {
+	resources := relay.DefaultResources()
+	resources.MaxReservations = config.MaxReservations
+	resources.MaxReservationsPerPeer = config.MaxReservationsPerPeer
+	resources.MaxReservationsPerIP = config.MaxReservationsPerIP
+	resources.MaxCircuits = config.MaxCircuits
+	resources.ReservationTTL = time.Duration(config.ReservationTTL) * time.Second
+	resources.Limit = &relay.RelayLimit{
+		Duration: time.Duration(config.CircuitDuration) * time.Second,
+		Data:     config.CircuitData,
+	}
+	return resources
+}
+
+// Usage of the relay since it started
+type Stats struct {
+	Active              bool  `json:"active"`
+	ActiveReservations  int64 `json:"activeReservations"`
+	ReservationsAllowed int64 `json:"reservationsAllowed"`
+	ReservationsRefused int64 `json:"reservationsRefused"`
+	ActiveCircuits      int64 `json:"activeCircuits"`
+	CircuitsOpened      int64 `json:"circuitsOpened"`
+	CircuitsRefused     int64 `json:"circuitsRefused"`
+	BytesRelayed        int64 `json:"bytesRelayed"`
+}
+
+// Counts relay events into Stats, the relay service reports them through its MetricsTracer
+type StatsTracer struct {
+	stats Stats
+	mut   sync.Mutex
+}
+
+var _ relay.MetricsTracer = &StatsTracer{}
+
+// Returns a copy of the current stats
+func (tracer *StatsTracer) Stats() Stats {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	return tracer.stats
+}
+
+func (tracer *StatsTracer) RelayStatus(enabled bool) {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.Active = enabled
+}
+
+func (tracer *StatsTracer) ConnectionOpened() {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.ActiveCircuits++
+	tracer.stats.CircuitsOpened++
+}
+
+func (tracer *StatsTracer) ConnectionClosed(d time.Duration) {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.ActiveCircuits--
+}
+
+func (tracer *StatsTracer) ConnectionRequestHandled(status pbv2.Status) {
+	if status == pbv2.Status_OK {
+		return
+	}
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.CircuitsRefused++
+}
+
+func (tracer *StatsTracer) ReservationAllowed(isRenewal bool) {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.ReservationsAllowed++
+	if !isRenewal {
+		tracer.stats.ActiveReservations++
+	}
+}
+
+func (tracer *StatsTracer) ReservationClosed(cnt int) {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.ActiveReservations -= int64(cnt)
+}
+
+func (tracer *StatsTracer) ReservationRequestHandled(status pbv2.Status) {
+	if status == pbv2.Status_OK {
+		return
+	}
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.ReservationsRefused++
+}
+
+func (tracer *StatsTracer) BytesTransferred(cnt int) {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	tracer.stats.BytesRelayed += int64(cnt)
+}
+
+/*
+ * Run a circuit v2 relay on a host. The host keeps its identity, so NATed peers holding a
+ * reservation keep working across restarts of the relay.
+ *
+ * Parameters:
+ *   h: libp2p host to relay through
+ *   config: Relay settings
+ *
+ * Returns:
+ *   The relay, close it to stop relaying
+ *   The tracer counting relay usage
+ *   An error, if any
+ */
+func StartRelay(h host.Host, config Config) (*relay.Relay, *StatsTracer, error) {
+	listenAddrs := make([]ma.Multiaddr, 0)
+	for _, addr := range config.ListenAddrs {
+		listenAddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		listenAddrs = append(listenAddrs, listenAddr)
+	}
+	if len(listenAddrs) > 0 {
+		err := h.Network().Listen(listenAddrs...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tracer := &StatsTracer{}
+	service, err := relay.New(h, relay.WithResources(config.Resources()), relay.WithMetricsTracer(tracer))
+	if err != nil {
+		return nil, nil, err
+	}
+	tracer.RelayStatus(true)
+
+	info := peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+	addrInfoJSON, err := json.Marshal(info)
+	if err != nil {
+		service.Close()
+		return nil, nil, err
+	}
+	addr = addrInfoJSON
+	log.Println("Successfully setup relay service, hit /relay on the HTTP API to get relay information")
+	log.Println("Relay ID:", h.ID())
+	log.Println("Relay Listen Addresses:", h.Addrs())
+	return service, tracer, nil
+}
+
 var addr []byte
 
-func SetupRelay() {
-	addr = createRelayServer()
-	quitChannel := make(chan os.Signal, 1)
-	signal.Notify(quitChannel, syscall.SIGINT, syscall.SIGTERM)
-	<-quitChannel
-	http.HandleFunc("/relay", handleRelay)
-	http.ListenAndServe(":45677", nil)
+// Register the HTTP routes describing the relay on mux, /relay for its address and /relay/stats for its usage
+func RegisterRoutes(mux *http.ServeMux, tracer *StatsTracer) {
+	mux.HandleFunc("/relay", handleRelay)
+	mux.HandleFunc("/relay/stats", func(w http.ResponseWriter, r *http.Request) {
+		handleRelayStats(w, r, tracer)
+	})
 }
 
 type RelayReponse struct {
@@ -89,34 +284,18 @@ func handleRelay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
-func createRelayServer() []byte {
-	relay1, err := libp2p.New()
-	if err != nil {
-		log.Printf("Failed to create relay1: %v", err)
-		return nil
-	}
 
-	_, err = relay.New(relay1)
-	if err != nil {
-		log.Printf("Failed to instantiate the relay: %v", err)
-		return nil
-	}
-
-	relay1info := peer.AddrInfo{
-		ID:    relay1.ID(),
-		Addrs: relay1.Addrs(),
-	}
-	log.Println("Successfully setup relay service, hit the {ip}:45677/relay to get relay information")
-	log.Println("Relay ID:")
-	log.Println(relay1.ID())
-	log.Println()
-	log.Println("Relay Listen Addresses:")
-	log.Println(relay1.Addrs())
-	log.Println()
-	addrInfoJSON, err := json.Marshal(relay1info)
+func handleRelayStats(w http.ResponseWriter, r *http.Request, statsTracer *StatsTracer) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	responseJSON, err := json.Marshal(statsTracer.Stats())
 	if err != nil {
-		fmt.Println("Error marshaling AddrInfo:", err)
-		return nil
+		http.Error(w, "Failed to encode relay stats", http.StatusInternalServerError)
+		return
 	}
-	return addrInfoJSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
 }
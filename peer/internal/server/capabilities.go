@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	orcaCapability "orca-peer/internal/capability"
+	"os"
+	"path/filepath"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+func (s *FileShareServerNode) revokedTokensPath() string {
+	return filepath.Join(orcaCapability.TokenDirectory(s.dataDir), "revoked.json")
+}
+
+func (s *FileShareServerNode) restrictedFilesPath() string {
+	return filepath.Join(orcaCapability.TokenDirectory(s.dataDir), "restricted.json")
+}
+
+// Read a JSON list of ids saved by writeIdSet into set
+func readIdSet(path string, set map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	ids := make([]string, 0)
+	err = json.Unmarshal(data, &ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		set[id] = true
+	}
+	return nil
+}
+
+// Save the ids in set as a JSON list
+func writeIdSet(path string, set map[string]bool) error {
+	ids := make([]string, 0)
+	for id := range set {
+		ids = append(ids, id)
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Load the ids of revoked capability tokens saved by previous runs
+func (s *FileShareServerNode) LoadRevokedTokens() {
+	s.capabilitiesMUT.Lock()
+	defer s.capabilitiesMUT.Unlock()
+	err := readIdSet(s.revokedTokensPath(), s.revokedTokens)
+	if err != nil {
+		fmt.Println("Error reading revoked tokens:", err)
+	}
+}
+
+// Load the keys of files restricted to capability holders by previous runs
+func (s *FileShareServerNode) LoadRestrictedFiles() {
+	s.capabilitiesMUT.Lock()
+	defer s.capabilitiesMUT.Unlock()
+	err := readIdSet(s.restrictedFilesPath(), s.restrictedFiles)
+	if err != nil {
+		fmt.Println("Error reading restricted files:", err)
+	}
+}
+
+// Check whether a file is only served to consumers holding a capability token for it
+func (s *FileShareServerNode) isRestricted(fileKey string) bool {
+	s.capabilitiesMUT.Lock()
+	defer s.capabilitiesMUT.Unlock()
+	return s.restrictedFiles[fileKey]
+}
+
+// Only serve a file this peer is storing to consumers holding a capability token for it, the restriction is saved so it survives restarts
+func (s *FileShareServerNode) SetupRestrictFile(fileKey string) error {
+	if _, ok := s.StoredFileInfoMap[fileKey]; !ok {
+		return errors.New("this peer is not storing a file with key " + fileKey)
+	}
+	s.capabilitiesMUT.Lock()
+	defer s.capabilitiesMUT.Unlock()
+	s.restrictedFiles[fileKey] = true
+	return writeIdSet(s.restrictedFilesPath(), s.restrictedFiles)
+}
+
+// Register a file on the DHT market that is only served to consumers holding a capability token for it
+func (s *FileShareServerNode) SetupRegisterRestrictedFile(filePath string, fileName string, amountPerMB int64, port int32) (string, error) {
+	osFileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if osFileInfo.IsDir() {
+		return "", errors.New("Specified file is a directory.")
+	}
+	return s.registerChunkedFile(filePath, fileName, amountPerMB, port, true)
+}
+
+/*
+ * Issue a capability token for a restricted file.
+ *
+ * Parameters:
+ *   fileKey: Key of a file this peer is storing
+ *   consumerKey: Public key of the consumer allowed to download it
+ *   duration: How long the token lasts
+ *
+ * Returns:
+ *   A marshalled SignedCapabilityToken to hand to the consumer
+ *   The id of the token, used to revoke it
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SetupIssueCapability(fileKey string, consumerKey libp2pcrypto.PubKey, duration time.Duration) ([]byte, string, error) {
+	if _, ok := s.StoredFileInfoMap[fileKey]; !ok {
+		return nil, "", errors.New("this peer is not storing a file with key " + fileKey)
+	}
+	return orcaCapability.CreateToken(fileKey, consumerKey, duration, s.PrivKey)
+}
+
+// Revoke a capability token by its id, the revocation is saved so it survives restarts
+func (s *FileShareServerNode) SetupRevokeCapability(id string) error {
+	s.capabilitiesMUT.Lock()
+	defer s.capabilitiesMUT.Unlock()
+	s.revokedTokens[id] = true
+	return writeIdSet(s.revokedTokensPath(), s.revokedTokens)
+}
+
+// Check whether a file may be served to the peer on the other end of the stream
+func (s *FileShareServerNode) mayServeFile(stream network.Stream, fileKey string, tokens [][]byte) bool {
+	s.capabilitiesMUT.Lock()
+	defer s.capabilitiesMUT.Unlock()
+	if !s.restrictedFiles[fileKey] {
+		return true
+	}
+	consumerKey := stream.Conn().RemotePublicKey()
+	if consumerKey == nil {
+		return false
+	}
+	for _, token := range tokens {
+		_, err := orcaCapability.VerifyToken(token, s.PubKey, consumerKey, fileKey, s.revokedTokens)
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	orcaEncryption "orca-peer/internal/encryption"
+	orcaHash "orca-peer/internal/hash"
+	"os"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+/*
+ * Find the public key of a recipient of an encrypted file.
+ *
+ * Parameters:
+ *   recipient: Either the path to a PEM encoded RSA public key or a peer ID
+ *
+ * Returns:
+ *   The public key of the recipient
+ *   An error, if any
+ */
+func (s *FileShareServerNode) ResolveRecipientKey(recipient string) (libp2pcrypto.PubKey, error) {
+	if pemBytes, err := os.ReadFile(recipient); err == nil {
+		rsaKey, err := orcaHash.ParseRsaPublicKeyFromPemStr(string(pemBytes))
+		if err != nil {
+			return nil, err
+		}
+		derBytes, err := x509.MarshalPKIXPublicKey(rsaKey)
+		if err != nil {
+			return nil, err
+		}
+		return libp2pcrypto.UnmarshalRsaPublicKey(derBytes)
+	}
+
+	id, err := peer.Decode(recipient)
+	if err != nil {
+		return nil, errors.New("recipient is neither a public key file nor a peer ID: " + recipient)
+	}
+	if pubKey := s.Host.Peerstore().PubKey(id); pubKey != nil {
+		return pubKey, nil
+	}
+	return s.K_DHT.GetPublicKey(context.Background(), id)
+}
+
+/*
+ * Encrypt a file with a random content key, register the ciphertext on the DHT market and
+ * save a manifest carrying the content key wrapped for every recipient. Holders only ever
+ * see the ciphertext, recipients decrypt after downloading through the manifest.
+ *
+ * Parameters:
+ *   filePath: Path to the plaintext file
+ *   fileName: Name of the file inside of the files folder
+ *   amountPerMB: Price of the file
+ *   port: HTTP port of this peer
+ *   recipients: Public keys allowed to decrypt the file, this peer is always added
+ *
+ * Returns:
+ *   The key of the encrypted file
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SetupRegisterEncryptedFile(filePath string, fileName string, amountPerMB int64, port int32, recipients []libp2pcrypto.PubKey) (string, error) {
+	osFileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if osFileInfo.IsDir() {
+		return "", errors.New("Specified file is a directory.")
+	}
+
+	contentKey, err := orcaEncryption.NewContentKey()
+	if err != nil {
+		return "", err
+	}
+	wrappedKeys, err := orcaEncryption.WrapKeyForRecipients(contentKey, append([]libp2pcrypto.PubKey{s.PubKey}, recipients...))
+	if err != nil {
+		return "", err
+	}
+
+	encryptedPath := filePath + ".enc"
+	err = orcaEncryption.EncryptFile(filePath, encryptedPath, contentKey)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(encryptedPath)
+
+	fileKey, orcaFileInfo, err := orcaHash.SaveChunkedFile(s.dataDir, encryptedPath, fileName)
+	if err != nil {
+		return "", err
+	}
+	s.StoredFileInfoMap[fileKey] = &orcaFileInfo
+	s.WrappedKeyMap[fileKey] = wrappedKeys
+	fmt.Printf("Final Hashed: %s\n", fileKey)
+
+	err = s.registerOnMarket(fileKey, amountPerMB, port)
+	if err != nil {
+		return "", err
+	}
+	s.Host.SetStreamHandler(protocol.ID("orcanet-fileshare/1.0/"+fileKey), s.HandleStoredFileStream)
+
+	_, err = s.SetupExportManifest(fileKey, map[string]string{"encrypted": "true"})
+	if err != nil {
+		return "", err
+	}
+	return fileKey, nil
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	orcaAccounting "orca-peer/internal/accounting"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	"orca-peer/internal/config"
+	orcaSubscription "orca-peer/internal/subscription"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// A publisher's feed, subscribers can download every file inside of it until their token expires
+type Feed struct {
+	Name        string
+	PricePerDay int64
+	FileKeys    map[string]bool
+}
+
+func (s *FileShareServerNode) feedsPath() string {
+	return config.Path(s.dataDir, "files", "feeds", "feeds.json")
+}
+
+func (s *FileShareServerNode) subscriptionQuotesPath() string {
+	return config.Path(s.dataDir, "files", "feeds", "quotes.json")
+}
+
+// Read a JSON object saved by writeJSON into v, a missing file leaves v as it is
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Save v as JSON
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Load the feeds and the unredeemed subscription quotes saved by previous runs
+func (s *FileShareServerNode) LoadFeeds() {
+	s.feedsMUT.Lock()
+	defer s.feedsMUT.Unlock()
+	err := readJSON(s.feedsPath(), &s.feeds)
+	if err != nil {
+		fmt.Println("Error reading feeds:", err)
+	}
+	err = readJSON(s.subscriptionQuotesPath(), &s.subscriptionQuotes)
+	if err != nil {
+		fmt.Println("Error reading subscription quotes:", err)
+	}
+}
+
+// Answer requests for every feed on h, feeds created before the host was up are served from now on
+func (s *FileShareServerNode) serveFeeds(h host.Host) {
+	s.feedsMUT.Lock()
+	defer s.feedsMUT.Unlock()
+	for name := range s.feeds {
+		h.SetStreamHandler(protocol.ID("orcanet-feed/1.0/"+name), s.HandleFeedStream)
+	}
+}
+
+/*
+ * Create a feed that consumers can subscribe to over orcanet-feed/1.0/<name>.
+ *
+ * Parameters:
+ *   name: Name of the feed, letters, digits, '.', '_' and '-' only
+ *   pricePerDay: Price of a subscription for one day
+ *
+ * Returns:
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SetupCreateFeed(name string, pricePerDay int64) error {
+	if !nameRegex.MatchString(name) {
+		return errors.New("feed names may only contain letters, digits, '.', '_' and '-' and be at most 64 characters")
+	}
+	if pricePerDay < 0 {
+		return errors.New("price per day cannot be negative")
+	}
+	s.feedsMUT.Lock()
+	defer s.feedsMUT.Unlock()
+	if feed, ok := s.feeds[name]; ok {
+		feed.PricePerDay = pricePerDay
+		return writeJSON(s.feedsPath(), s.feeds)
+	}
+	s.feeds[name] = &Feed{Name: name, PricePerDay: pricePerDay, FileKeys: make(map[string]bool)}
+	s.Host.SetStreamHandler(protocol.ID("orcanet-feed/1.0/"+name), s.HandleFeedStream)
+	return writeJSON(s.feedsPath(), s.feeds)
+}
+
+// Release a file this peer is storing under one of its feeds
+func (s *FileShareServerNode) SetupAddToFeed(name string, fileKey string) error {
+	if _, ok := s.StoredFileInfoMap[fileKey]; !ok {
+		return errors.New("this peer is not storing a file with key " + fileKey)
+	}
+	s.feedsMUT.Lock()
+	defer s.feedsMUT.Unlock()
+	feed, ok := s.feeds[name]
+	if !ok {
+		return errors.New("no feed named " + name)
+	}
+	feed.FileKeys[fileKey] = true
+	return writeJSON(s.feedsPath(), s.feeds)
+}
+
+// Check whether one of the tokens sent with a chunk request covers the file for the peer on the other end of the stream
+func (s *FileShareServerNode) subscribedToFile(stream network.Stream, fileKey string, tokens [][]byte) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	subscriberKey := stream.Conn().RemotePublicKey()
+	if subscriberKey == nil {
+		return false
+	}
+	s.feedsMUT.Lock()
+	defer s.feedsMUT.Unlock()
+	for _, token := range tokens {
+		parsed, err := orcaSubscription.ParseToken(token)
+		if err != nil {
+			continue
+		}
+		feed, ok := s.feeds[parsed.GetFeed()]
+		if !ok || !feed.FileKeys[fileKey] {
+			continue
+		}
+		_, err = orcaSubscription.VerifyToken(token, s.PubKey, subscriberKey, feed.Name)
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// A subscription a consumer was quoted, it gets a token once Price coins reached the address created for it
+type pendingSubscription struct {
+	Feed       string
+	Subscriber peer.ID
+	Days       int64
+	Price      int64
+}
+
+/*
+ * Answer a feed request. A request for 0 days gets the price and files of the feed. A request for
+ * more days without an address is quoted a price and a wallet address created for it, the same
+ * request with that address gets a token once the payment reached it. Each payment buys one token.
+ *
+ * Parameters:
+ *   ctx: Bounds how long to wait for a payment
+ *   name: Name of the feed
+ *   subscriberKey: Public key of the consumer
+ *   request: What the consumer asked for
+ *
+ * Returns:
+ *   The response to send the consumer
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SellSubscription(ctx context.Context, name string, subscriberKey libp2pcrypto.PubKey, request orcaSubscription.FeedRequest) (*orcaSubscription.FeedResponse, error) {
+	s.feedsMUT.Lock()
+	feed, ok := s.feeds[name]
+	response := &orcaSubscription.FeedResponse{Feed: name, FileKeys: make([]string, 0)}
+	if ok {
+		response.PricePerDay = feed.PricePerDay
+		for fileKey := range feed.FileKeys {
+			response.FileKeys = append(response.FileKeys, fileKey)
+		}
+	}
+	s.feedsMUT.Unlock()
+	sort.Strings(response.FileKeys)
+	if !ok {
+		return nil, errors.New("no feed named " + name)
+	}
+	if request.Days < 0 || request.Days > 366 {
+		return nil, errors.New("subscriptions last between 1 and 366 days")
+	}
+	if request.Days == 0 {
+		return response, nil
+	}
+	subscriber, err := peer.IDFromPublicKey(subscriberKey)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription := &pendingSubscription{Feed: name, Subscriber: subscriber, Days: request.Days, Price: request.Days * response.PricePerDay}
+	if subscription.Price > 0 {
+		if request.Address == "" {
+			response.WalletAddress, err = orcaBlockchain.GetNewAddress()
+			if err != nil {
+				return nil, fmt.Errorf("unable to take payments: %w", err)
+			}
+			response.Price = subscription.Price
+			s.feedsMUT.Lock()
+			s.subscriptionQuotes[response.WalletAddress] = subscription
+			err = writeJSON(s.subscriptionQuotesPath(), s.subscriptionQuotes)
+			s.feedsMUT.Unlock()
+			if err != nil {
+				return nil, fmt.Errorf("unable to save the quote: %w", err)
+			}
+			return response, nil
+		}
+		subscription, err = s.redeemSubscription(ctx, name, subscriber, request.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response.Token, err = orcaSubscription.CreateToken(name, subscriberKey, time.Duration(subscription.Days)*24*time.Hour, s.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Sold a %d day subscription to %s for %s\n", subscription.Days, name, subscriber)
+	return response, nil
+}
+
+// Wait until the subscription quoted at address is paid and take it off the pending ones, so no other token is signed for it
+func (s *FileShareServerNode) redeemSubscription(ctx context.Context, name string, subscriber peer.ID, address string) (*pendingSubscription, error) {
+	s.feedsMUT.Lock()
+	subscription, ok := s.subscriptionQuotes[address]
+	s.feedsMUT.Unlock()
+	if !ok || subscription.Feed != name || subscription.Subscriber != subscriber {
+		return nil, errors.New("no subscription was quoted at " + address)
+	}
+	for {
+		received, err := orcaBlockchain.GetReceivedByAddress(address, s.confirmations)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check payments: %w", err)
+		}
+		if received >= float64(subscription.Price) {
+			break
+		}
+		select {
+		case <-time.After(paymentPollInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%d coins for the subscription were not paid to %s yet", subscription.Price, address)
+		}
+	}
+
+	s.feedsMUT.Lock()
+	_, ok = s.subscriptionQuotes[address]
+	delete(s.subscriptionQuotes, address)
+	err := writeJSON(s.subscriptionQuotesPath(), s.subscriptionQuotes)
+	s.feedsMUT.Unlock()
+	if err != nil {
+		fmt.Println("Error saving subscription quotes:", err)
+	}
+	if !ok {
+		return nil, errors.New("the subscription paid at " + address + " was already redeemed")
+	}
+	s.Accounts.Add(orcaAccounting.Entry{
+		Kind:    orcaAccounting.KindSale,
+		Amount:  float64(subscription.Price),
+		Address: address,
+		Peer:    subscriber.String(),
+		Note:    "subscription to " + name,
+	})
+	return subscription, nil
+}
+
+// Answer a feed request over orcanet-feed/1.0/<name>, see SellSubscription
+func (s *FileShareServerNode) HandleFeedStream(stream network.Stream) {
+	defer stream.Close()
+	name := strings.TrimPrefix(string(stream.Protocol()), "orcanet-feed/1.0/")
+	payload, err := readMessage(stream)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	request := orcaSubscription.FeedRequest{}
+	err = json.Unmarshal(payload, &request)
+	if err != nil {
+		fmt.Println("Error unmarshaling JSON:", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), paymentWait)
+	defer cancel()
+	response, err := s.SellSubscription(ctx, name, stream.Conn().RemotePublicKey(), request)
+	if err != nil {
+		response = &orcaSubscription.FeedResponse{Feed: name, FileKeys: make([]string, 0), Error: err.Error()}
+	}
+
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		fmt.Printf("Error marshaling json %s\n", err)
+		return
+	}
+	err = writeMessage(stream, responseBytes)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Read a message prefixed with its 4 byte little endian length
+func readMessage(r io.Reader) ([]byte, error) {
+	lengthBytes := make([]byte, 4)
+	_, err := io.ReadFull(r, lengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(lengthBytes))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Write a message prefixed with its 4 byte little endian length
+func writeMessage(w io.Writer, payload []byte) error {
+	lengthBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(lengthBytes, uint32(len(payload)))
+	_, err := w.Write(append(lengthBytes, payload...))
+	return err
+}
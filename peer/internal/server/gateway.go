@@ -0,0 +1,307 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"orca-peer/internal/config"
+	"orca-peer/internal/fileshare"
+	orcaHash "orca-peer/internal/hash"
+	orcaManifest "orca-peer/internal/manifest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Chunks are cut at 4 MB by hash.SaveChunkedFile, so the chunk holding a byte offset is known without fetching anything
+const (
+	gatewayChunkSize  = 4 * 1024 * 1024
+	gatewayCacheSize  = 8
+	gatewayKeyHexSize = 64
+)
+
+var errGatewayBudget = errors.New("gateway budget exhausted")
+
+// Set how many coins the gateway may spend on chunks in total, 0 only serves chunks that are free
+func (s *FileShareServerNode) SetGatewayBudget(budget int64) {
+	s.gatewayMUT.Lock()
+	defer s.gatewayMUT.Unlock()
+	s.gatewayBudget = budget
+}
+
+// Take price coins out of the gateway budget
+func (s *FileShareServerNode) reserveGatewayBudget(price int64) error {
+	s.gatewayMUT.Lock()
+	defer s.gatewayMUT.Unlock()
+	if s.gatewaySpent+price > s.gatewayBudget {
+		return errGatewayBudget
+	}
+	s.gatewaySpent += price
+	return nil
+}
+
+// Put back coins that were reserved but not spent
+func (s *FileShareServerNode) refundGatewayBudget(price int64) {
+	s.gatewayMUT.Lock()
+	defer s.gatewayMUT.Unlock()
+	s.gatewaySpent -= price
+}
+
+func (s *FileShareServerNode) cachedChunk(key string) ([]byte, bool) {
+	s.gatewayCacheMUT.Lock()
+	defer s.gatewayCacheMUT.Unlock()
+	data, ok := s.gatewayCache[key]
+	return data, ok
+}
+
+func (s *FileShareServerNode) cacheChunk(key string, data []byte) {
+	s.gatewayCacheMUT.Lock()
+	defer s.gatewayCacheMUT.Unlock()
+	if _, ok := s.gatewayCache[key]; ok {
+		return
+	}
+	if len(s.gatewayCacheOrder) >= gatewayCacheSize {
+		delete(s.gatewayCache, s.gatewayCacheOrder[0])
+		s.gatewayCacheOrder = s.gatewayCacheOrder[1:]
+	}
+	s.gatewayCache[key] = data
+	s.gatewayCacheOrder = append(s.gatewayCacheOrder, key)
+}
+
+// A file on the network that is read through chunk by chunk, only fetching the chunks that are read
+type networkFile struct {
+	node     *FileShareServerNode // caches the chunks
+	fileKey  string
+	fileInfo *fileshare.FileInfo // nil when no manifest is known, chunks are then not checked
+	size     int64
+	offset   int64
+	fetch    func(chunkIndex int) ([]byte, error)
+}
+
+// Returns a chunk, from the cache if it was fetched recently
+func (file *networkFile) chunk(chunkIndex int) ([]byte, error) {
+	cacheKey := fmt.Sprintf("%s/%d", file.fileKey, chunkIndex)
+	if data, ok := file.node.cachedChunk(cacheKey); ok {
+		return data, nil
+	}
+	data, err := file.fetch(chunkIndex)
+	if err != nil {
+		return nil, err
+	}
+	if file.fileInfo != nil {
+		chunkHash := sha256.Sum256(data)
+		if chunkIndex >= len(file.fileInfo.GetChunkHashes()) || hex.EncodeToString(chunkHash[:]) != file.fileInfo.GetChunkHashes()[chunkIndex] {
+			return nil, fmt.Errorf("chunk %d of %s does not match its manifest", chunkIndex, file.fileKey)
+		}
+	}
+	file.node.cacheChunk(cacheKey, data)
+	return data, nil
+}
+
+func (file *networkFile) Read(p []byte) (int, error) {
+	if file.offset >= file.size {
+		return 0, io.EOF
+	}
+	chunkIndex := int(file.offset / gatewayChunkSize)
+	data, err := file.chunk(chunkIndex)
+	if err != nil {
+		return 0, err
+	}
+	chunkOffset := file.offset - int64(chunkIndex)*gatewayChunkSize
+	if chunkOffset >= int64(len(data)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	bytesRead := copy(p, data[chunkOffset:])
+	file.offset += int64(bytesRead)
+	return bytesRead, nil
+}
+
+func (file *networkFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.offset
+	case io.SeekEnd:
+		offset += file.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	file.offset = offset
+	return offset, nil
+}
+
+// Open a file this peer is storing, reading its chunks from disk
+func (s *FileShareServerNode) openStoredFile(fileKey string) (*networkFile, bool) {
+	fileInfo, ok := s.StoredFileInfoMap[fileKey]
+	if !ok {
+		return nil, false
+	}
+	return &networkFile{
+		node:     s,
+		fileKey:  fileKey,
+		fileInfo: fileInfo,
+		size:     fileInfo.GetFileSize(),
+		fetch: func(chunkIndex int) ([]byte, error) {
+			if chunkIndex >= len(fileInfo.GetChunkHashes()) {
+				return nil, errors.New("chunk index out of range")
+			}
+			return os.ReadFile(config.Path(s.dataDir, "files", "stored", fileInfo.GetChunkHashes()[chunkIndex]))
+		},
+	}, true
+}
+
+/*
+ * Open a file for the gateway. Files this peer is storing are read from disk, any other file is
+ * fetched from its cheapest holder and paid for out of the gateway budget. When a manifest for the
+ * file was imported its chunks are checked against it, otherwise the size is learned from the
+ * holder by fetching the first and last chunk.
+ *
+ * Parameters:
+ *   fileKey: Key of the file
+ *
+ * Returns:
+ *   The file
+ *   The name of the file, empty if it is not known
+ *   An error, if any
+ */
+func (s *FileShareServerNode) openNetworkFile(fileKey string) (*networkFile, string, error) {
+	if file, ok := s.openStoredFile(fileKey); ok {
+		return file, file.fileInfo.GetFileName(), nil
+	}
+
+	var fileInfo *fileshare.FileInfo
+	var known []*fileshare.User
+	manifest, err := orcaManifest.ReadFile(config.Path(s.dataDir, "files", "manifests", fileKey+orcaManifest.FileExtension))
+	if err == nil && orcaHash.GetFileKey(manifest.GetFileInfo()) == fileKey {
+		fileInfo = manifest.GetFileInfo()
+		known = manifest.GetHolders()
+	}
+	holder := CheapestHolder(s.ManifestHolders(fileKey, known, nil))
+	if holder == nil {
+		return nil, "", errors.New("unable to find holder for this file")
+	}
+	walletAddress, err := HolderWalletAddress(holder)
+	if err != nil {
+		return nil, "", err
+	}
+	price := holder.GetPrice()
+	if price > 0 {
+		// Refuse up front rather than after the response headers are written
+		if err := s.reserveGatewayBudget(price); err != nil {
+			return nil, "", err
+		}
+		s.refundGatewayBudget(price)
+	}
+
+	maxChunk := 0
+	fetch := func(chunkIndex int) ([]byte, error) {
+		if price > 0 {
+			if err := s.reserveGatewayBudget(price); err != nil {
+				return nil, err
+			}
+		}
+		fileChunk, err := s.Client.FetchChunk(holder.GetIp(), fileKey, chunkIndex, walletAddress, fmt.Sprint(price), s.PassKey)
+		if price > 0 && (err != nil || fileChunk.Subscribed) {
+			s.refundGatewayBudget(price)
+		}
+		if err != nil {
+			return nil, err
+		}
+		maxChunk = fileChunk.MaxChunk
+		return fileChunk.Data, nil
+	}
+
+	file := &networkFile{node: s, fileKey: fileKey, fileInfo: fileInfo, fetch: fetch}
+	if fileInfo != nil {
+		file.size = fileInfo.GetFileSize()
+		return file, fileInfo.GetFileName(), nil
+	}
+	firstChunk, err := file.chunk(0)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxChunk <= 1 {
+		file.size = int64(len(firstChunk))
+		return file, "", nil
+	}
+	lastChunk, err := file.chunk(maxChunk - 1)
+	if err != nil {
+		return nil, "", err
+	}
+	file.size = int64(maxChunk-1)*gatewayChunkSize + int64(len(lastChunk))
+	return file, "", nil
+}
+
+// The gateway spends this peer's coins and serves its stored files without an invoice, so only local clients may use it
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+/*
+ * HTTP route streaming a file from the network, GET /orca/<fileKey>.
+ * Range requests are supported so media can be played and seeked through directly, only the
+ * chunks covering the requested range are fetched. The name query parameter sets the name used
+ * to pick the content type when no manifest for the file was imported. Only requests from the
+ * loopback interface are served, and restricted files are refused as they are by /transfer/.
+ */
+func (s *FileShareServerNode) GatewayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only GET requests will be handled.")
+		return
+	}
+	if !isLocalRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		writeStatusUpdate(w, "The gateway only serves local clients.")
+		return
+	}
+	fileKey := strings.TrimPrefix(r.URL.Path, "/orca/")
+	if _, err := hex.DecodeString(fileKey); err != nil || len(fileKey) != gatewayKeyHexSize {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStatusUpdate(w, "Invalid file key.")
+		return
+	}
+	if s.isRestricted(fileKey) {
+		w.WriteHeader(http.StatusForbidden)
+		writeStatusUpdate(w, "This file is restricted to holders of a capability token.")
+		return
+	}
+
+	file, fileName, err := s.openNetworkFile(fileKey)
+	if errors.Is(err, errGatewayBudget) {
+		w.WriteHeader(http.StatusPaymentRequired)
+		writeStatusUpdate(w, "The gateway budget does not cover this file.")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeStatusUpdate(w, err.Error())
+		return
+	}
+	if name := r.URL.Query().Get("name"); name != "" {
+		fileName = name
+	}
+	fileName = filepath.Base(filepath.Clean("/" + fileName))
+
+	// Without a content type ServeContent sniffs the first chunk, which may have to be paid for
+	contentType := mime.TypeByExtension(filepath.Ext(fileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, fileName, time.Time{}, file)
+}
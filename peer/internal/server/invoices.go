@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	orcaAccounting "orca-peer/internal/accounting"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	orcaInvoice "orca-peer/internal/invoice"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// How often the wallet is asked about invoices that are not settled yet
+	invoiceSettleInterval = 10 * time.Second
+	// How long payments to an expired invoice are still looked for
+	invoiceGracePeriod = 24 * time.Hour
+)
+
+/*
+ * Issue a signed invoice for a whole file this peer is storing, priced per chunk as it is listed and
+ * payable to a fresh wallet address. It is kept along with the other invoices until it is settled.
+ * Once paid, only the consumer it was issued to can download the file with it.
+ *
+ * Parameters:
+ *   fileKey: Key of the file
+ *   jobId: Job of the consumer the invoice is for, if any
+ *   consumer: Peer ID of the consumer
+ *
+ * Returns:
+ *   The record of the invoice, holding the signed invoice and its orcacoin: URI
+ *   An error, if any
+ */
+func (s *FileShareServerNode) IssueFileInvoice(fileKey string, jobId string, consumer peer.ID) (*orcaInvoice.Record, error) {
+	if consumer.Validate() != nil {
+		return nil, errors.New("invalid consumer peer ID")
+	}
+	file, ok := s.openStoredFile(fileKey)
+	if !ok {
+		return nil, errors.New("this peer is not storing a file with key " + fileKey)
+	}
+	amount := s.MarketEntryMap[fileKey].GetPrice() * int64(len(file.fileInfo.GetChunkHashes()))
+	if amount <= 0 {
+		return nil, errors.New("file is free")
+	}
+	address, err := orcaBlockchain.GetNewAddress()
+	if err != nil {
+		return nil, err
+	}
+	data, err := orcaInvoice.CreateForJob(fileKey, jobId, consumer.String(), 0, file.size-1, amount, address, s.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.Invoices.Add(data, orcaInvoice.RoleSale)
+}
+
+/*
+ * Pay an invoice from its orcacoin: URI out of this peer's wallet. It is marked settled once the
+ * wallet has seen the transaction with enough confirmations.
+ *
+ * Parameters:
+ *   uri: The invoice's payment request
+ *
+ * Returns:
+ *   The record of the invoice
+ *   An error, if any
+ */
+func (s *FileShareServerNode) PayInvoice(uri string) (*orcaInvoice.Record, error) {
+	s.payInvoiceMUT.Lock()
+	defer s.payInvoiceMUT.Unlock()
+	invoice, data, err := orcaInvoice.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := s.Invoices.Get(invoice.Id)
+	if !ok {
+		record, err = s.Invoices.Add(data, orcaInvoice.RolePurchase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if record.Role != orcaInvoice.RolePurchase {
+		return nil, errors.New("this peer issued the invoice")
+	}
+	if record.Status != orcaInvoice.StatusOpen {
+		return nil, errors.New("invoice is already " + record.Status)
+	}
+	txId, err := orcaBlockchain.SendToAddress(fmt.Sprint(invoice.Amount), invoice.Address, s.PassKey)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Invoices.Update(invoice.Id, func(record *orcaInvoice.Record) {
+		record.Status = orcaInvoice.StatusPaid
+		record.TxId = txId
+		record.PaidAt = time.Now().UTC().Unix()
+	})
+	if err != nil {
+		fmt.Println("Error saving invoices:", err)
+	}
+	s.Accounts.Add(orcaAccounting.Entry{
+		Kind:    orcaAccounting.KindPurchase,
+		Amount:  -float64(invoice.Amount),
+		TxId:    txId,
+		Address: invoice.Address,
+		JobId:   invoice.JobId,
+		FileKey: invoice.FileKey,
+		Note:    "invoice " + invoice.Id,
+	})
+	record, _ = s.Invoices.Get(invoice.Id)
+	return record, nil
+}
+
+// Whether one of the invoices sent with a chunk request was issued by this peer for the whole file to the peer on the other end of the stream, and paid
+func (s *FileShareServerNode) invoicedFile(stream network.Stream, fileKey string, invoices [][]byte) bool {
+	for _, data := range invoices {
+		var signed orcaInvoice.SignedInvoice
+		var invoice orcaInvoice.Invoice
+		if json.Unmarshal(data, &signed) != nil || json.Unmarshal(signed.Invoice, &invoice) != nil {
+			continue
+		}
+		// Our own copy of the invoice is trusted, the consumer's must be exactly the same
+		record, ok := s.Invoices.Get(invoice.Id)
+		if !ok || record.Role != orcaInvoice.RoleSale || !bytes.Equal(record.Signed, data) || record.Invoice.FileKey != fileKey {
+			continue
+		}
+		// Anyone could send the bytes of an invoice they saw, only the consumer who bought it may use it
+		if record.Invoice.Consumer != stream.Conn().RemotePeer().String() {
+			continue
+		}
+		if record.Invoice.RangeStart != 0 || record.Invoice.RangeEnd < s.StoredFileInfoMap[fileKey].GetFileSize()-1 {
+			continue
+		}
+		if record.Status == orcaInvoice.StatusSettled || s.settleSale(record) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether an invoice this peer issued was paid, and mark it settled if it was
+func (s *FileShareServerNode) settleSale(record *orcaInvoice.Record) bool {
+	received, err := orcaBlockchain.GetReceivedByAddress(record.Invoice.Address, s.confirmations)
+	if err != nil || received < float64(record.Invoice.Amount) {
+		return false
+	}
+	s.markSaleSettled(record.Invoice.Id, "")
+	fmt.Printf("Invoice %s for %s settled\n", record.Invoice.Id, record.Invoice.FileKey)
+	return true
+}
+
+// Mark an invoice this peer issued as settled, its sale is recorded the first time it is
+func (s *FileShareServerNode) markSaleSettled(id string, txId string) {
+	settled := false
+	err := s.Invoices.Update(id, func(record *orcaInvoice.Record) {
+		settled = record.Status != orcaInvoice.StatusSettled
+		record.Status = orcaInvoice.StatusSettled
+		if txId != "" {
+			record.TxId = txId
+		}
+		record.PaidAt = time.Now().UTC().Unix()
+		record.SettledAt = record.PaidAt
+	})
+	if err != nil {
+		fmt.Println("Error saving invoices:", err)
+	}
+	record, ok := s.Invoices.Get(id)
+	if !settled || !ok {
+		return
+	}
+	s.Accounts.Add(orcaAccounting.Entry{
+		Kind:    orcaAccounting.KindSale,
+		Amount:  float64(record.Invoice.Amount),
+		TxId:    record.TxId,
+		Address: record.Invoice.Address,
+		JobId:   record.Invoice.JobId,
+		FileKey: record.Invoice.FileKey,
+		Note:    "invoice " + id,
+	})
+}
+
+// Check whether the transaction that paid an invoice reached enough confirmations, and mark it settled if it did
+func (s *FileShareServerNode) settlePurchase(record *orcaInvoice.Record) bool {
+	transaction, err := orcaBlockchain.GetTransaction(record.TxId)
+	if err != nil || transaction.Confirmations < s.confirmations {
+		return false
+	}
+	err = s.Invoices.Update(record.Invoice.Id, func(record *orcaInvoice.Record) {
+		record.Status = orcaInvoice.StatusSettled
+		record.SettledAt = time.Now().UTC().Unix()
+	})
+	if err != nil {
+		fmt.Println("Error saving invoices:", err)
+	}
+	return true
+}
+
+// Settle invoices as their payments are seen by the wallet, and expire issued invoices that were never paid
+func (s *FileShareServerNode) settleInvoices(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(invoiceSettleInterval):
+		}
+		now := time.Now().UTC()
+		for _, record := range s.Invoices.List() {
+			switch {
+			case record.Role == orcaInvoice.RolePurchase && record.Status == orcaInvoice.StatusPaid:
+				s.settlePurchase(&record)
+			case record.Role == orcaInvoice.RoleSale && record.Status == orcaInvoice.StatusOpen:
+				if !s.settleSale(&record) && now.Unix() > record.Invoice.ExpiresAt {
+					s.Invoices.Update(record.Invoice.Id, func(record *orcaInvoice.Record) {
+						record.Status = orcaInvoice.StatusExpired
+					})
+				}
+			case record.Role == orcaInvoice.RoleSale && record.Status == orcaInvoice.StatusExpired:
+				if now.Add(-invoiceGracePeriod).Unix() < record.Invoice.ExpiresAt {
+					s.settleSale(&record)
+				}
+			}
+		}
+	}
+}
+
+// Answer a request for an invoice for a whole file over orcanet-invoice/1.0
+func (s *FileShareServerNode) HandleInvoiceStream(stream network.Stream) {
+	defer stream.Close()
+	payload, err := readMessage(stream)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	request := orcaInvoice.Request{}
+	response := orcaInvoice.Response{}
+	err = json.Unmarshal(payload, &request)
+	if err == nil && !s.mayServeFile(stream, request.FileKey, request.Capabilities) {
+		err = errors.New("this file is restricted and no valid capability token was sent")
+	}
+	var record *orcaInvoice.Record
+	if err == nil {
+		record, err = s.IssueFileInvoice(request.FileKey, request.JobId, stream.Conn().RemotePeer())
+	}
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Invoice = record.Signed
+		response.URI = record.URI
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		fmt.Println("Error marshaling json:", err)
+		return
+	}
+	err = writeMessage(stream, data)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+/*
+ * HTTP route for invoices.
+ * GET /invoices lists every invoice this peer issued or paid.
+ * GET /invoices/<fileKey>?consumer=<peerId>&jobId=<jobId> issues an invoice for a whole file this peer is storing.
+ * POST /invoices/pay with {"uri": "orcacoin:..."} pays an invoice out of this peer's wallet.
+ */
+func (s *FileShareServerNode) InvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/invoices"), "/")
+	var result interface{}
+	var err error
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		result = s.Invoices.List()
+	case r.Method == http.MethodGet:
+		var consumer peer.ID
+		consumer, err = peer.Decode(r.URL.Query().Get("consumer"))
+		if err == nil {
+			result, err = s.IssueFileInvoice(path, r.URL.Query().Get("jobId"), consumer)
+		}
+	case r.Method == http.MethodPost && path == "pay":
+		var payload struct {
+			URI string `json:"uri"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
+			return
+		}
+		result, err = s.PayInvoice(payload.URI)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only GET and POST requests will be handled.")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStatusUpdate(w, err.Error())
+		return
+	}
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStatusUpdate(w, "Failed to convert JSON Data into a string")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
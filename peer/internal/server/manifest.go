@@ -0,0 +1,270 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"orca-peer/internal/config"
+	"orca-peer/internal/fileshare"
+	orcaHash "orca-peer/internal/hash"
+	orcaJobs "orca-peer/internal/jobs"
+	orcaManifest "orca-peer/internal/manifest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ImportManifestReqPayload struct {
+	Link string `json:"link"`
+}
+
+type ImportManifestResPayload struct {
+	JobId    string `json:"jobID"`
+	FileKey  string `json:"fileKey"`
+	FileName string `json:"fileName"`
+}
+
+type ExportManifestResPayload struct {
+	Link string `json:"link"`
+}
+
+/*
+ * Sign a manifest for a file this peer is storing and save it to files/manifests.
+ *
+ * Parameters:
+ *   fileKey: Key of a file registered by this peer
+ *   metadata: Free form metadata to include, may be nil
+ *
+ * Returns:
+ *   The signed manifest
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SetupExportManifest(fileKey string, metadata map[string]string) (*fileshare.SignedOrcaManifest, error) {
+	fileInfo, ok := s.StoredFileInfoMap[fileKey]
+	if !ok {
+		return nil, errors.New("this peer is not storing a file with key " + fileKey)
+	}
+	holders, err := s.SetupCheckHolders(fileKey)
+	if err != nil {
+		holders = &fileshare.HoldersResponse{}
+	}
+	signed, err := orcaManifest.Create(fileKey, fileInfo, metadata, holders.GetHolders(), s.WrappedKeyMap[fileKey], s.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	err = orcaManifest.WriteFile(config.Path(s.dataDir, "files", "manifests", fileKey+orcaManifest.FileExtension), signed)
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+/*
+ * Combine the holders currently on the market with the ones that came with a manifest or link.
+ *
+ * Parameters:
+ *   fileKey: Key of the file
+ *   known: Holders listed inside of a signed manifest
+ *   preferred: Holder multiaddrs listed inside of a link, these are moved to the front
+ *
+ * Returns:
+ *   Holders with no duplicate addresses
+ */
+func (s *FileShareServerNode) ManifestHolders(fileKey string, known []*fileshare.User, preferred []string) []*fileshare.User {
+	holders := make([]*fileshare.User, 0)
+	seen := make(map[string]bool)
+	marketHolders, err := s.SetupCheckHolders(fileKey)
+	if err == nil {
+		known = append(marketHolders.GetHolders(), known...)
+	}
+	for _, addr := range preferred {
+		for _, holder := range known {
+			if holder.GetIp() == addr && !seen[addr] {
+				seen[addr] = true
+				holders = append(holders, holder)
+			}
+		}
+	}
+	for _, holder := range known {
+		if !seen[holder.GetIp()] {
+			seen[holder.GetIp()] = true
+			holders = append(holders, holder)
+		}
+	}
+	s.rememberHolderAddrs(holders)
+	return holders
+}
+
+// Returns the cheapest holder, preferring earlier holders on ties, or nil if there are none
+func CheapestHolder(holders []*fileshare.User) *fileshare.User {
+	var bestHolder *fileshare.User
+	for _, holder := range holders {
+		if bestHolder == nil || holder.GetPrice() < bestHolder.GetPrice() {
+			bestHolder = holder
+		}
+	}
+	return bestHolder
+}
+
+/*
+ * HTTP route to import a .orca manifest or orca:// link and queue a job to download its file.
+ * The body is either the raw .orca file or JSON of the form {"link": "orca://..."}.
+ */
+func (s *FileShareServerNode) ImportManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only POST requests will be handled.")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStatusUpdate(w, "Unable to read request body.")
+		return
+	}
+
+	var fileKey, fileName string
+	var holders []*fileshare.User
+	var fileInfo *fileshare.FileInfo // Chunks are checked against it, links come without one
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var payload ImportManifestReqPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
+			return
+		}
+		link, err := orcaManifest.ParseLink(payload.Link)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		fileKey = link.FileKey
+		fileName = link.FileName
+		holders = s.ManifestHolders(fileKey, nil, link.Holders)
+	} else {
+		manifest, err := orcaManifest.Unmarshal(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStatusUpdate(w, "Invalid manifest: "+err.Error())
+			return
+		}
+		fileKey = manifest.GetFileKey()
+		fileName = manifest.GetFileInfo().GetFileName()
+		fileInfo = manifest.GetFileInfo()
+		holders = s.ManifestHolders(fileKey, manifest.GetHolders(), nil)
+		err = os.WriteFile(config.Path(s.dataDir, "files", "manifests", fileKey+orcaManifest.FileExtension), body, 0644)
+		if err != nil {
+			fmt.Println("Unable to save imported manifest:", err)
+		}
+	}
+
+	holder := CheapestHolder(holders)
+	if holder == nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeStatusUpdate(w, "Unable to find holder for this file.")
+		return
+	}
+	newJob := orcaJobs.Job{
+		FileHash:        fileKey,
+		JobId:           uuid.New().String(),
+		TimeQueued:      time.Now().Format(time.RFC3339),
+		Status:          "active",
+		AccumulatedCost: 0,
+		ProjectedCost:   -1,
+		ETA:             -1,
+		PeerId:          holder.GetIp(),
+	}
+	s.Jobs.AddJob(newJob)
+	go s.importJob(newJob.JobId, fileKey, fileInfo)
+
+	jsonData, err := json.Marshal(ImportManifestResPayload{JobId: newJob.JobId, FileKey: fileKey, FileName: fileName})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStatusUpdate(w, "Failed to convert JSON Data into a string")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// Download the file of an imported manifest and check every chunk against it, a file that does not match is removed
+func (s *FileShareServerNode) importJob(jobId string, fileKey string, fileInfo *fileshare.FileInfo) {
+	// Chunks are appended to files/requested/<fileKey>, so start from an empty file
+	downloadPath := config.Path(s.dataDir, "files", "requested", fileKey)
+	os.Remove(downloadPath)
+	err := s.Jobs.StartJob(jobId)
+	if err != nil {
+		fmt.Println("Error downloading imported file:", err)
+		return
+	}
+	if fileInfo == nil {
+		return
+	}
+	err = orcaHash.VerifyChunkedFile(downloadPath, fileInfo)
+	if err != nil {
+		os.Remove(downloadPath)
+		s.Jobs.UpdateJobStatus(jobId, "terminated")
+		fmt.Println("Downloaded file does not match its manifest:", err)
+	}
+}
+
+/*
+ * HTTP route to export a signed manifest for a file this peer is storing.
+ * Responds with the raw .orca file, or with JSON {"link": "orca://..."} when link=true is passed.
+ */
+func (s *FileShareServerNode) ExportManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only GET requests will be handled.")
+		return
+	}
+	fileKey := r.URL.Query().Get("fileKey")
+	signed, err := s.SetupExportManifest(fileKey, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeStatusUpdate(w, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("link") == "true" {
+		manifest, err := orcaManifest.Verify(signed)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		link, err := orcaManifest.ToLink(manifest)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		jsonData, err := json.Marshal(ExportManifestResPayload{Link: link})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, "Failed to convert JSON Data into a string")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+		return
+	}
+
+	data, err := orcaManifest.Marshal(signed)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStatusUpdate(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+fileKey+orcaManifest.FileExtension+"\"")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"orca-peer/internal/fileshare"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+)
+
+const (
+	DefaultMarketProtocolPrefix = "orcanet/market"
+	DefaultBootstrapPeersFile   = "internal/cli/bootstrap.peers"
+)
+
+/*
+ * Choose which market this peer joins. Markets with a different DHT protocol prefix do not
+ * see each other's peers or listings, so a closed market uses its own prefix together with
+ * its own bootstrap peers. Must be called before the host is created.
+ *
+ * Parameters:
+ *   protocolPrefix: DHT protocol prefix of the market, empty for the public market
+ *   bootstrapFile: File listing one bootstrap multiaddr per line, empty for the public bootstrap peers
+ */
+func (s *FileShareServerNode) ConfigureMarket(protocolPrefix string, bootstrapFile string) {
+	s.marketProtocolPrefix = DefaultMarketProtocolPrefix
+	if protocolPrefix != "" {
+		s.marketProtocolPrefix = strings.Trim(protocolPrefix, "/")
+	}
+	s.bootstrapPeersFile = DefaultBootstrapPeersFile
+	if bootstrapFile != "" {
+		s.bootstrapPeersFile = bootstrapFile
+	}
+}
+
+// The DHT protocol prefix of the market this peer joined
+func (s *FileShareServerNode) MarketProtocolPrefix() string {
+	return s.marketProtocolPrefix
+}
+
+/*
+ * Host options joining the private network of a swarm key. Only peers holding the same key can
+ * connect, the key file is in the usual libp2p format:
+ *   /key/swarm/psk/1.0.0/
+ *   /base16/
+ *   <64 hex characters>
+ * QUIC, WebTransport and WebRTC cannot run on a private network, so the host only uses TCP.
+ *
+ * Parameters:
+ *   swarmKeyFile: Path to the swarm key, empty to join the public network
+ *
+ * Returns:
+ *   Options to create the host with, none for the public network
+ *   An error, if any
+ */
+func PrivateNetworkOptions(swarmKeyFile string) ([]libp2p.Option, error) {
+	if swarmKeyFile == "" {
+		return nil, nil
+	}
+	file, err := os.Open(swarmKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	psk, err := pnet.DecodeV1PSK(file)
+	if err != nil {
+		return nil, err
+	}
+	return []libp2p.Option{libp2p.PrivateNetwork(psk), libp2p.Transport(tcp.NewTCPTransport)}, nil
+}
+
+/*
+ * Collect every address a holder listed. Listings made before holders carried several addresses
+ * only have ip. Addresses of another peer than the one behind ip are left out.
+ *
+ * Parameters:
+ *   holder: A holder from the market
+ *
+ * Returns:
+ *   The holder's ID with all of its addresses
+ *   An error, if the holder has no usable address
+ */
+func HolderAddrInfo(holder *fileshare.User) (peer.AddrInfo, error) {
+	var info peer.AddrInfo
+	seen := make(map[string]bool)
+	for _, addr := range append([]string{holder.GetIp()}, holder.GetAddrs()...) {
+		addrInfo, err := peer.AddrInfoFromString(addr)
+		if err != nil || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		if info.ID == "" {
+			info.ID = addrInfo.ID
+		}
+		if addrInfo.ID == info.ID {
+			info.Addrs = append(info.Addrs, addrInfo.Addrs...)
+		}
+	}
+	if info.ID == "" {
+		return info, errors.New("holder has no usable address")
+	}
+	return info, nil
+}
+
+// Keep every address of the holders in the peerstore. Dialing a holder then races all of them,
+// with QUIC and IPv6 tried first and TCP and IPv4 following a moment later, happy eyeballs style.
+func (s *FileShareServerNode) rememberHolderAddrs(holders []*fileshare.User) {
+	if s.Host == nil {
+		return
+	}
+	for _, holder := range holders {
+		info, err := HolderAddrInfo(holder)
+		if err != nil || info.ID == s.Host.ID() {
+			continue
+		}
+		s.Host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.AddressTTL)
+	}
+}
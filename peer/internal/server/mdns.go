@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// Peers on the same network announce themselves over mDNS under this service name
+const MDNSServiceName = "orcanet-market"
+
+// Location shown in the peer table for peers found on the local network
+const LocalNetworkLocation = "Local network"
+
+type lanNotifee struct {
+	h    host.Host
+	node *FileShareServerNode
+}
+
+/*
+ * Connect to a peer found on the local network and list it in the peer table. Its LAN addresses
+ * stay in the peerstore, so later connections to it, such as file transfers from a market listing
+ * under a public or relay address, also dial it directly. Once connected the DHT adds it to its
+ * routing table if it serves records, which makes it usable for market lookups without internet.
+ *
+ * Parameters:
+ *   info: The peer found
+ */
+func (n *lanNotifee) HandlePeerFound(info peer.AddrInfo) {
+	if info.ID == n.h.ID() {
+		return
+	}
+	n.h.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.AddressTTL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := n.h.Connect(ctx, info); err != nil {
+		fmt.Printf("Unable to connect to %s on the local network: %s\n", info.ID, err)
+		return
+	}
+
+	connection := ""
+	if len(info.Addrs) > 0 {
+		connection = info.Addrs[0].String()
+	}
+	if n.node.addPeer(PeerInfo{
+		Location:    LocalNetworkLocation,
+		Latency:     "",
+		PeerID:      info.ID.String(),
+		Connection:  connection,
+		OpenStreams: "YES",
+		FlagUrl:     "",
+	}) {
+		fmt.Println("Found peer on the local network:", info.ID)
+	}
+}
+
+/*
+ * Announce this peer on the local network and connect to other peers announcing themselves there.
+ *
+ * Parameters:
+ *   h: libp2p host
+ *
+ * Returns:
+ *   The mDNS service, close it to stop
+ *   An error, if any
+ */
+func (s *FileShareServerNode) StartMDNS(h host.Host) (mdns.Service, error) {
+	service := mdns.NewMdnsService(h, MDNSServiceName, &lanNotifee{h: h, node: s})
+	if err := service.Start(); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"orca-peer/internal/fileshare"
+	"regexp"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+const nameRecordPrefix = "/" + NameNamespace + "/"
+
+var nameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Returns the DHT key of a name owned by a peer
+func NameRecordKey(peerId peer.ID, name string) string {
+	return nameRecordPrefix + peerId.String() + "/" + name
+}
+
+// Unmarshal the NameRecord inside of a marshalled SignedNameRecord, without checking its signature
+func ParseNameRecord(value []byte) (*fileshare.NameRecord, error) {
+	signed := &fileshare.SignedNameRecord{}
+	err := proto.Unmarshal(value, signed)
+	if err != nil {
+		return nil, err
+	}
+	record := &fileshare.NameRecord{}
+	err = proto.Unmarshal(signed.GetRecord(), record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+/*
+ * Create a signed name record pointing a name at a file key.
+ *
+ * Parameters:
+ *   name: Name of the pointer, letters, digits, '.', '_' and '-' only
+ *   fileKey: File key the name currently points at
+ *   sequence: Must be higher than the sequence of the record being replaced
+ *   privKey: Private key of the publisher, whose peer ID owns the name
+ *
+ * Returns:
+ *   A marshalled SignedNameRecord
+ *   An error, if any
+ */
+func CreateNameRecord(name string, fileKey string, sequence uint64, privKey libp2pcrypto.PrivKey) ([]byte, error) {
+	if !nameRegex.MatchString(name) {
+		return nil, errors.New("names may only contain letters, digits, '.', '_' and '-' and be at most 64 characters")
+	}
+	publisherKey, err := libp2pcrypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	recordBytes, err := proto.Marshal(&fileshare.NameRecord{
+		Name:         name,
+		FileKey:      fileKey,
+		Sequence:     sequence,
+		PublisherKey: publisherKey,
+		CreatedAt:    time.Now().UTC().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signature, err := privKey.Sign(recordBytes)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&fileshare.SignedNameRecord{Record: recordBytes, Signature: signature})
+}
+
+/*
+ * Point one of this peer's names at a file key on the DHT, replacing the previous record.
+ *
+ * Parameters:
+ *   name: Name to publish under
+ *   fileKey: File key the name should point at
+ *
+ * Returns:
+ *   The sequence number of the new record
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SetupPublishName(name string, fileKey string) (uint64, error) {
+	ctx := context.Background()
+	key := NameRecordKey(s.Host.ID(), name)
+
+	sequence := uint64(1)
+	value, err := s.K_DHT.GetValue(ctx, key)
+	if err == nil {
+		previous, err := ParseNameRecord(value)
+		if err == nil {
+			sequence = previous.GetSequence() + 1
+		}
+	}
+
+	value, err = CreateNameRecord(name, fileKey, sequence, s.PrivKey)
+	if err != nil {
+		return 0, err
+	}
+	err = s.K_DHT.PutValue(ctx, key, value)
+	if err != nil {
+		return 0, err
+	}
+	return sequence, nil
+}
+
+/*
+ * Look up the file key a peer's name currently points at.
+ *
+ * Parameters:
+ *   peerId: Peer ID of the publisher
+ *   name: Name to resolve
+ *
+ * Returns:
+ *   The latest name record, already validated by the DHT
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SetupResolveName(peerId string, name string) (*fileshare.NameRecord, error) {
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return nil, err
+	}
+	value, err := s.K_DHT.GetValue(context.Background(), NameRecordKey(id, name))
+	if err != nil {
+		return nil, err
+	}
+	return ParseNameRecord(value)
+}
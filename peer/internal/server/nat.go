@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	orcaRelay "orca-peer/internal/relay"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+)
+
+/*
+ * Candidate relays for autorelay. The bootstrap peers are offered first since they run relays,
+ * followed by peers that advertised themselves as relays on the DHT. Autorelay checks that every
+ * candidate actually supports relaying before reserving a slot.
+ *
+ * Parameters:
+ *   ctx: The context
+ *   numPeers: How many candidates autorelay wants
+ *
+ * Returns:
+ *   A channel of candidates, closed when there are no more
+ */
+func (s *FileShareServerNode) RelayPeerSource(ctx context.Context, numPeers int) <-chan peer.AddrInfo {
+	peerChan := make(chan peer.AddrInfo)
+	go func() {
+		defer close(peerChan)
+		found := 0
+		send := func(info peer.AddrInfo) bool {
+			select {
+			case peerChan <- info:
+				found++
+				return found < numPeers
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		bootstrapPeers, err := s.ReadBootstrapPeers()
+		if err != nil {
+			fmt.Println("Error reading bootstrap peers:", err)
+		}
+		for _, addr := range bootstrapPeers {
+			info, err := peer.AddrInfoFromP2pAddr(addr)
+			if err != nil {
+				continue
+			}
+			if !send(*info) {
+				return
+			}
+		}
+
+		// The DHT is started after the host, so it may not be there yet
+		kDHT := s.K_DHT
+		if kDHT == nil {
+			return
+		}
+		relays, err := drouting.NewRoutingDiscovery(kDHT).FindPeers(ctx, orcaRelay.Namespace, discovery.Limit(numPeers))
+		if err != nil {
+			fmt.Println("Error finding relays:", err)
+			return
+		}
+		for info := range relays {
+			if info.ID == kDHT.Host().ID() || len(info.Addrs) == 0 {
+				continue
+			}
+			if !send(info) {
+				return
+			}
+		}
+	}()
+	return peerChan
+}
+
+// Change the addresses this peer is listed under and list every file it is selling again under the new addresses
+func (s *FileShareServerNode) SetHostMultiAddrs(hostMultiAddrs []string) {
+	fmt.Println("Now listed on the market as", hostMultiAddrs[0])
+	s.HostMultiAddr = hostMultiAddrs[0]
+	s.HostMultiAddrs = hostMultiAddrs
+	entries := make(map[string]int64)
+	ports := make(map[string]int32)
+	for key, user := range s.MarketEntryMap {
+		entries[key] = user.GetPrice()
+		ports[key] = user.GetPort()
+	}
+	for key, price := range entries {
+		err := s.registerOnMarket(key, price, ports[key])
+		if err != nil {
+			fmt.Printf("Unable to list %s under the new address: %s\n", key, err)
+		}
+	}
+}
+
+// Advertise this peer as a relay on the DHT until ctx is done, so NATed peers find it through RelayPeerSource
+func (s *FileShareServerNode) SetupAdvertiseRelay(ctx context.Context) error {
+	if s.K_DHT == nil {
+		return errors.New("the DHT has not started yet")
+	}
+	dutil.Advertise(ctx, drouting.NewRoutingDiscovery(s.K_DHT), orcaRelay.Namespace)
+	return nil
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	orcaAccounting "orca-peer/internal/accounting"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	orcaSettlement "orca-peer/internal/settlement"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// How long a consumer that used up its credit has to pay before the download is cut off
+	paymentWait = time.Minute
+	// How often the wallet is asked whether a payment arrived while waiting for it
+	paymentPollInterval = 2 * time.Second
+	// How often payments for chunks that were already served are looked for
+	paymentRecordInterval = 30 * time.Second
+)
+
+// What one consumer owes for the chunks of one file it downloaded from this peer
+type chunkAccount struct {
+	address string // Wallet address created for this account only, every payment to it is for these chunks
+	owed    int64  // Coins charged for the chunks served so far
+	// Coins for the chunks a consumer settling in batches acknowledged in its latest IOU
+	acknowledged int64
+	// Coins received at address that were already recorded as sales
+	recorded float64
+	// Who the account is for and the download it was opened for. A consumer settling in batches pays
+	// for all of its downloads through one account, so its fileKey and jobId are empty.
+	consumer peer.ID
+	fileKey  string
+	jobId    string
+}
+
+/*
+ * Charge a consumer for a chunk before it is served. Each consumer pays for each file at its own
+ * wallet address, so the coins that address received tell how much of its debt is paid. A consumer
+ * may owe for fewer than creditChunks chunks, past that this waits until its payment arrives or ctx is done.
+ *
+ * Parameters:
+ *   ctx: Bounds how long to wait for a payment
+ *   consumer: The peer downloading the file
+ *   fileKey: Key of the file
+ *   jobId: Job the consumer is downloading the file for, if any
+ *   price: Coins for this chunk
+ *
+ * Returns:
+ *   The address the consumer must pay
+ *   An error if the chunk may not be served
+ */
+func (s *FileShareServerNode) ChargeChunk(ctx context.Context, consumer peer.ID, fileKey string, jobId string, price int64) (string, error) {
+	account, err := s.chunkAccount(consumer.String()+"/"+fileKey, consumer, fileKey, jobId)
+	if err != nil {
+		return "", err
+	}
+	for {
+		received, err := orcaBlockchain.GetReceivedByAddress(account.address, s.confirmations)
+		if err != nil {
+			return "", fmt.Errorf("unable to check payments: %w", err)
+		}
+		s.recordChunkPayment(account, received)
+		s.chunkAccountsMUT.Lock()
+		unpaid := float64(account.owed) - received
+		if unpaid < float64(s.creditChunks*price) {
+			account.owed += price
+			s.chunkAccountsMUT.Unlock()
+			return account.address, nil
+		}
+		s.chunkAccountsMUT.Unlock()
+
+		select {
+		case <-time.After(paymentPollInterval):
+		case <-ctx.Done():
+			return "", fmt.Errorf("%g coins for earlier chunks were not paid to %s", unpaid, account.address)
+		}
+	}
+}
+
+/*
+ * Charge a consumer that settles in batches for a chunk before it is served. Such a consumer pays for
+ * every file it downloads from this peer at one address, and signs an IOU for each chunk it is served
+ * instead of paying for it. It may owe up to creditLimit coins, past that this waits until its payment
+ * arrives or ctx is done. Chunks served to it must be acknowledged before it is served many more.
+ * Its payments cover every download at once, so they are recorded without a file or job.
+ *
+ * Parameters:
+ *   ctx: Bounds how long to wait for a payment
+ *   consumer: The peer downloading the file
+ *   consumerKey: The consumer's public key, its IOUs are signed with it
+ *   price: Coins for this chunk
+ *   iou: The latest IOU the consumer signed for this peer, if any
+ *
+ * Returns:
+ *   The address the consumer must pay
+ *   An error if the chunk may not be served
+ */
+func (s *FileShareServerNode) ChargeBatchedChunk(ctx context.Context, consumer peer.ID, consumerKey libp2pcrypto.PubKey, price int64, iou []byte) (string, error) {
+	account, err := s.chunkAccount(consumer.String(), consumer, "", "")
+	if err != nil {
+		return "", err
+	}
+	if len(iou) > 0 {
+		s.acknowledgeChunks(account, consumerKey, iou)
+	}
+	for {
+		received, err := orcaBlockchain.GetReceivedByAddress(account.address, s.confirmations)
+		if err != nil {
+			return "", fmt.Errorf("unable to check payments: %w", err)
+		}
+		s.recordChunkPayment(account, received)
+		s.chunkAccountsMUT.Lock()
+		if account.owed-account.acknowledged >= s.creditChunks*price {
+			s.chunkAccountsMUT.Unlock()
+			return "", fmt.Errorf("%d coins for chunks served earlier were not acknowledged with an IOU", account.owed-account.acknowledged)
+		}
+		unpaid := float64(account.owed) - received
+		if unpaid+float64(price) <= float64(s.creditLimit) {
+			account.owed += price
+			s.chunkAccountsMUT.Unlock()
+			return account.address, nil
+		}
+		s.chunkAccountsMUT.Unlock()
+
+		select {
+		case <-time.After(paymentPollInterval):
+		case <-ctx.Done():
+			return "", fmt.Errorf("%g coins owed at %s reached the credit limit of %d", unpaid, account.address, s.creditLimit)
+		}
+	}
+}
+
+// Count the chunks a consumer acknowledged with an IOU, older IOUs than the one already counted are ignored
+func (s *FileShareServerNode) acknowledgeChunks(account *chunkAccount, consumerKey libp2pcrypto.PubKey, data []byte) {
+	iou, err := orcaSettlement.Verify(data, consumerKey)
+	if err != nil {
+		fmt.Println("Ignoring IOU:", err)
+		return
+	}
+	provider, err := peer.IDFromPublicKey(s.PubKey)
+	if err != nil || iou.Provider != provider.String() || iou.Address != account.address {
+		fmt.Println("Ignoring IOU for another account")
+		return
+	}
+	s.chunkAccountsMUT.Lock()
+	defer s.chunkAccountsMUT.Unlock()
+	// The consumer cannot acknowledge chunks it was not served
+	if iou.Total > account.acknowledged && iou.Total <= account.owed {
+		account.acknowledged = iou.Total
+	}
+}
+
+// Returns the account kept under key, opening one with a fresh address the first time. fileKey and jobId are empty for an account that is not tied to one download.
+func (s *FileShareServerNode) chunkAccount(key string, consumer peer.ID, fileKey string, jobId string) (*chunkAccount, error) {
+	s.chunkAccountsMUT.Lock()
+	account, ok := s.chunkAccounts[key]
+	s.chunkAccountsMUT.Unlock()
+	if ok {
+		return account, nil
+	}
+
+	address, err := orcaBlockchain.GetNewAddress()
+	if err != nil {
+		return nil, fmt.Errorf("unable to take payments: %w", err)
+	}
+	s.chunkAccountsMUT.Lock()
+	defer s.chunkAccountsMUT.Unlock()
+	// Another stream of the same consumer may have opened the account in the meantime
+	if account, ok := s.chunkAccounts[key]; ok {
+		return account, nil
+	}
+	account = &chunkAccount{address: address, consumer: consumer, fileKey: fileKey, jobId: jobId}
+	s.chunkAccounts[key] = account
+	if fileKey == "" {
+		fmt.Printf("Charging %s for every download settled in batches at %s\n", consumer, address)
+	} else {
+		fmt.Printf("Charging %s for %s (job %q) at %s\n", consumer, fileKey, jobId, address)
+	}
+	return account, nil
+}
+
+// Record the coins an account received since it was last looked at as a sale
+func (s *FileShareServerNode) recordChunkPayment(account *chunkAccount, received float64) {
+	s.chunkAccountsMUT.Lock()
+	paid := received - account.recorded
+	if paid > 0 {
+		account.recorded = received
+	}
+	s.chunkAccountsMUT.Unlock()
+	if paid <= 0 {
+		return
+	}
+	s.Accounts.Add(orcaAccounting.Entry{
+		Kind:    orcaAccounting.KindSale,
+		Amount:  paid,
+		Address: account.address,
+		JobId:   account.jobId,
+		FileKey: account.fileKey,
+		Peer:    account.consumer.String(),
+	})
+}
+
+// Look for payments to accounts that still owe for chunks, consumers usually pay for the last ones after they were served
+func (s *FileShareServerNode) recordChunkPayments(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(paymentRecordInterval):
+		}
+		s.chunkAccountsMUT.Lock()
+		accounts := make([]*chunkAccount, 0, len(s.chunkAccounts))
+		for _, account := range s.chunkAccounts {
+			if account.recorded < float64(account.owed) {
+				accounts = append(accounts, account)
+			}
+		}
+		s.chunkAccountsMUT.Unlock()
+		for _, account := range accounts {
+			received, err := orcaBlockchain.GetReceivedByAddress(account.address, s.confirmations)
+			if err != nil {
+				fmt.Println("Unable to check payments:", err)
+				break
+			}
+			s.recordChunkPayment(account, received)
+		}
+	}
+}
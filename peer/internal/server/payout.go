@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	"orca-peer/internal/fileshare"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Signed along with a payout address so the signature cannot be passed off as any other message
+const payoutSignaturePrefix = "orcanet-payout:"
+
+// Sign the OrcaCoin address a peer is paid at with the peer's key
+func SignPayoutAddress(address string, privKey libp2pcrypto.PrivKey) ([]byte, error) {
+	return privKey.Sign([]byte(payoutSignaturePrefix + address))
+}
+
+/*
+ * Return the OrcaCoin address this peer is paid at and its signature. The address is taken
+ * from the peer's wallet the first time it is needed and kept for as long as the peer runs.
+ *
+ * Returns:
+ *   The payout address
+ *   Its signature by this peer's key
+ *   An error, if any
+ */
+func (s *FileShareServerNode) PayoutAddress() (string, []byte, error) {
+	s.payoutMUT.Lock()
+	defer s.payoutMUT.Unlock()
+	if s.payoutAddress != "" {
+		return s.payoutAddress, s.payoutSignature, nil
+	}
+	address, err := orcaBlockchain.GetNewAddress()
+	if err != nil {
+		return "", nil, err
+	}
+	signature, err := SignPayoutAddress(address, s.PrivKey)
+	if err != nil {
+		return "", nil, err
+	}
+	s.payoutAddress = address
+	s.payoutSignature = signature
+	return address, signature, nil
+}
+
+// Pay this peer at address instead of one taken from its wallet, listings made afterwards carry it
+func (s *FileShareServerNode) SetPayoutAddress(address string) error {
+	if address == "" {
+		return errors.New("payout address cannot be empty")
+	}
+	signature, err := SignPayoutAddress(address, s.PrivKey)
+	if err != nil {
+		return err
+	}
+	s.payoutMUT.Lock()
+	defer s.payoutMUT.Unlock()
+	s.payoutAddress = address
+	s.payoutSignature = signature
+	return nil
+}
+
+// Returns the OrcaCoin address to pay a holder, once its signature by the holder's key is checked. The key
+// must belong to the peer the holder's address dials, or anyone could list their own address for that peer.
+func HolderWalletAddress(holder *fileshare.User) (string, error) {
+	address := holder.GetPayoutAddress()
+	if address == "" {
+		return "", errors.New("holder did not list a payout address")
+	}
+	pubKey, err := libp2pcrypto.UnmarshalRsaPublicKey(holder.GetId())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the holder's public key: %s", err)
+	}
+	keyId, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	addrInfo, err := peer.AddrInfoFromString(holder.GetIp())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the holder's address: %s", err)
+	}
+	if addrInfo.ID != keyId {
+		return "", errors.New("holder's key does not belong to the peer at its address")
+	}
+	ok, err := pubKey.Verify([]byte(payoutSignaturePrefix+address), holder.GetPayoutSignature())
+	if err != nil || !ok {
+		return "", errors.New("holder's payout address is not signed by its key")
+	}
+	return address, nil
+}
@@ -4,15 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	orcaMetrics "orca-peer/internal/metrics"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
 type PeerIdPOSTPayload struct {
 	PeerID string `json:"peerId"`
 }
 
-func getAllPeers(w http.ResponseWriter, r *http.Request) {
+func (s *FileShareServerNode) getAllPeers(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		peerTable := GetPeerTable()
+		peerTable := s.GetPeerTable()
 		var peers []PeerInfo
 		for _, peer := range peerTable {
 			peers = append(peers, peer)
@@ -32,11 +35,11 @@ func getAllPeers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
-func getPeer(w http.ResponseWriter, r *http.Request) {
+func (s *FileShareServerNode) getPeer(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		queryParams := r.URL.Query()
 		peerId := queryParams.Get("peer-id")
-		peerTable := GetPeerTable()
+		peerTable := s.GetPeerTable()
 		if peer, ok := peerTable[peerId]; ok {
 			jsonPeer, err := json.Marshal(peer)
 			if err != nil {
@@ -59,7 +62,7 @@ func getPeer(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func removePeer(w http.ResponseWriter, r *http.Request) {
+func (s *FileShareServerNode) removePeer(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		var payload PeerIdPOSTPayload
 		decoder := json.NewDecoder(r.Body)
@@ -68,7 +71,7 @@ func removePeer(w http.ResponseWriter, r *http.Request) {
 			writeStatusUpdate(w, "Cannot marshal payload in Go object. Does the payload have the correct body structure?")
 			return
 		}
-		err := DisconnectPeer(payload.PeerID)
+		err := s.DisconnectPeer(payload.PeerID)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			writeStatusUpdate(w, "Bad Request, was not able to disconnect this peer id")
@@ -83,6 +86,44 @@ func removePeer(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Use a metrics tracker for the latency and traffic of the peer table and /stats/peers
+func (s *FileShareServerNode) SetMetricsTracker(tracker *orcaMetrics.Tracker) {
+	s.peerMetrics = tracker
+}
+
+type PeerStatsResponse struct {
+	Peers     []orcaMetrics.PeerMetrics           `json:"peers"`
+	Protocols map[protocol.ID]orcaMetrics.Traffic `json:"protocols"`
+	Total     orcaMetrics.Traffic                 `json:"total"`
+}
+
+func (s *FileShareServerNode) getPeerStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only GET requests will be handled.")
+		return
+	}
+	if s.peerMetrics == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeStatusUpdate(w, "Peer metrics are not being collected.")
+		return
+	}
+	response := PeerStatsResponse{
+		Peers:     s.peerMetrics.Peers(),
+		Protocols: s.peerMetrics.Protocols(),
+		Total:     s.peerMetrics.Total(),
+	}
+	jsonStats, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStatusUpdate(w, "Failed to convert peer stats into a string")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonStats)
+}
+
 func writeStatusUpdate(w http.ResponseWriter, message string) {
 	responseMsg := map[string]interface{}{
 		"status": message,
@@ -10,32 +10,18 @@ import (
 	"io"
 	"math/big"
 	"net/http"
-	orcaClient "orca-peer/internal/client"
-	"orca-peer/internal/fileshare"
+	"orca-peer/internal/config"
 	"orca-peer/internal/hash"
 	orcaJobs "orca-peer/internal/jobs"
 	"os"
 	"path/filepath"
 	"time"
 
-	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
-	"github.com/libp2p/go-libp2p/core/host"
-
 	"github.com/google/uuid"
 )
 
 const keyServerAddr = "serverAddr"
 
-var (
-	eventChannel chan bool
-	Client       *orcaClient.Client
-	PassKey      string
-)
-
-type HTTPServer struct {
-	storage *hash.DataStore
-}
-
 type TransactionFile struct {
 	Bytes               []byte  `json:"bytes"`
 	UnlockedTransaction []byte  `json:"transaction"`
@@ -49,7 +35,7 @@ type Transaction struct {
 	Uuid      string  `json:"uuid"`
 }
 
-func handleTransaction(w http.ResponseWriter, r *http.Request) {
+func (s *FileShareServerNode) handleTransaction(w http.ResponseWriter, r *http.Request) {
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -70,19 +56,14 @@ func handleTransaction(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Unable to unmarshalling public key:", err)
 		return
 	}
-	timestamp := time.Now()
-	timestampStr := timestamp.Format(time.RFC3339Nano)
-	err = os.WriteFile("./files/transactions/"+timestampStr, body, 0644)
+	// This is only the consumer's account of a payment, chunks are served once the payment itself reaches the wallet
+	err = hash.VerifySignature(data.UnlockedTransaction, data.Bytes, publicKey)
 	if err != nil {
-		fmt.Println("Error writing transaction to file:", err)
+		fmt.Println("Did not properly hash transaction:", err)
+		http.Error(w, "Transaction signature is invalid", http.StatusBadRequest)
 		return
 	}
-	error := hash.VerifySignature(data.UnlockedTransaction, data.Bytes, publicKey)
-	if error != nil {
-		fmt.Println("Properly Hashed Transaction")
-	} else {
-		fmt.Println("Did not properly hash transaction")
-	}
+	fmt.Println("Properly Hashed Transaction")
 	var transaction Transaction
 	err = json.Unmarshal(data.UnlockedTransaction, &transaction)
 	if err != nil {
@@ -95,51 +76,32 @@ func handleTransaction(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("UUID:")
 		fmt.Println(transaction.Uuid)
 	}
-	eventChannel <- true
+	s.eventChannel <- true
 	fmt.Println("> ")
 }
 
-type Settings struct {
-	MarketRPCPort      string `json:"RPC_PORT"`
-	MarketDHTPort      string `json:"DHT_PORT"`
-	HTTPAPIPort        string `json:"API_PORT"`
-	BlockchainPassword string `json:"BLOCKCHAIN_PW"`
-}
-
-// Start HTTP/RPC server
-func StartServer(settings Settings, serverReady chan bool, confirming *bool, confirmation *string, libp2pPrivKey libp2pcrypto.PrivKey, client *orcaClient.Client, startAPIRoutes func(*map[string]fileshare.FileInfo), host host.Host, hostMultiAddr string) {
-	eventChannel = make(chan bool)
-	server := HTTPServer{
-		storage: hash.NewDataStore("files/stored/"),
-	}
-	Client = client
-	PassKey = settings.BlockchainPassword
-	fileShareServer := FileShareServerNode{
-		StoredFileInfoMap: make(map[string]fileshare.FileInfo),
-	}
-
-	go orcaJobs.InitPeriodicJobSave(host, &fileShareServer.StoredFileInfoMap)
-
+// Register the HTTP routes of the market server on mux
+func (s *FileShareServerNode) RegisterRoutes(mux *http.ServeMux) {
 	//Why are there routes in 2 different spots?
-	http.HandleFunc("/requestFile/", func(w http.ResponseWriter, r *http.Request) {
-		server.sendFile(w, r, confirming, confirmation)
-	})
-	http.HandleFunc("/storeFile/", func(w http.ResponseWriter, r *http.Request) {
-		server.storeFile(w, r, confirming, confirmation)
-	})
-	http.HandleFunc("/sendTransaction", handleTransaction)
-	http.HandleFunc("/get-peers", getAllPeers)
-	http.HandleFunc("/get-peer", getPeer)
-	http.HandleFunc("/find-peer", FindPeersForHash)
-	http.HandleFunc("/remove-peer", removePeer)
-
-	http.HandleFunc("/add-job", AddJobHandler)
-
-	fmt.Printf("HTTP Listening on port %s...\n", settings.HTTPAPIPort)
-	go CreateMarketServer(settings.MarketDHTPort, settings.MarketRPCPort, serverReady, &fileShareServer, host, hostMultiAddr, libp2pPrivKey)
-	startAPIRoutes(&fileShareServer.StoredFileInfoMap)
-
-	http.ListenAndServe(":"+settings.HTTPAPIPort, nil)
+	mux.HandleFunc("/requestFile/", s.sendFile)
+	mux.HandleFunc("/storeFile/", s.storeFile)
+	mux.HandleFunc("/sendTransaction", s.handleTransaction)
+	mux.HandleFunc("/get-peers", s.getAllPeers)
+	mux.HandleFunc("/get-peer", s.getPeer)
+	mux.HandleFunc("/find-peer", s.FindPeersForHash)
+	mux.HandleFunc("/remove-peer", s.removePeer)
+	mux.HandleFunc("/stats/peers", s.getPeerStats)
+	mux.HandleFunc("/stats/network", s.getStatsNetwork)
+
+	mux.HandleFunc("/add-job", s.AddJobHandler)
+	mux.HandleFunc("/import-manifest", s.ImportManifestHandler)
+	mux.HandleFunc("/export-manifest", s.ExportManifestHandler)
+	mux.HandleFunc("/orca/", s.GatewayHandler)
+	mux.HandleFunc("/transfer/", s.TransferHandler)
+	mux.HandleFunc("/invoices", s.InvoicesHandler)
+	mux.HandleFunc("/invoices/", s.InvoicesHandler)
+	mux.HandleFunc("/settlement", s.SettlementHandler)
+	mux.HandleFunc("/settlement/", s.SettlementHandler)
 }
 
 type Peer struct {
@@ -149,11 +111,11 @@ type Peer struct {
 	Price  float32 `json:"price"`
 }
 
-func FindPeersForHash(w http.ResponseWriter, r *http.Request) {
+func (s *FileShareServerNode) FindPeersForHash(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		queryParams := r.URL.Query()
 		hash := queryParams.Get("fileHash")
-		peers, err := findPeersForHash(hash)
+		peers, err := s.findPeersForHash(hash)
 		if err != nil {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			writeStatusUpdate(w, "Errors retrieving information about peers holding this hash.")
@@ -175,14 +137,14 @@ func FindPeersForHash(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func findPeersForHash(fileHash string) ([]Peer, error) {
-	holders, err := SetupCheckHolders(fileHash)
+func (s *FileShareServerNode) findPeersForHash(fileHash string) ([]Peer, error) {
+	holders, err := s.SetupCheckHolders(fileHash)
 	if err != nil {
 		return []Peer{}, err
 	}
 	peers := make([]Peer, 0)
 	for _, holder := range holders.Holders {
-		location, err := getLocationFromIP(string(holder.GetId()))
+		location, err := s.getLocationFromIP(string(holder.GetId()))
 		if err != nil {
 			return []Peer{}, errors.New("unable to get location about peer")
 		}
@@ -195,11 +157,11 @@ func findPeersForHash(fileHash string) ([]Peer, error) {
 	return peers, nil
 }
 
-func (server *HTTPServer) sendFile(w http.ResponseWriter, r *http.Request, confirming *bool, confirmation *string) {
+func (s *FileShareServerNode) sendFile(w http.ResponseWriter, r *http.Request) {
 	// Extract filename from URL path
 	filename := r.URL.Path[len("/requestFile/"):]
 
-	file, err := os.Open("./files/stored/" + filename)
+	file, err := os.Open(config.Path(s.dataDir, "files", "stored", filename))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -253,8 +215,8 @@ func (server *HTTPServer) sendFile(w http.ResponseWriter, r *http.Request, confi
 			fmt.Println("Sending chunk...")
 			// Write the 10-byte chunk to the response
 			w.Write(buffer[:n])
-			<-eventChannel
-			//w.Write([]byte("\n@@@@\n"))
+			<-s.eventChannel
+			//w.Write([]byte("\n\n"))
 		}
 	} else {
 		fmt.Println("sending in one piece")
@@ -275,7 +237,7 @@ type FileData struct {
 	Content  []byte `json:"content"`
 }
 
-func (server *HTTPServer) storeFile(w http.ResponseWriter, r *http.Request, confirming *bool, confirmation *string) {
+func (s *FileShareServerNode) storeFile(w http.ResponseWriter, r *http.Request) {
 	// Parse JSON object from request body
 	var fileData FileData
 	err := json.NewDecoder(r.Body).Decode(&fileData)
@@ -285,23 +247,23 @@ func (server *HTTPServer) storeFile(w http.ResponseWriter, r *http.Request, conf
 	}
 
 	// Ask for confirmation
-	*confirming = true
+	s.confirming = true
 	fmt.Printf("\nYou have just received a request to store file '%s'. Do you want to store the file? (yes/no): ", fileData.FileName)
 
 	// Check if confirmation is received
-	for *confirmation != "yes" {
-		if *confirmation != "" {
+	for s.confirmation != "yes" {
+		if s.confirmation != "" {
 			http.Error(w, fmt.Sprintf("Client declined to store file '%s'.", fileData.FileName), http.StatusUnauthorized)
-			*confirmation = ""
-			*confirming = false
+			s.confirmation = ""
+			s.confirming = false
 			return
 		}
 	}
-	*confirmation = ""
-	*confirming = false
+	s.confirmation = ""
+	s.confirming = false
 
 	// Create file
-	file_hash, err := server.storage.PutFile(fileData.Content)
+	file_hash, err := s.storage.PutFile(fileData.Content)
 	if err != nil {
 		http.Error(w, "Failed to create file", http.StatusInternalServerError)
 		return
@@ -376,8 +338,8 @@ func ConvertKeyToString(n *big.Int, e int) string {
 	publicKeyString := string(pem.EncodeToMemory(&publicKeyPEM))
 	return publicKeyString
 }
-func jobRoutine(jobId string, hash string, peerId string) {
-	// holders, err := SetupCheckHolders(hash)
+func (s *FileShareServerNode) jobRoutine(jobId string, hash string, peerId string) {
+	// holders, err := s.SetupCheckHolders(hash)
 	// if err != nil {
 	// 	fmt.Printf("Error finding holders for file: %x", err)
 	// 	return
@@ -414,14 +376,14 @@ func jobRoutine(jobId string, hash string, peerId string) {
 	// 	log.Fatal("not an RSA public key")
 	// }
 	// key := ConvertKeyToString(rsaPubKey.N, rsaPubKey.E)
-	//err = Client.GetFileOnce(bestHolder.GetIp(), bestHolder.GetPort(), hash, key, fmt.Sprintf("%d", bestHolder.GetPrice()), PassKey, jobId)
-	go orcaJobs.StartJob(jobId)
+	//err = s.Client.GetFileOnce(bestHolder.GetIp(), bestHolder.GetPort(), hash, key, fmt.Sprintf("%d", bestHolder.GetPrice()), s.PassKey, jobId)
+	go s.Jobs.StartJob(jobId)
 	// if err != nil {
 	// 	fmt.Printf("Error getting file %s", err)
 	// }
 }
 
-func AddJobHandler(w http.ResponseWriter, r *http.Request) {
+func (s *FileShareServerNode) AddJobHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPut {
 		var payload orcaJobs.AddJobReqPayload
 		decoder := json.NewDecoder(r.Body)
@@ -442,9 +404,9 @@ func AddJobHandler(w http.ResponseWriter, r *http.Request) {
 			ETA:             -1,
 			PeerId:          payload.PeerId,
 		}
-		orcaJobs.AddJob(newJob)
+		s.Jobs.AddJob(newJob)
 
-		go jobRoutine(newJob.JobId, payload.FileHash, payload.PeerId)
+		go s.jobRoutine(newJob.JobId, payload.FileHash, payload.PeerId)
 		response := orcaJobs.AddJobResPayload{JobId: newJob.JobId}
 		jsonData, err := json.Marshal(response)
 		if err != nil {
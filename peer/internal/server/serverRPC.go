@@ -20,15 +20,21 @@ import (
 	"log"
 	"net"
 	"net/http"
+	orcaAccounting "orca-peer/internal/accounting"
+	orcaClient "orca-peer/internal/client"
+	"orca-peer/internal/config"
 	"orca-peer/internal/fileshare"
 	orcaHash "orca-peer/internal/hash"
+	orcaInvoice "orca-peer/internal/invoice"
 	orcaJobs "orca-peer/internal/jobs"
+	orcaMetrics "orca-peer/internal/metrics"
+	orcaSettlement "orca-peer/internal/settlement"
+	orcaSpeedtest "orca-peer/internal/speedtest"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-ping/ping"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	record "github.com/libp2p/go-libp2p-record"
 	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
@@ -36,68 +42,220 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
-	"github.com/multiformats/go-multiaddr"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/oschwald/geoip2-golang"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
-
-	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
-	// "github.com/libp2p/go-libp2p/core/network"
 )
 
+/*
+ * A peer on the market. It owns the DHT and the gRPC server of the market, the files it is selling
+ * and everything it learned about other peers, so several of them can run in one process.
+ */
 type FileShareServerNode struct {
 	fileshare.UnimplementedFileShareServer
-	K_DHT             *dht.IpfsDHT
-	PrivKey           libp2pcrypto.PrivKey
-	PubKey            libp2pcrypto.PubKey
-	V                 record.Validator
-	StoredFileInfoMap map[string]fileshare.FileInfo //This is the list of files we are storing
-	Host              host.Host
-	HostMultiAddr     string
-}
+	K_DHT              *dht.IpfsDHT
+	PrivKey            libp2pcrypto.PrivKey
+	PubKey             libp2pcrypto.PubKey
+	V                  record.Validator
+	StoredFileInfoMap  map[string]*fileshare.FileInfo          //This is the list of files we are storing
+	StoredDirectoryMap map[string]*fileshare.DirectoryManifest //This is the list of directories we are storing
+	WrappedKeyMap      map[string][]*fileshare.WrappedKey      //This is the content keys of the encrypted files we published
+	MarketEntryMap     map[string]*fileshare.User              //This is how we listed each file on the market
+	Host               host.Host
+	HostMultiAddr      string   //The address we are listed under on the market
+	HostMultiAddrs     []string //Every address we are listed under, best first
+	Client             *orcaClient.Client
+	Jobs               *orcaJobs.JobManager
+	PassKey            string
+
+	marketProtocolPrefix string
+	bootstrapPeersFile   string
+	geoIPDatabase        string // The GeoLite2 country database used to locate peers
 
-var (
-	serverStruct FileShareServerNode
 	peerTable    map[string]PeerInfo
 	peerTableMUT sync.Mutex
-	relay1info   peer.AddrInfo // will potentially find a better place to put this later
-)
+	peerMetrics  *orcaMetrics.Tracker
+	speedTester  *orcaSpeedtest.Tester
+
+	restrictedFiles map[string]bool
+	revokedTokens   map[string]bool
+	capabilitiesMUT sync.Mutex
+
+	redeemedInvoices    map[string]int64 // invoice id to the time its grace period ends
+	servingInvoices     map[string]bool  // invoices whose range is being served, they are redeemed once it was
+	redeemedInvoicesMUT sync.Mutex
+	Invoices            *orcaInvoice.Store // Every invoice this peer issued or paid
+	payInvoiceMUT       sync.Mutex
+
+	gatewayBudget int64
+	gatewaySpent  int64
+	gatewayMUT    sync.Mutex
+	// Players seek back and forth a lot, keep recently fetched chunks so they are not paid for twice
+	gatewayCache      map[string][]byte
+	gatewayCacheOrder []string
+	gatewayCacheMUT   sync.Mutex
+
+	feeds    map[string]*Feed
+	feedsMUT sync.Mutex
+	// Subscriptions consumers were quoted and have not redeemed yet, by the address created for each
+	subscriptionQuotes map[string]*pendingSubscription
+
+	payoutAddress   string
+	payoutSignature []byte
+	payoutMUT       sync.Mutex
+
+	chunkAccounts    map[string]*chunkAccount // consumer peer ID and file key to what it owes
+	chunkAccountsMUT sync.Mutex
+	creditChunks     int64 // unpaid chunks a consumer may have before it must pay
+	confirmations    int64 // confirmations a payment needs before it counts
+	creditLimit      int64 // unpaid coins a consumer settling in batches may owe
+
+	Settlement *orcaSettlement.Ledger // IOUs this peer owes to holders, nil unless it settles in batches
+	Accounts   *orcaAccounting.Ledger // Every purchase, sale, fee and refund of this peer
+
+	dataDir      string // Everything this peer keeps is inside of its data directory
+	storage      *orcaHash.DataStore
+	eventChannel chan bool
+	confirming   bool
+	confirmation string
+
+	grpcServer *grpc.Server
+	mdns       mdns.Service
+}
 
-func CreateMarketServer(dhtPort string, rpcPort string, serverReady chan bool, fileShareServer *FileShareServerNode, host host.Host, hostMultiAddr string, privKey libp2pcrypto.PrivKey) {
-	ctx := context.Background()
+/*
+ * Create a market peer. Its host and market are set up with StartMarket.
+ *
+ * Parameters:
+ *   settings: The node's settings
+ *   privKey: The node's private key, it signs market records and identifies the host
+ *
+ * Returns:
+ *   The market peer
+ */
+func NewFileShareServerNode(settings config.Settings, privKey libp2pcrypto.PrivKey) *FileShareServerNode {
+	s := &FileShareServerNode{
+		PrivKey:            privKey,
+		PubKey:             privKey.GetPublic(),
+		StoredFileInfoMap:  make(map[string]*fileshare.FileInfo),
+		StoredDirectoryMap: make(map[string]*fileshare.DirectoryManifest),
+		WrappedKeyMap:      make(map[string][]*fileshare.WrappedKey),
+		MarketEntryMap:     make(map[string]*fileshare.User),
+		PassKey:            settings.BlockchainPassword,
+		geoIPDatabase:      settings.GeoIPDatabase,
+		peerTable:          make(map[string]PeerInfo),
+		restrictedFiles:    make(map[string]bool),
+		revokedTokens:      make(map[string]bool),
+		redeemedInvoices:   make(map[string]int64),
+		servingInvoices:    make(map[string]bool),
+		Invoices:           orcaInvoice.NewStore(config.Path(settings.DataDir, "files", "invoices", "invoices.json")),
+		gatewayBudget:      int64(settings.GatewayBudget),
+		gatewayCache:       make(map[string][]byte),
+		gatewayCacheOrder:  make([]string, 0),
+		feeds:              make(map[string]*Feed),
+		subscriptionQuotes: make(map[string]*pendingSubscription),
+		chunkAccounts:      make(map[string]*chunkAccount),
+		creditChunks:       int64(settings.CreditChunks),
+		confirmations:      int64(settings.Confirmations),
+		creditLimit:        int64(settings.CreditLimit),
+		storage:            orcaHash.NewDataStore(config.Path(settings.DataDir, "files", "stored")),
+		dataDir:            settings.DataDir,
+		eventChannel:       make(chan bool),
+	}
+	s.ConfigureMarket(settings.MarketProtocol, settings.BootstrapPeers)
+	s.LoadRevokedTokens()
+	s.LoadRestrictedFiles()
+	s.LoadRedeemedInvoices()
+	s.LoadFeeds()
+	return s
+}
+
+// The data directory of this peer
+func (s *FileShareServerNode) DataDir() string {
+	return s.dataDir
+}
+
+/*
+ * Parse the DHT_MODE setting. In auto mode the DHT serves records while AutoNAT reports
+ * this peer as publicly reachable and falls back to client mode when it is not.
+ *
+ * Parameters:
+ *   mode: "auto", "server" or "client", empty is auto
+ *
+ * Returns:
+ *   The DHT mode
+ *   An error, if any
+ */
+func ParseDHTMode(mode string) (dht.ModeOpt, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "auto":
+		return dht.ModeAuto, nil
+	case "server":
+		return dht.ModeServer, nil
+	case "client":
+		return dht.ModeClient, nil
+	}
+	return dht.ModeAuto, errors.New("DHT_MODE must be auto, server or client, got " + mode)
+}
+
+// Whether the DHT is currently serving records, which in auto mode changes with our reachability.
+// A DHT in server mode handles its kad protocol on the host, in client mode it does not.
+func IsDHTServer(h host.Host, protocolPrefix string) bool {
+	kadProtocol := protocol.ID(protocolPrefix + "/kad/1.0.0")
+	for _, id := range h.Mux().Protocols() {
+		if id == kadProtocol {
+			return true
+		}
+	}
+	return false
+}
 
-	bootstrapPeers := ReadBootstrapPeers()
-	pubKey := privKey.GetPublic()
+/*
+ * Join the market and serve it over gRPC. The DHT, discovery of other peers and the gRPC server
+ * keep running until ctx is done or Close is called.
+ *
+ * Parameters:
+ *   ctx: The context
+ *   h: libp2p host of this peer
+ *   rpcPort: Port of the gRPC server
+ *   dhtMode: "auto", "server" or "client"
+ *   hostMultiAddrs: Every address to list this peer under, best first
+ *
+ * Returns:
+ *   An error, if any
+ */
+func (s *FileShareServerNode) StartMarket(ctx context.Context, h host.Host, rpcPort string, dhtMode string, hostMultiAddrs []string) error {
+	bootstrapPeers, err := s.ReadBootstrapPeers()
+	if err != nil {
+		return err
+	}
 
-	// Start a DHT, for now we will start in client mode until we can implement a way to
-	// detect if we are behind a NAT or not to run in server mode.
-	var validator record.Validator = OrcaValidator{}
+	// Start a DHT, by default it switches between server and client mode as our reachability changes
+	mode, err := ParseDHTMode(dhtMode)
+	if err != nil {
+		return err
+	}
+	var validator record.Validator = NewValidator()
 	var options []dht.Option
-	options = append(options, dht.Mode(dht.ModeClient))
-	options = append(options, dht.ProtocolPrefix("orcanet/market"), dht.Validator(validator))
-	kDHT, err := dht.New(ctx, host, options...)
+	options = append(options, dht.Mode(mode))
+	options = append(options, dht.ProtocolPrefix(protocol.ID(s.marketProtocolPrefix)), dht.Validator(validator))
+	kDHT, err := dht.New(ctx, h, options...)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Bootstrap the DHT. In the default configuration, this spawns a Background
 	// thread that will refresh the peer table every five minutes.
 	if err = kDHT.Bootstrap(ctx); err != nil {
-		panic(err)
+		kDHT.Close()
+		return err
 	}
 
-	// Will put in better place later
-	// Just adding 1st bootstrap as peer now
-	bootstrapAddr := "/ip4/194.113.73.99/tcp/44981/p2p/QmZyLQd66AYP9sPxGbdjqZ5Ys76ZBaFFJy5PwzXxosXz74"
-	maddr, _ := multiaddr.NewMultiaddr(bootstrapAddr)
-	peerInfo, _ := peer.AddrInfoFromP2pAddr(maddr)
-	relay1info = *peerInfo
-	log.Printf("Relay with ID: %s, Addrs: %v", relay1info.ID, relay1info.Addrs)
-
 	// Let's connect to the bootstrap nodes first. They will tell us about the
 	// other nodes in the network.
 	var wg sync.WaitGroup
@@ -107,7 +265,7 @@ func CreateMarketServer(dhtPort string, rpcPort string, serverReady chan bool, f
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := host.Connect(ctx, *peerinfo); err != nil {
+			if err := h.Connect(ctx, *peerinfo); err != nil {
 				fmt.Println("WARNING: ", err)
 			} else {
 				fmt.Println("Connection established with DHT bootstrap node:", *peerinfo)
@@ -116,72 +274,132 @@ func CreateMarketServer(dhtPort string, rpcPort string, serverReady chan bool, f
 	}
 	wg.Wait()
 
-	go DiscoverPeers(ctx, host, kDHT, "orcanet/market")
-
 	//Start gRPC server
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", rpcPort))
 	if err != nil {
-		panic(err)
-	}
-
-	s := grpc.NewServer()
-	fileShareServer.K_DHT = kDHT
-	fileShareServer.PrivKey = privKey
-	fileShareServer.PubKey = pubKey
-	fileShareServer.V = validator
-	fileShareServer.Host = host
-	fileShareServer.HostMultiAddr = hostMultiAddr
-	fileshare.RegisterFileShareServer(s, fileShareServer)
-	go ListAllDHTPeers(ctx, host)
+		kDHT.Close()
+		return err
+	}
+
+	s.K_DHT = kDHT
+	s.V = validator
+	s.Host = h
+	s.HostMultiAddrs = hostMultiAddrs
+	if len(hostMultiAddrs) > 0 {
+		s.HostMultiAddr = hostMultiAddrs[0]
+	}
+
+	// Peers on the same network find each other without the bootstrap nodes or internet
+	if service, err := s.StartMDNS(h); err != nil {
+		fmt.Println("Unable to start local network discovery:", err)
+	} else {
+		s.mdns = service
+	}
+
+	go DiscoverPeers(ctx, h, kDHT, s.marketProtocolPrefix)
+
+	s.grpcServer = grpc.NewServer()
+	fileshare.RegisterFileShareServer(s.grpcServer, s)
+	go s.ListAllDHTPeers(ctx)
+	h.SetStreamHandler(orcaInvoice.Protocol, s.HandleInvoiceStream)
+	s.serveFeeds(h)
+	go s.settleInvoices(ctx)
+	go s.recordChunkPayments(ctx)
 	fmt.Printf("Market RPC Server listening at %v\n\n", lis.Addr())
+	go func() {
+		if err := s.grpcServer.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			fmt.Println("Market RPC Server stopped:", err)
+		}
+	}()
+	return nil
+}
 
-	serverReady <- true
-	serverStruct = *fileShareServer
-	if err := s.Serve(lis); err != nil {
-		panic(err)
+// Leave the market, stopping the gRPC server once its calls finish
+func (s *FileShareServerNode) Close() error {
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
+	if s.mdns != nil {
+		s.mdns.Close()
+	}
+	if s.K_DHT != nil {
+		return s.K_DHT.Close()
 	}
+	return nil
 }
 
 type PeerInfo struct {
 	Location    string `json:"location"`
-	Latency     string `json:"latency"`
+	Latency     string `json:"latency"` // Milliseconds, from the latest libp2p ping
 	PeerID      string `json:"peerId"`
 	Connection  string `json:"connection"`
 	OpenStreams string `json:"openStreams"`
 	FlagUrl     string `json:"flagUrl"`
+
+	Traffic orcaMetrics.Traffic  `json:"traffic"` // Bytes exchanged with the peer
+	History []orcaMetrics.Sample `json:"history"` // Recent latency and throughput, oldest first
+}
+
+// Returns a copy of the peer table with the latest metrics of each peer
+func (s *FileShareServerNode) GetPeerTable() map[string]PeerInfo {
+	s.peerTableMUT.Lock()
+	defer s.peerTableMUT.Unlock()
+	table := make(map[string]PeerInfo, len(s.peerTable))
+	for key, info := range s.peerTable {
+		if s.peerMetrics != nil {
+			if id, err := peer.Decode(key); err == nil {
+				if metrics, ok := s.peerMetrics.Peer(id); ok {
+					info.Latency = fmt.Sprint(metrics.LatencyMs)
+					info.Traffic = metrics.Traffic
+					info.History = metrics.History
+				}
+			}
+		}
+		table[key] = info
+	}
+	return table
 }
 
-func GetPeerTable() map[string]PeerInfo {
-	return peerTable
+// Add a peer to the peer table unless it is already listed, returns whether it was added
+func (s *FileShareServerNode) addPeer(info PeerInfo) bool {
+	s.peerTableMUT.Lock()
+	defer s.peerTableMUT.Unlock()
+	if _, ok := s.peerTable[info.PeerID]; ok {
+		return false
+	}
+	s.peerTable[info.PeerID] = info
+	return true
 }
-func DisconnectPeer(peerId string) error {
-	peerTableMUT.Lock()
-	if val, ok := peerTable[peerId]; ok {
+func (s *FileShareServerNode) DisconnectPeer(peerId string) error {
+	s.peerTableMUT.Lock()
+	if val, ok := s.peerTable[peerId]; ok {
 		val.OpenStreams = "NO"
-		peerTable[peerId] = val
+		s.peerTable[peerId] = val
 	} else {
-		peerTableMUT.Unlock()
+		s.peerTableMUT.Unlock()
 		return errors.New("key does not exist")
 	}
-	peerTableMUT.Unlock()
+	s.peerTableMUT.Unlock()
 	return nil
 }
 
-func getLocationFromIP(peerId string) (string, error) {
+func (s *FileShareServerNode) getLocationFromIP(peerId string) (string, error) {
 	location := ""
-	peerTableMUT.Lock()
-	if val, ok := peerTable[peerId]; ok {
+	s.peerTableMUT.Lock()
+	if val, ok := s.peerTable[peerId]; ok {
 		mAddr, err := ma.NewMultiaddr(val.Connection)
 		if err != nil {
+			s.peerTableMUT.Unlock()
 			return "", errors.New("cannot convert multiaddress to IP")
 		}
 		ipStr, err := mAddr.ValueForProtocol(ma.P_IP4)
 		if err != nil || strings.Contains(ipStr, "127.0.0.1") {
+			s.peerTableMUT.Unlock()
 			return "", nil
 		}
 		ip := net.ParseIP(ipStr)
 
-		db, err := geoip2.Open("./rsrc/GeoLite2-Country.mmdb")
+		db, err := geoip2.Open(s.geoIPDatabase)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -190,87 +408,54 @@ func getLocationFromIP(peerId string) (string, error) {
 			log.Fatal(err)
 		}
 		val.Location = record.Country.Names["en"]
-		peerTable[peerId] = val
+		s.peerTable[peerId] = val
 	} else {
-		peerTableMUT.Unlock()
+		s.peerTableMUT.Unlock()
 		return "", errors.New("key does not exist")
 	}
-	peerTableMUT.Unlock()
+	s.peerTableMUT.Unlock()
 	return location, nil
 }
 
-func getLatency(peerId string) error {
-	peerTableMUT.Lock()
-	if val, ok := peerTable[peerId]; ok {
-		mAddr, err := ma.NewMultiaddr(val.Connection)
-		if err != nil {
-			return errors.New("cannot convert multiaddress to IP")
-		}
-		ipStr, err := mAddr.ValueForProtocol(ma.P_IP4)
-		if err != nil {
-			return nil
-		}
-		pinger, err := ping.NewPinger(ipStr)
-		if err != nil {
-			fmt.Printf("Error creating pinger: %s\n", err)
-			return errors.New("cant create pinger")
-		}
-
-		pinger.Count = 3
-		pinger.Timeout = time.Second * 2
-		pinger.Size = 64
-		pinger.Run()
-		stats := pinger.Statistics()
-		// fmt.Printf("  Packets: Sent = %d, Received = %d, Lost = %d (%.2f%% loss),\n",
-		// 	stats.PacketsSent, stats.PacketsRecv, stats.PacketsSent-stats.PacketsRecv,
-		// 	stats.PacketLoss*100)
-		val.Latency = fmt.Sprint(stats.AvgRtt.Seconds() * 1000)
-		// fmt.Printf("  Minimum = %.2fms, Maximum = %.2fms, Average = %.2fms\n",
-		// 	stats.MinRtt.Seconds()*1000, stats.MaxRtt.Seconds()*1000, stats.AvgRtt.Seconds()*1000)
-		peerTable[peerId] = val
-	} else {
-		peerTableMUT.Unlock()
-		return errors.New("key does not exist")
-	}
-	peerTableMUT.Unlock()
-	return nil
-}
-func UpdateAllPeerLatency() {
-	for peerId := range peerTable {
-		go getLatency(peerId)
-	}
-}
-func ListAllDHTPeers(ctx context.Context, host host.Host) {
-	peerTable = make(map[string]PeerInfo)
+// Add the peers of the DHT routing table to the peer table as they show up, their metrics are
+// kept by the metrics tracker
+func (s *FileShareServerNode) ListAllDHTPeers(ctx context.Context) {
 	for {
-		time.Sleep(time.Second * 3)
-		peers := serverStruct.K_DHT.RoutingTable().ListPeers()
-		// Should make a channel that waits for this
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * 10):
+		}
+		peers := s.K_DHT.RoutingTable().ListPeers()
 
 		for _, p := range peers {
-			addr, err := serverStruct.K_DHT.FindPeer(ctx, p)
+			s.peerTableMUT.Lock()
+			_, listed := s.peerTable[p.String()]
+			s.peerTableMUT.Unlock()
+			if listed {
+				continue
+			}
+			addr, err := s.K_DHT.FindPeer(ctx, p)
 			if err != nil {
 				fmt.Printf("Error finding peer %s: %s\n", p, err)
 				continue
 			}
 			key := addr.ID.String()
-			if _, ok := peerTable[key]; !ok {
-				connection := ""
-				if len(addr.Addrs) > 0 {
-					connection = addr.Addrs[0].String()
-				}
-				peerTable[key] = PeerInfo{
-					Location:    "",
-					Latency:     "",
-					PeerID:      addr.ID.String(),
-					Connection:  connection,
-					OpenStreams: "YES",
-					FlagUrl:     "",
-				}
-				go getLocationFromIP(key)
+			connection := ""
+			if len(addr.Addrs) > 0 {
+				connection = addr.Addrs[0].String()
+			}
+			if s.addPeer(PeerInfo{
+				Location:    "",
+				Latency:     "",
+				PeerID:      key,
+				Connection:  connection,
+				OpenStreams: "YES",
+				FlagUrl:     "",
+			}) {
+				go s.getLocationFromIP(key)
 			}
 		}
-		go UpdateAllPeerLatency()
 	}
 }
 
@@ -289,77 +474,47 @@ func ListAllDHTPeers(ctx context.Context, host host.Host) {
  */
 func DiscoverPeers(ctx context.Context, h host.Host, kDHT *dht.IpfsDHT, advertise string) {
 	routingDiscovery := drouting.NewRoutingDiscovery(kDHT)
-	if kDHT.Mode() == dht.ModeServer {
-		dutil.Advertise(ctx, routingDiscovery, advertise)
-	}
+	var stopAdvertising context.CancelFunc
 
-	// Look for others who have announced and attempt to connect to them
+	// Look for others who have announced and attempt to connect to them. Unreachable peers are
+	// listed under relay circuits, connecting through those is upgraded to a direct connection by hole punching.
 	for {
-		peerChan, err := routingDiscovery.FindPeers(ctx, advertise)
-		if err != nil {
-			panic(err)
+		// The DHT may have switched modes since the last round, only announce ourselves while serving records
+		isServer := IsDHTServer(h, advertise)
+		if isServer && stopAdvertising == nil {
+			var advertiseCtx context.Context
+			advertiseCtx, stopAdvertising = context.WithCancel(ctx)
+			dutil.Advertise(advertiseCtx, routingDiscovery, advertise)
+			fmt.Println("DHT is in server mode, advertising under", advertise)
+		} else if !isServer && stopAdvertising != nil {
+			stopAdvertising()
+			stopAdvertising = nil
+			fmt.Println("DHT is in client mode, no longer advertising under", advertise)
 		}
-		for peer := range peerChan {
-			if peer.ID == h.ID() {
-				continue // No self connection
-			}
-
-			// The following code needs to be on the bootstrap server to enable relay services. relay1
-			// is of type Host:
 
-			// _, err = relay.New(relay1)
-			// if err != nil {
-			// 	log.Printf("Failed to instantiate the relay: %v", err)
-			// 	return
-			// }
-
-			// Connect to relay1
-			log.Printf("Attempting to connect to relay with ID: %s, Addrs: %v", relay1info.ID, relay1info.Addrs)
-			if err := h.Connect(context.Background(), relay1info); err != nil {
-				log.Printf("Failed to connect host and relay1: %v", err)
-				return
-			}
-
-			// Non-optional step. Probably don't close it though? Will check more
-			h.SetStreamHandler("/customprotocol", func(s network.Stream) {
-				log.Println("Awesome! We're now communicating via the relay!")
-				// technically in the example the peer reads using
-				// s.Read(make([]byte, 1))
-				s.Close()
-			})
-
-			log.Printf("Connected to relay. Attempting to set a reservation with the relay")
-			_, err = client.Reserve(context.Background(), h, relay1info)
-			if err != nil {
-				log.Printf("Recieving peer failed to receive a relay reservation from relay1. %v", err)
-				return
-			}
-
-			// Multiaddress was updated in peer/internal/internal/cli.go BUT
-			// I dont think we're actually updated it in h. Just stored it in dht.
-			// Technically, in the example, the new multiaddress was set up for the host AFTER it
-			// made a reservation with its old multiaddress
-
-			// Check if h is using the new multiaddress to connect to peers.
-			// When I set the multiaddress to ALWAYS update in cli.go, the following check confirms that
-			// we are NOT updating the multiaddress that h actually uses to connect
-			// Will look into it more later
-
-			fmt.Print("Multiaddress: ")
-			for _, addr := range h.Addrs() {
-				fmt.Printf("%s/p2p/%s\n", addr, h.ID())
+		peerChan, err := routingDiscovery.FindPeers(ctx, advertise)
+		if err != nil && ctx.Err() == nil {
+			fmt.Println("Error finding peers:", err)
+		}
+		if err == nil {
+			for peer := range peerChan {
+				if peer.ID == h.ID() || h.Network().Connectedness(peer.ID) == network.Connected {
+					continue // No self connection
+				}
+				if err := h.Connect(ctx, peer); err != nil {
+					log.Printf("Failed to connect host and peer: %v", err)
+					continue
+				}
 			}
-
-			log.Printf("Expected: /ip4/194.113.73.99/tcp/44981/p2p/QmZyLQd66AYP9sPxGbdjqZ5Ys76ZBaFFJy5PwzXxosXz74/p2p-circuit/p2p/%v", h.ID())
-
-			if err := h.Connect(ctx, peer); err != nil {
-				log.Printf("Failed to connect host and peer: %v", err)
-				return
+		}
+		select {
+		case <-ctx.Done():
+			if stopAdvertising != nil {
+				stopAdvertising()
 			}
-
-			log.Println("Got to the end of the connection function. Reservation was set")
+			return
+		case <-time.After(time.Second * 10):
 		}
-		time.Sleep(time.Second * 10)
 	}
 }
 
@@ -409,8 +564,8 @@ func sendFileToConsumer(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func SetupRegisterFile(filePath string, fileName string, amountPerMB int64, hostMultiAddr string, port int32) error {
-	srcFilePath := fmt.Sprintf("./files/%s", fileName)
+func (s *FileShareServerNode) SetupRegisterFile(filePath string, fileName string, amountPerMB int64, hostMultiAddr string, port int32) error {
+	srcFilePath := config.Path(s.dataDir, "files", fileName)
 	osFileInfo, err := os.Stat(srcFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -424,33 +579,135 @@ func SetupRegisterFile(filePath string, fileName string, amountPerMB int64, host
 		return errors.New("Specified file is a directory.")
 	}
 
-	fileKey, orcaFileInfo, err := orcaHash.SaveChunkedFile(filePath, fileName)
+	_, err = s.registerChunkedFile(filePath, fileName, amountPerMB, port, false)
+	return err
+}
+
+// Chunk a file, register it on the DHT market and start serving it. A restricted file is only
+// served to consumers with a capability token, and is restricted before it can be requested.
+func (s *FileShareServerNode) registerChunkedFile(filePath string, fileName string, amountPerMB int64, port int32, restricted bool) (string, error) {
+	fileKey, orcaFileInfo, err := orcaHash.SaveChunkedFile(s.dataDir, filePath, fileName)
 	if err != nil {
-		return err
+		return "", err
 	}
-	serverStruct.StoredFileInfoMap[fileKey] = orcaFileInfo
+	s.StoredFileInfoMap[fileKey] = &orcaFileInfo
 	fmt.Printf("Final Hashed: %s\n", fileKey)
+	if restricted {
+		err = s.SetupRestrictFile(fileKey)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = s.registerOnMarket(fileKey, amountPerMB, port)
+	if err != nil {
+		return "", err
+	}
+
+	s.Host.SetStreamHandler(protocol.ID("orcanet-fileshare/1.0/"+fileKey), s.HandleStoredFileStream)
+	return fileKey, nil
+}
+
+/*
+ * Chunk every file inside of a directory and register the directory on the DHT market.
+ * The directory is listed under a single key that serves its manifest, and every file
+ * inside of it is listed under its own file key so it can also be bought on its own.
+ *
+ * Parameters:
+ *   dirPath: Path to the directory on disk
+ *   dirName: Name the directory will be reconstructed under by consumers
+ *   amountPerMB: Price of the files inside of the directory
+ *   port: HTTP port of this peer
+ *
+ * Returns:
+ *   The key of the directory
+ *   An error, if any
+ */
+func (s *FileShareServerNode) SetupRegisterDirectory(dirPath string, dirName string, amountPerMB int64, port int32) (string, error) {
+	osFileInfo, err := os.Stat(dirPath)
+	if err != nil {
+		return "", err
+	}
+	if !osFileInfo.IsDir() {
+		return "", errors.New("Specified path is not a directory.")
+	}
+
+	dirKey, manifest, err := orcaHash.SaveChunkedDirectory(s.dataDir, dirPath, dirName)
+	if err != nil {
+		return "", err
+	}
 
+	for _, entry := range manifest.GetEntries() {
+		s.StoredFileInfoMap[entry.GetFileKey()] = entry.GetFileInfo()
+		err = s.registerOnMarket(entry.GetFileKey(), amountPerMB, port)
+		if err != nil {
+			return "", err
+		}
+		s.Host.SetStreamHandler(protocol.ID("orcanet-fileshare/1.0/"+entry.GetFileKey()), s.HandleStoredFileStream)
+	}
+
+	s.StoredDirectoryMap[dirKey] = manifest
+	err = s.registerOnMarket(dirKey, amountPerMB, port)
+	if err != nil {
+		return "", err
+	}
+	s.Host.SetStreamHandler(protocol.ID("orcanet-manifest/1.0/"+dirKey), s.HandleDirectoryManifestStream)
+	fmt.Printf("Directory Hashed: %s (%d files)\n", dirKey, len(manifest.GetEntries()))
+	return dirKey, nil
+}
+
+// Put a record for this peer under the given key on the DHT market
+func (s *FileShareServerNode) registerOnMarket(key string, amountPerMB int64, port int32) error {
+	var err error
 	ctx := context.Background()
 	fileReq := fileshare.RegisterFileRequest{}
 	fileReq.User = &fileshare.User{}
 	fileReq.User.Price = amountPerMB
-	fileReq.User.Ip = serverStruct.HostMultiAddr
+	fileReq.User.Ip = s.HostMultiAddr
+	fileReq.User.Addrs = s.HostMultiAddrs
 	fileReq.User.Port = port
-	fileReq.FileKey = fileKey
-	_, err = serverStruct.RegisterFile(ctx, &fileReq)
+	fileReq.User.PayoutAddress, fileReq.User.PayoutSignature, err = s.PayoutAddress()
+	if err != nil {
+		fmt.Println("Unable to get a payout address, consumers will not be able to pay for", key+":", err)
+	}
+	fileReq.FileKey = key
+	_, err = s.RegisterFile(ctx, &fileReq)
 	if err != nil {
 		return err
 	}
-
-	serverStruct.Host.SetStreamHandler(protocol.ID("orcanet-fileshare/1.0/"+fileKey), HandleStoredFileStream)
+	s.MarketEntryMap[key] = fileReq.User
 	return nil
 }
 
-func HandleStoredFileStream(s network.Stream) {
-	defer s.Close()
+// Reply to a manifest request with the length prefixed DirectoryManifest protobuf for the directory
+func (s *FileShareServerNode) HandleDirectoryManifestStream(stream network.Stream) {
+	defer stream.Close()
+	dirKey := strings.TrimPrefix(string(stream.Protocol()), "orcanet-manifest/1.0/")
+	manifest, ok := s.StoredDirectoryMap[dirKey]
+	if !ok {
+		fmt.Println("Error: requested manifest for unknown directory", dirKey)
+		return
+	}
+
+	manifestBytes, err := proto.Marshal(manifest)
+	if err != nil {
+		fmt.Printf("Error marshaling manifest %s\n", err)
+		return
+	}
+
+	respLengthHeader := make([]byte, 4)
+	binary.LittleEndian.PutUint32(respLengthHeader, uint32(len(manifestBytes)))
+	_, err = stream.Write(append(respLengthHeader, manifestBytes...))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
+func (s *FileShareServerNode) HandleStoredFileStream(stream network.Stream) {
+	defer stream.Close()
 	for {
-		buf := bufio.NewReader(s)
+		buf := bufio.NewReader(stream)
 		lengthBytes := make([]byte, 0)
 		for i := 0; i < 4; i++ {
 			b, err := buf.ReadByte()
@@ -472,10 +729,34 @@ func HandleStoredFileStream(s network.Stream) {
 			return
 		}
 
-		orcaFileInfo := serverStruct.StoredFileInfoMap[fileChunkReq.FileHash]
+		if !s.mayServeFile(stream, fileChunkReq.FileHash, fileChunkReq.Capabilities) {
+			refusal, err := json.Marshal(orcaJobs.FileChunk{
+				FileHash: fileChunkReq.FileHash,
+				JobId:    fileChunkReq.JobId,
+				Error:    "this file is restricted and no valid capability token was sent",
+			})
+			if err == nil {
+				writeMessage(stream, refusal)
+			}
+			fmt.Printf("Refused restricted file %s to %s\n", fileChunkReq.FileHash, stream.Conn().RemotePeer())
+			return
+		}
+
+		orcaFileInfo := s.StoredFileInfoMap[fileChunkReq.FileHash]
+		if fileChunkReq.ChunkIndex < 0 || fileChunkReq.ChunkIndex >= len(orcaFileInfo.GetChunkHashes()) {
+			refusal, err := json.Marshal(orcaJobs.FileChunk{
+				FileHash: fileChunkReq.FileHash,
+				JobId:    fileChunkReq.JobId,
+				Error:    "chunk index out of range",
+			})
+			if err == nil {
+				writeMessage(stream, refusal)
+			}
+			return
+		}
 		chunkHash := orcaFileInfo.GetChunkHashes()[fileChunkReq.ChunkIndex]
 
-		file, err := os.Open("./files/stored/" + chunkHash)
+		file, err := os.Open(config.Path(s.dataDir, "files", "stored", chunkHash))
 		if err != nil {
 			fmt.Println("Error:", err)
 			return
@@ -487,6 +768,34 @@ func HandleStoredFileStream(s network.Stream) {
 			ChunkIndex: fileChunkReq.ChunkIndex,
 			MaxChunk:   len(orcaFileInfo.GetChunkHashes()),
 			JobId:      fileChunkReq.JobId,
+			Subscribed: s.subscribedToFile(stream, fileChunkReq.FileHash, fileChunkReq.Tokens),
+		}
+		price := s.MarketEntryMap[fileChunkReq.FileHash].GetPrice()
+		if price > 0 && !fileChunk.Subscribed {
+			fileChunk.Invoiced = s.invoicedFile(stream, fileChunkReq.FileHash, fileChunkReq.Invoices)
+		}
+		if price > 0 && !fileChunk.Subscribed && !fileChunk.Invoiced {
+			ctx, cancel := context.WithTimeout(context.Background(), paymentWait)
+			if fileChunkReq.Batched && s.creditLimit > 0 {
+				fileChunk.PaymentAddress, err = s.ChargeBatchedChunk(ctx, stream.Conn().RemotePeer(), stream.Conn().RemotePublicKey(), price, fileChunkReq.IOU)
+				fileChunk.CreditLimit = s.creditLimit
+			} else {
+				fileChunk.PaymentAddress, err = s.ChargeChunk(ctx, stream.Conn().RemotePeer(), fileChunkReq.FileHash, fileChunkReq.JobId, price)
+			}
+			cancel()
+			if err != nil {
+				refusal, err := json.Marshal(orcaJobs.FileChunk{
+					FileHash: fileChunkReq.FileHash,
+					JobId:    fileChunkReq.JobId,
+					Error:    "payment required: " + err.Error(),
+				})
+				if err == nil {
+					writeMessage(stream, refusal)
+				}
+				fmt.Printf("Stopped serving %s to %s: %s\n", fileChunkReq.FileHash, stream.Conn().RemotePeer(), err)
+				return
+			}
+			fileChunk.Price = price
 		}
 
 		var chunkData bytes.Buffer
@@ -508,13 +817,13 @@ func HandleStoredFileStream(s network.Stream) {
 
 		respLengthHeader := make([]byte, 4)
 		binary.LittleEndian.PutUint32(respLengthHeader, uint32(len(payloadBytes)))
-		_, err = s.Write(respLengthHeader)
+		_, err = stream.Write(respLengthHeader)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		_, err = s.Write(payloadBytes)
+		_, err = stream.Write(payloadBytes)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -541,7 +850,7 @@ func (s *FileShareServerNode) RegisterFile(ctx context.Context, in *fileshare.Re
 	}
 	in.GetUser().Id = pubKeyBytes
 
-	value, err := s.K_DHT.GetValue(ctx, "orcanet/market/"+hash)
+	value, err := s.K_DHT.GetValue(ctx, marketKeyPrefix+hash)
 	if err != nil {
 		value = make([]byte, 0)
 	}
@@ -607,24 +916,34 @@ func (s *FileShareServerNode) RegisterFile(ctx context.Context, in *fileshare.Re
 	}
 	value = append(value, record...)
 
-	err = s.K_DHT.PutValue(ctx, "orcanet/market/"+in.GetFileKey(), value)
+	err = s.K_DHT.PutValue(ctx, marketKeyPrefix+in.GetFileKey(), value)
 	if err != nil {
 		return nil, err
 	}
 	return &emptypb.Empty{}, nil
 }
 
-func SetupCheckHolders(fileHash string) (*fileshare.HoldersResponse, error) {
+func (s *FileShareServerNode) SetupCheckHolders(fileHash string) (*fileshare.HoldersResponse, error) {
 	ctx := context.Background()
 	fileReq := fileshare.CheckHoldersRequest{}
 	fileReq.FileKey = fileHash
-	holdersResponse, err := serverStruct.CheckHolders(ctx, &fileReq)
+	holdersResponse, err := s.CheckHolders(ctx, &fileReq)
 	if err != nil {
 		return nil, err
 	}
+	s.rememberHolderAddrs(holdersResponse.GetHolders())
 	return holdersResponse, nil
 }
 
+// Look up the addresses of a peer through the DHT
+func (s *FileShareServerNode) SetupFindPeer(peerId string) (peer.AddrInfo, error) {
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	return s.K_DHT.FindPeer(context.Background(), id)
+}
+
 /*
  * gRPC service to check for producers who have registered a specific file.
  *
@@ -639,7 +958,7 @@ func SetupCheckHolders(fileHash string) (*fileshare.HoldersResponse, error) {
 func (s *FileShareServerNode) CheckHolders(ctx context.Context, in *fileshare.CheckHoldersRequest) (*fileshare.HoldersResponse, error) {
 	hash := in.GetFileKey()
 	users := make([]*fileshare.User, 0)
-	value, err := s.K_DHT.GetValue(ctx, "orcanet/market/"+hash)
+	value, err := s.K_DHT.GetValue(ctx, marketKeyPrefix+hash)
 	if err != nil {
 		return &fileshare.HoldersResponse{Holders: users}, nil
 	}
@@ -662,28 +981,32 @@ func (s *FileShareServerNode) CheckHolders(ctx context.Context, in *fileshare.Ch
 	return &fileshare.HoldersResponse{Holders: users}, nil
 }
 
-// Find file bootstrap.peers and parse it to get multiaddrs of bootstrap peers
-func ReadBootstrapPeers() []ma.Multiaddr {
+// Find the bootstrap peers file and parse it to get multiaddrs of bootstrap peers
+func (s *FileShareServerNode) ReadBootstrapPeers() ([]ma.Multiaddr, error) {
 	peers := []ma.Multiaddr{}
 
-	// For now bootstrap.peers can be in cli folder but it can be moved
-	file, err := os.Open("internal/cli/bootstrap.peers")
+	// The public market's bootstrap.peers is in the cli folder, closed markets bring their own list
+	file, err := os.Open(s.bootstrapPeersFile)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanLines)
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 
 		multiadd, err := ma.NewMultiaddr(line)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		peers = append(peers, multiadd)
 	}
 
-	return peers
+	return peers, nil
 }
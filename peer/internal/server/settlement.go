@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	orcaSettlement "orca-peer/internal/settlement"
+	"strings"
+)
+
+type settlementStatus struct {
+	Owed        int64                       `json:"owed"`
+	Debts       []orcaSettlement.Debt       `json:"debts"`
+	Settlements []orcaSettlement.Settlement `json:"settlements"`
+}
+
+/*
+ * HTTP route for batched settlement.
+ * GET /settlement lists the IOUs this peer owes to holders and the settlements that paid them.
+ * POST /settlement/settle pays every holder this peer owes now, instead of waiting for the threshold or schedule.
+ */
+func (s *FileShareServerNode) SettlementHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Settlement == nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeStatusUpdate(w, "This peer pays for every chunk on its own. Set SETTLE_THRESHOLD to settle in batches.")
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/settlement"), "/")
+	var result interface{}
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		result = settlementStatus{
+			Owed:        s.Settlement.Owed(),
+			Debts:       s.Settlement.Debts(),
+			Settlements: s.Settlement.Settlements(),
+		}
+	case r.Method == http.MethodPost && path == "settle":
+		settlement, err := s.Settlement.Settle()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		if settlement == nil {
+			w.WriteHeader(http.StatusOK)
+			writeStatusUpdate(w, "Nothing is owed")
+			return
+		}
+		result = settlement
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only GET and POST requests will be handled.")
+		return
+	}
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStatusUpdate(w, "Failed to convert JSON Data into a string")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
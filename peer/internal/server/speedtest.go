@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	orcaSpeedtest "orca-peer/internal/speedtest"
+
+	"github.com/google/uuid"
+)
+
+// Use a speed tester for /stats/network
+func (s *FileShareServerNode) SetSpeedTester(tester *orcaSpeedtest.Tester) {
+	s.speedTester = tester
+}
+
+type NetworkStatsResponse struct {
+	Id            string                 `json:"_id"`
+	PublicKey     string                 `json:"pub_key"`
+	IncomingSpeed string                 `json:"incoming_speed"` // KB per second
+	OutgoingSpeed string                 `json:"outgoing_speed"` // KB per second
+	LatencyMs     float64                `json:"latency_ms"`
+	Results       []orcaSpeedtest.Result `json:"results"`
+}
+
+// Kilobytes per second from megabits per second
+func mbpsToKBps(mbps float64) float64 {
+	return mbps * 1e6 / 8 / 1024
+}
+
+func (s *FileShareServerNode) getStatsNetwork(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.speedTester == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeStatusUpdate(w, "Speed tests are not available.")
+		return
+	}
+	results, err := s.speedTester.Run(context.Background())
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeStatusUpdate(w, fmt.Sprintf("Unable to test network speeds: %s", err))
+		return
+	}
+	summary := orcaSpeedtest.Summarize(results)
+	response := NetworkStatsResponse{
+		Id:            uuid.New().String(),
+		PublicKey:     "",
+		IncomingSpeed: fmt.Sprintf("%f", mbpsToKBps(summary.DownloadMbps)),
+		OutgoingSpeed: fmt.Sprintf("%f", mbpsToKBps(summary.UploadMbps)),
+		LatencyMs:     summary.LatencyMs,
+		Results:       results,
+	}
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
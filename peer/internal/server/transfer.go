@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	"orca-peer/internal/config"
+	orcaInvoice "orca-peer/internal/invoice"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (s *FileShareServerNode) redeemedInvoicesPath() string {
+	return config.Path(s.dataDir, "files", "invoices", "redeemed.json")
+}
+
+// Load the ids of invoices that were paid and served by previous runs
+func (s *FileShareServerNode) LoadRedeemedInvoices() {
+	data, err := os.ReadFile(s.redeemedInvoicesPath())
+	if err != nil {
+		return
+	}
+	s.redeemedInvoicesMUT.Lock()
+	defer s.redeemedInvoicesMUT.Unlock()
+	err = json.Unmarshal(data, &s.redeemedInvoices)
+	if err != nil {
+		fmt.Println("Error reading redeemed invoices:", err)
+	}
+}
+
+// Start serving an invoice, returns false if it was already redeemed or is being served. Call
+// releaseInvoice once done, its range is only redeemed if it was served.
+func (s *FileShareServerNode) reserveInvoice(invoice *orcaInvoice.Invoice) bool {
+	s.redeemedInvoicesMUT.Lock()
+	defer s.redeemedInvoicesMUT.Unlock()
+	if _, ok := s.redeemedInvoices[invoice.Id]; ok || s.servingInvoices[invoice.Id] {
+		return false
+	}
+	s.servingInvoices[invoice.Id] = true
+	return true
+}
+
+// Stop serving an invoice reserved with reserveInvoice, it is redeemed if served is true and can be used again otherwise
+func (s *FileShareServerNode) releaseInvoice(invoice *orcaInvoice.Invoice, served bool) {
+	s.redeemedInvoicesMUT.Lock()
+	defer s.redeemedInvoicesMUT.Unlock()
+	delete(s.servingInvoices, invoice.Id)
+	if served {
+		s.redeemInvoice(invoice)
+	}
+}
+
+// Mark an invoice as served, redeemedInvoicesMUT must be held. Invoices are forgotten once their grace period is over since they are refused anyway.
+func (s *FileShareServerNode) redeemInvoice(invoice *orcaInvoice.Invoice) {
+	now := time.Now().UTC().Unix()
+	for id, expiresAt := range s.redeemedInvoices {
+		if expiresAt < now {
+			delete(s.redeemedInvoices, id)
+		}
+	}
+	s.redeemedInvoices[invoice.Id] = time.Unix(invoice.ExpiresAt, 0).Add(invoiceGracePeriod).Unix()
+
+	data, err := json.Marshal(s.redeemedInvoices)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.redeemedInvoicesPath()), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(s.redeemedInvoicesPath(), data, 0644)
+	}
+	if err != nil {
+		fmt.Println("Error saving redeemed invoices:", err)
+	}
+}
+
+// Write bytes start to end of a file, as a partial response when it is not the whole file. Returns an error if the range was not served.
+func serveRange(w http.ResponseWriter, file *networkFile, start int64, end int64) error {
+	_, err := file.Seek(start, io.SeekStart)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStatusUpdate(w, err.Error())
+		return err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+	if start == 0 && end == file.size-1 {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	_, err = io.CopyN(w, file, end-start+1)
+	if err != nil {
+		fmt.Println("Error serving transfer:", err)
+	}
+	return err
+}
+
+/*
+ * HTTP route selling a byte range of a file this peer is storing to clients without a libp2p host,
+ * GET /transfer/<fileKey>. A request without an invoice is answered with 402 and a signed invoice
+ * for the range in its Range header, priced per chunk it touches and payable to a fresh wallet
+ * address. The client pays, then repeats the request with the invoice base64 encoded in
+ * X-Orca-Invoice and its transaction id in X-Orca-Payment to receive the range, once the payment has
+ * PAYMENT_CONFIRMATIONS confirmations. The invoice is also sent as an orcacoin: URI in
+ * X-Orca-Payment-URI. An invoice is redeemed once its range was served, if serving fails it can be
+ * used again.
+ */
+func (s *FileShareServerNode) TransferHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeStatusUpdate(w, "Only GET requests will be handled.")
+		return
+	}
+	fileKey := strings.TrimPrefix(r.URL.Path, "/transfer/")
+	file, ok := s.openStoredFile(fileKey)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		writeStatusUpdate(w, "This peer is not storing a file with key "+fileKey)
+		return
+	}
+	if s.isRestricted(fileKey) {
+		w.WriteHeader(http.StatusForbidden)
+		writeStatusUpdate(w, "This file is restricted to holders of a capability token.")
+		return
+	}
+
+	encodedInvoice := r.Header.Get(orcaInvoice.InvoiceHeader)
+	if encodedInvoice == "" {
+		start, end, err := orcaInvoice.ParseRange(r.Header.Get("Range"), file.size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		chunks := end/gatewayChunkSize - start/gatewayChunkSize + 1
+		amount := s.MarketEntryMap[fileKey].GetPrice() * chunks
+		if amount == 0 {
+			serveRange(w, file, start, end)
+			return
+		}
+		address, err := orcaBlockchain.GetNewAddress()
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			writeStatusUpdate(w, "Unable to create an invoice: "+err.Error())
+			return
+		}
+		data, err := orcaInvoice.Create(fileKey, start, end, amount, address, s.PrivKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		record, err := s.Invoices.Add(data, orcaInvoice.RoleSale)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeStatusUpdate(w, err.Error())
+			return
+		}
+		w.Header().Set(orcaInvoice.InvoiceHeader, base64.StdEncoding.EncodeToString(data))
+		w.Header().Set(orcaInvoice.URIHeader, record.URI)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPaymentRequired)
+		w.Write(data)
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encodedInvoice)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStatusUpdate(w, "Invoice is not base64 encoded.")
+		return
+	}
+	// An invoice only has to be paid before it expires, the range may be fetched during the grace period after
+	invoice, err := orcaInvoice.VerifySignature(data, s.PubKey)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStatusUpdate(w, "Invalid invoice: "+err.Error())
+		return
+	}
+	if time.Now().UTC().Add(-invoiceGracePeriod).Unix() > invoice.ExpiresAt {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStatusUpdate(w, "Invalid invoice: invoice has expired")
+		return
+	}
+	if invoice.FileKey != fileKey {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStatusUpdate(w, "Invoice is for another file.")
+		return
+	}
+	transaction, err := orcaBlockchain.GetTransaction(r.Header.Get(orcaInvoice.PaymentHeader))
+	if err != nil {
+		w.WriteHeader(http.StatusPaymentRequired)
+		writeStatusUpdate(w, "Unable to find payment: "+err.Error())
+		return
+	}
+	if transaction.ReceivedAmount(invoice.Address) < float64(invoice.Amount) {
+		w.WriteHeader(http.StatusPaymentRequired)
+		writeStatusUpdate(w, "Payment does not cover the invoice.")
+		return
+	}
+	if transaction.TimeReceived > invoice.ExpiresAt {
+		w.WriteHeader(http.StatusPaymentRequired)
+		writeStatusUpdate(w, "Invoice was paid after it expired.")
+		return
+	}
+	if transaction.Confirmations < s.confirmations {
+		w.WriteHeader(http.StatusPaymentRequired)
+		writeStatusUpdate(w, fmt.Sprintf("Payment has %d of the %d confirmations it needs.", transaction.Confirmations, s.confirmations))
+		return
+	}
+	if !s.reserveInvoice(invoice) {
+		w.WriteHeader(http.StatusConflict)
+		writeStatusUpdate(w, "Invoice has already been redeemed.")
+		return
+	}
+	err = serveRange(w, file, invoice.RangeStart, invoice.RangeEnd)
+	s.releaseInvoice(invoice, err == nil)
+	if err == nil {
+		s.markSaleSettled(invoice.Id, r.Header.Get(orcaInvoice.PaymentHeader))
+	}
+}
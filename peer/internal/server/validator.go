@@ -9,9 +9,39 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	record "github.com/libp2p/go-libp2p-record"
 	crypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
+// DHT namespace of name records, market listings keep their original orcanet/market/<file key> keys
+const (
+	NameNamespace   = "orcanet-name"
+	marketKeyPrefix = "orcanet/market/"
+)
+
+// Returns the validator of the market DHT
+func NewValidator() record.Validator {
+	return OrcaDHTValidator{}
+}
+
+// Validator of the market DHT, name records go to OrcaNameValidator and every other key to OrcaValidator
+type OrcaDHTValidator struct{}
+
+func (v OrcaDHTValidator) Select(key string, value [][]byte) (int, error) {
+	if strings.HasPrefix(key, nameRecordPrefix) {
+		return OrcaNameValidator{}.Select(key, value)
+	}
+	return OrcaValidator{}.Select(key, value)
+}
+
+func (v OrcaDHTValidator) Validate(key string, value []byte) error {
+	if strings.HasPrefix(key, nameRecordPrefix) {
+		return OrcaNameValidator{}.Validate(key, value)
+	}
+	return OrcaValidator{}.Validate(key, value)
+}
+
 type OrcaValidator struct{}
 
 /*
@@ -59,16 +89,26 @@ func (v OrcaValidator) Validate(key string, value []byte) error {
 	// verify key is a sha256 hash
 	hexPattern := "^[a-fA-F0-9]{64}$"
 	regex := regexp.MustCompile(hexPattern)
-	if !regex.MatchString(strings.Replace(key, "orcanet/market/", "", -1)) {
+	if !regex.MatchString(strings.TrimPrefix(key, marketKeyPrefix)) {
 		return errors.New("Provided key is not in the form of a SHA-256 digest!")
 	}
 
+	if len(value) < 8 {
+		return errors.New("Value is too short to hold a timestamp!")
+	}
+
 	pubKeySet := make(map[string]bool)
 
 	for i := 0; i < len(value)-8; i++ {
+		if i+4 > len(value)-8 {
+			return errors.New("Record header runs past the end of the value!")
+		}
 		messageLength := uint16(value[i+1])<<8 | uint16(value[i])
 		digitalSignatureLength := uint16(value[i+3])<<8 | uint16(value[i+2])
-		contentLength := messageLength + digitalSignatureLength
+		contentLength := int(messageLength) + int(digitalSignatureLength)
+		if i+4+contentLength > len(value)-8 {
+			return errors.New("Record runs past the end of the value!")
+		}
 		user := &pb.User{}
 
 		err := proto.Unmarshal(value[i+4:i+4+int(messageLength)], user)
@@ -89,7 +129,7 @@ func (v OrcaValidator) Validate(key string, value []byte) error {
 			return err
 		}
 
-		signatureBytes := value[i+4+int(messageLength) : i+4+int(contentLength)]
+		signatureBytes := value[i+4+int(messageLength) : i+4+contentLength]
 		valid, err := publicKey.Verify(userMessageBytes, signatureBytes) //this function will automatically compute hash of data to compare signauture
 
 		if err != nil {
@@ -100,7 +140,7 @@ func (v OrcaValidator) Validate(key string, value []byte) error {
 			return errors.New("Signature invalid!")
 		}
 
-		i = i + 4 + int(contentLength) - 1
+		i = i + 4 + contentLength - 1
 	}
 
 	currentTime := time.Now().UTC()
@@ -114,6 +154,98 @@ func (v OrcaValidator) Validate(key string, value []byte) error {
 	return nil
 }
 
+type OrcaNameValidator struct{}
+
+/*
+ * Given a list of name records from the DHT, select the index of the one with the highest sequence number.
+ * Records that do not parse are never selected.
+ *
+ * Parameters:
+ *   key: /orcanet-name/<peer ID>/<name>
+ *   value: A slice of byte slices that represent the values to be compared.
+ *
+ * Returns:
+ *   The index of the best value
+ *   An error, if any
+ */
+func (v OrcaNameValidator) Select(key string, value [][]byte) (int, error) {
+	bestIndex := -1
+	var bestRecord *pb.NameRecord
+	for i := range value {
+		record, err := ParseNameRecord(value[i])
+		if err != nil {
+			continue
+		}
+		if bestRecord == nil || record.GetSequence() > bestRecord.GetSequence() ||
+			(record.GetSequence() == bestRecord.GetSequence() && record.GetCreatedAt() > bestRecord.GetCreatedAt()) {
+			bestIndex = i
+			bestRecord = record
+		}
+	}
+	if bestIndex == -1 {
+		return 0, errors.New("No valid name record found!")
+	}
+	return bestIndex, nil
+}
+
+/*
+ * Validates name records that are being put into the DHT. The record must be signed by the key
+ * of the peer ID inside of the DHT key and must point at a SHA-256 file key.
+ *
+ * Parameters:
+ *   key: /orcanet-name/<peer ID>/<name>
+ *   value: A marshalled SignedNameRecord
+ *
+ * Returns:
+ *   An error, if any
+ */
+func (v OrcaNameValidator) Validate(key string, value []byte) error {
+	peerIdString, name, found := strings.Cut(strings.TrimPrefix(key, nameRecordPrefix), "/")
+	if !found || !nameRegex.MatchString(name) {
+		return errors.New("Provided key is not in the form of /orcanet-name/<peer ID>/<name>!")
+	}
+	peerId, err := peer.Decode(peerIdString)
+	if err != nil {
+		return err
+	}
+
+	signed := &pb.SignedNameRecord{}
+	err = proto.Unmarshal(value, signed)
+	if err != nil {
+		return err
+	}
+	record, err := ParseNameRecord(value)
+	if err != nil {
+		return err
+	}
+	if record.GetName() != name {
+		return errors.New("Name record does not match its key!")
+	}
+	if !regexp.MustCompile("^[a-f0-9]{64}$").MatchString(record.GetFileKey()) {
+		return errors.New("Name record does not point at a SHA-256 digest!")
+	}
+
+	publicKey, err := crypto.UnmarshalPublicKey(record.GetPublisherKey())
+	if err != nil {
+		return err
+	}
+	if !peerId.MatchesPublicKey(publicKey) {
+		return errors.New("Name record was not published by the owner of the key!")
+	}
+	valid, err := publicKey.Verify(signed.GetRecord(), signed.GetSignature())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("Signature invalid!")
+	}
+
+	if record.GetCreatedAt() > time.Now().UTC().Unix() {
+		return errors.New("Supplied time cannot be less than current time")
+	}
+	return nil
+}
+
 /*
  * Convert a max 8 byte slice to its 64 bit int value.
  *
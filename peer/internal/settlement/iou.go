@@ -0,0 +1,82 @@
+package settlement
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A consumer that settles in batches does not pay for each chunk as it arrives. It signs an IOU for
+// the chunk instead and sends it along with its next chunk request, then pays every holder it owes
+// in a single sendmany transaction once it owes enough or some time has passed. Holders cap how
+// many coins a consumer may owe them and stop serving it until it pays.
+type IOU struct {
+	Consumer   string `json:"consumer"` // Peer ID of the consumer that owes the coins
+	Provider   string `json:"provider"` // Peer ID of the holder that served the chunk
+	Address    string `json:"address"`  // Where the holder is paid
+	FileKey    string `json:"fileKey"`
+	JobId      string `json:"jobId,omitempty"`
+	ChunkIndex int    `json:"chunkIndex"`
+	Amount     int64  `json:"amount"` // Coins for this chunk
+	Total      int64  `json:"total"`  // Coins for every chunk served to the consumer at Address so far, paid or not
+	IssuedAt   int64  `json:"issuedAt"`
+}
+
+// The IOU is kept as the exact bytes that were signed, it still reads as plain JSON
+type SignedIOU struct {
+	IOU       json.RawMessage `json:"iou"`
+	Signature []byte          `json:"signature"`
+}
+
+// Sign an IOU with the consumer's key
+func Sign(iou IOU, privKey libp2pcrypto.PrivKey) ([]byte, error) {
+	consumer, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	iou.Consumer = consumer.String()
+	if iou.IssuedAt == 0 {
+		iou.IssuedAt = time.Now().UTC().Unix()
+	}
+	iouBytes, err := json.Marshal(iou)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := privKey.Sign(iouBytes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(SignedIOU{IOU: iouBytes, Signature: signature})
+}
+
+// Check that an IOU was signed by the consumer holding pubKey and names it as the consumer
+func Verify(data []byte, pubKey libp2pcrypto.PubKey) (*IOU, error) {
+	var signed SignedIOU
+	err := json.Unmarshal(data, &signed)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := pubKey.Verify(signed.IOU, signed.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errors.New("IOU signature invalid")
+	}
+	var iou IOU
+	err = json.Unmarshal(signed.IOU, &iou)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if iou.Consumer != consumer.String() {
+		return nil, errors.New("IOU is from another peer")
+	}
+	return &iou, nil
+}
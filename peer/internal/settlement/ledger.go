@@ -0,0 +1,285 @@
+package settlement
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// What this peer owes a holder at one of the holder's addresses
+type Debt struct {
+	Provider  string `json:"provider"`
+	Address   string `json:"address"`
+	Total     int64  `json:"total"`         // Coins for every chunk served at Address, as in the latest IOU
+	Paid      int64  `json:"paid"`          // Coins sent to Address so far
+	IOU       []byte `json:"iou,omitempty"` // The latest IOU signed for Address
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// Coins owed at the debt's address that were not sent yet
+func (debt Debt) Outstanding() int64 {
+	return debt.Total - debt.Paid
+}
+
+// One sendmany transaction that paid every holder this peer owed
+type Settlement struct {
+	TxId      string           `json:"txid"`
+	Amounts   map[string]int64 `json:"amounts"` // Coins sent to each address
+	SettledAt int64            `json:"settledAt"`
+}
+
+type ledgerFile struct {
+	Debts       map[string]*Debt `json:"debts"`
+	Settlements []Settlement     `json:"settlements"`
+}
+
+// The IOUs a consumer signed and the settlements that paid them, saved to a JSON file after every change
+type Ledger struct {
+	path      string
+	privKey   libp2pcrypto.PrivKey
+	threshold int64
+	interval  time.Duration
+	sendMany  func(amounts map[string]float64) (string, error)
+	state     ledgerFile
+	mut       sync.Mutex
+	settleMUT sync.Mutex // Only one settlement is sent at a time
+}
+
+/*
+ * Open the ledger saved at path, it is empty if the file does not exist yet.
+ *
+ * Parameters:
+ *   path: File the ledger is saved to
+ *   privKey: The consumer's key, IOUs are signed with it
+ *   threshold: Coins owed in total that trigger a settlement
+ *   interval: Time between settlements of whatever is owed
+ *   sendMany: Pays several addresses in one transaction and returns its id
+ *
+ * Returns:
+ *   The ledger
+ */
+func NewLedger(path string, privKey libp2pcrypto.PrivKey, threshold int64, interval time.Duration, sendMany func(amounts map[string]float64) (string, error)) *Ledger {
+	ledger := &Ledger{
+		path:      path,
+		privKey:   privKey,
+		threshold: threshold,
+		interval:  interval,
+		sendMany:  sendMany,
+		state:     ledgerFile{Debts: make(map[string]*Debt), Settlements: make([]Settlement, 0)},
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ledger
+	}
+	err = json.Unmarshal(data, &ledger.state)
+	if err != nil {
+		fmt.Println("Error reading settlement ledger:", err)
+	}
+	if ledger.state.Debts == nil {
+		ledger.state.Debts = make(map[string]*Debt)
+	}
+	return ledger
+}
+
+/*
+ * Owe a holder for a chunk it served and sign an IOU for it. Everything owed is settled right away
+ * if it reaches the ledger's threshold, or if the holder would not serve another chunk at this
+ * price before its address is paid.
+ *
+ * Parameters:
+ *   provider: The holder that served the chunk
+ *   address: Where the holder is paid
+ *   fileKey: Key of the file
+ *   jobId: Job the chunk was downloaded for, if any
+ *   chunkIndex: Index of the chunk
+ *   amount: Coins for the chunk
+ *   creditLimit: Coins the holder lets this peer owe it
+ *
+ * Returns:
+ *   An error if the IOU could not be signed or a settlement that was due failed
+ */
+func (ledger *Ledger) Accrue(provider peer.ID, address string, fileKey string, jobId string, chunkIndex int, amount int64, creditLimit int64) error {
+	if address == "" || amount <= 0 {
+		return errors.New("holder did not name an address and price for the chunk")
+	}
+	ledger.mut.Lock()
+	debt, ok := ledger.state.Debts[address]
+	if !ok {
+		debt = &Debt{Provider: provider.String(), Address: address}
+	}
+	if debt.Provider != provider.String() {
+		ledger.mut.Unlock()
+		return errors.New("address " + address + " belongs to another holder")
+	}
+	iou, err := Sign(IOU{
+		Provider:   provider.String(),
+		Address:    address,
+		FileKey:    fileKey,
+		JobId:      jobId,
+		ChunkIndex: chunkIndex,
+		Amount:     amount,
+		Total:      debt.Total + amount,
+	}, ledger.privKey)
+	if err != nil {
+		ledger.mut.Unlock()
+		return err
+	}
+	debt.Total += amount
+	debt.IOU = iou
+	debt.UpdatedAt = time.Now().UTC().Unix()
+	ledger.state.Debts[address] = debt
+	err = ledger.save()
+	if err != nil {
+		fmt.Println("Error saving settlement ledger:", err)
+	}
+	settle := ledger.owed() >= ledger.threshold || debt.Outstanding()+amount > creditLimit
+	ledger.mut.Unlock()
+
+	if settle {
+		_, err = ledger.Settle()
+	}
+	return err
+}
+
+// Returns the latest IOU signed for a holder, it is sent with chunk requests to acknowledge the chunks it served
+func (ledger *Ledger) LatestIOU(provider peer.ID) []byte {
+	if ledger == nil {
+		return nil
+	}
+	ledger.mut.Lock()
+	defer ledger.mut.Unlock()
+	var latest *Debt
+	for _, debt := range ledger.state.Debts {
+		if debt.Provider == provider.String() && (latest == nil || debt.UpdatedAt >= latest.UpdatedAt) {
+			latest = debt
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return latest.IOU
+}
+
+// Coins owed to every holder that were not sent yet
+func (ledger *Ledger) Owed() int64 {
+	ledger.mut.Lock()
+	defer ledger.mut.Unlock()
+	return ledger.owed()
+}
+
+func (ledger *Ledger) owed() int64 {
+	owed := int64(0)
+	for _, debt := range ledger.state.Debts {
+		owed += debt.Outstanding()
+	}
+	return owed
+}
+
+/*
+ * Pay every holder this peer owes in one sendmany transaction.
+ *
+ * Returns:
+ *   The settlement, nil if nothing was owed
+ *   An error, if any
+ */
+func (ledger *Ledger) Settle() (*Settlement, error) {
+	ledger.settleMUT.Lock()
+	defer ledger.settleMUT.Unlock()
+
+	ledger.mut.Lock()
+	amounts := make(map[string]int64)
+	coins := make(map[string]float64)
+	for address, debt := range ledger.state.Debts {
+		if outstanding := debt.Outstanding(); outstanding > 0 {
+			amounts[address] = outstanding
+			coins[address] = float64(outstanding)
+		}
+	}
+	ledger.mut.Unlock()
+	if len(amounts) == 0 {
+		return nil, nil
+	}
+
+	// IOUs signed while the transaction is sent stay outstanding until the next settlement
+	txId, err := ledger.sendMany(coins)
+	if err != nil {
+		return nil, fmt.Errorf("unable to settle with %d holders: %w", len(amounts), err)
+	}
+	settlement := Settlement{TxId: txId, Amounts: amounts, SettledAt: time.Now().UTC().Unix()}
+	ledger.mut.Lock()
+	defer ledger.mut.Unlock()
+	total := int64(0)
+	for address, amount := range amounts {
+		ledger.state.Debts[address].Paid += amount
+		total += amount
+	}
+	ledger.state.Settlements = append(ledger.state.Settlements, settlement)
+	err = ledger.save()
+	if err != nil {
+		fmt.Println("Error saving settlement ledger:", err)
+	}
+	fmt.Printf("Settled %d OrcaCoin with %d holders in transaction %s\n", total, len(amounts), txId)
+	return &settlement, nil
+}
+
+// Settle whatever is owed every interval until ctx is done
+func (ledger *Ledger) Run(ctx context.Context) {
+	ticker := time.NewTicker(ledger.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := ledger.Settle()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// Returns copies of the debts to every holder, most recently updated first
+func (ledger *Ledger) Debts() []Debt {
+	ledger.mut.Lock()
+	defer ledger.mut.Unlock()
+	debts := make([]Debt, 0, len(ledger.state.Debts))
+	for _, debt := range ledger.state.Debts {
+		debts = append(debts, *debt)
+	}
+	sort.Slice(debts, func(i, j int) bool {
+		if debts[i].UpdatedAt != debts[j].UpdatedAt {
+			return debts[i].UpdatedAt > debts[j].UpdatedAt
+		}
+		return debts[i].Address < debts[j].Address
+	})
+	return debts
+}
+
+// Returns the settlements sent so far, oldest first
+func (ledger *Ledger) Settlements() []Settlement {
+	ledger.mut.Lock()
+	defer ledger.mut.Unlock()
+	return append([]Settlement(nil), ledger.state.Settlements...)
+}
+
+func (ledger *Ledger) save() error {
+	data, err := json.Marshal(ledger.state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(ledger.path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ledger.path, data, 0644)
+}
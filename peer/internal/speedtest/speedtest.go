@@ -0,0 +1,273 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	ID          = protocol.ID("/orcanet/speedtest/1.0.0")
+	DefaultSize = 4 * 1024 * 1024  // Bytes sent each way by a test, one chunk
+	MaxSize     = 32 * 1024 * 1024 // Largest test a peer agrees to serve
+	pingCount   = 3
+
+	opDownload byte = 'd' // The peer sends us size bytes
+	opUpload   byte = 'u' // We send the peer size bytes, it acknowledges them with one byte
+)
+
+var zeros = make([]byte, 64*1024)
+
+// Result of testing against one peer
+type Result struct {
+	PeerID       string    `json:"peerId"`
+	Time         time.Time `json:"time"`
+	LatencyMs    float64   `json:"latencyMs"`
+	DownloadMbps float64   `json:"downloadMbps"`
+	UploadMbps   float64   `json:"uploadMbps"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Averages over the peers that were tested successfully
+type Summary struct {
+	Peers        int     `json:"peers"`
+	LatencyMs    float64 `json:"latencyMs"`
+	DownloadMbps float64 `json:"downloadMbps"`
+	UploadMbps   float64 `json:"uploadMbps"`
+}
+
+// Serve speed tests to other peers
+func Register(h host.Host) {
+	h.SetStreamHandler(ID, handleStream)
+}
+
+func handleStream(s network.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(time.Minute))
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(s, header); err != nil {
+		s.Reset()
+		return
+	}
+	size := int64(binary.LittleEndian.Uint64(header[1:]))
+	if size > MaxSize {
+		s.Reset()
+		return
+	}
+	switch header[0] {
+	case opDownload:
+		if err := writeZeros(s, size); err != nil {
+			s.Reset()
+		}
+	case opUpload:
+		if _, err := io.CopyN(io.Discard, s, size); err != nil {
+			s.Reset()
+			return
+		}
+		s.Write([]byte{1})
+	default:
+		s.Reset()
+	}
+}
+
+func writeZeros(w io.Writer, size int64) error {
+	for size > 0 {
+		n := int64(len(zeros))
+		if size < n {
+			n = size
+		}
+		if _, err := w.Write(zeros[:n]); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
+func mbps(size int64, elapsed time.Duration) float64 {
+	return float64(size) * 8 / elapsed.Seconds() / 1e6
+}
+
+// Time one transfer of size bytes in the direction of op
+func transfer(ctx context.Context, h host.Host, id peer.ID, op byte, size int64) (float64, error) {
+	s, err := h.NewStream(network.WithAllowLimitedConn(ctx, "speedtest"), id, ID)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	header := make([]byte, 9)
+	header[0] = op
+	binary.LittleEndian.PutUint64(header[1:], uint64(size))
+	start := time.Now()
+	if _, err := s.Write(header); err != nil {
+		return 0, err
+	}
+	if op == opDownload {
+		if _, err := io.CopyN(io.Discard, s, size); err != nil {
+			return 0, err
+		}
+	} else {
+		if err := writeZeros(s, size); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(s, make([]byte, 1)); err != nil {
+			return 0, err
+		}
+	}
+	return mbps(size, time.Since(start)), nil
+}
+
+/*
+ * Measure the round trip time, download and upload throughput between us and a peer running the
+ * speedtest protocol. Latency comes from libp2p pings, throughput from sending size bytes each way.
+ *
+ * Parameters:
+ *   ctx: The context, bounds the whole test
+ *   h: libp2p host
+ *   id: The peer to test against, we must know its addresses
+ *   size: Bytes to send each way, at most MaxSize
+ *
+ * Returns:
+ *   The result, Error is set if the test failed
+ */
+func Run(ctx context.Context, h host.Host, id peer.ID, size int64) Result {
+	result := Result{PeerID: id.String(), Time: time.Now()}
+	fail := func(err error) Result {
+		result.Error = err.Error()
+		return result
+	}
+	if size > MaxSize {
+		return fail(errors.New("test size is larger than peers serve"))
+	}
+	if err := h.Connect(ctx, peer.AddrInfo{ID: id}); err != nil {
+		return fail(err)
+	}
+
+	pingCtx, cancel := context.WithCancel(network.WithAllowLimitedConn(ctx, "speedtest"))
+	pings := ping.Ping(pingCtx, h, id)
+	var total time.Duration
+	for i := 0; i < pingCount; i++ {
+		res := <-pings
+		if res.Error != nil {
+			cancel()
+			return fail(res.Error)
+		}
+		total += res.RTT
+	}
+	cancel()
+	result.LatencyMs = float64(total.Microseconds()) / 1000 / pingCount
+
+	var err error
+	if result.DownloadMbps, err = transfer(ctx, h, id, opDownload, size); err != nil {
+		return fail(err)
+	}
+	if result.UploadMbps, err = transfer(ctx, h, id, opUpload, size); err != nil {
+		return fail(err)
+	}
+	return result
+}
+
+// Average the successful results
+func Summarize(results []Result) Summary {
+	summary := Summary{}
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		summary.Peers++
+		summary.LatencyMs += result.LatencyMs
+		summary.DownloadMbps += result.DownloadMbps
+		summary.UploadMbps += result.UploadMbps
+	}
+	if summary.Peers > 0 {
+		summary.LatencyMs /= float64(summary.Peers)
+		summary.DownloadMbps /= float64(summary.Peers)
+		summary.UploadMbps /= float64(summary.Peers)
+	}
+	return summary
+}
+
+// Runs speed tests against a configured set of peers and remembers the latest results
+type Tester struct {
+	host   host.Host
+	peers  []string
+	size   int64
+	latest []Result
+	mut    sync.Mutex
+}
+
+/*
+ * Create a tester.
+ *
+ * Parameters:
+ *   h: libp2p host
+ *   peers: Peers to test against, as /p2p multiaddrs or peer IDs of peers we know the addresses
+ *          of. When empty, up to 3 of the peers we are connected to are used.
+ *   size: Bytes to send each way
+ *
+ * Returns:
+ *   The tester
+ */
+func NewTester(h host.Host, peers []string, size int64) *Tester {
+	return &Tester{host: h, peers: peers, size: size}
+}
+
+func (tester *Tester) targets() []peer.ID {
+	targets := make([]peer.ID, 0)
+	for _, p := range tester.peers {
+		if info, err := peer.AddrInfoFromString(p); err == nil {
+			tester.host.Peerstore().AddAddrs(info.ID, info.Addrs, time.Hour)
+			targets = append(targets, info.ID)
+		} else if id, err := peer.Decode(p); err == nil {
+			targets = append(targets, id)
+		}
+	}
+	if len(tester.peers) > 0 {
+		return targets
+	}
+	for _, id := range tester.host.Network().Peers() {
+		if len(targets) == 3 {
+			break
+		}
+		targets = append(targets, id)
+	}
+	return targets
+}
+
+// Test against every target one after another, so the tests do not compete for bandwidth
+func (tester *Tester) Run(ctx context.Context) ([]Result, error) {
+	targets := tester.targets()
+	if len(targets) == 0 {
+		return nil, errors.New("no peers to test against")
+	}
+	results := make([]Result, 0, len(targets))
+	for _, id := range targets {
+		testCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		results = append(results, Run(testCtx, tester.host, id, tester.size))
+		cancel()
+	}
+	tester.mut.Lock()
+	tester.latest = results
+	tester.mut.Unlock()
+	return results, nil
+}
+
+// The results of the latest run, nil if there was none
+func (tester *Tester) Latest() []Result {
+	tester.mut.Lock()
+	defer tester.mut.Unlock()
+	return tester.latest
+}
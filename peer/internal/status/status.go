@@ -6,18 +6,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"orca-peer/internal/config"
 	"os"
-
-	"github.com/cbergoon/speedtest-go"
 )
 
-type NetworkStatus struct {
-	Success           bool
-	DownloadSpeedMbps float64
-	UploadSpeedMbps   float64
-	LatencyMs         float64
-}
-
 type PeerNodeFileData struct {
 	IsMe          bool    `json:"is_me"`
 	Balance       float64 `json:"balance"`
@@ -38,8 +30,8 @@ type PeerNodes struct {
 	Nodes []PeerNodeJSON `json:"peers"`
 }
 
-func GetNodeInfo() PeerNode {
-	jsonFile, err := os.Open("config/self.json")
+func GetNodeInfo(dataDir string) PeerNode {
+	jsonFile, err := os.Open(config.Path(dataDir, "config", "self.json"))
 	if err != nil {
 		fmt.Println("Error on loading config, please try again")
 	}
@@ -63,8 +55,8 @@ func GetNodeInfo() PeerNode {
 	return me
 }
 
-func GetPeerNodeInfo() PeerNodes {
-	jsonFile, err := os.Open("config/peers.json")
+func GetPeerNodeInfo(dataDir string) PeerNodes {
+	jsonFile, err := os.Open(config.Path(dataDir, "config", "peers.json"))
 	if err != nil {
 		fmt.Println("Error on loading config, please try again")
 	}
@@ -75,20 +67,7 @@ func GetPeerNodeInfo() PeerNodes {
 	return nodes
 }
 
-func GetNetworkInfo() NetworkStatus {
-	user, _ := speedtest.FetchUserInfo()
-
-	serverList, _ := speedtest.FetchServerList(user)
-	targets, _ := serverList.FindServer([]int{})
-
-	for _, s := range targets {
-		s.PingTest()
-		s.DownloadTest()
-		s.UploadTest()
-		return NetworkStatus{Success: true, LatencyMs: float64(s.Latency), DownloadSpeedMbps: s.DLSpeed, UploadSpeedMbps: s.ULSpeed}
-	}
-	return NetworkStatus{Success: false}
-}
+// Look up our public IP and its location, empty if the lookup services cannot be reached
 func GetLocationData() string {
 	ipapiClient := http.Client{}
 
@@ -98,7 +77,8 @@ func GetLocationData() string {
 	}
 	resp, err := ipapiClient.Do(ipv4Req)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Println("Unable to look up public IP:", err)
+		return ""
 	}
 
 	defer resp.Body.Close()
@@ -111,12 +91,13 @@ func GetLocationData() string {
 	ipv4Body := string(body)
 	var ipv4JSON map[string]interface{}
 	err = json.Unmarshal([]byte(ipv4Body), &ipv4JSON)
-	if err != nil {
-		log.Fatal("Unable to establish user IP, please try again")
+	origin, ok := ipv4JSON["origin"].(string)
+	if err != nil || !ok {
+		fmt.Println("Unable to establish user IP, please try again")
 		return ""
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://ipapi.co/%s/json/", ipv4JSON["origin"].(string)), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://ipapi.co/%s/json/", origin), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -124,7 +105,8 @@ func GetLocationData() string {
 
 	resp, err = ipapiClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Println("Unable to look up location:", err)
+		return ""
 	}
 
 	defer resp.Body.Close()
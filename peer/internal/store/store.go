@@ -2,6 +2,7 @@ package store
 
 import (
 	"log"
+	"orca-peer/internal/config"
 	"os"
 	"time"
 )
@@ -13,15 +14,15 @@ type FileInfo struct {
 	Size    int64
 }
 
-//Searches for files in stored. 
-func GetAllLocalFiles() []FileInfo {
-	files, err := os.ReadDir("files/stored")
+//Searches for files in stored inside of dataDir. 
+func GetAllLocalFiles(dataDir string) []FileInfo {
+	files, err := os.ReadDir(config.Path(dataDir, "files", "stored"))
 	if err != nil {
 		log.Fatal(err)
 	}
 	fileNames := make([]FileInfo, 0)
 	for _, file := range files {
-		fileInfo, err := os.Stat("files/stored/" + file.Name())
+		fileInfo, err := os.Stat(config.Path(dataDir, "files", "stored", file.Name()))
 		if err == nil{
 			if len(file.Name()) >= 64 {
 				fileNames = append(fileNames, 
@@ -0,0 +1,155 @@
+package subscription
+
+import (
+	"errors"
+	"orca-peer/internal/config"
+	"orca-peer/internal/fileshare"
+	orcaToken "orca-peer/internal/token"
+	"regexp"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+const TokenExtension = ".token"
+
+// Tokens are saved in files/subscriptions inside of the data directory
+func TokenDirectory(dataDir string) string {
+	return config.Path(dataDir, "files", "subscriptions")
+}
+
+// Sent by a consumer over orcanet-feed/1.0/<feed>. Days is 0 to only ask for the feed's info,
+// otherwise the consumer asks for a subscription. Without an address it is quoted a price and a
+// wallet address created for the subscription, once it paid there it asks again with that address
+// and gets a token.
+type FeedRequest struct {
+	Days    int64  `json:"days"`
+	Address string `json:"address,omitempty"`
+}
+
+type FeedResponse struct {
+	Feed          string   `json:"feed"`
+	PricePerDay   int64    `json:"pricePerDay"`
+	WalletAddress string   `json:"walletAddress,omitempty"` // Where to pay for a quoted subscription
+	Price         int64    `json:"price,omitempty"`         // Coins to pay at WalletAddress
+	FileKeys      []string `json:"fileKeys"`
+	Token         []byte   `json:"token,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+/*
+ * Create a subscription token for a subscriber, signed by the publisher.
+ *
+ * Parameters:
+ *   feed: Name of the publisher's feed
+ *   subscriberKey: Public key of the subscriber's peer
+ *   duration: How long the subscription lasts, starting now
+ *   privKey: Private key of the publisher
+ *
+ * Returns:
+ *   A marshalled SignedSubscriptionToken
+ *   An error, if any
+ */
+func CreateToken(feed string, subscriberKey libp2pcrypto.PubKey, duration time.Duration, privKey libp2pcrypto.PrivKey) ([]byte, error) {
+	publisherKeyBytes, err := libp2pcrypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	subscriberKeyBytes, err := libp2pcrypto.MarshalPublicKey(subscriberKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	tokenBytes, signature, err := orcaToken.Sign(&fileshare.SubscriptionToken{
+		Feed:          feed,
+		PublisherKey:  publisherKeyBytes,
+		SubscriberKey: subscriberKeyBytes,
+		NotBefore:     now.Unix(),
+		NotAfter:      now.Add(duration).Unix(),
+	}, privKey)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&fileshare.SignedSubscriptionToken{Token: tokenBytes, Signature: signature})
+}
+
+// Unmarshal the token inside of a marshalled SignedSubscriptionToken, without checking its signature
+func ParseToken(data []byte) (*fileshare.SubscriptionToken, error) {
+	token := &fileshare.SubscriptionToken{}
+	err := orcaToken.Parse(data, &fileshare.SignedSubscriptionToken{}, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+/*
+ * Check that a token was signed by a publisher for a subscriber and feed, and has not expired.
+ *
+ * Parameters:
+ *   data: A marshalled SignedSubscriptionToken
+ *   publisherKey: Public key the token must be signed by
+ *   subscriberKey: Public key of the peer presenting the token
+ *   feed: Feed the token must grant access to
+ *
+ * Returns:
+ *   The token
+ *   An error, if any
+ */
+func VerifyToken(data []byte, publisherKey libp2pcrypto.PubKey, subscriberKey libp2pcrypto.PubKey, feed string) (*fileshare.SubscriptionToken, error) {
+	token := &fileshare.SubscriptionToken{}
+	err := orcaToken.Verify(data, &fileshare.SignedSubscriptionToken{}, token, publisherKey)
+	if err != nil {
+		return nil, err
+	}
+	if token.GetFeed() != feed {
+		return nil, errors.New("token is for another feed")
+	}
+	err = orcaToken.CheckHolder(token.GetSubscriberKey(), subscriberKey)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Returns the peer ID of the publisher that issued a token
+func Publisher(token *fileshare.SubscriptionToken) (peer.ID, error) {
+	return orcaToken.Publisher(token)
+}
+
+// Feed names are letters, digits, '.', '_' and '-', as publishers require
+var feedRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Save a token received from a publisher to the token directory of dataDir, replacing any older token for the same feed.
+// The token must be signed by the publisher it names.
+func SaveToken(dataDir string, data []byte) error {
+	token, err := ParseToken(data)
+	if err != nil {
+		return err
+	}
+	publisherKey, err := orcaToken.PublisherKey(token)
+	if err != nil {
+		return err
+	}
+	err = orcaToken.Verify(data, &fileshare.SignedSubscriptionToken{}, token, publisherKey)
+	if err != nil {
+		return err
+	}
+	if !feedRegex.MatchString(token.GetFeed()) {
+		return errors.New("token is for an invalid feed name")
+	}
+	publisher, err := peer.IDFromPublicKey(publisherKey)
+	if err != nil {
+		return err
+	}
+	return orcaToken.Save(TokenDirectory(dataDir), publisher, token.GetFeed(), TokenExtension, data)
+}
+
+// Returns every token saved in dataDir that was issued by a publisher and has not expired yet
+func LoadTokens(dataDir string, publisher peer.ID) [][]byte {
+	return orcaToken.Load(TokenDirectory(dataDir), publisher, TokenExtension, func(data []byte) (orcaToken.Claims, error) {
+		return ParseToken(data)
+	})
+}
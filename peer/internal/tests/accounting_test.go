@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	orcaAccounting "orca-peer/internal/accounting"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountingLedger(t *testing.T) {
+	dir := t.TempDir()
+	feeLookups := 0
+	ledger, err := orcaAccounting.Open(filepath.Join(dir, "ledger.db"), func(txId string) (float64, error) {
+		feeLookups++
+		return -0.5, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	entries := []orcaAccounting.Entry{
+		{Kind: orcaAccounting.KindPurchase, Amount: -3, Time: day, TxId: "tx1", JobId: "job1", FileKey: "file1", Peer: "holder"},
+		{Kind: orcaAccounting.KindPurchase, Amount: -2, Time: day.Add(time.Minute), TxId: "tx1", JobId: "job1", FileKey: "file1", Peer: "holder"},
+		{Kind: orcaAccounting.KindSale, Amount: 10, Time: day.AddDate(0, 0, 1), FileKey: "file2", Peer: "consumer"},
+		{Kind: orcaAccounting.KindRefund, Amount: 1, Time: day.AddDate(0, 1, 0), JobId: "job1"},
+	}
+	for _, entry := range entries {
+		if _, err := ledger.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ledger.Record(orcaAccounting.Entry{Kind: orcaAccounting.KindSale, Amount: -1}); err == nil {
+		t.Error("Recorded a sale that spent coins")
+	}
+
+	// Both purchases were paid in tx1, its fee is recorded once
+	if feeLookups != 1 {
+		t.Errorf("Looked up the fee of tx1 %d times", feeLookups)
+	}
+	fees, err := ledger.Query(orcaAccounting.Filter{Kind: orcaAccounting.KindFee})
+	if err != nil || len(fees) != 1 || fees[0].Amount != -0.5 || fees[0].JobId != "job1" {
+		t.Fatalf("Unexpected fees %+v: %v", fees, err)
+	}
+
+	job, err := ledger.Query(orcaAccounting.Filter{JobId: "job1"})
+	if err != nil || len(job) != 4 {
+		t.Fatalf("Expected 4 entries for job1, got %+v: %v", job, err)
+	}
+	for i := 1; i < len(job); i++ {
+		if job[i].Time.Before(job[i-1].Time) {
+			t.Error("Entries are not ordered by time")
+		}
+	}
+	sales, err := ledger.Query(orcaAccounting.Filter{From: day.Add(time.Hour), To: day.AddDate(0, 0, 2)})
+	if err != nil || len(sales) != 1 || sales[0].Kind != orcaAccounting.KindSale {
+		t.Fatalf("Expected the sale only, got %+v: %v", sales, err)
+	}
+
+	daily, err := ledger.Revenue("day", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 3 || daily[0].Date != "2024-03-10" || daily[0].Spending != 5.5 || daily[0].Net != -5.5 || daily[1].Earning != 10 {
+		t.Errorf("Unexpected daily revenue %+v", daily)
+	}
+	monthly, err := ledger.Revenue("month", time.Time{}, time.Time{})
+	if err != nil || len(monthly) != 2 || monthly[0].Date != "2024-03" || monthly[0].Net != 4.5 || monthly[0].Entries != 4 {
+		t.Errorf("Unexpected monthly revenue %+v: %v", monthly, err)
+	}
+	if _, err := ledger.Revenue("week", time.Time{}, time.Time{}); err == nil {
+		t.Error("Grouped revenue by week")
+	}
+
+	var out bytes.Buffer
+	if err := orcaAccounting.WriteCSV(&out, job); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil || len(rows) != 5 || rows[0][0] != "id" || rows[1][3] != "-3" {
+		t.Errorf("Unexpected CSV %v: %v", rows, err)
+	}
+}
+
+func TestAccountingImportLegacy(t *testing.T) {
+	dir := t.TempDir()
+	ledger, err := orcaAccounting.Open(filepath.Join(dir, "ledger.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+
+	legacyDir := filepath.Join(dir, "transactions")
+	os.MkdirAll(legacyDir, 0755)
+	bought := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(time.RFC3339Nano)
+	sold := time.Date(2024, 1, 3, 3, 4, 5, 0, time.UTC).Format(time.RFC3339Nano)
+	os.WriteFile(filepath.Join(legacyDir, bought), []byte(`{"public_key":"mine","date":"`+bought+`","cost":4}`), 0644)
+	os.WriteFile(filepath.Join(legacyDir, "transactions.json"), []byte(`[{"transaction":{"public_key":"theirs","date":"`+sold+`","cost":6},"date":"`+sold+`"}]`), 0644)
+	os.WriteFile(filepath.Join(legacyDir, "broken"), []byte(`{`), 0644)
+
+	imported, err := ledger.ImportLegacy(legacyDir, "mine")
+	if err != nil || imported != 2 {
+		t.Fatalf("Imported %d transactions: %v", imported, err)
+	}
+	entries, err := ledger.Query(orcaAccounting.Filter{})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v: %v", entries, err)
+	}
+	if entries[0].Kind != orcaAccounting.KindPurchase || entries[0].Amount != -4 || entries[1].Kind != orcaAccounting.KindSale || entries[1].Peer != "theirs" {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+	left, _ := os.ReadDir(legacyDir)
+	if len(left) != 1 || left[0].Name() != "broken" {
+		t.Errorf("Expected only the unreadable file to be left, got %v", left)
+	}
+}
+
+func TestWalletStatsRoutes(t *testing.T) {
+	ledger, err := orcaAccounting.Open(filepath.Join(t.TempDir(), "ledger.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	mux := http.NewServeMux()
+	orcaBlockchain.RegisterStatsRoutes(mux, ledger)
+
+	request := func(method string, target string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return recorder
+	}
+
+	recorder := request(http.MethodPost, "/wallet/ledger", `{"kind":"sale","amount":7,"fileKey":"file1"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Recording an entry failed with %d: %s", recorder.Code, recorder.Body)
+	}
+	if recorder := request(http.MethodPost, "/wallet/ledger", `{"kind":"purchase","amount":7}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Recorded a purchase that received coins, status %d", recorder.Code)
+	}
+
+	var revenue []orcaAccounting.Revenue
+	recorder = request(http.MethodGet, "/wallet/revenue/daily", "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &revenue); err != nil || len(revenue) != 1 || revenue[0].Earning != 7 {
+		t.Errorf("Unexpected daily revenue %s: %v", recorder.Body, err)
+	}
+	recorder = request(http.MethodGet, "/wallet/revenue/yearly?format=csv", "")
+	if !strings.HasPrefix(recorder.Body.String(), "date,earning,spending,net,entries\n") || recorder.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("Unexpected yearly revenue CSV %q", recorder.Body)
+	}
+	if recorder := request(http.MethodGet, "/wallet/revenue/monthly?from=yesterday", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Accepted an invalid from, status %d", recorder.Code)
+	}
+
+	var entries []orcaAccounting.Entry
+	recorder = request(http.MethodGet, "/wallet/ledger?fileKey=file1", "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil || len(entries) != 1 || entries[0].Amount != 7 {
+		t.Errorf("Unexpected ledger %s: %v", recorder.Body, err)
+	}
+	var latest struct {
+		Transactions []struct {
+			Amount string `json:"amount"`
+			Reason string `json:"reason"`
+		} `json:"transactions"`
+	}
+	recorder = request(http.MethodGet, "/wallet/transactions/latest", "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &latest); err != nil || len(latest.Transactions) != 1 || latest.Transactions[0].Reason != "sale" {
+		t.Errorf("Unexpected latest transactions %s: %v", recorder.Body, err)
+	}
+}
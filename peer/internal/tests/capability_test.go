@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	orcaCapability "orca-peer/internal/capability"
+	"orca-peer/internal/config"
+	"orca-peer/internal/fileshare"
+	orcaServer "orca-peer/internal/server"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCapabilityToken(t *testing.T) {
+	publisherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	consumerKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	otherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	fileKey := strings.Repeat("ab", 32)
+
+	token, id, err := orcaCapability.CreateToken(fileKey, consumerKey.GetPublic(), time.Hour, publisherKey)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if _, err := orcaCapability.VerifyToken(token, publisherKey.GetPublic(), consumerKey.GetPublic(), fileKey, nil); err != nil {
+		t.Errorf("Expected token to verify, got %s", err)
+	}
+	if _, err := orcaCapability.VerifyToken(token, publisherKey.GetPublic(), otherKey.GetPublic(), fileKey, nil); err == nil {
+		t.Errorf("Expected an error: token presented by another peer")
+	}
+	if _, err := orcaCapability.VerifyToken(token, publisherKey.GetPublic(), consumerKey.GetPublic(), strings.Repeat("cd", 32), nil); err == nil {
+		t.Errorf("Expected an error: token is for another file")
+	}
+	if _, err := orcaCapability.VerifyToken(token, publisherKey.GetPublic(), consumerKey.GetPublic(), fileKey, map[string]bool{id: true}); err == nil {
+		t.Errorf("Expected an error: token was revoked")
+	}
+}
+
+func TestSaveCapabilityToken(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	publisherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	consumerKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	publisherKeyBytes, _ := libp2pcrypto.MarshalPublicKey(publisherKey.GetPublic())
+	fileKey := strings.Repeat("ab", 32)
+
+	token, _, err := orcaCapability.CreateToken(fileKey, consumerKey.GetPublic(), time.Hour, publisherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orcaCapability.SaveToken(".", token); err != nil {
+		t.Errorf("Expected token to be saved, got %s", err)
+	}
+
+	// A token naming a path as its id, signed by the publisher it names
+	tokenBytes, _ := proto.Marshal(&fileshare.CapabilityToken{
+		Id:           "../../escaped",
+		FileKey:      fileKey,
+		PublisherKey: publisherKeyBytes,
+		NotBefore:    time.Now().Unix(),
+		NotAfter:     time.Now().Add(time.Hour).Unix(),
+	})
+	signature, _ := publisherKey.Sign(tokenBytes)
+	crafted, _ := proto.Marshal(&fileshare.SignedCapabilityToken{Token: tokenBytes, Signature: signature})
+	if err := orcaCapability.SaveToken(".", crafted); err == nil {
+		t.Errorf("Expected an error: token id is not hex")
+	}
+	// The same token with a signature from another key
+	otherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	signature, _ = otherKey.Sign(tokenBytes)
+	forged, _ := proto.Marshal(&fileshare.SignedCapabilityToken{Token: tokenBytes, Signature: signature})
+	if err := orcaCapability.SaveToken(".", forged); err == nil {
+		t.Errorf("Expected an error: token is not signed by its publisher")
+	}
+	if _, err := os.Stat("escaped.cap"); err == nil {
+		t.Errorf("Token was saved outside of the token directory")
+	}
+}
+
+func TestRestrictedFilePersists(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	privKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	fileKey := strings.Repeat("ab", 32)
+
+	s := orcaServer.NewFileShareServerNode(config.Defaults(), privKey)
+	s.StoredFileInfoMap[fileKey] = &fileshare.FileInfo{FileName: "notes.txt"}
+	if err := s.SetupRestrictFile(fileKey); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := orcaServer.NewFileShareServerNode(config.Defaults(), privKey)
+	restarted.StoredFileInfoMap[fileKey] = &fileshare.FileInfo{FileName: "notes.txt"}
+	recorder := httptest.NewRecorder()
+	restarted.TransferHandler(recorder, httptest.NewRequest(http.MethodGet, "/transfer/"+fileKey, nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected the file to stay restricted after a restart, got status %d", recorder.Code)
+	}
+
+	// The gateway refuses restricted files to local clients, and any file to remote clients
+	request := httptest.NewRequest(http.MethodGet, "/orca/"+fileKey, nil)
+	request.RemoteAddr = "127.0.0.1:50000"
+	recorder = httptest.NewRecorder()
+	restarted.GatewayHandler(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected the gateway to refuse a restricted file, got status %d", recorder.Code)
+	}
+	otherKey := strings.Repeat("cd", 32)
+	restarted.StoredFileInfoMap[otherKey] = &fileshare.FileInfo{FileName: "other.txt"}
+	recorder = httptest.NewRecorder()
+	restarted.GatewayHandler(recorder, httptest.NewRequest(http.MethodGet, "/orca/"+otherKey, nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected the gateway to refuse a remote client, got status %d", recorder.Code)
+	}
+}
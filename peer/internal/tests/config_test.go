@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"orca-peer/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Create a data directory holding settingsJSON and a bootstrap list, returns the data directory
+// and flags pointing the bootstrap list at it, since the default one is relative to the repo root
+func newDataDir(t *testing.T, settingsJSON string) (string, []string) {
+	dir := t.TempDir()
+	bootstrap := filepath.Join(dir, "bootstrap.peers")
+	if err := os.WriteFile(bootstrap, []byte("# no peers\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if settingsJSON != "" {
+		if err := os.MkdirAll(filepath.Join(dir, "config"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config", "settings.json"), []byte(settingsJSON), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, []string{"--data-dir", dir, "--bootstrap-peers", bootstrap}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	dir, args := newDataDir(t, "")
+	settings, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MarketRPCPort != 8080 || settings.MarketDHTPort != 8081 || settings.HTTPAPIPort != 8082 {
+		t.Errorf("Expected the default ports, got %d %d %d", settings.MarketRPCPort, settings.MarketDHTPort, settings.HTTPAPIPort)
+	}
+	if settings.DHTMode != "auto" || settings.MarketProtocol != "orcanet/market" {
+		t.Errorf("Expected the default DHT mode and market, got %s %s", settings.DHTMode, settings.MarketProtocol)
+	}
+	if config.Path(settings.DataDir, "files", "stored") != filepath.Join(dir, "files", "stored") {
+		t.Errorf("Expected paths inside of the data directory, got %s", config.Path(settings.DataDir, "files", "stored"))
+	}
+	if !filepath.IsAbs(settings.GeoIPDatabase) || !filepath.IsAbs(settings.CoinServerDir) {
+		t.Errorf("Expected absolute paths, got %s and %s", settings.GeoIPDatabase, settings.CoinServerDir)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	// The file quotes its numbers, as settings.json always has
+	_, args := newDataDir(t, `{"RPC_PORT": "9080", "DHT_PORT": 9081, "API_PORT": "9082", "DHT_MODE": "client"}`)
+	t.Setenv("ORCA_DHT_PORT", "9091")
+	t.Setenv("ORCA_API_PORT", "9092")
+	settings, err := config.Load(append(args, "get", "somefile", "--api-port", "9102"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MarketRPCPort != 9080 {
+		t.Errorf("Expected the port from the settings file, got %d", settings.MarketRPCPort)
+	}
+	if settings.MarketDHTPort != 9091 {
+		t.Errorf("Expected the environment to override the settings file, got %d", settings.MarketDHTPort)
+	}
+	if settings.HTTPAPIPort != 9102 {
+		t.Errorf("Expected the flag to override the environment, got %d", settings.HTTPAPIPort)
+	}
+	if settings.DHTMode != "client" {
+		t.Errorf("Expected the DHT mode from the settings file, got %s", settings.DHTMode)
+	}
+}
+
+func TestConfigDataDirFromEnvironment(t *testing.T) {
+	dir, args := newDataDir(t, `{"RPC_PORT": "7080"}`)
+	t.Setenv("ORCA_DATA_DIR", dir)
+	settings, err := config.Load(args[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MarketRPCPort != 7080 {
+		t.Errorf("Expected the settings file of the data directory to be read, got port %d", settings.MarketRPCPort)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		settings string
+		args     []string
+		err      string
+	}{
+		{`{"RPC_PORT": "70000"}`, nil, "RPC_PORT"},
+		{`{"RPC_PORT": "8081"}`, nil, "DHT_PORT"},
+		{`{"RPC_PORT": "eighty"}`, nil, "whole number"},
+		{`{"GATEWAY_BUDGET": -1}`, nil, "GATEWAY_BUDGET"},
+		{`{"DHT_MODE": "sometimes"}`, nil, "DHT_MODE"},
+		{"", []string{"--swarm-key", "missing.key"}, "SWARM_KEY"},
+		{"", []string{"--api-port", "x"}, "API_PORT"},
+	}
+	for _, test := range tests {
+		_, args := newDataDir(t, test.settings)
+		_, err := config.Load(append(args, test.args...))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Expected an error about %s for %s %v, got %v", test.err, test.settings, test.args, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"context"
+	orcaServer "orca-peer/internal/server"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+func TestParseDHTMode(t *testing.T) {
+	cases := map[string]dht.ModeOpt{"": dht.ModeAuto, "auto": dht.ModeAuto, "Server": dht.ModeServer, "client": dht.ModeClient}
+	for setting, expected := range cases {
+		mode, err := orcaServer.ParseDHTMode(setting)
+		if err != nil || mode != expected {
+			t.Errorf("ParseDHTMode(%q) = %v, %v, expected %v", setting, mode, err, expected)
+		}
+	}
+	if _, err := orcaServer.ParseDHTMode("relay"); err == nil {
+		t.Errorf("Expected an error for an unknown mode")
+	}
+}
+
+func TestDHTModeFollowsReachability(t *testing.T) {
+	cases := []struct {
+		reachability libp2p.Option
+		expected     bool
+	}{
+		{libp2p.ForceReachabilityPublic(), true},
+		{libp2p.ForceReachabilityPrivate(), false},
+	}
+	for _, c := range cases {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), c.reachability)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kDHT, err := dht.New(context.Background(), h, dht.Mode(dht.ModeAuto), dht.ProtocolPrefix("orcanet/market"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for orcaServer.IsDHTServer(h, "orcanet/market") != c.expected && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if orcaServer.IsDHTServer(h, "orcanet/market") != c.expected {
+			t.Errorf("Expected DHT server mode to be %t", c.expected)
+		}
+		kDHT.Close()
+		h.Close()
+	}
+}
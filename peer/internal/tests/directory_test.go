@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"orca-peer/internal/fileshare"
+	orcaHash "orca-peer/internal/hash"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectoryManifest(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	os.MkdirAll("files/stored", 0755)
+	os.MkdirAll("dataset/images", 0755)
+	os.WriteFile("dataset/labels.txt", []byte("cat\ndog\n"), 0644)
+	os.WriteFile("dataset/images/cat.raw", []byte("meow"), 0644)
+
+	dirKey, manifest, err := orcaHash.SaveChunkedDirectory(".", "dataset", "dataset")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(manifest.GetEntries()) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(manifest.GetEntries()))
+	}
+	if manifest.GetEntries()[0].GetRelativePath() != "images/cat.raw" {
+		t.Errorf("Expected entries in lexical order, got %s first", manifest.GetEntries()[0].GetRelativePath())
+	}
+	for _, entry := range manifest.GetEntries() {
+		chunk, err := os.ReadFile(filepath.Join("files/stored", entry.GetFileInfo().GetChunkHashes()[0]))
+		if err != nil {
+			t.Fatalf("Expected chunk to be stored, got %s", err)
+		}
+		if int64(len(chunk)) != entry.GetFileInfo().GetFileSize() {
+			t.Errorf("Expected chunk of %d bytes, got %d", entry.GetFileInfo().GetFileSize(), len(chunk))
+		}
+	}
+	if err := orcaHash.VerifyDirectoryManifest(dirKey, manifest); err != nil {
+		t.Errorf("Expected manifest to verify, got %s", err)
+	}
+
+	// Fields must not run together, "a" + "bc" is not "ab" + "c"
+	shifted := &fileshare.DirectoryManifest{Name: "a", Entries: []*fileshare.DirectoryEntry{{RelativePath: "bc", FileKey: "key"}}}
+	other := &fileshare.DirectoryManifest{Name: "ab", Entries: []*fileshare.DirectoryEntry{{RelativePath: "c", FileKey: "key"}}}
+	if orcaHash.GetDirectoryKey(shifted) == orcaHash.GetDirectoryKey(other) {
+		t.Errorf("Expected different keys for different manifests")
+	}
+
+	manifest.GetEntries()[1].RelativePath = "../labels.txt"
+	if err := orcaHash.VerifyDirectoryManifest(orcaHash.GetDirectoryKey(manifest), manifest); err == nil {
+		t.Errorf("Expected an error: entry escapes the directory")
+	}
+}
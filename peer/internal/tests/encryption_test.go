@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	orcaEncryption "orca-peer/internal/encryption"
+	"testing"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	contentKey, _ := orcaEncryption.NewContentKey()
+	for _, size := range []int{0, 100, 64 * 1024, 200 * 1024} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		var ciphertext, decrypted bytes.Buffer
+		if err := orcaEncryption.Encrypt(&ciphertext, bytes.NewReader(plaintext), contentKey); err != nil {
+			t.Fatalf("Expected no error encrypting %d bytes, got %s", size, err)
+		}
+		sealed := ciphertext.Bytes()
+		if err := orcaEncryption.Decrypt(&decrypted, bytes.NewReader(sealed), contentKey); err != nil {
+			t.Fatalf("Expected no error decrypting %d bytes, got %s", size, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("Decrypted %d bytes do not match the plaintext", size)
+		}
+		if size > 64*1024 {
+			truncated := sealed[:len(sealed)-len(sealed)%(64*1024+16)]
+			if err := orcaEncryption.Decrypt(&decrypted, bytes.NewReader(truncated), contentKey); err == nil {
+				t.Errorf("Expected an error: ciphertext was truncated")
+			}
+		}
+	}
+}
+
+func TestKeyWrapping(t *testing.T) {
+	recipient, _ := rsa.GenerateKey(rand.Reader, 2048)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, recipientPubKey, _ := libp2pcrypto.KeyPairFromStdKey(recipient)
+
+	contentKey, _ := orcaEncryption.NewContentKey()
+	wrappedKeys, err := orcaEncryption.WrapKeyForRecipients(contentKey, []libp2pcrypto.PubKey{recipientPubKey})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	unwrapped, err := orcaEncryption.UnwrapKeyFromRecipients(wrappedKeys, recipient)
+	if err != nil || !bytes.Equal(unwrapped, contentKey) {
+		t.Errorf("Expected recipient to unwrap the content key, got %v", err)
+	}
+	if _, err := orcaEncryption.UnwrapKeyFromRecipients(wrappedKeys, other); err == nil {
+		t.Errorf("Expected an error: file was not encrypted for this key")
+	}
+}
@@ -7,7 +7,7 @@ import (
 
 func TestBasicHash(t *testing.T) {
 	fileName := "test.mp4"
-	_, err := orcaHash.HashFile(fileName)
+	_, err := orcaHash.HashFile(".", fileName)
 	if err != nil {
 		t.Errorf("Expected no error, got %s", err)
 	}
@@ -15,7 +15,7 @@ func TestBasicHash(t *testing.T) {
 
 func TestErrorNoFile(t *testing.T) {
 	fileName := "tester.mp4"
-	_, err := orcaHash.HashFile(fileName)
+	_, err := orcaHash.HashFile(".", fileName)
 	if err == nil {
 		t.Errorf("Expected error: file not found")
 	}
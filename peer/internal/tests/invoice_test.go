@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"orca-peer/internal/config"
+	"orca-peer/internal/fileshare"
+	orcaHash "orca-peer/internal/hash"
+	orcaInvoice "orca-peer/internal/invoice"
+	orcaServer "orca-peer/internal/server"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coloshword/OrcaNetAPIServer/orcarpc"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestInvoiceSignature(t *testing.T) {
+	privKey, pubKey, err := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := orcaInvoice.Create("filekey", 0, 99, 3, "address", privKey)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	invoice, err := orcaInvoice.Verify(data, pubKey)
+	if err != nil {
+		t.Fatalf("Expected invoice to verify, got %s", err)
+	}
+	if invoice.FileKey != "filekey" || invoice.RangeEnd != 99 || invoice.Amount != 3 || invoice.Address != "address" {
+		t.Errorf("Invoice did not round trip: %+v", invoice)
+	}
+	if _, err := orcaInvoice.Verify(data, otherKey); err == nil {
+		t.Errorf("Expected an error: invoice was signed by another peer")
+	}
+
+	var signed orcaInvoice.SignedInvoice
+	json.Unmarshal(data, &signed)
+	signed.Invoice = []byte(`{"id":"x","fileKey":"filekey","rangeStart":0,"rangeEnd":99,"amount":0}`)
+	tampered, _ := json.Marshal(signed)
+	if _, err := orcaInvoice.Verify(tampered, pubKey); err == nil {
+		t.Errorf("Expected an error: invoice was tampered with")
+	}
+}
+
+func TestExpiredInvoicePaidInTime(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	os.MkdirAll("files/stored", 0755)
+	os.WriteFile("notes.txt", []byte("orca invoices"), 0644)
+	fileKey, fileInfo, err := orcaHash.SaveChunkedFile(".", "notes.txt", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, pubKey, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	publisherKey, _ := libp2pcrypto.MarshalPublicKey(pubKey)
+	settings := config.Defaults()
+	settings.Confirmations = 1
+	s := orcaServer.NewFileShareServerNode(settings, privKey)
+	s.StoredFileInfoMap[fileKey] = &fileInfo
+
+	expiresAt := time.Now().Add(-time.Minute).Unix()
+	paidAt := map[string]int64{"early": expiresAt - 60, "late": expiresAt + 30, "unconfirmed": expiresAt - 60}
+	confirmations := map[string]int64{"early": 1, "late": 1, "unconfirmed": 0}
+	newFakeWallet(t, func(method string, params []json.RawMessage) (interface{}, *orcarpc.Error) {
+		if method != "gettransaction" {
+			return nil, &orcarpc.Error{Code: orcarpc.ErrRPCMethodNotFound, Message: "Method not found"}
+		}
+		var txId string
+		json.Unmarshal(params[0], &txId)
+		return map[string]interface{}{
+			"txid":          txId,
+			"amount":        1,
+			"timereceived":  paidAt[txId],
+			"confirmations": confirmations[txId],
+			"details":       []map[string]interface{}{{"address": "1OrcaAddress", "amount": 1, "category": "receive"}},
+		}, nil
+	})
+
+	// An invoice that expired a minute ago, signed by the server
+	invoiceBytes, _ := json.Marshal(orcaInvoice.Invoice{
+		Id:           "expired",
+		FileKey:      fileKey,
+		RangeEnd:     4,
+		Amount:       1,
+		Address:      "1OrcaAddress",
+		PublisherKey: publisherKey,
+		ExpiresAt:    expiresAt,
+	})
+	signature, _ := privKey.Sign(invoiceBytes)
+	data, _ := json.Marshal(orcaInvoice.SignedInvoice{Invoice: invoiceBytes, Signature: signature})
+
+	transfer := func(txId string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/transfer/"+fileKey, nil)
+		request.Header.Set(orcaInvoice.InvoiceHeader, base64.StdEncoding.EncodeToString(data))
+		request.Header.Set(orcaInvoice.PaymentHeader, txId)
+		recorder := httptest.NewRecorder()
+		s.TransferHandler(recorder, request)
+		return recorder
+	}
+	if recorder := transfer("late"); recorder.Code != http.StatusPaymentRequired {
+		t.Errorf("Expected an invoice paid after it expired to be refused, got status %d", recorder.Code)
+	}
+	if recorder := transfer("unconfirmed"); recorder.Code != http.StatusPaymentRequired {
+		t.Errorf("Expected a payment without enough confirmations to be refused, got status %d", recorder.Code)
+	}
+	if recorder := transfer("early"); recorder.Code/100 != 2 || recorder.Body.String() != "orca " {
+		t.Errorf("Expected an invoice paid before it expired to be served, got status %d: %q", recorder.Code, recorder.Body)
+	}
+	if recorder := transfer("early"); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected an invoice to be redeemed once it was served, got status %d", recorder.Code)
+	}
+}
+
+func TestFileInvoiceConsumer(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	fileKey := strings.Repeat("ab", 32)
+	privKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	_, consumerKey, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	consumer, _ := peer.IDFromPublicKey(consumerKey)
+	s := orcaServer.NewFileShareServerNode(config.Defaults(), privKey)
+	s.StoredFileInfoMap[fileKey] = &fileshare.FileInfo{FileName: "notes.txt", FileSize: 10, ChunkHashes: []string{"chunk"}}
+	s.MarketEntryMap[fileKey] = &fileshare.User{Price: 2}
+	newFakeWallet(t, func(method string, params []json.RawMessage) (interface{}, *orcarpc.Error) {
+		if method == "getnewaddress" {
+			return "1OrcaAddress", nil
+		}
+		return nil, &orcarpc.Error{Code: orcarpc.ErrRPCMethodNotFound, Message: "Method not found"}
+	})
+
+	recorder := httptest.NewRecorder()
+	s.InvoicesHandler(recorder, httptest.NewRequest(http.MethodGet, "/invoices/"+fileKey, nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invoice without a consumer to be refused, got status %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	s.InvoicesHandler(recorder, httptest.NewRequest(http.MethodGet, "/invoices/"+fileKey+"?consumer="+consumer.String(), nil))
+	var record orcaInvoice.Record
+	if err := json.Unmarshal(recorder.Body.Bytes(), &record); err != nil || record.Invoice.Consumer != consumer.String() || record.Invoice.Amount != 2 {
+		t.Errorf("Expected an invoice for the consumer, got %s: %v", recorder.Body, err)
+	}
+}
+
+func TestInvoiceURI(t *testing.T) {
+	privKey, pubKey, err := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := orcaInvoice.CreateForJob("filekey", "job", "", 0, 99, 3, "1OrcaAddress", privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := orcaInvoice.URI(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(uri, "orcacoin:1OrcaAddress?amount=3&") {
+		t.Errorf("Expected a payment request for 3 coins to the invoice's address, got %s", uri)
+	}
+	invoice, parsed, err := orcaInvoice.ParseURI(uri)
+	if err != nil || invoice.JobId != "job" || invoice.Amount != 3 || string(parsed) != string(data) {
+		t.Errorf("Invoice did not round trip through its URI: %+v %v", invoice, err)
+	}
+	for _, tampered := range []string{
+		strings.Replace(uri, "1OrcaAddress", "1OtherAddress", 1),
+		strings.Replace(uri, "amount=3", "amount=1", 1),
+		strings.Replace(uri, "orcacoin:", "bitcoin:", 1),
+		strings.Replace(uri, "amount=3", "req-fee=1&amount=3", 1),
+	} {
+		if _, _, err := orcaInvoice.ParseURI(tampered); err == nil {
+			t.Errorf("Expected an error for %s", tampered)
+		}
+	}
+
+	store := orcaInvoice.NewStore(filepath.Join(t.TempDir(), "invoices.json"))
+	record, err := store.Add(data, orcaInvoice.RolePurchase)
+	if err != nil || record.Status != orcaInvoice.StatusOpen {
+		t.Fatalf("Expected an open invoice, got %+v %v", record, err)
+	}
+	publisherKey, _ := libp2pcrypto.MarshalPublicKey(pubKey)
+	if paid := store.PaidFor("filekey", publisherKey); len(paid) != 0 {
+		t.Errorf("Expected an open invoice not to be sent with chunk requests")
+	}
+	store.Update(record.Invoice.Id, func(record *orcaInvoice.Record) { record.Status = orcaInvoice.StatusPaid })
+	if paid := store.PaidFor("filekey", publisherKey); len(paid) != 1 || string(paid[0]) != string(data) {
+		t.Errorf("Expected the paid invoice to be sent with chunk requests, got %d", len(paid))
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header string
+		start  int64
+		end    int64
+	}{
+		{"", 0, 999},
+		{"bytes=0-99", 0, 99},
+		{"bytes=500-", 500, 999},
+		{"bytes=-100", 900, 999},
+		{"bytes=900-5000", 900, 999},
+	}
+	for _, c := range cases {
+		start, end, err := orcaInvoice.ParseRange(c.header, 1000)
+		if err != nil || start != c.start || end != c.end {
+			t.Errorf("ParseRange(%q) = %d, %d, %v, expected %d, %d", c.header, start, end, err, c.start, c.end)
+		}
+	}
+	for _, header := range []string{"bytes=1000-", "bytes=5-1", "bytes=0-1,5-6", "items=0-1"} {
+		if _, _, err := orcaInvoice.ParseRange(header, 1000); err == nil {
+			t.Errorf("Expected an error for %q", header)
+		}
+	}
+}
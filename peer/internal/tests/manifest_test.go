@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"crypto/rand"
+	orcaHash "orca-peer/internal/hash"
+	orcaManifest "orca-peer/internal/manifest"
+	"os"
+	"testing"
+
+	"orca-peer/internal/fileshare"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	os.MkdirAll("files/stored", 0755)
+	os.WriteFile("notes.txt", []byte("orca manifests"), 0644)
+
+	fileKey, fileInfo, err := orcaHash.SaveChunkedFile(".", "notes.txt", "notes.txt")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if err := orcaHash.VerifyChunkedFile("notes.txt", &fileInfo); err != nil {
+		t.Errorf("Expected file to match its chunks, got %s", err)
+	}
+
+	privKey, _, err := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holders := []*fileshare.User{{Ip: "/ip4/10.0.0.1/tcp/4001/p2p/QmZyLQd66AYP9sPxGbdjqZ5Ys76ZBaFFJy5PwzXxosXz74", Price: 2}}
+	signed, err := orcaManifest.Create(fileKey, &fileInfo, map[string]string{"author": "orca"}, holders, nil, privKey)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if err := orcaManifest.WriteFile("notes.orca", signed); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := orcaManifest.ReadFile("notes.orca")
+	if err != nil {
+		t.Fatalf("Expected manifest to verify, got %s", err)
+	}
+	if manifest.GetMetadata()["author"] != "orca" {
+		t.Errorf("Expected metadata to survive, got %v", manifest.GetMetadata())
+	}
+
+	link, err := orcaManifest.ToLink(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := orcaManifest.ParseLink(link)
+	if err != nil {
+		t.Fatalf("Expected link to parse, got %s", err)
+	}
+	if parsed.FileKey != fileKey || parsed.FileName != "notes.txt" || parsed.FileSize != fileInfo.GetFileSize() || len(parsed.Holders) != 1 {
+		t.Errorf("Link did not round trip: %+v", parsed)
+	}
+
+	signed.Manifest[len(signed.Manifest)-1] ^= 1
+	if _, err := orcaManifest.Verify(signed); err == nil {
+		t.Errorf("Expected an error: manifest was tampered with")
+	}
+	os.WriteFile("notes.txt", []byte("orca manifests!"), 0644)
+	if err := orcaHash.VerifyChunkedFile("notes.txt", &fileInfo); err == nil {
+		t.Errorf("Expected an error: file was modified")
+	}
+}
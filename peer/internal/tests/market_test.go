@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"orca-peer/internal/config"
+	orcaServer "orca-peer/internal/server"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A market peer with a fresh key and the default settings in a temporary data directory, its market is not started
+func newMarketPeer(t *testing.T) *orcaServer.FileShareServerNode {
+	privKey, _, err := libp2pcrypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := config.Defaults()
+	settings.DataDir = t.TempDir()
+	return orcaServer.NewFileShareServerNode(settings, privKey)
+}
+
+func writeSwarmKey(t *testing.T, hexKey string) string {
+	path := filepath.Join(t.TempDir(), "swarm.key")
+	err := os.WriteFile(path, []byte("/key/swarm/psk/1.0.0/\n/base16/\n"+hexKey+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newPrivateHost(t *testing.T, swarmKeyFile string) host.Host {
+	opts, err := orcaServer.PrivateNetworkOptions(swarmKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := libp2p.New(append(opts, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := writeSwarmKey(t, strings.Repeat("ab", 32))
+	otherKey := writeSwarmKey(t, strings.Repeat("cd", 32))
+	a := newPrivateHost(t, key)
+	defer a.Close()
+	b := newPrivateHost(t, key)
+	defer b.Close()
+	outsider := newPrivateHost(t, otherKey)
+	defer outsider.Close()
+	public := newPrivateHost(t, "")
+	defer public.Close()
+
+	if err := b.Connect(ctx, peer.AddrInfo{ID: a.ID(), Addrs: a.Addrs()}); err != nil {
+		t.Errorf("Expected peers with the same swarm key to connect, got %s", err)
+	}
+	for _, h := range []host.Host{outsider, public} {
+		if err := h.Connect(ctx, peer.AddrInfo{ID: a.ID(), Addrs: a.Addrs()}); err == nil {
+			t.Errorf("Expected a peer without the swarm key to be refused")
+		}
+	}
+}
+
+func TestConfigureMarket(t *testing.T) {
+	market := newMarketPeer(t)
+	market.ConfigureMarket("/consortium/market/", "")
+	if prefix := market.MarketProtocolPrefix(); prefix != "consortium/market" {
+		t.Errorf("Expected consortium/market, got %s", prefix)
+	}
+
+	path := filepath.Join(t.TempDir(), "bootstrap.peers")
+	os.WriteFile(path, []byte("# consortium bootstrap\n/ip4/10.0.0.1/tcp/4001/p2p/QmZyLQd66AYP9sPxGbdjqZ5Ys76ZBaFFJy5PwzXxosXz74\n\n"), 0644)
+	market.ConfigureMarket("", path)
+	if prefix := market.MarketProtocolPrefix(); prefix != orcaServer.DefaultMarketProtocolPrefix {
+		t.Errorf("Expected the public market, got %s", prefix)
+	}
+	if peers, err := market.ReadBootstrapPeers(); err != nil || len(peers) != 1 || !strings.HasPrefix(peers[0].String(), "/ip4/10.0.0.1") {
+		t.Errorf("Expected the one bootstrap peer of the list, got %v", peers)
+	}
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+	orcaServer "orca-peer/internal/server"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+func TestMDNSDiscovery(t *testing.T) {
+	a, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	marketA := newMarketPeer(t)
+	serviceA, err := marketA.StartMDNS(a)
+	if err != nil {
+		t.Skip("mDNS unavailable:", err)
+	}
+	defer serviceA.Close()
+	serviceB, err := newMarketPeer(t).StartMDNS(b)
+	if err != nil {
+		t.Skip("mDNS unavailable:", err)
+	}
+	defer serviceB.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for a.Network().Connectedness(b.ID()) != network.Connected && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if a.Network().Connectedness(b.ID()) != network.Connected {
+		t.Skip("no multicast on this network")
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if info, ok := marketA.GetPeerTable()[b.ID().String()]; ok {
+			if info.Location != orcaServer.LocalNetworkLocation {
+				t.Errorf("Expected a peer found over mDNS to be on the local network, got %q", info.Location)
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("Expected a peer found over mDNS in the peer table")
+}
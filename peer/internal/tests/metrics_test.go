@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"context"
+	orcaMetrics "orca-peer/internal/metrics"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	libp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+func TestPeerMetrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bandwidth := libp2pmetrics.NewBandwidthCounter()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.BandwidthReporter(bandwidth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	other, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := h.Connect(ctx, peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Send some bytes over a protocol of our own
+	const testProtocol = protocol.ID("/orca-test/1.0")
+	other.SetStreamHandler(testProtocol, func(s network.Stream) {
+		s.Write([]byte{1})
+		buf := make([]byte, 1024)
+		for {
+			if _, err := s.Read(buf); err != nil {
+				s.Close()
+				return
+			}
+		}
+	})
+	s, err := h.NewStream(ctx, other.ID(), testProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Streams are only counted under their protocol once it is negotiated
+	if _, err := s.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(make([]byte, 64*1024))
+	s.Close()
+
+	tracker := orcaMetrics.NewTracker(h, bandwidth, 2)
+	for i := 0; i < 3; i++ {
+		tracker.Sample(ctx)
+	}
+	metrics, ok := tracker.Peer(other.ID())
+	if !ok {
+		t.Fatalf("Expected metrics for a connected peer")
+	}
+	if metrics.LatencyMs <= 0 {
+		t.Errorf("Expected a ping to measure latency, got %v", metrics.LatencyMs)
+	}
+	if len(metrics.History) != 2 {
+		t.Errorf("Expected the history to keep the last 2 samples, got %d", len(metrics.History))
+	}
+	// The bandwidth counter updates its totals about once a second
+	deadline := time.Now().Add(3 * time.Second)
+	for tracker.Protocols()[testProtocol].TotalOut < 64*1024 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	metrics, _ = tracker.Peer(other.ID())
+	if metrics.Traffic.TotalOut < 64*1024 {
+		t.Errorf("Expected at least 64 KiB sent to the peer, got %d", metrics.Traffic.TotalOut)
+	}
+	if tracker.Protocols()[testProtocol].TotalOut < 64*1024 {
+		t.Errorf("Expected at least 64 KiB sent over %s, got %+v", testProtocol, tracker.Protocols()[testProtocol])
+	}
+
+	// Peers we disconnect from are forgotten
+	h.Network().ClosePeer(other.ID())
+	tracker.Sample(ctx)
+	if _, ok := tracker.Peer(other.ID()); ok {
+		t.Errorf("Expected no metrics for a disconnected peer")
+	}
+}
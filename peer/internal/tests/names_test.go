@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"crypto/rand"
+	orcaServer "orca-peer/internal/server"
+	"strings"
+	"testing"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestNameRecordValidation(t *testing.T) {
+	privKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	otherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	peerId, _ := peer.IDFromPrivateKey(privKey)
+	fileKey := strings.Repeat("ab", 32)
+	key := orcaServer.NameRecordKey(peerId, "nightly")
+	validator := orcaServer.NewValidator()
+
+	first, err := orcaServer.CreateNameRecord("nightly", fileKey, 1, privKey)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if err := validator.Validate(key, first); err != nil {
+		t.Errorf("Expected record to validate, got %s", err)
+	}
+
+	second, _ := orcaServer.CreateNameRecord("nightly", strings.Repeat("cd", 32), 2, privKey)
+	index, err := validator.Select(key, [][]byte{second, first})
+	if err != nil || index != 0 {
+		t.Errorf("Expected the higher sequence to be selected, got %d (%v)", index, err)
+	}
+
+	forged, _ := orcaServer.CreateNameRecord("nightly", fileKey, 3, otherKey)
+	if err := validator.Validate(key, forged); err == nil {
+		t.Errorf("Expected an error: record was signed by another peer")
+	}
+	if err := validator.Validate(orcaServer.NameRecordKey(peerId, "weekly"), first); err == nil {
+		t.Errorf("Expected an error: record was published under another name")
+	}
+	if err := validator.Validate("orcanet/market/"+fileKey, first); err == nil {
+		t.Errorf("Expected an error: name record was put under a market key")
+	}
+	if _, err := orcaServer.CreateNameRecord("../nightly", fileKey, 1, privKey); err == nil {
+		t.Errorf("Expected an error: name contains a slash")
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	orcaNat "orca-peer/internal/nat"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestSelectAdvertiseAddr(t *testing.T) {
+	id, err := peer.Decode("QmZyLQd66AYP9sPxGbdjqZ5Ys76ZBaFFJy5PwzXxosXz74")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loopback := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+	private := ma.StringCast("/ip4/192.168.1.2/tcp/4001")
+	public := ma.StringCast("/ip4/8.8.8.8/tcp/4001")
+	relayed := ma.StringCast("/ip4/8.8.4.4/tcp/4001/p2p/QmcAhU6MTzDeDvPhJgbk83PpT5dyB5LrZdSYaZW9K7gJm1/p2p-circuit")
+
+	cases := []struct {
+		addrs        []ma.Multiaddr
+		reachability network.Reachability
+		expected     ma.Multiaddr
+	}{
+		{[]ma.Multiaddr{loopback, private, public, relayed}, network.ReachabilityPublic, public},
+		{[]ma.Multiaddr{loopback, private, public, relayed}, network.ReachabilityPrivate, relayed},
+		{[]ma.Multiaddr{loopback, private, public}, network.ReachabilityPrivate, public},
+		{[]ma.Multiaddr{loopback, private}, network.ReachabilityUnknown, private},
+	}
+	for _, c := range cases {
+		addr := orcaNat.SelectAdvertiseAddr(id, c.addrs, c.reachability)
+		if addr != c.expected.String()+"/p2p/"+id.String() {
+			t.Errorf("Expected %s when %s, got %s", c.expected, c.reachability, addr)
+		}
+	}
+	if addr := orcaNat.SelectAdvertiseAddr(id, []ma.Multiaddr{loopback}, network.ReachabilityPublic); addr != "" {
+		t.Errorf("Expected no address, got %s", addr)
+	}
+
+	// Listings carry every address, best first
+	quic := ma.StringCast("/ip6/2001:4860:4860::8888/udp/4001/quic-v1")
+	addrs := orcaNat.SelectAdvertiseAddrs(id, []ma.Multiaddr{loopback, private, public, quic, relayed}, network.ReachabilityPrivate)
+	expected := []ma.Multiaddr{relayed, public, quic, private}
+	if len(addrs) != len(expected) {
+		t.Fatalf("Expected %d addresses, got %v", len(expected), addrs)
+	}
+	for i, addr := range expected {
+		if addrs[i] != addr.String()+"/p2p/"+id.String() {
+			t.Errorf("Expected %s at %d, got %s", addr, i, addrs[i])
+		}
+	}
+}
+
+func TestRelayedConnection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	relayHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.ForceReachabilityPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relayHost.Close()
+	if _, err := relay.New(relayHost); err != nil {
+		t.Fatal(err)
+	}
+
+	unreachable, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.EnableRelay(), libp2p.ForceReachabilityPrivate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unreachable.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+	if err := unreachable.Connect(ctx, relayInfo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Reserve(ctx, unreachable, relayInfo); err != nil {
+		t.Fatalf("Expected a reservation, got %s", err)
+	}
+
+	// The relay leaves loopback addresses out of the reservation, so build the circuit address the way autorelay would
+	circuitAddrs := make([]ma.Multiaddr, 0)
+	for _, addr := range relayHost.Addrs() {
+		circuitAddrs = append(circuitAddrs, addr.Encapsulate(ma.StringCast("/p2p/"+relayHost.ID().String()+"/p2p-circuit")))
+	}
+	advertised := orcaNat.SelectAdvertiseAddr(unreachable.ID(), append(unreachable.Addrs(), circuitAddrs...), network.ReachabilityPrivate)
+	if !strings.Contains(advertised, "/p2p-circuit/p2p/"+unreachable.ID().String()) {
+		t.Fatalf("Expected an unreachable peer to be listed under a relay, got %s", advertised)
+	}
+
+	// A consumer dialing the advertised address reaches the unreachable peer through the relay
+	consumer, err := libp2p.New(libp2p.NoListenAddrs, libp2p.EnableRelay())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	info, err := peer.AddrInfoFromString(advertised)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Connect(ctx, *info); err != nil {
+		t.Fatalf("Expected to connect through the relay, got %s", err)
+	}
+	unreachable.SetStreamHandler("/orca-test/1.0", func(s network.Stream) { s.Close() })
+	s, err := consumer.NewStream(network.WithAllowLimitedConn(ctx, "test"), unreachable.ID(), "/orca-test/1.0")
+	if err != nil {
+		t.Fatalf("Expected to open a stream through the relay, got %s", err)
+	}
+	s.Close()
+}
+
+func TestWatchAddresses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	expected := orcaNat.SelectAdvertiseAddrs(h.ID(), h.Addrs(), network.ReachabilityUnknown)
+	if len(expected) == 0 {
+		t.Skip("no network interface besides loopback")
+	}
+
+	changes := make(chan []string, 1)
+	go orcaNat.WatchAddresses(ctx, h, nil, func(addrs []string) { changes <- addrs })
+	emitter, err := h.EventBus().Emitter(new(event.EvtLocalAddressesUpdated), eventbus.Stateful)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+	// give the watcher time to subscribe
+	time.Sleep(100 * time.Millisecond)
+	emitter.Emit(event.EvtLocalAddressesUpdated{})
+
+	select {
+	case addrs := <-changes:
+		if strings.Join(addrs, " ") != strings.Join(expected, " ") {
+			t.Errorf("Expected %v, got %v", expected, addrs)
+		}
+	case <-ctx.Done():
+		t.Errorf("Expected the address to be reported after an address update")
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"orca-peer/internal/config"
+	"orca-peer/internal/node"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestTwoNodesInOneProcess(t *testing.T) {
+	dir, _ := newDataDir(t, "")
+	// A private market only listens on TCP, and keeps the nodes off of the public bootstrap peers
+	swarmKey := writeSwarmKey(t, strings.Repeat("5e", 32))
+
+	nodes := make([]*node.Node, 0)
+	for _, port := range []config.Number{19080, 19180} {
+		settings := config.Defaults()
+		settings.DataDir = filepath.Join(dir, port.String())
+		settings.MarketRPCPort, settings.MarketDHTPort, settings.HTTPAPIPort = port, port+1, port+2
+		settings.SwarmKey = swarmKey
+		settings.BootstrapPeers = filepath.Join(dir, "bootstrap.peers")
+		settings.BlockchainPassword = "unused"
+		settings.CoinServerDir = ""
+		privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := node.New(settings, &privKey.PublicKey, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer n.Stop()
+		nodes = append(nodes, n)
+	}
+	for _, n := range nodes {
+		if _, err := os.Stat(filepath.Join(n.Settings.DataDir, "files", "ledger.db")); err != nil {
+			t.Errorf("Expected each node to keep its ledger in its own data directory, got %s", err)
+		}
+	}
+
+	for _, n := range nodes {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/get-peers", n.Settings.HTTPAPIPort))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected the API of each node to answer, got %s", resp.Status)
+		}
+	}
+	err := nodes[0].Host.Connect(context.Background(), peer.AddrInfo{ID: nodes[1].Host.ID(), Addrs: nodes[1].Host.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range nodes {
+		if err := n.Stop(); err != nil {
+			t.Errorf("Expected a clean stop, got %s", err)
+		}
+		if _, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/get-peers", n.Settings.HTTPAPIPort)); err == nil {
+			t.Errorf("Expected the API to be closed once the node stopped")
+		}
+	}
+	if nodes[1].Host.Network().Connectedness(nodes[0].Host.ID()) == network.Connected {
+		t.Errorf("Expected the hosts to be closed once the nodes stopped")
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"orca-peer/internal/config"
+	orcaServer "orca-peer/internal/server"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coloshword/OrcaNetAPIServer/orcarpc"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestChunkCredit(t *testing.T) {
+	var mut sync.Mutex
+	addresses := 0
+	received := map[string]float64{}
+	newFakeWallet(t, func(method string, params []json.RawMessage) (interface{}, *orcarpc.Error) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch method {
+		case "getnewaddress":
+			addresses++
+			return []string{"1FirstDownload", "1SecondDownload"}[addresses-1], nil
+		case "getreceivedbyaddress":
+			var address string
+			json.Unmarshal(params[0], &address)
+			return received[address], nil
+		}
+		return nil, &orcarpc.Error{Code: orcarpc.ErrRPCMethodNotFound, Message: "Method not found"}
+	})
+
+	privKey, _, err := libp2pcrypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := config.Defaults()
+	settings.DataDir = t.TempDir()
+	settings.CreditChunks = 2
+	s := orcaServer.NewFileShareServerNode(settings, privKey)
+	consumer, other := peer.ID("consumer"), peer.ID("other consumer")
+
+	for i := 0; i < 2; i++ {
+		address, err := s.ChargeChunk(context.Background(), consumer, "file", "job", 3)
+		if err != nil || address != "1FirstDownload" {
+			t.Fatalf("Expected chunk %d to be served on credit, got %s %v", i, address, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.ChargeChunk(ctx, consumer, "file", "job", 3); err == nil {
+		t.Error("Expected a third unpaid chunk to be refused")
+	}
+	if address, err := s.ChargeChunk(context.Background(), other, "file", "", 3); err != nil || address != "1SecondDownload" {
+		t.Errorf("Expected another consumer to have its own account, got %s %v", address, err)
+	}
+
+	mut.Lock()
+	received["1FirstDownload"] = 3
+	mut.Unlock()
+	if _, err := s.ChargeChunk(context.Background(), consumer, "file", "job", 3); err != nil {
+		t.Errorf("Expected a chunk to be served once the first one is paid for, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"crypto/rand"
+	"orca-peer/internal/fileshare"
+	orcaServer "orca-peer/internal/server"
+	"testing"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestHolderWalletAddress(t *testing.T) {
+	privKey, pubKey, err := libp2pcrypto.GenerateRSAKeyPair(2048, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := pubKey.Raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := orcaServer.SignPayoutAddress("1OrcaAddress", privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerId, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := &fileshare.User{Id: id, Ip: "/ip4/10.0.0.1/tcp/4001/p2p/" + peerId.String(), PayoutAddress: "1OrcaAddress", PayoutSignature: signature}
+	address, err := orcaServer.HolderWalletAddress(holder)
+	if err != nil || address != "1OrcaAddress" {
+		t.Errorf("Expected the signed payout address, got %s %v", address, err)
+	}
+
+	// Another peer's record listing this key and its signed address, to be paid in its place
+	_, otherKey, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	otherId, _ := peer.IDFromPublicKey(otherKey)
+	holder.Ip = "/ip4/10.0.0.2/tcp/4001/p2p/" + otherId.String()
+	if _, err := orcaServer.HolderWalletAddress(holder); err == nil {
+		t.Error("Expected a key that does not belong to the holder's peer to be refused")
+	}
+	holder.Ip = "/ip4/10.0.0.1/tcp/4001/p2p/" + peerId.String()
+
+	holder.PayoutAddress = "1OtherAddress"
+	if _, err := orcaServer.HolderWalletAddress(holder); err == nil {
+		t.Error("Expected an address the holder did not sign to be refused")
+	}
+	holder.PayoutAddress = ""
+	if _, err := orcaServer.HolderWalletAddress(holder); err == nil {
+		t.Error("Expected a holder without a payout address to be refused")
+	}
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"context"
+	orcaRelay "orca-peer/internal/relay"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+)
+
+func TestRelayConfig(t *testing.T) {
+	config, err := orcaRelay.LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || config.MaxReservations != orcaRelay.DefaultConfig().MaxReservations {
+		t.Errorf("Expected defaults for a missing file, got %+v, %v", config, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "relay.json")
+	os.WriteFile(path, []byte(`{"maxReservations": 2, "circuitData": 1024}`), 0644)
+	config, err = orcaRelay.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := config.Resources()
+	if resources.MaxReservations != 2 || resources.Limit.Data != 1024 || resources.MaxCircuits != orcaRelay.DefaultConfig().MaxCircuits {
+		t.Errorf("Expected settings to override only the fields given, got %+v", resources)
+	}
+}
+
+func TestRelayReservationLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	relayHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relayHost.Close()
+	config := orcaRelay.DefaultConfig()
+	config.MaxReservations = 1
+	service, tracer, err := orcaRelay.StartRelay(relayHost, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	for i := 0; i < 2; i++ {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.EnableRelay())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		if err := h.Connect(ctx, relayInfo); err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Reserve(ctx, h, relayInfo)
+		if i == 0 && err != nil {
+			t.Fatalf("Expected the first reservation to be allowed, got %s", err)
+		}
+		if i == 1 && err == nil {
+			t.Fatalf("Expected the second reservation to be refused")
+		}
+	}
+
+	stats := tracer.Stats()
+	if !stats.Active || stats.ActiveReservations != 1 || stats.ReservationsAllowed != 1 || stats.ReservationsRefused != 1 {
+		t.Errorf("Unexpected relay stats %+v", stats)
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	orcaAccounting "orca-peer/internal/accounting"
+	"orca-peer/internal/config"
+	orcaServer "orca-peer/internal/server"
+	orcaSettlement "orca-peer/internal/settlement"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coloshword/OrcaNetAPIServer/orcarpc"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestBatchedSettlement(t *testing.T) {
+	var mut sync.Mutex
+	received := map[string]float64{}
+	newFakeWallet(t, func(method string, params []json.RawMessage) (interface{}, *orcarpc.Error) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch method {
+		case "getnewaddress":
+			return "1Batched", nil
+		case "getreceivedbyaddress":
+			var address string
+			json.Unmarshal(params[0], &address)
+			return received[address], nil
+		}
+		return nil, &orcarpc.Error{Code: orcarpc.ErrRPCMethodNotFound, Message: "Method not found"}
+	})
+
+	dir := t.TempDir()
+	providerKey, _, err := libp2pcrypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumerKey, _, err := libp2pcrypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, _ := peer.IDFromPrivateKey(providerKey)
+	consumer, _ := peer.IDFromPrivateKey(consumerKey)
+	settings := config.Defaults()
+	settings.DataDir = dir
+	settings.CreditChunks = 2
+	settings.CreditLimit = 6
+	s := orcaServer.NewFileShareServerNode(settings, providerKey)
+	s.Accounts, err = orcaAccounting.Open(filepath.Join(dir, "ledger.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Accounts.Close()
+
+	settlements := make([]map[string]float64, 0)
+	ledger := orcaSettlement.NewLedger(filepath.Join(dir, "ledger.json"), consumerKey, 100, time.Hour, func(amounts map[string]float64) (string, error) {
+		mut.Lock()
+		defer mut.Unlock()
+		settlements = append(settlements, amounts)
+		for address, amount := range amounts {
+			received[address] += amount
+		}
+		return "f00d", nil
+	})
+
+	// The consumer owes up to the credit limit, then settles before the holder would stop serving it
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		address, err := s.ChargeBatchedChunk(ctx, consumer, consumerKey.GetPublic(), 3, ledger.LatestIOU(provider))
+		cancel()
+		if err != nil || address != "1Batched" {
+			t.Fatalf("Expected chunk %d to be served for an IOU, got %s %v", i, address, err)
+		}
+		err = ledger.Accrue(provider, address, "file", "job", i, 3, 6)
+		if err != nil {
+			t.Fatalf("Unable to owe for chunk %d: %v", i, err)
+		}
+	}
+	if len(settlements) != 1 || settlements[0]["1Batched"] != 6 {
+		t.Errorf("Expected the first two chunks to be settled together, got %v", settlements)
+	}
+	if ledger.Owed() != 3 {
+		t.Errorf("Expected the last chunk to still be owed, got %d", ledger.Owed())
+	}
+	iou, err := orcaSettlement.Verify(ledger.LatestIOU(provider), consumerKey.GetPublic())
+	if err != nil || iou.Total != 9 || iou.Provider != provider.String() || iou.ChunkIndex != 2 {
+		t.Errorf("Expected an IOU for all three chunks, got %+v %v", iou, err)
+	}
+	// The settlement paid for every download of the consumer, so the sale is not put down to one of them
+	sales, err := s.Accounts.Query(orcaAccounting.Filter{Kind: orcaAccounting.KindSale})
+	if err != nil || len(sales) != 1 || sales[0].Amount != 6 || sales[0].FileKey != "" || sales[0].JobId != "" {
+		t.Errorf("Expected one sale of 6 coins without a file or job, got %+v %v", sales, err)
+	}
+
+	// A consumer that never acknowledges its chunks is cut off after CREDIT_CHUNKS of them
+	otherKey, _, _ := libp2pcrypto.GenerateEd25519Key(nil)
+	other, _ := peer.IDFromPrivateKey(otherKey)
+	for i := 0; i < 2; i++ {
+		if _, err := s.ChargeBatchedChunk(context.Background(), other, otherKey.GetPublic(), 1, nil); err != nil {
+			t.Fatalf("Expected chunk %d to be served, got %v", i, err)
+		}
+	}
+	if _, err := s.ChargeBatchedChunk(context.Background(), other, otherKey.GetPublic(), 1, nil); err == nil {
+		t.Error("Expected a consumer without IOUs to be refused")
+	}
+	// Nor can one consumer's IOU stand in for another's
+	if _, err := s.ChargeBatchedChunk(context.Background(), other, otherKey.GetPublic(), 1, ledger.LatestIOU(provider)); err == nil {
+		t.Error("Expected another consumer's IOU to be ignored")
+	}
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"context"
+	orcaSpeedtest "orca-peer/internal/speedtest"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+func TestSpeedtest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	orcaSpeedtest.Register(server)
+	client, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tester := orcaSpeedtest.NewTester(client, []string{server.Addrs()[0].String() + "/p2p/" + server.ID().String()}, 1024*1024)
+	results, err := tester.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("Expected one successful result, got %+v", results)
+	}
+	if results[0].LatencyMs <= 0 || results[0].DownloadMbps <= 0 || results[0].UploadMbps <= 0 {
+		t.Errorf("Expected latency and throughput to be measured, got %+v", results[0])
+	}
+	if len(tester.Latest()) != 1 {
+		t.Errorf("Expected the tester to remember the latest results")
+	}
+
+	// Peers refuse tests larger than they serve
+	result := orcaSpeedtest.Run(ctx, client, server.ID(), orcaSpeedtest.MaxSize+1)
+	if result.Error == "" {
+		t.Errorf("Expected an error for an oversized test")
+	}
+	summary := orcaSpeedtest.Summarize(append(results, result))
+	if summary.Peers != 1 || summary.DownloadMbps != results[0].DownloadMbps {
+		t.Errorf("Expected failed results to be left out of the summary, got %+v", summary)
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"orca-peer/internal/config"
+	orcaServer "orca-peer/internal/server"
+	orcaSubscription "orca-peer/internal/subscription"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coloshword/OrcaNetAPIServer/orcarpc"
+	"github.com/libp2p/go-libp2p"
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestSubscriptionToken(t *testing.T) {
+	publisherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	subscriberKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	otherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+
+	token, err := orcaSubscription.CreateToken("datasets", subscriberKey.GetPublic(), 24*time.Hour, publisherKey)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if _, err := orcaSubscription.VerifyToken(token, publisherKey.GetPublic(), subscriberKey.GetPublic(), "datasets"); err != nil {
+		t.Errorf("Expected token to verify, got %s", err)
+	}
+	if _, err := orcaSubscription.VerifyToken(token, publisherKey.GetPublic(), otherKey.GetPublic(), "datasets"); err == nil {
+		t.Errorf("Expected an error: token presented by another peer")
+	}
+	if _, err := orcaSubscription.VerifyToken(token, publisherKey.GetPublic(), subscriberKey.GetPublic(), "nightly"); err == nil {
+		t.Errorf("Expected an error: token is for another feed")
+	}
+	if _, err := orcaSubscription.VerifyToken(token, otherKey.GetPublic(), subscriberKey.GetPublic(), "datasets"); err == nil {
+		t.Errorf("Expected an error: token signed by another publisher")
+	}
+
+	expired, _ := orcaSubscription.CreateToken("datasets", subscriberKey.GetPublic(), -time.Hour, publisherKey)
+	if _, err := orcaSubscription.VerifyToken(expired, publisherKey.GetPublic(), subscriberKey.GetPublic(), "datasets"); err == nil {
+		t.Errorf("Expected an error: token has expired")
+	}
+}
+
+func TestSubscriptionPayment(t *testing.T) {
+	var mut sync.Mutex
+	received := map[string]float64{}
+	newFakeWallet(t, func(method string, params []json.RawMessage) (interface{}, *orcarpc.Error) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch method {
+		case "getnewaddress":
+			return "1Subscription", nil
+		case "getreceivedbyaddress":
+			var address string
+			json.Unmarshal(params[0], &address)
+			return received[address], nil
+		}
+		return nil, &orcarpc.Error{Code: orcarpc.ErrRPCMethodNotFound, Message: "Method not found"}
+	})
+
+	publisherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	subscriberKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	otherKey, _, _ := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	h, err := libp2p.New(libp2p.Identity(publisherKey), libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	settings := config.Defaults()
+	settings.DataDir = t.TempDir()
+	s := orcaServer.NewFileShareServerNode(settings, publisherKey)
+	s.Host = h
+	if err := s.SetupCreateFeed("datasets", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	quote, err := s.SellSubscription(context.Background(), "datasets", subscriberKey.GetPublic(), orcaSubscription.FeedRequest{Days: 3})
+	if err != nil || quote.Token != nil || quote.WalletAddress != "1Subscription" || quote.Price != 6 {
+		t.Fatalf("Expected a quote of 6 coins, got %+v %v", quote, err)
+	}
+	redeem := orcaSubscription.FeedRequest{Days: 3, Address: quote.WalletAddress}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.SellSubscription(ctx, "datasets", subscriberKey.GetPublic(), redeem); err == nil {
+		t.Error("Expected no token before the subscription is paid")
+	}
+
+	// The feed and its quote are saved, so a restarted publisher still honours the payment
+	restarted := orcaServer.NewFileShareServerNode(settings, publisherKey)
+	mut.Lock()
+	received["1Subscription"] = 6
+	mut.Unlock()
+	if _, err := restarted.SellSubscription(context.Background(), "datasets", otherKey.GetPublic(), redeem); err == nil {
+		t.Error("Expected another peer not to redeem the subscription")
+	}
+	response, err := restarted.SellSubscription(context.Background(), "datasets", subscriberKey.GetPublic(), redeem)
+	if err != nil {
+		t.Fatalf("Expected a token once paid, got %v", err)
+	}
+	if _, err := orcaSubscription.VerifyToken(response.Token, publisherKey.GetPublic(), subscriberKey.GetPublic(), "datasets"); err != nil {
+		t.Errorf("Expected token to verify, got %s", err)
+	}
+	restarted = orcaServer.NewFileShareServerNode(settings, publisherKey)
+	if _, err := restarted.SellSubscription(context.Background(), "datasets", subscriberKey.GetPublic(), redeem); err == nil {
+		t.Error("Expected one payment to buy one token")
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"orca-peer/internal/fileshare"
+	orcaNat "orca-peer/internal/nat"
+	orcaServer "orca-peer/internal/server"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+)
+
+func TestHolderAddrInfo(t *testing.T) {
+	id := "QmZyLQd66AYP9sPxGbdjqZ5Ys76ZBaFFJy5PwzXxosXz74"
+	other := "QmcAhU6MTzDeDvPhJgbk83PpT5dyB5LrZdSYaZW9K7gJm1"
+	holder := &fileshare.User{
+		Ip: "/ip4/8.8.8.8/tcp/4001/p2p/" + id,
+		Addrs: []string{
+			"/ip4/8.8.8.8/tcp/4001/p2p/" + id,
+			"/ip6/2001:4860:4860::8888/udp/4001/quic-v1/p2p/" + id,
+			"/ip4/8.8.4.4/tcp/4001/p2p/" + other + "/p2p-circuit/p2p/" + id,
+			"/ip4/1.1.1.1/tcp/4001/p2p/" + other,
+			"not an address",
+		},
+	}
+	info, err := orcaServer.HolderAddrInfo(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID.String() != id || len(info.Addrs) != 3 {
+		t.Errorf("Expected the 3 addresses of %s, got %v", id, info)
+	}
+
+	// Listings from before holders carried several addresses
+	info, err = orcaServer.HolderAddrInfo(&fileshare.User{Ip: holder.Ip})
+	if err != nil || len(info.Addrs) != 1 {
+		t.Errorf("Expected the address in ip, got %v, %v", info, err)
+	}
+	if _, err := orcaServer.HolderAddrInfo(&fileshare.User{}); err == nil {
+		t.Errorf("Expected an error for a holder without addresses")
+	}
+}
+
+func TestDialHolderOnAnyAddress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listenAddrs := make([]string, 0)
+	for _, addr := range orcaNat.ListenAddrs("0", true) {
+		listenAddrs = append(listenAddrs, strings.Replace(strings.Replace(addr, "0.0.0.0", "127.0.0.1", 1), "/ip6/::/", "/ip6/::1/", 1))
+	}
+	holder, err := libp2p.New(libp2p.ListenAddrStrings(listenAddrs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+
+	listed := make([]string, 0)
+	quic := false
+	for _, addr := range holder.Addrs() {
+		quic = quic || strings.Contains(addr.String(), "/quic-v1")
+		listed = append(listed, addr.String()+"/p2p/"+holder.ID().String())
+	}
+	if !quic {
+		t.Errorf("Expected the holder to listen on QUIC, got %v", holder.Addrs())
+	}
+
+	// The preferred address is dead, the downloader still reaches the holder on one of the others
+	downloader, err := libp2p.New(libp2p.NoListenAddrs, libp2p.Transport(tcp.NewTCPTransport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer downloader.Close()
+	listing := &fileshare.User{Ip: "/ip4/127.0.0.1/tcp/1/p2p/" + holder.ID().String(), Addrs: listed}
+	info, err := orcaServer.HolderAddrInfo(listing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := downloader.Connect(ctx, info); err != nil {
+		t.Fatalf("Expected to reach the holder on one of its addresses, got %s", err)
+	}
+	if downloader.Network().Connectedness(holder.ID()) != network.Connected {
+		t.Fatalf("Expected a connection to the holder")
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	orcaBlockchain "orca-peer/internal/blockchain"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coloshword/OrcaNetAPIServer/orcarpc"
+)
+
+var (
+	fakeWalletKey  *ecdsa.PrivateKey
+	fakeWalletCert []byte
+	fakeWalletOnce sync.Once
+)
+
+// Start a fake OrcaWallet on its RPC port, with btcd.conf and rpc.cert in a temporary home directory.
+// The wallet's client is kept for the whole process, so every fake wallet has the same certificate.
+func newFakeWallet(t *testing.T, handle func(method string, params []json.RawMessage) (interface{}, *orcarpc.Error)) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	fakeWalletOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     []string{"localhost"},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			IsCA:         true,
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		fakeWalletCert, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fakeWalletKey = key
+	})
+	key, der := fakeWalletKey, fakeWalletCert
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	for file, content := range map[string][]byte{
+		filepath.Join(orcarpc.AppDataDir("btcd"), "btcd.conf"):     []byte("rpcuser=orca\nrpcpass=c2VjcmV0=\n"),
+		filepath.Join(orcarpc.AppDataDir("btcwallet"), "rpc.cert"): certPEM,
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "localhost:8332")
+	if err != nil {
+		t.Skip("the wallet's RPC port is in use:", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "orca" || pass != "c2VjcmV0=" {
+			http.Error(w, "401 Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			ID     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		result, rpcErr := handle(request.Method, request.Params)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": request.ID, "result": result, "error": rpcErr})
+	}))
+	// Every test has its own fake wallet on the same port, the client must not keep a connection to an earlier one
+	server.Config.SetKeepAlivesEnabled(false)
+	server.Listener.Close()
+	server.Listener = listener
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+}
+
+func TestWalletRPC(t *testing.T) {
+	unlocked := false
+	newFakeWallet(t, func(method string, params []json.RawMessage) (interface{}, *orcarpc.Error) {
+		switch method {
+		case "walletpassphrase":
+			var passphrase string
+			json.Unmarshal(params[0], &passphrase)
+			if passphrase != "right horse battery" {
+				return nil, &orcarpc.Error{Code: orcarpc.ErrRPCWalletPassphraseIncorrect, Message: "incorrect passphrase"}
+			}
+			unlocked = true
+			return nil, nil
+		case "sendtoaddress":
+			if !unlocked {
+				return nil, &orcarpc.Error{Code: orcarpc.ErrRPCWalletUnlockNeeded, Message: "unlock needed"}
+			}
+			return "f00d", nil
+		case "getnewaddress":
+			return "1OrcaAddress", nil
+		case "gettransaction":
+			return map[string]interface{}{
+				"txid":          "f00d",
+				"amount":        1.5,
+				"confirmations": 2,
+				"details": []map[string]interface{}{
+					{"address": "1OrcaAddress", "amount": 1.5, "category": "receive"},
+					{"address": "1OtherAddress", "amount": 3, "category": "receive"},
+				},
+			}, nil
+		}
+		return nil, &orcarpc.Error{Code: orcarpc.ErrRPCMethodNotFound, Message: "Method not found"}
+	})
+
+	_, err := orcaBlockchain.SendToAddress("1.5", "1OrcaAddress", "wrong passphrase")
+	if !orcarpc.IsCode(err, orcarpc.ErrRPCWalletPassphraseIncorrect) {
+		t.Errorf("Expected the wallet's incorrect passphrase error, got %v", err)
+	}
+	if txId, err := orcaBlockchain.SendToAddress("1.5", "1OrcaAddress", "right horse battery"); err != nil || txId != "f00d" {
+		t.Errorf("Expected the coins to be sent, got %s %v", txId, err)
+	}
+	address, err := orcaBlockchain.GetNewAddress()
+	if err != nil || address != "1OrcaAddress" {
+		t.Errorf("Expected a new address, got %s %v", address, err)
+	}
+	transaction, err := orcaBlockchain.GetTransaction("f00d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Confirmations != 2 || transaction.ReceivedAmount("1OrcaAddress") != 1.5 {
+		t.Errorf("Expected the transaction to pay 1.5 to the address with 2 confirmations, got %+v", transaction)
+	}
+}
@@ -0,0 +1,153 @@
+package token
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// What a publisher signs for a peer, such as a SubscriptionToken or a CapabilityToken
+type Claims interface {
+	proto.Message
+	GetPublisherKey() []byte
+	GetNotBefore() int64
+	GetNotAfter() int64
+}
+
+// The message a token is sent in, such as a SignedSubscriptionToken or a SignedCapabilityToken
+type Signed interface {
+	proto.Message
+	GetToken() []byte
+	GetSignature() []byte
+}
+
+// Marshal claims and sign them with the publisher's key, returns the token and signature to send in a Signed message
+func Sign(claims Claims, privKey libp2pcrypto.PrivKey) ([]byte, []byte, error) {
+	token, err := proto.Marshal(claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := privKey.Sign(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, signature, nil
+}
+
+// Unmarshal data into signed and the token inside of it into claims, without checking the signature
+func Parse(data []byte, signed Signed, claims Claims) error {
+	err := proto.Unmarshal(data, signed)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(signed.GetToken(), claims)
+}
+
+/*
+ * Parse a token and check that it was signed by a publisher and has not expired.
+ *
+ * Parameters:
+ *   data: A marshalled Signed message
+ *   signed: An empty message of the type data holds
+ *   claims: An empty message of the type signed holds, filled in with the token
+ *   publisherKey: Public key the token must be signed by
+ *
+ * Returns:
+ *   An error, if any
+ */
+func Verify(data []byte, signed Signed, claims Claims, publisherKey libp2pcrypto.PubKey) error {
+	err := Parse(data, signed, claims)
+	if err != nil {
+		return err
+	}
+	valid, err := publisherKey.Verify(signed.GetToken(), signed.GetSignature())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("token signature invalid")
+	}
+	publisherKeyBytes, err := libp2pcrypto.MarshalPublicKey(publisherKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(claims.GetPublisherKey(), publisherKeyBytes) {
+		return errors.New("token is for another publisher")
+	}
+	now := time.Now().UTC().Unix()
+	if now < claims.GetNotBefore() || now > claims.GetNotAfter() {
+		return errors.New("token has expired")
+	}
+	return nil
+}
+
+// Check that a token names holderKey as the peer it was issued to
+func CheckHolder(issuedTo []byte, holderKey libp2pcrypto.PubKey) error {
+	holderKeyBytes, err := libp2pcrypto.MarshalPublicKey(holderKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(issuedTo, holderKeyBytes) {
+		return errors.New("token was issued to another peer")
+	}
+	return nil
+}
+
+// Returns the public key of the publisher that issued a token
+func PublisherKey(claims Claims) (libp2pcrypto.PubKey, error) {
+	return libp2pcrypto.UnmarshalPublicKey(claims.GetPublisherKey())
+}
+
+// Returns the peer ID of the publisher that issued a token
+func Publisher(claims Claims) (peer.ID, error) {
+	publisherKey, err := PublisherKey(claims)
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPublicKey(publisherKey)
+}
+
+// Save a token issued by a publisher to dir as <publisher>_<name><extension>, name must not reach outside of dir
+func Save(dir string, publisher peer.ID, name string, extension string, data []byte) error {
+	fileName := publisher.String() + "_" + name + extension
+	if name == "" || filepath.Base(fileName) != fileName || !filepath.IsLocal(fileName) {
+		return errors.New("invalid token name " + name)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fileName), data, 0644)
+}
+
+// Returns every token saved in dir by Save for a publisher that has not expired yet, parse reads the token inside of a file
+func Load(dir string, publisher peer.ID, extension string, parse func(data []byte) (Claims, error)) [][]byte {
+	tokens := make([][]byte, 0)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return tokens
+	}
+	now := time.Now().UTC().Unix()
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), publisher.String()+"_") || !strings.HasSuffix(entry.Name(), extension) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		claims, err := parse(data)
+		if err != nil || claims.GetNotAfter() < now {
+			continue
+		}
+		tokens = append(tokens, data)
+	}
+	return tokens
+}